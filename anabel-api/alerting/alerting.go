@@ -0,0 +1,129 @@
+package alerting
+
+import (
+	"time"
+
+	"anacove.com/backend/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// EventCreated the alert was raised
+	EventCreated = "created"
+	// EventAssigned the alert was assigned for the first time and became Active
+	EventAssigned = "assigned"
+	// EventReassigned the assignees of an Active alert changed
+	EventReassigned = "reassigned"
+	// EventUnassigned the assignees of an Active alert were removed and it became New again
+	EventUnassigned = "unassigned"
+	// EventUpdated the reason, the details, the priority or the assignees changed without changing the status
+	EventUpdated = "updated"
+	// EventCleared the alert was resolved
+	EventCleared = "cleared"
+	// EventReopened a Cleared alert was opened again
+	EventReopened = "reopened"
+	// EventEscalated the alert stayed New past a tier of its escalation policy
+	EventEscalated = "escalated"
+	// EventCommented a comment was left on the alert, the alert itself did not change
+	EventCommented = "commented"
+	// EventSuppressed the alert was raised during a maintenance window of its site and cleared right away
+	EventSuppressed = "suppressed"
+	// EventDowngraded the alert was raised during a maintenance window of its site which lowered its priority
+	EventDowngraded = "downgraded"
+)
+
+// Event godoc
+// describes a change of an alert, the previous state is nil for created alerts and comments
+type Event struct {
+	Type      string
+	Alert     *common.Alert
+	Previous  *common.Alert
+	ActorID   string
+	CommentID string
+	At        time.Time
+}
+
+// Listener reacts to the changes of alerts, like posting them to chat tools
+type Listener func(event *Event) error
+
+// registration keeps the name of a listener for the logs
+type registration struct {
+	name     string
+	listener Listener
+}
+
+var recorders []registration
+var listeners []registration
+
+// RegisterRecorder adds a listener which stores the change, like the history of the alert.
+// Recorders are called before the listeners so the change is stored before anything reacts to it,
+// they queue a retry themselves when they cannot store it
+func RegisterRecorder(name string, recorder Listener) {
+	recorders = append(recorders, registration{name: name, listener: recorder})
+}
+
+// RegisterListener adds a listener which is called with every change of an alert in the order of registration,
+// it must be called before the api serves requests
+func RegisterListener(name string, listener Listener) {
+	listeners = append(listeners, registration{name: name, listener: listener})
+}
+
+// Emit passes the change to the recorders and then to every listener. The alert is stored already, so failing
+// listeners are only logged and do not stop the others, listeners queue slow work in the outbox
+func Emit(event *Event) {
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+
+	for _, registration := range append(append([]registration{}, recorders...), listeners...) {
+		err := registration.listener(event)
+		if err != nil {
+			log.Errorf("Alert listener %s failed on %s of alert %s, error: %v", registration.name, event.Type, event.Alert.ID.Hex(), err)
+		}
+	}
+}
+
+// EventOf returns the event type of a change of the status, the assignees, the reason, the details or the priority,
+// an empty type when none of them changed
+func EventOf(previous *common.Alert, alert *common.Alert) string {
+	if previous == nil {
+		return EventCreated
+	}
+
+	switch {
+	case previous.Status == common.AlertStatusCleared && alert.Status != common.AlertStatusCleared:
+		return EventReopened
+	case previous.Status != common.AlertStatusCleared && alert.Status == common.AlertStatusCleared:
+		return EventCleared
+	case previous.Status == common.AlertStatusNew && alert.Status == common.AlertStatusActive:
+		return EventAssigned
+	case previous.Status == common.AlertStatusActive && alert.Status == common.AlertStatusNew:
+		return EventUnassigned
+	case alert.Status == common.AlertStatusActive && !SameAssignees(previous.AssignedTo, alert.AssignedTo):
+		return EventReassigned
+	case previous.Status != alert.Status || !SameAssignees(previous.AssignedTo, alert.AssignedTo) ||
+		previous.Reason != alert.Reason || previous.Detailed != alert.Detailed || previous.Priority != alert.Priority:
+		return EventUpdated
+	}
+
+	return ""
+}
+
+// SameAssignees compares the assigned users regardless of their order
+func SameAssignees(a []common.SimpleUser, b []common.SimpleUser) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	ids := map[string]bool{}
+	for _, user := range a {
+		ids[user.ID] = true
+	}
+	for _, user := range b {
+		if !ids[user.ID] {
+			return false
+		}
+	}
+
+	return true
+}
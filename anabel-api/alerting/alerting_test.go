@@ -0,0 +1,33 @@
+package alerting
+
+import (
+	"testing"
+
+	"anacove.com/backend/common"
+)
+
+func TestEventOf(t *testing.T) {
+	kim := []common.SimpleUser{{ID: "kim"}}
+	lee := []common.SimpleUser{{ID: "lee"}}
+
+	for _, test := range []struct {
+		name     string
+		previous *common.Alert
+		alert    common.Alert
+		event    string
+	}{
+		{"raised", nil, common.Alert{Status: common.AlertStatusNew}, EventCreated},
+		{"assigned", &common.Alert{Status: common.AlertStatusNew}, common.Alert{Status: common.AlertStatusActive, AssignedTo: kim}, EventAssigned},
+		{"reassigned", &common.Alert{Status: common.AlertStatusActive, AssignedTo: kim}, common.Alert{Status: common.AlertStatusActive, AssignedTo: lee}, EventReassigned},
+		{"unassigned", &common.Alert{Status: common.AlertStatusActive, AssignedTo: kim}, common.Alert{Status: common.AlertStatusNew}, EventUnassigned},
+		{"cleared", &common.Alert{Status: common.AlertStatusActive, AssignedTo: kim}, common.Alert{Status: common.AlertStatusCleared, AssignedTo: kim}, EventCleared},
+		{"reopened", &common.Alert{Status: common.AlertStatusCleared}, common.Alert{Status: common.AlertStatusNew}, EventReopened},
+		{"reason edited", &common.Alert{Status: common.AlertStatusCleared, Reason: "fixed"}, common.Alert{Status: common.AlertStatusCleared, Reason: "replaced"}, EventUpdated},
+		{"priority changed", &common.Alert{Status: common.AlertStatusNew, Priority: common.AlertPriorityLow}, common.Alert{Status: common.AlertStatusNew, Priority: common.AlertPriorityHigh}, EventUpdated},
+		{"unchanged", &common.Alert{Status: common.AlertStatusActive, AssignedTo: kim}, common.Alert{Status: common.AlertStatusActive, AssignedTo: kim}, ""},
+	} {
+		if event := EventOf(test.previous, &test.alert); event != test.event {
+			t.Errorf("%s: event %q instead of %q", test.name, event, test.event)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"strings"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+)
+
+// Card godoc
+// holds what is shown about an alert in every chat tool
+type Card struct {
+	Title       string
+	Status      string
+	Type        string
+	Priority    string
+	Location    string
+	JobName     string
+	Description string
+	Assignees   string
+	Link        string
+	Color       string
+}
+
+// priorityColors marks the cards by priority, cleared alerts are grey
+var priorityColors = map[string]string{
+	common.AlertPriorityHigh:   "#D93F3C",
+	common.AlertPriorityMedium: "#F2A33A",
+	common.AlertPriorityLow:    "#3C8DD9",
+}
+
+// clearedColor marks the cards of cleared alerts
+const clearedColor = "#9E9E9E"
+
+// cardOf returns the card of the alert, the link opens the alert in the app when app.alert_url is configured
+func cardOf(alert *common.Alert) *Card {
+	card := &Card{
+		Title:       "[" + alert.Priority + "] " + alert.JobName,
+		Status:      alert.Status,
+		Type:        alert.Type,
+		Priority:    alert.Priority,
+		Location:    alert.Location,
+		JobName:     alert.JobName,
+		Description: alert.Description,
+		Color:       priorityColors[alert.Priority],
+	}
+	if len(alert.Location) > 0 {
+		card.Title += " at " + alert.Location
+	}
+	if alert.Status == common.AlertStatusCleared {
+		card.Color = clearedColor
+	}
+	if len(card.Color) == 0 {
+		card.Color = priorityColors[common.AlertPriorityLow]
+	}
+
+	names := []string{}
+	for _, user := range alert.AssignedTo {
+		names = append(names, strings.TrimSpace(user.FirstName+" "+user.FamilyName))
+	}
+	card.Assignees = strings.Join(names, ", ")
+
+	if base := config.GetConfig().GetString("app.alert_url"); len(base) > 0 {
+		card.Link = base + alert.ID.Hex()
+	}
+
+	return card
+}
+
+// fallbackText is the plain text of the card shown in notifications of the chat tools
+func (card *Card) fallbackText() string {
+	return card.Title + " - " + card.Status
+}
+
+// orDash shows empty values as a dash
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+
+	return value
+}
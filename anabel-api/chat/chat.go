@@ -0,0 +1,325 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// KindSlack posts to a slack incoming webhook, or through the slack api when a bot token is set
+	KindSlack = "slack"
+	// KindTeams posts to a microsoft teams incoming webhook
+	KindTeams = "teams"
+)
+
+// Kinds lists the chat tools a site can post alerts to
+var Kinds = []string{KindSlack, KindTeams}
+
+const (
+	// defaultTimeout is used when chat.timeout_in_seconds is not configured
+	defaultTimeout = 10 * time.Second
+	// maxResponseSize limits the part of the chat response which is read, it is never kept in lastError
+	maxResponseSize = 4096
+)
+
+// Integration godoc
+// describes where the alerts of a site are posted, alerts of other types or a lower priority are left out
+type Integration struct {
+	ID           bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	SiteID       string        `json:"siteId" bson:"siteId"`
+	ClientID     string        `json:"clientId" bson:"clientId"`
+	Kind         string        `json:"kind" bson:"kind"`
+	Name         string        `json:"name" bson:"name"`
+	WebhookURL   string        `json:"webhookUrl" bson:"webhookUrl"`
+	BotToken     string        `json:"botToken,omitempty" bson:"botToken"`
+	Channel      string        `json:"channel" bson:"channel"`
+	AlertTypes   []string      `json:"alertTypes" bson:"alertTypes"`
+	MinPriority  string        `json:"minPriority" bson:"minPriority"`
+	Enabled      bool          `json:"enabled" bson:"enabled"`
+	LastError    string        `json:"lastError" bson:"lastError"`
+	LastPostedAt time.Time     `json:"lastPostedAt" bson:"lastPostedAt,omitempty"`
+	CreatedBy    string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt    time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Message godoc
+// remembers the chat message posted about an alert so it is updated in place, it has no ts while it is being posted
+type Message struct {
+	ID            bson.ObjectId `bson:"_id,omitempty"`
+	IntegrationID string        `bson:"integrationId"`
+	AlertID       string        `bson:"alertId"`
+	Channel       string        `bson:"channel"`
+	TS            string        `bson:"ts"`
+	ClaimedAt     time.Time     `bson:"claimedAt,omitempty"`
+	CreatedAt     time.Time     `bson:"createdAt"`
+	UpdatedAt     time.Time     `bson:"updatedAt"`
+}
+
+// queued is the outbox payload of a chat post
+type queued struct {
+	IntegrationID string `bson:"integrationId"`
+	AlertID       string `bson:"alertId"`
+	Event         string `bson:"event"`
+}
+
+// Init creates the indexes and registers the posting of alert changes, it must be called before outbox.Start
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.IntegrationCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "enabled"}})
+	if err == nil {
+		err = session.DB("").C(common.ChatMessageCollection).EnsureIndex(mgo.Index{Key: []string{"integrationId", "alertId"}, Unique: true})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create chat indexes, error: %v", err)
+	}
+
+	outbox.RegisterHandler(outbox.KindChat, deliverQueued)
+	alerting.RegisterListener("chat", queueAlert)
+}
+
+// Matches checks the integration takes alerts of the type and priority
+func (integration *Integration) Matches(alert *common.Alert) bool {
+	if len(integration.AlertTypes) > 0 && !utils.Contains(integration.AlertTypes, alert.Type) {
+		return false
+	}
+
+	return notification.MeetsPriority(alert.Priority, integration.MinPriority)
+}
+
+// UpdatesInPlace checks the integration can edit the message it posted, incoming webhooks can only post new messages
+func (integration *Integration) UpdatesInPlace() bool {
+	return integration.Kind == KindSlack && len(integration.BotToken) > 0
+}
+
+// Validate checks the integration can post, slack needs a webhook url or a bot token with a channel,
+// teams needs a webhook url
+func (integration *Integration) Validate() error {
+	if !utils.Contains(Kinds, integration.Kind) {
+		return errors.New("unknown kind " + integration.Kind)
+	}
+	for _, alertType := range integration.AlertTypes {
+		if !utils.Contains(notification.AlertTypes, alertType) {
+			return errors.New("unknown alert type " + alertType)
+		}
+	}
+	if !notification.ValidPriority(integration.MinPriority) {
+		return errors.New("unknown priority " + integration.MinPriority)
+	}
+
+	if integration.UpdatesInPlace() {
+		if len(integration.Channel) == 0 {
+			return errors.New("a bot token needs a channel")
+		}
+		return nil
+	}
+
+	if !validURL(integration.WebhookURL) {
+		return errors.New("the webhook url must be an https url of a public host")
+	}
+
+	return nil
+}
+
+// Post sends the card of the alert to the integration right away as a new message which is never updated,
+// it is used to try an integration out
+func Post(integration *Integration, alert *common.Alert) error {
+	card := cardOf(alert)
+	if integration.Kind == KindTeams {
+		return postTeams(integration, card)
+	}
+	if integration.UpdatesInPlace() {
+		message := slackMessage(card)
+		message["channel"] = integration.Channel
+		_, err := callSlack(integration, "chat.postMessage", message)
+		return err
+	}
+
+	return postSlackWebhook(integration, card)
+}
+
+// queueAlert queues a post of the changed alert for every enabled integration of the site which takes it,
+// escalations and comments do not change the card. Suppressed alerts are not posted, and downgraded ones
+// are posted once they are announced as created after the deduplication
+func queueAlert(event *alerting.Event) error {
+	if event.Type == alerting.EventEscalated || event.Type == alerting.EventCommented || event.Type == alerting.EventSuppressed ||
+		event.Type == alerting.EventDowngraded {
+		return nil
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IntegrationCollection)
+
+	integrations := []Integration{}
+	err := c.Find(bson.M{"siteId": event.Alert.SiteID, "enabled": true}).All(&integrations)
+	if err != nil {
+		return err
+	}
+
+	for _, integration := range integrations {
+		if !integration.Matches(event.Alert) {
+			continue
+		}
+
+		_, err = outbox.Enqueue(outbox.KindChat, "alert:"+event.Alert.ID.Hex(), queued{
+			IntegrationID: integration.ID.Hex(),
+			AlertID:       event.Alert.ID.Hex(),
+			Event:         event.Type,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliverQueued posts the alert as it is now, so a late retry never shows an outdated status
+func deliverQueued(message *outbox.Message) error {
+	payload := queued{}
+	err := message.Decode(&payload)
+	if err != nil {
+		return err
+	}
+	if !bson.IsObjectIdHex(payload.IntegrationID) || !bson.IsObjectIdHex(payload.AlertID) {
+		return outbox.Permanent(errors.New("invalid chat post " + payload.IntegrationID + " " + payload.AlertID))
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IntegrationCollection)
+
+	integration := Integration{}
+	err = c.FindId(bson.ObjectIdHex(payload.IntegrationID)).One(&integration)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !integration.Enabled {
+		log.Infof("Not posting alert %s to disabled integration %s", payload.AlertID, payload.IntegrationID)
+		return nil
+	}
+
+	alert := common.Alert{}
+	err = session.DB("").C(common.AlertCollection).FindId(bson.ObjectIdHex(payload.AlertID)).One(&alert)
+	if err == mgo.ErrNotFound {
+		return outbox.Permanent(errors.New("alert " + payload.AlertID + " does not exist"))
+	}
+	if err != nil {
+		return err
+	}
+
+	err = post(&integration, &alert)
+
+	update := bson.M{"lastError": ""}
+	if err != nil {
+		update["lastError"] = err.Error()
+	} else {
+		update["lastPostedAt"] = time.Now().UTC()
+	}
+	if updateErr := c.UpdateId(integration.ID, bson.M{"$set": update}); updateErr != nil {
+		log.Errorf("Failed to update integration %s, error: %v", integration.ID.Hex(), updateErr)
+	}
+
+	return err
+}
+
+// post sends the card to the chat tool of the integration
+func post(integration *Integration, alert *common.Alert) error {
+	card := cardOf(alert)
+	if integration.Kind == KindTeams {
+		return postTeams(integration, card)
+	}
+	if integration.UpdatesInPlace() {
+		return postSlackAPI(integration, alert, card)
+	}
+
+	return postSlackWebhook(integration, card)
+}
+
+// validURL checks the url is absolute and uses https, plain http is accepted when chat.allow_http is set.
+// The host must resolve to public addresses unless chat.allow_private_addresses is set
+func validURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || len(parsed.Host) == 0 {
+		return false
+	}
+
+	if parsed.Scheme != "https" && (parsed.Scheme != "http" || !config.GetConfig().GetBool("chat.allow_http")) {
+		return false
+	}
+	if config.GetConfig().GetBool("chat.allow_private_addresses") {
+		return true
+	}
+
+	err = utils.CheckPublicURL(raw)
+	if err != nil {
+		log.Infof("Refused chat url %s, error: %v", raw, err)
+		return false
+	}
+
+	return true
+}
+
+// httpClient returns the client posting to the chat tools, redirects are not followed
+// and private addresses are refused when connecting
+func httpClient() *http.Client {
+	timeout := defaultTimeout
+	if seconds := config.GetConfig().GetInt("chat.timeout_in_seconds"); seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	return utils.NewPublicClient(timeout, config.GetConfig().GetBool("chat.allow_private_addresses"))
+}
+
+// postJSON posts the body as json, any status other than 2xx is a failure, the response body is returned.
+// The failure only names the status, the integrations show it to the site managers
+func postJSON(target string, token string, body interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("chat responded %d", resp.StatusCode)
+	}
+	reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+
+	return reply, nil
+}
@@ -0,0 +1,186 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultSlackAPI is used when chat.slack.api_url is not configured
+const defaultSlackAPI = "https://slack.com/api"
+
+// claimTimeout is how long the message of an alert claimed by an instance is left to it before another one posts it
+const claimTimeout = 2 * time.Minute
+
+// slackResponse is the answer of the slack api, failures come with ok false and 200
+type slackResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// slackMessage returns the slack message of the card, the blocks sit in an attachment so the priority colors the bar.
+// Every value of the alert is escaped, so names like <!channel> are shown as written
+func slackMessage(card *Card) map[string]interface{} {
+	fields := []map[string]interface{}{
+		{"type": "mrkdwn", "text": "*Priority*\n" + slackEscape(card.Priority)},
+		{"type": "mrkdwn", "text": "*Status*\n" + slackEscape(card.Status)},
+		{"type": "mrkdwn", "text": "*Location*\n" + slackEscape(orDash(card.Location))},
+		{"type": "mrkdwn", "text": "*Job*\n" + slackEscape(orDash(card.JobName))},
+		{"type": "mrkdwn", "text": "*Type*\n" + slackEscape(orDash(card.Type))},
+		{"type": "mrkdwn", "text": "*Assigned to*\n" + slackEscape(orDash(card.Assignees))},
+	}
+
+	title := "*" + slackEscape(card.Title) + "*"
+	if len(card.Link) > 0 {
+		title = "*<" + slackEscape(card.Link) + "|" + slackEscape(card.Title) + ">*"
+	}
+
+	blocks := []map[string]interface{}{
+		{"type": "section", "text": map[string]interface{}{"type": "mrkdwn", "text": title}},
+		{"type": "section", "fields": fields},
+	}
+	if len(card.Description) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": slackEscape(card.Description)},
+		})
+	}
+
+	return map[string]interface{}{
+		"text":        slackEscape(card.fallbackText()),
+		"attachments": []map[string]interface{}{{"color": card.Color, "blocks": blocks}},
+	}
+}
+
+// postSlackWebhook posts the card as a new message, incoming webhooks cannot edit what they posted
+func postSlackWebhook(integration *Integration, card *Card) error {
+	_, err := postJSON(integration.WebhookURL, "", slackMessage(card))
+	return err
+}
+
+// postSlackAPI posts the first card of the alert and edits that message on every later change.
+// The message of the alert is claimed before it is posted, so of the instances posting the same alert
+// only one posts it and the others retry until they can edit it
+func postSlackAPI(integration *Integration, alert *common.Alert, card *Card) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.ChatMessageCollection)
+
+	message := slackMessage(card)
+
+	posted, claimed, err := claimMessage(c, integration, alert, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if !claimed {
+		message["channel"] = posted.Channel
+		message["ts"] = posted.TS
+		_, err = callSlack(integration, "chat.update", message)
+		if err == nil {
+			return c.UpdateId(posted.ID, bson.M{"$set": bson.M{"updatedAt": time.Now().UTC()}})
+		}
+		// the message was deleted in slack, a new one is posted instead
+		if err.Error() != "slack: message_not_found" {
+			return err
+		}
+		err = c.Update(bson.M{"_id": posted.ID, "ts": posted.TS}, bson.M{"$set": bson.M{"ts": "", "claimedAt": time.Now().UTC()}})
+		if err == mgo.ErrNotFound {
+			return errors.New("the message of alert " + alert.ID.Hex() + " is posted again by another instance")
+		}
+		if err != nil {
+			return err
+		}
+		delete(message, "ts")
+	}
+
+	message["channel"] = integration.Channel
+	reply, err := callSlack(integration, "chat.postMessage", message)
+	if err != nil {
+		// the next attempt may claim the message right away
+		if releaseErr := c.Update(bson.M{"_id": posted.ID, "ts": ""}, bson.M{"$unset": bson.M{"claimedAt": ""}}); releaseErr != nil && releaseErr != mgo.ErrNotFound {
+			log.Errorf("Failed to release the message of alert %s, error: %v", alert.ID.Hex(), releaseErr)
+		}
+		return err
+	}
+
+	return c.UpdateId(posted.ID, bson.M{
+		"$set":   bson.M{"channel": reply.Channel, "ts": reply.TS, "updatedAt": time.Now().UTC()},
+		"$unset": bson.M{"claimedAt": ""},
+	})
+}
+
+// claimMessage returns the message of the alert and whether the instance has to post it. A message without ts
+// is being posted, the instance takes it over once the claim of another instance is older than claimTimeout
+// and fails so the post is retried otherwise
+func claimMessage(c *mgo.Collection, integration *Integration, alert *common.Alert, now time.Time) (*Message, bool, error) {
+	key := bson.M{"integrationId": integration.ID.Hex(), "alertId": alert.ID.Hex()}
+	info, err := c.Upsert(key, bson.M{"$setOnInsert": bson.M{"ts": "", "claimedAt": now, "createdAt": now, "updatedAt": now}})
+	if err != nil && !mgo.IsDup(err) {
+		return nil, false, err
+	}
+	if err == nil && info.UpsertedId != nil {
+		return &Message{ID: info.UpsertedId.(bson.ObjectId), IntegrationID: integration.ID.Hex(), AlertID: alert.ID.Hex()}, true, nil
+	}
+
+	posted := Message{}
+	err = c.Find(key).One(&posted)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(posted.TS) > 0 {
+		return &posted, false, nil
+	}
+
+	err = c.Update(bson.M{"_id": posted.ID, "ts": "", "claimedAt": bson.M{"$not": bson.M{"$gte": now.Add(-claimTimeout)}}}, bson.M{"$set": bson.M{"claimedAt": now}})
+	if err == mgo.ErrNotFound {
+		return nil, false, errors.New("the message of alert " + alert.ID.Hex() + " is being posted by another instance")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &posted, true, nil
+}
+
+// callSlack calls a method of the slack web api with the bot token of the integration
+func callSlack(integration *Integration, method string, body interface{}) (*slackResponse, error) {
+	base := config.GetConfig().GetString("chat.slack.api_url")
+	if len(base) == 0 {
+		base = defaultSlackAPI
+	}
+
+	data, err := postJSON(base+"/"+method, integration.BotToken, body)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := slackResponse{}
+	err = json.Unmarshal(data, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if !reply.OK {
+		return nil, errors.New("slack: " + reply.Error)
+	}
+
+	return &reply, nil
+}
+
+// slackEscaper escapes the characters slack reads as markup
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackEscape escapes the text for mrkdwn fields
+func slackEscape(text string) string {
+	return slackEscaper.Replace(text)
+}
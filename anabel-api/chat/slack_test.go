@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+)
+
+func TestSlackMessageEscapesEveryField(t *testing.T) {
+	config.SetConfig(viper.New())
+
+	alert := common.Alert{
+		ID:          bson.NewObjectId(),
+		Status:      common.AlertStatusNew,
+		Type:        "<!here>",
+		Priority:    common.AlertPriorityHigh,
+		Location:    "<https://evil.example|click>",
+		JobName:     "<!channel>",
+		Description: "<@U123> & co",
+		AssignedTo:  []common.SimpleUser{{FirstName: "<!everyone>", FamilyName: "Doe"}},
+	}
+
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(slackMessage(cardOf(&alert)))
+	if err != nil {
+		t.Fatalf("cannot marshal the message: %v", err)
+	}
+	message := buffer.String()
+	for _, markup := range []string{"<!channel>", "<!here>", "<!everyone>", "<https://evil.example", "<@U123>"} {
+		if strings.Contains(message, markup) {
+			t.Errorf("%s reaches slack unescaped: %s", markup, message)
+		}
+	}
+	if !strings.Contains(message, "&lt;!channel&gt;") || !strings.Contains(message, "&amp; co") {
+		t.Errorf("the values are not shown escaped: %s", message)
+	}
+}
+
+func TestValidURLRefusesPrivateAddresses(t *testing.T) {
+	v := viper.New()
+	v.Set("chat.allow_http", true)
+	config.SetConfig(v)
+
+	for _, raw := range []string{"http://127.0.0.1/hook", "https://169.254.169.254/latest", "https://172.16.0.5/hook", "https://[fe80::1]/hook"} {
+		if validURL(raw) {
+			t.Errorf("%s is accepted", raw)
+		}
+	}
+	if !validURL("https://93.184.216.34/services/T000/B000/XXX") {
+		t.Error("a public address is refused")
+	}
+}
@@ -0,0 +1,60 @@
+package chat
+
+import "anacove.com/backend/common"
+
+// teamsMessage returns the teams message of the card as an adaptive card
+func teamsMessage(card *Card) map[string]interface{} {
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "text": card.Title, "weight": "Bolder", "size": "Medium", "wrap": true, "color": teamsColor(card)},
+		{"type": "FactSet", "facts": []map[string]string{
+			{"title": "Priority", "value": card.Priority},
+			{"title": "Status", "value": card.Status},
+			{"title": "Location", "value": orDash(card.Location)},
+			{"title": "Job", "value": orDash(card.JobName)},
+			{"title": "Type", "value": orDash(card.Type)},
+			{"title": "Assigned to", "value": orDash(card.Assignees)},
+		}},
+	}
+	if len(card.Description) > 0 {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": card.Description, "wrap": true})
+	}
+
+	content := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if len(card.Link) > 0 {
+		content["actions"] = []map[string]interface{}{{"type": "Action.OpenUrl", "title": "Open alert", "url": card.Link}}
+	}
+
+	return map[string]interface{}{
+		"type":    "message",
+		"summary": card.fallbackText(),
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     content,
+		}},
+	}
+}
+
+// postTeams posts the card as a new message, teams incoming webhooks cannot edit what they posted
+func postTeams(integration *Integration, card *Card) error {
+	_, err := postJSON(integration.WebhookURL, "", teamsMessage(card))
+	return err
+}
+
+// teamsColor maps the card color to the named colors of adaptive cards
+func teamsColor(card *Card) string {
+	switch card.Color {
+	case priorityColors[common.AlertPriorityHigh]:
+		return "Attention"
+	case priorityColors[common.AlertPriorityMedium]:
+		return "Warning"
+	case clearedColor:
+		return "Default"
+	}
+
+	return "Accent"
+}
@@ -15,6 +15,52 @@ const (
 	ClientCollection string = "clients"
 	// SiteCollection refers to the sites collection in MongoDB
 	SiteCollection string = "sites"
+	// FileCollection refers to the files collection in MongoDB
+	FileCollection string = "files"
+	// UploadSessionCollection refers to the upload sessions collection in MongoDB
+	UploadSessionCollection string = "uploadSessions"
+	// OutboxCollection refers to the outbox messages collection in MongoDB
+	OutboxCollection string = "outbox"
+	// NotificationCollection refers to the in-app notifications collection in MongoDB
+	NotificationCollection string = "notifications"
+	// AlertCollection refers to the alerts collection in MongoDB
+	AlertCollection string = "alerts"
+	// IntegrationCollection refers to the site chat integrations collection in MongoDB
+	IntegrationCollection string = "integrations"
+	// ChatMessageCollection refers to the chat messages posted about alerts in MongoDB
+	ChatMessageCollection string = "chatMessages"
+	// WebhookCollection refers to the client webhook subscriptions collection in MongoDB
+	WebhookCollection string = "webhooks"
+	// WebhookDeliveryCollection refers to the webhook delivery log collection in MongoDB
+	WebhookDeliveryCollection string = "webhookDeliveries"
+	// SuppressionCollection refers to the suppressed email addresses collection in MongoDB
+	SuppressionCollection string = "suppressions"
+	// EscalationPolicyCollection refers to the alert escalation policies collection in MongoDB
+	EscalationPolicyCollection string = "escalationPolicies"
+	// RoutingRuleCollection refers to the alert routing rules of the sites in MongoDB
+	RoutingRuleCollection string = "routingRules"
+	// AlertHistoryCollection refers to the append-only change history of the alerts in MongoDB
+	AlertHistoryCollection string = "alertHistory"
+	// AlertCommentCollection refers to the comments on the alerts in MongoDB
+	AlertCommentCollection string = "alertComments"
+	// AlertFingerprintCollection refers to how often the sources of the alerts raised new alerts in MongoDB
+	AlertFingerprintCollection string = "alertFingerprints"
+	// CorrelationRuleCollection refers to the rules grouping the alerts of the sites into incidents in MongoDB
+	CorrelationRuleCollection string = "correlationRules"
+	// IncidentCollection refers to the incidents grouping related alerts in MongoDB
+	IncidentCollection string = "incidents"
+	// MaintenanceWindowCollection refers to the maintenance windows of the sites in MongoDB
+	MaintenanceWindowCollection string = "maintenanceWindows"
+	// AlertRuleSetCollection refers to the versions of the alert rules of the sites in MongoDB
+	AlertRuleSetCollection string = "alertRuleSets"
+	// TelemetryCollection refers to the device readings reported by other services in MongoDB
+	TelemetryCollection string = "telemetry"
+	// TelemetryStateCollection refers to the latest reading of every metric of the devices in MongoDB
+	TelemetryStateCollection string = "telemetryStates"
+	// TelemetryLeaseCollection refers to the leases of the instances evaluating the readings of the sites in MongoDB
+	TelemetryLeaseCollection string = "telemetryLeases"
+	// SLATargetCollection refers to the response time targets of the clients in MongoDB
+	SLATargetCollection string = "slaTargets"
 	// SortOrderAsc godoc
 	SortOrderAsc = "asc"
 	// SortOrderDesc godoc
@@ -31,4 +77,50 @@ const (
 	ReousrceClient = "client"
 	// ReousrceUser resource name
 	ReousrceUser = "user"
+	// ReousrceSite resource name
+	ReousrceSite = "site"
+	// FilePurposeLogo godoc
+	FilePurposeLogo = "logo"
+	// FilePurposeFloorPlan godoc
+	FilePurposeFloorPlan = "floorPlan"
+	// FilePurposeProfilePicture godoc
+	FilePurposeProfilePicture = "profilePicture"
+	// FilePurposeTVTheftAudio godoc
+	FilePurposeTVTheftAudio = "tvTheftAudio"
+	// FilePurposeAlertPhoto godoc
+	FilePurposeAlertPhoto = "alertPhoto"
+	// DeliverabilityDelivered the last email to the user was delivered
+	DeliverabilityDelivered = "delivered"
+	// DeliverabilitySoftBounced the last email to the user bounced temporarily, it is still retried
+	DeliverabilitySoftBounced = "softBounced"
+	// DeliverabilityBounced the address of the user bounced permanently and is suppressed
+	DeliverabilityBounced = "bounced"
+	// DeliverabilityComplained the user marked an email as spam and the address is suppressed
+	DeliverabilityComplained = "complained"
+	// AlertTypeStaffAlert godoc
+	AlertTypeStaffAlert = "Staff Alert"
+	// AlertTypeNotification godoc
+	AlertTypeNotification = "Notification"
+	// AlertTypeSystemAlert godoc
+	AlertTypeSystemAlert = "System Alert"
+	// AlertStatusNew the alert waits to be assigned
+	AlertStatusNew = "New"
+	// AlertStatusActive the alert is assigned and worked on
+	AlertStatusActive = "Active"
+	// AlertStatusCleared the alert is resolved
+	AlertStatusCleared = "Cleared"
+	// AlertPriorityLow godoc
+	AlertPriorityLow = "Low"
+	// AlertPriorityMedium godoc
+	AlertPriorityMedium = "Medium"
+	// AlertPriorityHigh godoc
+	AlertPriorityHigh = "High"
+	// InvitationPending the invitation waits to be accepted
+	InvitationPending = "pending"
+	// InvitationAccepted the user activated the account
+	InvitationAccepted = "accepted"
+	// InvitationExpired the invitation was not accepted in time, it is derived from a pending invitation past its expiry
+	InvitationExpired = "expired"
+	// InvitationRevoked the invitation was withdrawn before it was accepted
+	InvitationRevoked = "revoked"
 )
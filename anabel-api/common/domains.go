@@ -9,48 +9,61 @@ import (
 //User godoc
 // @Summary The User entity.
 type User struct {
-	ID                     bson.ObjectId `json:"id" bson:"_id,omitempty"`
-	Email                  string        `json:"email" bson:"email"`
-	Token                  string        `json:"-" bson:"token"`
-	ActivationCode         string        `json:"-" bson:"activationCode"`
-	Status                 string        `json:"status" bson:"status"`
-	FirstName              string        `json:"firstName" bson:"firstName"`
-	FamilyName             string        `json:"familyName" bson:"familyName"`
-	ProfileURL             string        `json:"profileUrl" bson:"profileUrl"`
-	Position               string        `json:"position" bson:"position"`
-	Phone                  string        `json:"phone" bson:"phone"`
-	NotificationPreference string        `json:"notificationPreference" bson:"notificationPreference"`
-	SiteID                 string        `json:"siteId" bson:"siteId"`
-	SiteTagID              int           `json:"siteTagId" bson:"siteTagId"`
-	SiteUserType           string        `json:"siteUserType" bson:"siteUserType"`
-	AdminUserType          string        `json:"adminUserType" bson:"adminUserType"`
-	SiteGroupName          string        `json:"siteUserGroup" bson:"siteUserGroup"`
-	ClientID               string        `json:"clientId" bson:"clientId"`
-	Password               string        `json:"-" bson:"password"`
-	CreatedAt              time.Time     `json:"createdAt" bson:"createdAt"`
-	UpdatedAt              time.Time     `json:"updatedAt" bson:"updatedAt"`
-	LastLoginAt            time.Time     `json:"lastLoginAt" bson:"lastLoginAt"`
-	Permission             []Permission  `json:"permissions" bson:"permissions"`
+	ID                     bson.ObjectId      `json:"id" bson:"_id,omitempty"`
+	Email                  string             `json:"email" bson:"email"`
+	Token                  string             `json:"-" bson:"token"`
+	ActivationCode         string             `json:"-" bson:"activationCode"`
+	Status                 string             `json:"status" bson:"status"`
+	FirstName              string             `json:"firstName" bson:"firstName"`
+	FamilyName             string             `json:"familyName" bson:"familyName"`
+	ProfileURL             string             `json:"profileUrl" bson:"profileUrl"`
+	ProfileVariants        []ImageVariant     `json:"profileVariants" bson:"profileVariants"`
+	Position               string             `json:"position" bson:"position"`
+	Phone                  string             `json:"phone" bson:"phone"`
+	NotificationPreference string             `json:"notificationPreference" bson:"notificationPreference"`
+	Locale                 string             `json:"locale" bson:"locale"`
+	TimeZone               string             `json:"timeZone" bson:"timeZone"`
+	QuietHours             *QuietHours        `json:"quietHours,omitempty" bson:"quietHours,omitempty"`
+	NotificationMatrix     []NotificationRule `json:"notificationMatrix" bson:"notificationMatrix"`
+	Digest                 *DigestSchedule    `json:"digest,omitempty" bson:"digest,omitempty"`
+	Shifts                 []Shift            `json:"shifts" bson:"shifts,omitempty"`
+	UserGroups             []string           `json:"userGroups" bson:"userGroups"`
+	Deliverability         string             `json:"deliverability" bson:"deliverability"`
+	DeliverabilityReason   string             `json:"deliverabilityReason" bson:"deliverabilityReason"`
+	DeliverabilityAt       time.Time          `json:"deliverabilityAt" bson:"deliverabilityAt,omitempty"`
+	Invitation             *Invitation        `json:"invitation,omitempty" bson:"invitation,omitempty"`
+	SiteID                 string             `json:"siteId" bson:"siteId"`
+	SiteTagID              int                `json:"siteTagId" bson:"siteTagId"`
+	SiteUserType           string             `json:"siteUserType" bson:"siteUserType"`
+	AdminUserType          string             `json:"adminUserType" bson:"adminUserType"`
+	SiteGroupName          string             `json:"siteUserGroup" bson:"siteUserGroup"`
+	ClientID               string             `json:"clientId" bson:"clientId"`
+	Password               string             `json:"-" bson:"password"`
+	CreatedAt              time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt              time.Time          `json:"updatedAt" bson:"updatedAt"`
+	LastLoginAt            time.Time          `json:"lastLoginAt" bson:"lastLoginAt"`
+	Permission             []Permission       `json:"permissions" bson:"permissions"`
 }
 
 //Client godoc
 // @Summary The Client entity.
 type Client struct {
-	ID             bson.ObjectId `json:"id" bson:"_id,omitempty"`
-	UID            int64         `json:"uid" bson:"uid"`
-	LogoURL        string        `json:"logoUrl" bson:"logoUrl"`
-	Name           string        `json:"name" bson:"name"`
-	Address        Address       `json:"address" bson:"address"`
-	FullAddress    string        `json:"fullAddress" bson:"fullAddress"`
-	BillingAddress Address       `json:"billingAddress" bson:"billingAddress"`
-	NumberOfAlerts int           `json:"numberOfAlerts" bson:"numberOfAlerts"`
-	NumberOfUsers  int           `json:"numberOfUsers" bson:"numberOfUsers"`
-	NumberOfSites  int           `json:"numberOfSites" bson:"numberOfSites"`
-	Status         string        `json:"status" bson:"status"`
-	CreatedOn      time.Time     `json:"createdOdn" bson:"createdOdn"`
-	UpdatedOn      time.Time     `json:"updatedOn" bson:"updatedOn"`
-	Contacts       []string      `json:"contacts" bson:"contacts"`
-	AdminUsers     []string      `json:"adminUsers" bson:"adminUsers"`
+	ID             bson.ObjectId  `json:"id" bson:"_id,omitempty"`
+	UID            int64          `json:"uid" bson:"uid"`
+	LogoURL        string         `json:"logoUrl" bson:"logoUrl"`
+	LogoVariants   []ImageVariant `json:"logoVariants" bson:"logoVariants"`
+	Name           string         `json:"name" bson:"name"`
+	Address        Address        `json:"address" bson:"address"`
+	FullAddress    string         `json:"fullAddress" bson:"fullAddress"`
+	BillingAddress Address        `json:"billingAddress" bson:"billingAddress"`
+	NumberOfAlerts int            `json:"numberOfAlerts" bson:"numberOfAlerts"`
+	NumberOfUsers  int            `json:"numberOfUsers" bson:"numberOfUsers"`
+	NumberOfSites  int            `json:"numberOfSites" bson:"numberOfSites"`
+	Status         string         `json:"status" bson:"status"`
+	CreatedOn      time.Time      `json:"createdOdn" bson:"createdOdn"`
+	UpdatedOn      time.Time      `json:"updatedOn" bson:"updatedOn"`
+	Contacts       []string       `json:"contacts" bson:"contacts"`
+	AdminUsers     []string       `json:"adminUsers" bson:"adminUsers"`
 	Configuration  struct {
 		FS             FS `json:"FS" bson:"FS"`
 		TFS            FS `json:"TFS" bson:"TFS"`
@@ -113,3 +126,165 @@ type Scope struct {
 	Resource []string `bson:"resources"`
 	Ids      []string `bson:"ids"`
 }
+
+//File godoc
+// @Summary The File entity, keeps track of every uploaded object and its owners.
+type File struct {
+	ID           bson.ObjectId  `json:"id" bson:"_id,omitempty"`
+	Name         string         `json:"name" bson:"name"`
+	OriginalName string         `json:"originalName" bson:"originalName"`
+	URL          string         `json:"url" bson:"url"`
+	Purpose      string         `json:"purpose" bson:"purpose"`
+	ContentType  string         `json:"contentType" bson:"contentType"`
+	Size         int64          `json:"size" bson:"size"`
+	UploadedBy   string         `json:"uploadedBy" bson:"uploadedBy"`
+	ClientID     string         `json:"clientId" bson:"clientId"`
+	SiteID       string         `json:"siteId" bson:"siteId"`
+	Width        int            `json:"width,omitempty" bson:"width,omitempty"`
+	Height       int            `json:"height,omitempty" bson:"height,omitempty"`
+	Variants     []ImageVariant `json:"variants,omitempty" bson:"variants,omitempty"`
+	CreatedAt    time.Time      `json:"createdAt" bson:"createdAt"`
+}
+
+//ImageVariant godoc
+// @Summary The resized copy of an uploaded image.
+type ImageVariant struct {
+	Size   int    `json:"size" bson:"size"`
+	Name   string `json:"name" bson:"name"`
+	URL    string `json:"url" bson:"url"`
+	Width  int    `json:"width" bson:"width"`
+	Height int    `json:"height" bson:"height"`
+}
+
+//Invitation godoc
+// @Summary The invitation of a user to activate the account, contacts are not invited.
+type Invitation struct {
+	Status     string    `json:"status" bson:"status"`
+	SentCount  int       `json:"sentCount" bson:"sentCount"`
+	SentAt     time.Time `json:"sentAt" bson:"sentAt,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt" bson:"expiresAt"`
+	AcceptedAt time.Time `json:"acceptedAt" bson:"acceptedAt,omitempty"`
+	RevokedAt  time.Time `json:"revokedAt" bson:"revokedAt,omitempty"`
+	RevokedBy  string    `json:"revokedBy" bson:"revokedBy,omitempty"`
+}
+
+//QuietHours godoc
+// @Summary The daily period in the time zone of the user when no text messages are sent, like 22:00 to 07:00.
+type QuietHours struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+//Shift godoc
+// @Summary The working hours of a user on the weekdays (0 is sunday) in the time zone of the user, like 22:00 to 06:00.
+type Shift struct {
+	Weekdays []int  `json:"weekdays" bson:"weekdays"`
+	Start    string `json:"start" bson:"start"`
+	End      string `json:"end" bson:"end"`
+}
+
+//NotificationRule godoc
+// @Summary The row of the notification matrix, the channels a user is notified on about an alert type from a priority on.
+type NotificationRule struct {
+	AlertType   string   `json:"alertType" bson:"alertType"`
+	Channels    []string `json:"channels" bson:"channels"`
+	MinPriority string   `json:"minPriority" bson:"minPriority"`
+}
+
+//DigestSchedule godoc
+// @Summary When the user gets the alert summary email, the hour and weekday are in the time zone of the user.
+type DigestSchedule struct {
+	Frequency        string    `json:"frequency" bson:"frequency"`
+	Hour             int       `json:"hour" bson:"hour"`
+	Weekday          int       `json:"weekday" bson:"weekday"`
+	LastPeriodEnd    time.Time `json:"lastPeriodEnd" bson:"lastPeriodEnd,omitempty"`
+	UnsubscribeToken string    `json:"-" bson:"unsubscribeToken,omitempty"`
+}
+
+//SimpleUser godoc
+// @Summary The short form of a user shown on other entities.
+type SimpleUser struct {
+	ID         string `json:"id" bson:"id"`
+	Email      string `json:"email" bson:"email"`
+	FirstName  string `json:"firstName" bson:"firstName"`
+	FamilyName string `json:"familyName" bson:"familyName"`
+	ProfileURL string `json:"profileUrl" bson:"profileUrl"`
+}
+
+//Alert godoc
+// @Summary The Alert entity, raised at a site by a device or the staff.
+type Alert struct {
+	ID                  bson.ObjectId     `json:"id" bson:"_id,omitempty"`
+	SiteID              string            `json:"siteId" bson:"siteId"`
+	ClientID            string            `json:"clientId" bson:"clientId"`
+	Status              string            `json:"status" bson:"status"`
+	Type                string            `json:"type" bson:"type"`
+	AssignedTo          []SimpleUser      `json:"assignedTo" bson:"assignedTo"`
+	SiteManager         *SimpleUser       `json:"siteManager,omitempty" bson:"siteManager,omitempty"`
+	Priority            string            `json:"priority" bson:"priority"`
+	Location            string            `json:"location" bson:"location"`
+	Device              string            `json:"device" bson:"device"`
+	DeviceModel         string            `json:"deviceModel" bson:"deviceModel"`
+	JobName             string            `json:"jobName" bson:"jobName"`
+	Description         string            `json:"description" bson:"description"`
+	JobAge              float64           `json:"jobAge" bson:"jobAge"`
+	StaffID             string            `json:"staffId" bson:"staffId"`
+	Reason              string            `json:"reason" bson:"reason"`
+	Detailed            string            `json:"detailed" bson:"detailed"`
+	AlertTime           time.Time         `json:"alertTime" bson:"alertTime"`
+	AssignTime          time.Time         `json:"assginTime" bson:"assginTime,omitempty"`
+	ClearTime           time.Time         `json:"clearTime" bson:"clearTime,omitempty"`
+	PendingSince        time.Time         `json:"pendingSince" bson:"pendingSince,omitempty"`
+	EscalationLevel     int               `json:"escalationLevel" bson:"escalationLevel"`
+	Escalations         []AlertEscalation `json:"escalations" bson:"escalations"`
+	RoutingRuleID       string            `json:"routingRuleId,omitempty" bson:"routingRuleId,omitempty"`
+	RoutedAt            time.Time         `json:"routedAt" bson:"routedAt,omitempty"`
+	Fingerprint         string            `json:"fingerprint,omitempty" bson:"fingerprint,omitempty"`
+	Occurrences         int               `json:"occurrences" bson:"occurrences,omitempty"`
+	LastSeen            time.Time         `json:"lastSeen" bson:"lastSeen,omitempty"`
+	IncidentID          string            `json:"incidentId,omitempty" bson:"incidentId,omitempty"`
+	MergedInto          string            `json:"mergedInto,omitempty" bson:"mergedInto,omitempty"`
+	Suppressed          bool              `json:"suppressed" bson:"suppressed,omitempty"`
+	DowngradedFrom      string            `json:"downgradedFrom,omitempty" bson:"downgradedFrom,omitempty"`
+	MaintenanceWindowID string            `json:"maintenanceWindowId,omitempty" bson:"maintenanceWindowId,omitempty"`
+	AlertRuleID         string            `json:"alertRuleId,omitempty" bson:"alertRuleId,omitempty"`
+	AlertRuleVersion    int               `json:"alertRuleVersion,omitempty" bson:"alertRuleVersion,omitempty"`
+	UpdatedAt           time.Time         `json:"updatedAt" bson:"updatedAt"`
+}
+
+//AlertComment godoc
+// @Summary The note left on an alert, a reply names the comment it answers.
+type AlertComment struct {
+	ID          bson.ObjectId       `json:"id" bson:"_id,omitempty"`
+	AlertID     string              `json:"alertId" bson:"alertId"`
+	SiteID      string              `json:"siteId" bson:"siteId"`
+	ClientID    string              `json:"clientId" bson:"clientId"`
+	ParentID    string              `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	Text        string              `json:"text" bson:"text"`
+	Author      SimpleUser          `json:"author" bson:"author"`
+	Mentions    []SimpleUser        `json:"mentions" bson:"mentions"`
+	Attachments []CommentAttachment `json:"attachments" bson:"attachments"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+//CommentAttachment godoc
+// @Summary The photo uploaded for a comment, served by the file routes.
+type CommentAttachment struct {
+	FileID      string         `json:"fileId" bson:"fileId"`
+	Name        string         `json:"name" bson:"name"`
+	URL         string         `json:"url" bson:"url"`
+	ContentType string         `json:"contentType" bson:"contentType"`
+	Variants    []ImageVariant `json:"variants,omitempty" bson:"variants,omitempty"`
+}
+
+//AlertEscalation godoc
+// @Summary The tier of an escalation policy reached by an alert, kept on the alert timeline.
+type AlertEscalation struct {
+	Tier         int       `json:"tier" bson:"tier"`
+	PolicyID     string    `json:"policyId" bson:"policyId"`
+	Target       string    `json:"target" bson:"target"`
+	AfterMinutes int       `json:"afterMinutes" bson:"afterMinutes"`
+	Paged        bool      `json:"paged" bson:"paged"`
+	Notified     []string  `json:"notified" bson:"notified"`
+	At           time.Time `json:"at" bson:"at"`
+}
@@ -24,3 +24,8 @@ func Init() {
 func GetConfig() *viper.Viper {
 	return config
 }
+
+// SetConfig replaces the configuration, tests use it with values set in code
+func SetConfig(c *viper.Viper) {
+	config = c
+}
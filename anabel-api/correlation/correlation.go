@@ -0,0 +1,394 @@
+package correlation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// GroupByFloor groups the alerts of rooms on the same floor
+	GroupByFloor = "floor"
+	// GroupByBuilding groups the alerts of rooms in the same building
+	GroupByBuilding = "building"
+	// GroupByDeviceModel groups the alerts of devices of the same model
+	GroupByDeviceModel = "deviceModel"
+)
+
+// GroupBys lists what the alerts of a rule can share besides their site
+var GroupBys = []string{GroupByFloor, GroupByBuilding, GroupByDeviceModel}
+
+// maxWindowMinutes limits the time window of a rule to a day
+const maxWindowMinutes = 24 * 60
+
+// defaultPollInterval is used when correlation.poll_interval_in_seconds is not configured
+const defaultPollInterval = 30 * time.Second
+
+// Rule godoc
+// groups the open alerts of a site of the alert types which share the floor, building or device model
+// and were raised within the window into an incident, once there are at least MinAlerts of them.
+// An empty group by groups the alerts of the whole site, rules apply in their order
+type Rule struct {
+	ID            bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID      string        `json:"clientId" bson:"clientId"`
+	SiteID        string        `json:"siteId" bson:"siteId"`
+	Name          string        `json:"name" bson:"name"`
+	Order         int           `json:"order" bson:"order"`
+	AlertTypes    []string      `json:"alertTypes" bson:"alertTypes"`
+	GroupBy       []string      `json:"groupBy" bson:"groupBy"`
+	WindowMinutes int           `json:"windowMinutes" bson:"windowMinutes"`
+	MinAlerts     int           `json:"minAlerts" bson:"minAlerts"`
+	Enabled       bool          `json:"enabled" bson:"enabled"`
+	CreatedBy     string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt     time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Incident godoc
+// groups the related alerts of a site, its status is its own: clearing the incident clears its alerts
+// but the alerts can be cleared on their own too. Key identifies the rule and shared values the incident was grouped by
+type Incident struct {
+	ID             bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID       string        `json:"clientId" bson:"clientId"`
+	SiteID         string        `json:"siteId" bson:"siteId"`
+	RuleID         string        `json:"ruleId" bson:"ruleId"`
+	Key            string        `json:"-" bson:"key"`
+	Title          string        `json:"title" bson:"title"`
+	Floor          string        `json:"floor,omitempty" bson:"floor,omitempty"`
+	Building       string        `json:"building,omitempty" bson:"building,omitempty"`
+	DeviceModel    string        `json:"deviceModel,omitempty" bson:"deviceModel,omitempty"`
+	Status         string        `json:"status" bson:"status"`
+	AlertCount     int           `json:"alertCount" bson:"alertCount"`
+	FirstAlertTime time.Time     `json:"firstAlertTime" bson:"firstAlertTime"`
+	LastAlertTime  time.Time     `json:"lastAlertTime" bson:"lastAlertTime"`
+	Reason         string        `json:"reason" bson:"reason"`
+	Detailed       string        `json:"detailed" bson:"detailed"`
+	ClearTime      time.Time     `json:"clearTime" bson:"clearTime,omitempty"`
+	UpdatedBy      string        `json:"updatedBy" bson:"updatedBy"`
+	CreatedAt      time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// place holds the floor and building of a room
+type place struct {
+	Floor    string
+	Building string
+}
+
+// Init creates the indexes used to find the rules of a site, the open incident of a group and the alerts of an incident
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.CorrelationRuleCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "order"}})
+	if err == nil {
+		err = session.DB("").C(common.IncidentCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "key", "status"}})
+	}
+	if err == nil {
+		err = session.DB("").C(common.AlertCollection).EnsureIndex(mgo.Index{Key: []string{"incidentId"}, Sparse: true})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create correlation indexes, error: %v", err)
+	}
+}
+
+// Start groups the open alerts into incidents in the background,
+// every instance of the api can run one as an alert only joins an incident while it has none
+func Start() {
+	interval := defaultPollInterval
+	if seconds := config.GetConfig().GetInt("correlation.poll_interval_in_seconds"); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(time.Now().UTC())
+		}
+	}()
+}
+
+// Validate checks the group by, window, minimum and alert types of the rule
+func (rule *Rule) Validate() error {
+	if len(rule.Name) == 0 {
+		return errors.New("a rule needs a name")
+	}
+	seen := map[string]bool{}
+	for _, groupBy := range rule.GroupBy {
+		if !utils.Contains(GroupBys, groupBy) {
+			return errors.New("unknown group by " + groupBy)
+		}
+		if seen[groupBy] {
+			return errors.New("group by " + groupBy + " is repeated")
+		}
+		seen[groupBy] = true
+	}
+	if rule.WindowMinutes < 1 || rule.WindowMinutes > maxWindowMinutes {
+		return fmt.Errorf("the window must be from 1 to %d minutes", maxWindowMinutes)
+	}
+	if rule.MinAlerts < 2 {
+		return errors.New("an incident groups at least 2 alerts")
+	}
+	for _, alertType := range rule.AlertTypes {
+		if !utils.Contains(notification.AlertTypes, alertType) {
+			return errors.New("unknown alert type " + alertType)
+		}
+	}
+
+	return nil
+}
+
+// keyOf returns the key of the incident the alert belongs to by the rule and the shared values,
+// alerts missing one of the values the rule groups by are not grouped
+func (rule *Rule) keyOf(alert *common.Alert, p place) (string, *Incident, bool) {
+	incident := Incident{SiteID: alert.SiteID, ClientID: alert.ClientID, RuleID: rule.ID.Hex()}
+	parts := []string{rule.ID.Hex()}
+	for _, groupBy := range GroupBys {
+		if !utils.Contains(rule.GroupBy, groupBy) {
+			continue
+		}
+
+		value := ""
+		switch groupBy {
+		case GroupByFloor:
+			value = p.Floor
+			incident.Floor = value
+		case GroupByBuilding:
+			value = p.Building
+			incident.Building = value
+		case GroupByDeviceModel:
+			value = alert.DeviceModel
+			incident.DeviceModel = value
+		}
+		if len(value) == 0 {
+			return "", nil, false
+		}
+		parts = append(parts, groupBy+"="+value)
+	}
+	incident.Key = strings.Join(parts, "|")
+
+	return incident.Key, &incident, true
+}
+
+// Matches checks the rule applies to the alert, rules only group the alerts raised after they were created and within their window
+func (rule *Rule) Matches(alert *common.Alert, now time.Time) bool {
+	return rule.Enabled && !alert.AlertTime.Before(rule.CreatedAt) &&
+		!alert.AlertTime.Before(now.Add(-time.Duration(rule.WindowMinutes)*time.Minute)) &&
+		(len(rule.AlertTypes) == 0 || utils.Contains(rule.AlertTypes, alert.Type))
+}
+
+// titleOf names the incident by its rule and the values its alerts share
+func titleOf(rule *Rule, incident *Incident) string {
+	values := []string{}
+	if len(incident.Building) > 0 {
+		values = append(values, "building "+incident.Building)
+	}
+	if len(incident.Floor) > 0 {
+		values = append(values, "floor "+incident.Floor)
+	}
+	if len(incident.DeviceModel) > 0 {
+		values = append(values, "model "+incident.DeviceModel)
+	}
+	if len(values) == 0 {
+		return rule.Name
+	}
+
+	return rule.Name + ", " + strings.Join(values, ", ")
+}
+
+// run groups the alerts of every site with enabled rules
+func run(now time.Time) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	rules := []Rule{}
+	err := session.DB("").C(common.CorrelationRuleCollection).Find(bson.M{"enabled": true}).Sort("siteId", "order", "createdAt").All(&rules)
+	if err != nil {
+		log.Errorf("Failed to load the correlation rules, error: %v", err)
+		return
+	}
+
+	bySite := map[string][]Rule{}
+	for _, rule := range rules {
+		bySite[rule.SiteID] = append(bySite[rule.SiteID], rule)
+	}
+	for siteID, siteRules := range bySite {
+		err = correlateSite(session, siteID, siteRules, now)
+		if err != nil {
+			log.Errorf("Failed to correlate the alerts of site %s, error: %v", siteID, err)
+		}
+	}
+}
+
+// correlateSite groups the open alerts of the site without incident by the first rule matching each of them.
+// A group joins the open incident with its key, or becomes a new incident once it has the minimum of alerts of its rule
+func correlateSite(session *mgo.Session, siteID string, rules []Rule, now time.Time) error {
+	window := 0
+	for _, rule := range rules {
+		if rule.WindowMinutes > window {
+			window = rule.WindowMinutes
+		}
+	}
+
+	// alerts are grouped once deduplication kept them
+	alerts := []common.Alert{}
+	err := session.DB("").C(common.AlertCollection).Find(bson.M{
+		"siteId":      siteID,
+		"status":      bson.M{"$ne": common.AlertStatusCleared},
+		"incidentId":  bson.M{"$exists": false},
+		"alertTime":   bson.M{"$gte": now.Add(-time.Duration(window) * time.Minute)},
+		"fingerprint": bson.M{"$exists": true},
+	}).Sort("alertTime").All(&alerts)
+	if err != nil || len(alerts) == 0 {
+		return err
+	}
+
+	places, err := placesOf(session, siteID)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{}
+	groups := map[string][]*common.Alert{}
+	incidents := map[string]*Incident{}
+	ruleOf := map[string]*Rule{}
+	for i := range alerts {
+		alert := &alerts[i]
+		for j := range rules {
+			rule := &rules[j]
+			if !rule.Matches(alert, now) {
+				continue
+			}
+			key, incident, ok := rule.keyOf(alert, places[alert.Location])
+			if !ok {
+				continue
+			}
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+				incidents[key] = incident
+				ruleOf[key] = rule
+			}
+			groups[key] = append(groups[key], alert)
+			break
+		}
+	}
+
+	for _, key := range keys {
+		err = group(session, ruleOf[key], incidents[key], groups[key], now)
+		if err != nil {
+			log.Errorf("Failed to group alerts into incident %s, error: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+// group adds the alerts to the open incident with the key, or creates it when there are enough alerts.
+// An incident another instance created for the same alerts meanwhile is joined instead
+func group(session *mgo.Session, rule *Rule, incident *Incident, alerts []*common.Alert, now time.Time) error {
+	c := session.DB("").C(common.IncidentCollection)
+
+	open := Incident{}
+	err := c.Find(bson.M{
+		"siteId":        incident.SiteID,
+		"key":           incident.Key,
+		"status":        bson.M{"$ne": common.AlertStatusCleared},
+		"lastAlertTime": bson.M{"$gte": alerts[0].AlertTime.Add(-time.Duration(rule.WindowMinutes) * time.Minute)},
+	}).Sort("-lastAlertTime").One(&open)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+
+	if err == mgo.ErrNotFound {
+		if len(alerts) < rule.MinAlerts {
+			return nil
+		}
+
+		// the oldest alert names the incident, so instances grouping the same alerts at once create it once
+		incident.ID = alerts[0].ID
+		incident.Title = titleOf(rule, incident)
+		incident.Status = common.AlertStatusNew
+		incident.FirstAlertTime = alerts[0].AlertTime
+		incident.LastAlertTime = alerts[0].AlertTime
+		incident.CreatedAt = now
+		incident.UpdatedAt = now
+		err = c.Insert(incident)
+		if mgo.IsDup(err) {
+			err = c.FindId(incident.ID).One(&open)
+			if err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else {
+			log.Infof("Rule %s raised incident %s", rule.ID.Hex(), incident.ID.Hex())
+			open = *incident
+		}
+	}
+
+	for _, alert := range alerts {
+		err = attach(session, &open, alert, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attach links the alert to the incident and counts it there, unless it joined another incident meanwhile
+func attach(session *mgo.Session, incident *Incident, alert *common.Alert, now time.Time) error {
+	err := session.DB("").C(common.AlertCollection).Update(
+		bson.M{"_id": alert.ID, "incidentId": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"incidentId": incident.ID.Hex()}},
+	)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return session.DB("").C(common.IncidentCollection).UpdateId(incident.ID, bson.M{
+		"$inc": bson.M{"alertCount": 1},
+		"$min": bson.M{"firstAlertTime": alert.AlertTime},
+		"$max": bson.M{"lastAlertTime": alert.AlertTime},
+		"$set": bson.M{"updatedAt": now},
+	})
+}
+
+// placesOf returns the floor and building of the rooms of the site by room number
+func placesOf(session *mgo.Session, siteID string) (map[string]place, error) {
+	places := map[string]place{}
+	if !bson.IsObjectIdHex(siteID) {
+		return places, nil
+	}
+
+	site := struct {
+		Rooms []struct {
+			Room     int    `bson:"room"`
+			Floor    string `bson:"floor"`
+			Building string `bson:"building"`
+		} `bson:"rooms"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).Select(bson.M{"rooms": 1}).One(&site)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, room := range site.Rooms {
+		places[strconv.Itoa(room.Room)] = place{Floor: room.Floor, Building: room.Building}
+	}
+
+	return places, nil
+}
@@ -0,0 +1,73 @@
+package correlation
+
+import (
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestKeyOf(t *testing.T) {
+	rule := &Rule{ID: bson.NewObjectId(), Name: "Leaks", GroupBy: []string{GroupByDeviceModel, GroupByFloor}}
+	alert := &common.Alert{SiteID: "tokyo", ClientID: "acme", DeviceModel: "WS-2"}
+
+	key, incident, ok := rule.keyOf(alert, place{Floor: "3", Building: "A"})
+	if !ok {
+		t.Fatal("an alert with every grouped value is not grouped")
+	}
+	if key != rule.ID.Hex()+"|floor=3|deviceModel=WS-2" || incident.Key != key {
+		t.Errorf("unexpected key %s", key)
+	}
+	if incident.SiteID != "tokyo" || incident.ClientID != "acme" || incident.RuleID != rule.ID.Hex() {
+		t.Errorf("unexpected incident %+v", incident)
+	}
+	if incident.Floor != "3" || incident.DeviceModel != "WS-2" || len(incident.Building) > 0 {
+		t.Errorf("the incident keeps values it was not grouped by %+v", incident)
+	}
+	if title := titleOf(rule, incident); title != "Leaks, floor 3, model WS-2" {
+		t.Errorf("unexpected title %s", title)
+	}
+
+	if _, _, ok := rule.keyOf(alert, place{Building: "A"}); ok {
+		t.Error("an alert of a room without a floor is grouped by floor")
+	}
+
+	other := &Rule{ID: bson.NewObjectId(), GroupBy: []string{GroupByDeviceModel, GroupByFloor}}
+	if otherKey, _, _ := other.keyOf(alert, place{Floor: "3"}); otherKey == key {
+		t.Error("two rules share a key")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	rule := &Rule{
+		Enabled:       true,
+		AlertTypes:    []string{common.AlertTypeSystemAlert},
+		WindowMinutes: 30,
+		CreatedAt:     now.Add(-time.Hour),
+	}
+
+	for _, test := range []struct {
+		name    string
+		alert   common.Alert
+		matches bool
+	}{
+		{"in the window", common.Alert{Type: common.AlertTypeSystemAlert, AlertTime: now.Add(-10 * time.Minute)}, true},
+		{"before the window", common.Alert{Type: common.AlertTypeSystemAlert, AlertTime: now.Add(-40 * time.Minute)}, false},
+		{"other type", common.Alert{Type: common.AlertTypeStaffAlert, AlertTime: now.Add(-10 * time.Minute)}, false},
+	} {
+		if rule.Matches(&test.alert, now) != test.matches {
+			t.Errorf("%s: matches should be %v", test.name, test.matches)
+		}
+	}
+
+	rule.CreatedAt = now.Add(-5 * time.Minute)
+	if rule.Matches(&common.Alert{Type: common.AlertTypeSystemAlert, AlertTime: now.Add(-10 * time.Minute)}, now) {
+		t.Error("an alert raised before the rule was created matches")
+	}
+	rule.Enabled = false
+	if rule.Matches(&common.Alert{Type: common.AlertTypeSystemAlert, AlertTime: now}, now) {
+		t.Error("a disabled rule matches")
+	}
+}
@@ -0,0 +1,339 @@
+package dedup
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/maintenance"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultPollInterval is used when dedup.poll_interval_in_seconds is not configured
+const defaultPollInterval = 5 * time.Second
+
+// defaultWindow is used for the alert types without a window in dedup.windows_in_minutes
+// when dedup.default_window_in_minutes is not configured
+const defaultWindow = 10 * time.Minute
+
+// defaultFlapThreshold and defaultFlapWindow are used when dedup.flap_threshold and dedup.flap_window_in_minutes are not configured
+const (
+	defaultFlapThreshold = 5
+	defaultFlapWindow    = 30 * time.Minute
+)
+
+// batchSize limits the alerts deduplicated in one pass, the rest wait for the next one
+const batchSize = 500
+
+// Fingerprint godoc
+// counts the new alerts a source raised since the start of its flap window,
+// the id is the fingerprint of the alerts and FlapAlertID the System Alert raised when the source started flapping
+type Fingerprint struct {
+	ID          string    `bson:"_id"`
+	SiteID      string    `bson:"siteId"`
+	WindowStart time.Time `bson:"windowStart"`
+	Count       int       `bson:"count"`
+	FlapAlertID string    `bson:"flapAlertId,omitempty"`
+	UpdatedAt   time.Time `bson:"updatedAt"`
+}
+
+// Init creates the indexes used to find the alerts to deduplicate and the open alert of a fingerprint
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.AlertCollection).EnsureIndex(mgo.Index{Key: []string{"fingerprint", "status"}})
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create dedup index, error: %v", err)
+	}
+}
+
+// Start deduplicates the alerts raised by other services in the background,
+// every instance of the api can run one as an alert is only taken while it has no fingerprint
+func Start() {
+	interval := defaultPollInterval
+	if seconds := config.GetConfig().GetInt("dedup.poll_interval_in_seconds"); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(time.Now().UTC())
+		}
+	}()
+}
+
+// FingerprintOf identifies the source of the alert, alerts of the same device in the same room for the same job are identical
+func FingerprintOf(alert *common.Alert) string {
+	sum := sha1.Sum([]byte(strings.Join([]string{alert.SiteID, alert.Device, alert.Location, alert.JobName}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// WindowOf returns how long after it was last seen an open alert of the type takes in the identical ones,
+// a zero window keeps every alert of the type
+func WindowOf(alertType string) time.Duration {
+	key := "dedup.windows_in_minutes." + strings.ToLower(alertType)
+	if config.GetConfig().IsSet(key) {
+		return time.Duration(config.GetConfig().GetInt(key)) * time.Minute
+	}
+	if config.GetConfig().IsSet("dedup.default_window_in_minutes") {
+		return time.Duration(config.GetConfig().GetInt("dedup.default_window_in_minutes")) * time.Minute
+	}
+
+	return defaultWindow
+}
+
+// flapSettings returns how many new alerts of a source within how long make it flapping
+func flapSettings() (int, time.Duration) {
+	threshold := defaultFlapThreshold
+	if count := config.GetConfig().GetInt("dedup.flap_threshold"); count > 0 {
+		threshold = count
+	}
+	window := defaultFlapWindow
+	if minutes := config.GetConfig().GetInt("dedup.flap_window_in_minutes"); minutes > 0 {
+		window = time.Duration(minutes) * time.Minute
+	}
+
+	return threshold, window
+}
+
+// run takes the open alerts without fingerprint from the oldest on, so the first of identical alerts stays open
+func run(now time.Time) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	alerts := []common.Alert{}
+	err := session.DB("").C(common.AlertCollection).Find(bson.M{
+		"fingerprint": bson.M{"$exists": false},
+		"status":      bson.M{"$ne": common.AlertStatusCleared},
+	}).Sort("alertTime").Limit(batchSize).All(&alerts)
+	if err != nil {
+		log.Errorf("Failed to load the alerts to deduplicate, error: %v", err)
+		return
+	}
+
+	for i := range alerts {
+		err = deduplicate(session, &alerts[i], now)
+		if err != nil {
+			log.Errorf("Failed to deduplicate alert %s, error: %v", alerts[i].ID.Hex(), err)
+		}
+	}
+}
+
+// deduplicate fingerprints the alert, applies the maintenance windows of its site and merges it into the flap alert
+// or the open alert of its source, an alert starting a new one counts towards the flap threshold of the source.
+// The alerts kept open are announced as created
+func deduplicate(session *mgo.Session, alert *common.Alert, now time.Time) error {
+	c := session.DB("").C(common.AlertCollection)
+
+	alert.Fingerprint = FingerprintOf(alert)
+	alert.Occurrences = 1
+	alert.LastSeen = alert.AlertTime
+	err := c.Update(bson.M{"_id": alert.ID, "fingerprint": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"fingerprint": alert.Fingerprint, "occurrences": alert.Occurrences, "lastSeen": alert.LastSeen},
+	})
+	if err == mgo.ErrNotFound {
+		// the alert was taken by another instance
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// alerts suppressed by a maintenance window are neither merged nor counted towards flapping
+	suppressed, err := maintenance.Apply(session, alert, now)
+	if suppressed || err != nil {
+		return err
+	}
+
+	fingerprint := Fingerprint{}
+	err = session.DB("").C(common.AlertFingerprintCollection).FindId(alert.Fingerprint).One(&fingerprint)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+
+	if len(fingerprint.FlapAlertID) > 0 {
+		merged, err := merge(session, alert, bson.M{"_id": bson.ObjectIdHex(fingerprint.FlapAlertID), "status": bson.M{"$ne": common.AlertStatusCleared}}, now)
+		if merged || err != nil {
+			return err
+		}
+	}
+
+	if window := WindowOf(alert.Type); window > 0 && alert.Status == common.AlertStatusNew {
+		merged, err := merge(session, alert, bson.M{
+			"_id":         bson.M{"$ne": alert.ID},
+			"fingerprint": alert.Fingerprint,
+			"status":      bson.M{"$in": []string{common.AlertStatusNew, common.AlertStatusActive}},
+			"alertTime":   bson.M{"$lte": alert.AlertTime},
+			"lastSeen":    bson.M{"$gte": alert.AlertTime.Add(-window)},
+		}, now)
+		if merged || err != nil {
+			return err
+		}
+	}
+
+	merged, err := countNew(session, alert, now)
+	if merged || err != nil {
+		return err
+	}
+
+	alerting.Emit(&alerting.Event{Type: alerting.EventCreated, Alert: alert, At: alert.AlertTime})
+	return nil
+}
+
+// merge counts the alert on the open alert matching the selector and clears it as merged into that alert.
+// Nothing is merged when there is no such alert or the alert was worked on meanwhile, it is only merged while it is still New
+// and not routed. The open alert is counted first so the occurrence is not lost, and uncounted when the alert cannot be merged
+func merge(session *mgo.Session, alert *common.Alert, selector bson.M, now time.Time) (bool, error) {
+	c := session.DB("").C(common.AlertCollection)
+
+	open := common.Alert{}
+	err := c.Find(selector).Sort("-lastSeen").One(&open)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = c.UpdateId(open.ID, bson.M{
+		"$inc": bson.M{"occurrences": 1},
+		"$max": bson.M{"lastSeen": alert.AlertTime},
+		"$set": bson.M{"updatedAt": now},
+	})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = c.Update(bson.M{"_id": alert.ID, "status": common.AlertStatusNew, "routedAt": bson.M{"$exists": false}}, bson.M{"$set": bson.M{
+		"status":     common.AlertStatusCleared,
+		"clearTime":  alert.AlertTime,
+		"reason":     "Merged",
+		"mergedInto": open.ID.Hex(),
+		"updatedAt":  now,
+	}})
+	if err != nil {
+		undo := c.UpdateId(open.ID, bson.M{"$inc": bson.M{"occurrences": -1}})
+		if undo != nil && undo != mgo.ErrNotFound {
+			log.Errorf("Failed to uncount alert %s on alert %s, error: %v", alert.ID.Hex(), open.ID.Hex(), undo)
+		}
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	log.Debugf("Merged alert %s into alert %s", alert.ID.Hex(), open.ID.Hex())
+	return true, nil
+}
+
+// countNew counts the alert in the flap window of its source, the source reaching the threshold is flapping
+// and gets one System Alert which takes in its next alerts until it is cleared. It tells whether the alert was merged into it.
+// The count is incremented in the database, so of the instances counting alerts of the same source at the same time
+// only the one crossing the threshold raises the System Alert
+func countNew(session *mgo.Session, alert *common.Alert, now time.Time) (bool, error) {
+	c := session.DB("").C(common.AlertFingerprintCollection)
+	threshold, window := flapSettings()
+
+	// an expired window starts over with the alert
+	err := c.Update(bson.M{"_id": alert.Fingerprint, "windowStart": bson.M{"$lt": alert.AlertTime.Add(-window)}}, bson.M{
+		"$set": bson.M{"windowStart": alert.AlertTime, "count": 0, "updatedAt": now},
+	})
+	if err != nil && err != mgo.ErrNotFound {
+		return false, err
+	}
+
+	fingerprint := Fingerprint{}
+	change := mgo.Change{
+		Update: bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$set":         bson.M{"siteId": alert.SiteID, "updatedAt": now},
+			"$setOnInsert": bson.M{"windowStart": alert.AlertTime},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	_, err = c.FindId(alert.Fingerprint).Apply(change, &fingerprint)
+	if mgo.IsDup(err) {
+		// another instance inserted the fingerprint first
+		_, err = c.FindId(alert.Fingerprint).Apply(change, &fingerprint)
+	}
+	if err != nil || fingerprint.Count != threshold {
+		return false, err
+	}
+
+	flap, err := raiseFlapping(session, alert, fingerprint.Count, window, now)
+	if err != nil {
+		return false, err
+	}
+	err = c.UpdateId(alert.Fingerprint, bson.M{
+		"$set": bson.M{"flapAlertId": flap.ID.Hex(), "windowStart": alert.AlertTime, "count": 0, "updatedAt": now},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// a flap alert suppressed by a maintenance window does not take in the alert
+	return merge(session, alert, bson.M{"_id": flap.ID, "status": bson.M{"$ne": common.AlertStatusCleared}}, now)
+}
+
+// raiseFlapping creates the System Alert telling the site the source raised count alerts within the window,
+// the maintenance windows of the site apply to it like to the alerts it takes in
+func raiseFlapping(session *mgo.Session, alert *common.Alert, count int, window time.Duration, now time.Time) (*common.Alert, error) {
+	flap := common.Alert{
+		ID:          bson.NewObjectId(),
+		SiteID:      alert.SiteID,
+		ClientID:    alert.ClientID,
+		Status:      common.AlertStatusNew,
+		Type:        common.AlertTypeSystemAlert,
+		AssignedTo:  []common.SimpleUser{},
+		Priority:    common.AlertPriorityHigh,
+		Location:    alert.Location,
+		Device:      alert.Device,
+		JobName:     alert.JobName,
+		Description: fmt.Sprintf("%s in room %s raised %d %s alerts for %s within %d minutes, its next alerts are counted here", deviceName(alert), alert.Location, count, alert.Type, alert.JobName, int(window.Minutes())),
+		AlertTime:   now,
+		Escalations: []common.AlertEscalation{},
+		Fingerprint: "flap:" + alert.Fingerprint,
+		Occurrences: 1,
+		LastSeen:    now,
+		UpdatedAt:   now,
+	}
+
+	err := session.DB("").C(common.AlertCollection).Insert(&flap)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Source %s of site %s is flapping, raised alert %s", alert.Fingerprint, alert.SiteID, flap.ID.Hex())
+	suppressed, err := maintenance.Apply(session, &flap, now)
+	if err != nil {
+		return nil, err
+	}
+	if !suppressed {
+		alerting.Emit(&alerting.Event{Type: alerting.EventCreated, Alert: &flap, At: now})
+	}
+
+	return &flap, nil
+}
+
+// deviceName names the device in the description of the flap alert
+func deviceName(alert *common.Alert) string {
+	if len(alert.Device) == 0 {
+		return "A device"
+	}
+
+	return "Device " + alert.Device
+}
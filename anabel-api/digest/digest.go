@@ -0,0 +1,255 @@
+package digest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FrequencyDaily sends the summary of the previous day
+	FrequencyDaily = "daily"
+	// FrequencyWeekly sends the summary of the previous seven days
+	FrequencyWeekly = "weekly"
+	// FrequencyOff sends no summary, not even the default of the role
+	FrequencyOff = "off"
+)
+
+// Frequencies lists the frequencies a user can choose, empty follows the default of the role
+var Frequencies = []string{FrequencyDaily, FrequencyWeekly, FrequencyOff}
+
+const (
+	// defaultPollInterval is used when digest.poll_interval_in_minutes is not configured
+	defaultPollInterval = 5 * time.Minute
+	// defaultHour is used when digest.default_hour is not configured
+	defaultHour = 7
+	// defaultWeekday is used when digest.default_weekday is not configured
+	defaultWeekday = int(time.Monday)
+)
+
+// Init creates the index used to find the user of an unsubscribe link
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.UserCollection).EnsureIndex(mgo.Index{Key: []string{"digest.unsubscribeToken"}, Sparse: true})
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create digest index, error: %v", err)
+	}
+}
+
+// Start runs the digest job in the background,
+// every instance of the api can run one as the period of a user is claimed atomically
+func Start() {
+	interval := defaultPollInterval
+	if minutes := config.GetConfig().GetInt("digest.poll_interval_in_minutes"); minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(time.Now().UTC())
+		}
+	}()
+}
+
+// Validate checks the frequency is known, the hour is a clock hour and the weekday is sunday (0) to saturday (6)
+func Validate(schedule *common.DigestSchedule) error {
+	if len(schedule.Frequency) > 0 && !utils.Contains(Frequencies, schedule.Frequency) {
+		return errors.New("unknown frequency " + schedule.Frequency)
+	}
+	if schedule.Hour < 0 || schedule.Hour > 23 {
+		return errors.New("the hour must be between 0 and 23")
+	}
+	if schedule.Weekday < 0 || schedule.Weekday > 6 {
+		return errors.New("the weekday must be between 0 and 6")
+	}
+
+	return nil
+}
+
+// RoleDefault returns the frequency users of the role get when they did not choose one, configured in digest.defaults
+func RoleDefault(role string) string {
+	frequency := config.GetConfig().GetString("digest.defaults." + strings.ToLower(role))
+	if frequency != FrequencyDaily && frequency != FrequencyWeekly {
+		return ""
+	}
+
+	return frequency
+}
+
+// ScheduleOf returns the schedule the user gets the summary on, nil when the user gets none.
+// Users following the default of the role get it at digest.default_hour, weekly ones on digest.default_weekday
+func ScheduleOf(user *common.User) *common.DigestSchedule {
+	schedule := common.DigestSchedule{}
+	if user.Digest != nil {
+		schedule = *user.Digest
+	}
+
+	if len(schedule.Frequency) == 0 {
+		schedule.Frequency = RoleDefault(roleOf(user))
+		schedule.Hour = defaultHour
+		if config.GetConfig().IsSet("digest.default_hour") {
+			schedule.Hour = config.GetConfig().GetInt("digest.default_hour")
+		}
+		schedule.Weekday = defaultWeekday
+		if config.GetConfig().IsSet("digest.default_weekday") {
+			schedule.Weekday = config.GetConfig().GetInt("digest.default_weekday")
+		}
+	}
+	if schedule.Frequency != FrequencyDaily && schedule.Frequency != FrequencyWeekly {
+		return nil
+	}
+
+	return &schedule
+}
+
+// Unsubscribe turns the summary of the user with the token off, the default of the role no longer applies
+func Unsubscribe(token string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	return c.Update(bson.M{"digest.unsubscribeToken": token}, bson.M{"$set": bson.M{
+		"digest.frequency": FrequencyOff,
+		"updatedAt":        time.Now().UTC(),
+	}})
+}
+
+// periodOf returns the last period of the schedule which ended at or before now,
+// the period ends at the hour of the schedule in the location, on the weekday of the schedule for weekly ones
+func periodOf(schedule *common.DigestSchedule, location *time.Location, now time.Time) (time.Time, time.Time) {
+	local := now.In(location)
+	end := time.Date(local.Year(), local.Month(), local.Day(), schedule.Hour, 0, 0, 0, location)
+
+	days := 1
+	if schedule.Frequency == FrequencyWeekly {
+		days = 7
+		for int(end.Weekday()) != schedule.Weekday {
+			end = end.AddDate(0, 0, -1)
+		}
+	}
+	if end.After(local) {
+		end = end.AddDate(0, 0, -days)
+	}
+
+	return end.AddDate(0, 0, -days), end
+}
+
+// run sends the summary to every user whose period ended since the last summary
+func run(now time.Time) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	query := []bson.M{{"digest.frequency": bson.M{"$in": []string{FrequencyDaily, FrequencyWeekly}}}}
+	roles := []string{}
+	for _, role := range []string{"SA", "AM", "CSA", "GA", "SM", "SU"} {
+		if len(RoleDefault(role)) > 0 {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) > 0 {
+		query = append(query, bson.M{"digest.frequency": bson.M{"$in": []interface{}{"", nil}}, "permissions.role": bson.M{"$in": roles}})
+	}
+
+	iter := c.Find(bson.M{"status": common.Active, "$or": query}).Iter()
+	user := common.User{}
+	for iter.Next(&user) {
+		schedule := ScheduleOf(&user)
+		if schedule != nil {
+			start, end := periodOf(schedule, notification.Location(&user), now)
+			if schedule.LastPeriodEnd.Before(end) {
+				err := send(c, &user, schedule, start, end)
+				if err != nil {
+					log.Errorf("Failed to send the summary to user %s, error: %v", user.ID.Hex(), err)
+				}
+			}
+		}
+		user = common.User{}
+	}
+	if err := iter.Close(); err != nil {
+		log.Errorf("Failed to find the users getting a summary, error: %v", err)
+	}
+}
+
+// send claims the period of the user and queues the summary, the claim is given back when the summary cannot be queued
+func send(c *mgo.Collection, user *common.User, schedule *common.DigestSchedule, start time.Time, end time.Time) error {
+	scope := scopeOf(user)
+	if scope == nil {
+		log.Infof("User %s has no clients or sites to summarize", user.ID.Hex())
+		return nil
+	}
+
+	token := schedule.UnsubscribeToken
+	if len(token) == 0 {
+		var err error
+		token, err = generateToken()
+		if err != nil {
+			return err
+		}
+	}
+
+	claim := bson.M{"_id": user.ID, "$or": []bson.M{
+		{"digest.lastPeriodEnd": bson.M{"$lt": end}},
+		{"digest.lastPeriodEnd": bson.M{"$exists": false}},
+	}}
+	err := c.Update(claim, bson.M{"$set": bson.M{"digest.lastPeriodEnd": end, "digest.unsubscribeToken": token}})
+	if err == mgo.ErrNotFound {
+		// another instance sent it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	email, err := emailOf(user, scope, schedule.Frequency, start, end, token)
+	if err == nil {
+		_, err = mail.Queue(email, "user:"+user.ID.Hex())
+	}
+	if err != nil {
+		release := bson.M{"$unset": bson.M{"digest.lastPeriodEnd": ""}}
+		if !schedule.LastPeriodEnd.IsZero() {
+			release = bson.M{"$set": bson.M{"digest.lastPeriodEnd": schedule.LastPeriodEnd}}
+		}
+		if releaseErr := c.UpdateId(user.ID, release); releaseErr != nil {
+			log.Errorf("Failed to release the summary period of user %s, error: %v", user.ID.Hex(), releaseErr)
+		}
+		return err
+	}
+
+	log.Infof("Queued the %s summary of %s for user %s", schedule.Frequency, start.Format(dateLayout), user.ID.Hex())
+	return nil
+}
+
+// generateToken returns a random token for the unsubscribe link
+func generateToken() (string, error) {
+	token := make([]byte, 24)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// roleOf returns the role of the user, users have one permission
+func roleOf(user *common.User) string {
+	for _, p := range user.Permission {
+		return p.Role
+	}
+
+	return ""
+}
@@ -0,0 +1,69 @@
+package digest
+
+import (
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"github.com/spf13/viper"
+)
+
+func TestPeriodOf(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("cannot load the time zone: %v", err)
+	}
+	daily := &common.DigestSchedule{Frequency: FrequencyDaily, Hour: 8}
+	weekly := &common.DigestSchedule{Frequency: FrequencyWeekly, Hour: 8, Weekday: int(time.Monday)}
+
+	for _, test := range []struct {
+		name     string
+		schedule *common.DigestSchedule
+		now      string
+		start    string
+		end      string
+	}{
+		{"daily after the hour", daily, "2026-10-19T09:30:00+09:00", "2026-10-18T08:00:00+09:00", "2026-10-19T08:00:00+09:00"},
+		{"daily at the hour", daily, "2026-10-19T08:00:00+09:00", "2026-10-18T08:00:00+09:00", "2026-10-19T08:00:00+09:00"},
+		{"daily before the hour", daily, "2026-10-19T07:59:00+09:00", "2026-10-17T08:00:00+09:00", "2026-10-18T08:00:00+09:00"},
+		{"daily in another time zone", daily, "2026-10-18T23:30:00Z", "2026-10-18T08:00:00+09:00", "2026-10-19T08:00:00+09:00"},
+		{"weekly on the weekday", weekly, "2026-10-19T10:00:00+09:00", "2026-10-12T08:00:00+09:00", "2026-10-19T08:00:00+09:00"},
+		{"weekly later in the week", weekly, "2026-10-22T10:00:00+09:00", "2026-10-12T08:00:00+09:00", "2026-10-19T08:00:00+09:00"},
+		{"weekly before the hour of the weekday", weekly, "2026-10-19T07:00:00+09:00", "2026-10-05T08:00:00+09:00", "2026-10-12T08:00:00+09:00"},
+	} {
+		now, _ := time.Parse(time.RFC3339, test.now)
+		start, end := periodOf(test.schedule, tokyo, now)
+		if start.Format(time.RFC3339) != test.start || end.Format(time.RFC3339) != test.end {
+			t.Errorf("%s: period from %s to %s instead of %s to %s", test.name, start.Format(time.RFC3339), end.Format(time.RFC3339), test.start, test.end)
+		}
+	}
+}
+
+func TestScheduleOf(t *testing.T) {
+	v := viper.New()
+	v.Set("digest.defaults.sm", FrequencyWeekly)
+	v.Set("digest.default_hour", 7)
+	v.Set("digest.default_weekday", 1)
+	config.SetConfig(v)
+
+	manager := &common.User{Permission: []common.Permission{{Role: "SM"}}}
+	if schedule := ScheduleOf(manager); schedule == nil || schedule.Frequency != FrequencyWeekly || schedule.Hour != 7 || schedule.Weekday != 1 {
+		t.Errorf("the role default is not followed: %+v", schedule)
+	}
+
+	staff := &common.User{Permission: []common.Permission{{Role: "SU"}}}
+	if schedule := ScheduleOf(staff); schedule != nil {
+		t.Errorf("a role without a default gets %+v", schedule)
+	}
+
+	staff.Digest = &common.DigestSchedule{Frequency: FrequencyDaily, Hour: 18}
+	if schedule := ScheduleOf(staff); schedule == nil || schedule.Frequency != FrequencyDaily || schedule.Hour != 18 {
+		t.Errorf("the chosen schedule is not followed: %+v", schedule)
+	}
+
+	manager.Digest = &common.DigestSchedule{Frequency: FrequencyOff}
+	if schedule := ScheduleOf(manager); schedule != nil {
+		t.Errorf("a user who turned the summary off gets %+v", schedule)
+	}
+}
@@ -0,0 +1,215 @@
+package digest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// dateLayout formats the days of the period in the summary
+const dateLayout = "2006-01-02"
+
+// siteSummary counts the alerts of a site over the period
+type siteSummary struct {
+	SiteID         string  `bson:"_id"`
+	New            int     `bson:"new"`
+	Active         int     `bson:"active"`
+	Cleared        int     `bson:"cleared"`
+	JobAgeTotal    float64 `bson:"jobAgeTotal"`
+	DevicesOffline int     `bson:"devicesOffline"`
+}
+
+// scopeOf returns the query limiting alerts and users to the clients and sites of the user,
+// nil when the user has no scope, super admins see everything
+func scopeOf(user *common.User) bson.M {
+	query := []bson.M{}
+	for _, p := range user.Permission {
+		if p.Role == "SA" {
+			return bson.M{}
+		}
+		for _, scope := range p.Scopes {
+			for _, resource := range scope.Resource {
+				if resource == "site" {
+					query = append(query, bson.M{"siteId": bson.M{"$in": scope.Ids}})
+				} else {
+					query = append(query, bson.M{"clientId": bson.M{"$in": scope.Ids}})
+				}
+			}
+		}
+	}
+
+	if len(query) == 0 {
+		return nil
+	}
+
+	return bson.M{"$or": query}
+}
+
+// summarize counts per site the alerts raised and cleared in the period and the ones still open,
+// open system alerts count as devices offline and the job age is averaged over the cleared alerts.
+// The alerts merged into identical ones are counted on those only
+func summarize(session *mgo.Session, scope bson.M, start time.Time, end time.Time) ([]siteSummary, error) {
+	inPeriod := func(field string) bson.M {
+		return bson.M{"$and": []bson.M{{"$gte": []interface{}{field, start}}, {"$lt": []interface{}{field, end}}}}
+	}
+	open := bson.M{"$ne": []interface{}{"$status", common.AlertStatusCleared}}
+	count := func(condition bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": []interface{}{condition, 1, 0}}}
+	}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"$and": []bson.M{scope, {"mergedInto": bson.M{"$exists": false}}, {"$or": []bson.M{
+			{"alertTime": bson.M{"$gte": start, "$lt": end}},
+			{"clearTime": bson.M{"$gte": start, "$lt": end}},
+			{"status": bson.M{"$ne": common.AlertStatusCleared}},
+		}}}}},
+		{"$group": bson.M{
+			"_id":     "$siteId",
+			"new":     count(inPeriod("$alertTime")),
+			"active":  count(open),
+			"cleared": count(inPeriod("$clearTime")),
+			"jobAgeTotal": bson.M{"$sum": bson.M{"$cond": []interface{}{
+				inPeriod("$clearTime"), "$jobAge", 0,
+			}}},
+			"devicesOffline": count(bson.M{"$and": []interface{}{
+				open, bson.M{"$eq": []interface{}{"$type", common.AlertTypeSystemAlert}},
+			}}),
+		}},
+	}
+
+	summaries := []siteSummary{}
+	err := session.DB("").C(common.AlertCollection).Pipe(pipeline).All(&summaries)
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+// siteNames returns the names of the sites by id
+func siteNames(session *mgo.Session, summaries []siteSummary) (map[string]string, error) {
+	ids := []bson.ObjectId{}
+	for _, summary := range summaries {
+		if bson.IsObjectIdHex(summary.SiteID) {
+			ids = append(ids, bson.ObjectIdHex(summary.SiteID))
+		}
+	}
+
+	sites := []struct {
+		ID   bson.ObjectId `bson:"_id"`
+		Name string        `bson:"name"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"name": 1}).All(&sites)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, site := range sites {
+		names[site.ID.Hex()] = site.Name
+	}
+
+	return names, nil
+}
+
+// emailOf builds the summary email of the user for the period
+func emailOf(user *common.User, scope bson.M, frequency string, start time.Time, end time.Time, token string) (*mail.Email, error) {
+	data := map[string]interface{}{
+		"FirstName": user.FirstName,
+		"Period":    start.Format(dateLayout),
+		"URL":       config.GetConfig().GetString("app.forntend_url"),
+	}
+	if frequency == FrequencyWeekly {
+		data["Period"] = start.Format(dateLayout) + " - " + end.AddDate(0, 0, -1).Format(dateLayout)
+	}
+	if base := config.GetConfig().GetString("digest.unsubscribe_url"); len(base) > 0 {
+		data["UnsubscribeURL"] = base + token
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	summaries, err := summarize(session, scope, start, end)
+	if err != nil {
+		return nil, err
+	}
+	names, err := siteNames(session, summaries)
+	if err != nil {
+		return nil, err
+	}
+
+	newUsers, err := session.DB("").C(common.UserCollection).Find(bson.M{"$and": []bson.M{scope, {
+		"createdAt": bson.M{"$gte": start, "$lt": end},
+	}}}).Count()
+	if err != nil {
+		return nil, err
+	}
+
+	total := siteSummary{}
+	sites := []map[string]interface{}{}
+	for _, summary := range summaries {
+		total.New += summary.New
+		total.Active += summary.Active
+		total.Cleared += summary.Cleared
+		total.JobAgeTotal += summary.JobAgeTotal
+		total.DevicesOffline += summary.DevicesOffline
+
+		name, ok := names[summary.SiteID]
+		if !ok {
+			name = summary.SiteID
+		}
+		sites = append(sites, map[string]interface{}{
+			"SiteName":       name,
+			"New":            summary.New,
+			"Active":         summary.Active,
+			"Cleared":        summary.Cleared,
+			"AverageJobAge":  averageJobAge(&summary),
+			"DevicesOffline": summary.DevicesOffline,
+		})
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i]["SiteName"].(string) < sites[j]["SiteName"].(string)
+	})
+
+	data["NewAlerts"] = total.New
+	data["ActiveAlerts"] = total.Active
+	data["ClearedAlerts"] = total.Cleared
+	data["AverageJobAge"] = averageJobAge(&total)
+	data["DevicesOffline"] = total.DevicesOffline
+	data["NewUsers"] = newUsers
+	data["Sites"] = sites
+
+	template := mail.TemplateDailyDigest
+	if frequency == FrequencyWeekly {
+		template = mail.TemplateWeeklyDigest
+	}
+
+	return &mail.Email{
+		Template: template,
+		Locale:   user.Locale,
+		To:       []string{user.Email},
+		Brand:    mail.GetBrand(user.ClientID),
+		Data:     data,
+	}, nil
+}
+
+// averageJobAge formats the average job age of the cleared alerts like 1h 05m, a dash when none was cleared
+func averageJobAge(summary *siteSummary) string {
+	if summary.Cleared == 0 {
+		return "-"
+	}
+
+	minutes := int(summary.JobAgeTotal/float64(summary.Cleared)) / 60
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
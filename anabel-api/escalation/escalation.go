@@ -0,0 +1,324 @@
+package escalation
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TargetSM notifies the site managers of the site of the alert
+	TargetSM = "SM"
+	// TargetGA notifies the group admins of the site of the alert
+	TargetGA = "GA"
+	// TargetCSA notifies the admins of the client of the alert
+	TargetCSA = "CSA"
+)
+
+// Targets lists the roles a tier can escalate to
+var Targets = []string{TargetSM, TargetGA, TargetCSA}
+
+const (
+	// maxTiers limits the tiers of a policy
+	maxTiers = 5
+	// defaultPollInterval is used when escalation.poll_interval_in_seconds is not configured
+	defaultPollInterval = time.Minute
+)
+
+// Policy godoc
+// describes how New alerts of a type and priority escalate at the sites of a client,
+// a policy without site applies to every site of the client and one without priority to every priority
+type Policy struct {
+	ID        bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID  string        `json:"clientId" bson:"clientId"`
+	SiteID    string        `json:"siteId" bson:"siteId"`
+	AlertType string        `json:"alertType" bson:"alertType"`
+	Priority  string        `json:"priority" bson:"priority"`
+	Tiers     []Tier        `json:"tiers" bson:"tiers"`
+	Enabled   bool          `json:"enabled" bson:"enabled"`
+	CreatedBy string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Tier godoc
+// notifies the users of the target role once the alert is New for the minutes, paging sends text messages
+// whatever the notification matrix of the users says
+type Tier struct {
+	AfterMinutes int    `json:"afterMinutes" bson:"afterMinutes"`
+	Target       string `json:"target" bson:"target"`
+	Page         bool   `json:"page" bson:"page"`
+}
+
+// Init creates the indexes, a client has one policy per site, alert type and priority
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.EscalationPolicyCollection).EnsureIndex(mgo.Index{
+		Key:    []string{"clientId", "siteId", "alertType", "priority"},
+		Unique: true,
+	})
+	if err == nil {
+		err = session.DB("").C(common.AlertCollection).EnsureIndex(mgo.Index{Key: []string{"status"}})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create escalation indexes, error: %v", err)
+	}
+}
+
+// Start runs the escalation of the New alerts in the background,
+// every instance of the api can run one as the tiers of an alert are claimed atomically
+func Start() {
+	interval := defaultPollInterval
+	if seconds := config.GetConfig().GetInt("escalation.poll_interval_in_seconds"); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(time.Now().UTC())
+		}
+	}()
+}
+
+// Validate checks the alert type, priority and tiers, the tiers follow each other in time
+func (policy *Policy) Validate() error {
+	if !utils.Contains(notification.AlertTypes, policy.AlertType) {
+		return errors.New("unknown alert type " + policy.AlertType)
+	}
+	if !notification.ValidPriority(policy.Priority) {
+		return errors.New("unknown priority " + policy.Priority)
+	}
+	if len(policy.Tiers) == 0 || len(policy.Tiers) > maxTiers {
+		return fmt.Errorf("a policy has 1 to %d tiers", maxTiers)
+	}
+
+	after := 0
+	for _, tier := range policy.Tiers {
+		if !utils.Contains(Targets, tier.Target) {
+			return errors.New("unknown target " + tier.Target)
+		}
+		if tier.AfterMinutes <= after {
+			return errors.New("every tier must come later than the one before")
+		}
+		after = tier.AfterMinutes
+	}
+
+	return nil
+}
+
+// Matches checks the policy applies to the alert
+func (policy *Policy) Matches(alert *common.Alert) bool {
+	return policy.Enabled && policy.ClientID == alert.ClientID && policy.AlertType == alert.Type &&
+		(len(policy.SiteID) == 0 || policy.SiteID == alert.SiteID) &&
+		(len(policy.Priority) == 0 || policy.Priority == alert.Priority)
+}
+
+// PolicyOf returns the policy of the alert, a policy of the site comes before one of the client
+// and one of the priority before one of every priority, nil when none applies
+func PolicyOf(policies []Policy, alert *common.Alert) *Policy {
+	var best *Policy
+	bestRank := -1
+	for i := range policies {
+		policy := &policies[i]
+		if !policy.Matches(alert) {
+			continue
+		}
+
+		rank := 0
+		if len(policy.SiteID) > 0 {
+			rank += 2
+		}
+		if len(policy.Priority) > 0 {
+			rank++
+		}
+		if rank > bestRank {
+			best, bestRank = policy, rank
+		}
+	}
+
+	return best
+}
+
+// dueTiers returns the tiers the alert has reached since it is New and was not escalated to yet
+func dueTiers(policy *Policy, alert *common.Alert, now time.Time) []int {
+	since := alert.PendingSince
+	if since.IsZero() {
+		since = alert.AlertTime
+	}
+	if since.IsZero() {
+		return nil
+	}
+
+	due := []int{}
+	for i := alert.EscalationLevel; i < len(policy.Tiers); i++ {
+		if now.Sub(since) < time.Duration(policy.Tiers[i].AfterMinutes)*time.Minute {
+			break
+		}
+		due = append(due, i)
+	}
+
+	return due
+}
+
+// run escalates every New alert which reached a tier of its policy
+func run(now time.Time) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	policies := []Policy{}
+	err := session.DB("").C(common.EscalationPolicyCollection).Find(bson.M{"enabled": true}).All(&policies)
+	if err != nil {
+		log.Errorf("Failed to load the escalation policies, error: %v", err)
+		return
+	}
+	if len(policies) == 0 {
+		return
+	}
+
+	c := session.DB("").C(common.AlertCollection)
+	iter := c.Find(bson.M{"status": common.AlertStatusNew}).Iter()
+	alert := common.Alert{}
+	for iter.Next(&alert) {
+		policy := PolicyOf(policies, &alert)
+		if policy != nil {
+			if due := dueTiers(policy, &alert, now); len(due) > 0 {
+				err = escalate(session, &alert, policy, due, now)
+				if err != nil {
+					log.Errorf("Failed to escalate alert %s, error: %v", alert.ID.Hex(), err)
+				}
+			}
+		}
+		alert = common.Alert{}
+	}
+	if err := iter.Close(); err != nil {
+		log.Errorf("Failed to find the alerts to escalate, error: %v", err)
+	}
+}
+
+// escalate records the tiers on the alert timeline and notifies their targets,
+// the tiers are only recorded when the alert did not change in the meantime so each tier notifies once
+func escalate(session *mgo.Session, alert *common.Alert, policy *Policy, due []int, now time.Time) error {
+	entries := []common.AlertEscalation{}
+	targets := [][]common.User{}
+	for _, i := range due {
+		tier := policy.Tiers[i]
+		users, err := usersOf(session, tier.Target, alert)
+		if err != nil {
+			return err
+		}
+
+		notified := []string{}
+		for _, user := range users {
+			notified = append(notified, user.ID.Hex())
+		}
+		entries = append(entries, common.AlertEscalation{
+			Tier:         i + 1,
+			PolicyID:     policy.ID.Hex(),
+			Target:       tier.Target,
+			AfterMinutes: tier.AfterMinutes,
+			Paged:        tier.Page,
+			Notified:     notified,
+			At:           now,
+		})
+		targets = append(targets, users)
+	}
+
+	escalated := *alert
+	escalated.EscalationLevel = due[len(due)-1] + 1
+	escalated.Escalations = append(append([]common.AlertEscalation{}, alert.Escalations...), entries...)
+	escalated.UpdatedAt = now
+
+	// alerts raised by other services have no level until their first escalation
+	var level interface{} = alert.EscalationLevel
+	if alert.EscalationLevel == 0 {
+		level = bson.M{"$in": []interface{}{0, nil}}
+	}
+
+	err := session.DB("").C(common.AlertCollection).Update(bson.M{
+		"_id":             alert.ID,
+		"status":          common.AlertStatusNew,
+		"escalationLevel": level,
+	}, bson.M{
+		"$set":  bson.M{"escalationLevel": escalated.EscalationLevel, "updatedAt": now},
+		"$push": bson.M{"escalations": bson.M{"$each": entries}},
+	})
+	if err == mgo.ErrNotFound {
+		// the alert was assigned or escalated by another instance
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"AlertType": alert.Type,
+		"Priority":  alert.Priority,
+		"SiteName":  siteName(session, alert.SiteID),
+		"Room":      alert.Location,
+		"CreatedAt": alert.AlertTime.Format("2006-01-02 15:04 MST"),
+		"URL":       config.GetConfig().GetString("app.alert_url") + alert.ID.Hex(),
+	}
+	brand := mail.GetBrand(alert.ClientID)
+	for i, entry := range entries {
+		data["Minutes"] = entry.AfterMinutes
+		notify := notification.NotifyAlert
+		if entry.Paged {
+			notify = notification.Page
+		}
+		err = notify(targets[i], alert.Type, alert.Priority, alert.ID.Hex(), mail.TemplateAlertEscalated, brand, data)
+		if err != nil {
+			log.Errorf("Failed to notify tier %d of alert %s, error: %v", entry.Tier, alert.ID.Hex(), err)
+		}
+	}
+
+	log.Infof("Escalated alert %s to tier %d", alert.ID.Hex(), escalated.EscalationLevel)
+	alerting.Emit(&alerting.Event{Type: alerting.EventEscalated, Alert: &escalated, Previous: alert, At: now})
+	return nil
+}
+
+// usersOf returns the active users of the role scoped to the site of the alert, or to its client for client admins
+func usersOf(session *mgo.Session, target string, alert *common.Alert) ([]common.User, error) {
+	scopeID := alert.SiteID
+	if target == TargetCSA {
+		scopeID = alert.ClientID
+	}
+
+	users := []common.User{}
+	err := session.DB("").C(common.UserCollection).Find(bson.M{
+		"status":                 common.Active,
+		"permissions.role":       target,
+		"permissions.scopes.ids": scopeID,
+	}).All(&users)
+
+	return users, err
+}
+
+// siteName returns the name of the site shown in the notification, its id when it cannot be found
+func siteName(session *mgo.Session, siteID string) string {
+	if !bson.IsObjectIdHex(siteID) {
+		return siteID
+	}
+
+	site := struct {
+		Name string `bson:"name"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).Select(bson.M{"name": 1}).One(&site)
+	if err != nil {
+		return siteID
+	}
+
+	return site.Name
+}
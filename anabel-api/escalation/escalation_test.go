@@ -0,0 +1,88 @@
+package escalation
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+)
+
+// raised is the time the alerts of the tests were raised
+var raised = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+func TestPolicyOf(t *testing.T) {
+	policies := []Policy{
+		{ClientID: "acme", AlertType: common.AlertTypeStaffAlert, Enabled: true, Tiers: []Tier{{AfterMinutes: 30}}},
+		{ClientID: "acme", AlertType: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh, Enabled: true, Tiers: []Tier{{AfterMinutes: 10}}},
+		{ClientID: "acme", SiteID: "tokyo", AlertType: common.AlertTypeStaffAlert, Enabled: true, Tiers: []Tier{{AfterMinutes: 20}}},
+		{ClientID: "acme", SiteID: "osaka", AlertType: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh, Enabled: false, Tiers: []Tier{{AfterMinutes: 5}}},
+	}
+
+	for _, test := range []struct {
+		name  string
+		alert common.Alert
+		after int
+	}{
+		{"the site comes before the priority", common.Alert{ClientID: "acme", SiteID: "tokyo", Type: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh}, 20},
+		{"the priority comes before every priority", common.Alert{ClientID: "acme", SiteID: "kyoto", Type: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh}, 10},
+		{"every priority", common.Alert{ClientID: "acme", SiteID: "kyoto", Type: common.AlertTypeStaffAlert, Priority: common.AlertPriorityLow}, 30},
+		{"disabled policies do not apply", common.Alert{ClientID: "acme", SiteID: "osaka", Type: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh}, 10},
+		{"another type", common.Alert{ClientID: "acme", SiteID: "tokyo", Type: common.AlertTypeSystemAlert}, 0},
+		{"another client", common.Alert{ClientID: "globex", SiteID: "tokyo", Type: common.AlertTypeStaffAlert}, 0},
+	} {
+		policy := PolicyOf(policies, &test.alert)
+		after := 0
+		if policy != nil {
+			after = policy.Tiers[0].AfterMinutes
+		}
+		if after != test.after {
+			t.Errorf("%s: the policy escalating after %d minutes applies instead of %d", test.name, after, test.after)
+		}
+	}
+}
+
+func TestDueTiers(t *testing.T) {
+	policy := &Policy{Tiers: []Tier{{AfterMinutes: 10, Target: TargetSM}, {AfterMinutes: 30, Target: TargetGA}, {AfterMinutes: 60, Target: TargetCSA}}}
+
+	for _, test := range []struct {
+		name  string
+		alert common.Alert
+		now   time.Time
+		due   string
+	}{
+		{"before the first tier", common.Alert{AlertTime: raised}, raised.Add(9 * time.Minute), ""},
+		{"at the first tier", common.Alert{AlertTime: raised}, raised.Add(10 * time.Minute), "0"},
+		{"tiers missed together", common.Alert{AlertTime: raised}, raised.Add(45 * time.Minute), "0,1"},
+		{"tiers escalated to already", common.Alert{AlertTime: raised, EscalationLevel: 2}, raised.Add(2 * time.Hour), "2"},
+		{"every tier escalated to", common.Alert{AlertTime: raised, EscalationLevel: 3}, raised.Add(2 * time.Hour), ""},
+		{"pending since it became New again", common.Alert{AlertTime: raised, PendingSince: raised.Add(time.Hour)}, raised.Add(80 * time.Minute), "0"},
+		{"without a time", common.Alert{}, raised, ""},
+	} {
+		due := []string{}
+		for _, tier := range dueTiers(policy, &test.alert, test.now) {
+			due = append(due, strconv.Itoa(tier))
+		}
+		if strings.Join(due, ",") != test.due {
+			t.Errorf("%s: tiers %v are due instead of %s", test.name, due, test.due)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	policy := Policy{AlertType: common.AlertTypeStaffAlert, Tiers: []Tier{{AfterMinutes: 10, Target: TargetSM}, {AfterMinutes: 30, Target: TargetGA}}}
+	if err := policy.Validate(); err != nil {
+		t.Errorf("a valid policy is refused: %v", err)
+	}
+
+	policy.Tiers = []Tier{{AfterMinutes: 30, Target: TargetSM}, {AfterMinutes: 30, Target: TargetGA}}
+	if err := policy.Validate(); err == nil {
+		t.Error("tiers at the same time are accepted")
+	}
+
+	policy.Tiers = []Tier{{AfterMinutes: 10, Target: "SU"}}
+	if err := policy.Validate(); err == nil {
+		t.Error("an unknown target is accepted")
+	}
+}
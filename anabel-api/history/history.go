@@ -0,0 +1,223 @@
+package history
+
+import (
+	"strings"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Entry godoc
+// records a change of an alert, entries are only ever added. An empty actor is the system,
+// like the escalation and routing jobs or the service which raised the alert
+type Entry struct {
+	ID         bson.ObjectId          `json:"id" bson:"_id,omitempty"`
+	AlertID    string                 `json:"alertId" bson:"alertId"`
+	SiteID     string                 `json:"siteId" bson:"siteId"`
+	ClientID   string                 `json:"clientId" bson:"clientId"`
+	Type       string                 `json:"type" bson:"type"`
+	ActorID    string                 `json:"actorId" bson:"actorId"`
+	ActorName  string                 `json:"actorName" bson:"actorName"`
+	Before     *State                 `json:"before,omitempty" bson:"before,omitempty"`
+	After      *State                 `json:"after,omitempty" bson:"after,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	At         time.Time              `json:"at" bson:"at"`
+	RecordedAt time.Time              `json:"recordedAt" bson:"recordedAt"`
+}
+
+// State godoc
+// holds the values of an alert a change can touch
+type State struct {
+	Status          string              `json:"status" bson:"status"`
+	Priority        string              `json:"priority" bson:"priority"`
+	AssignedTo      []common.SimpleUser `json:"assignedTo" bson:"assignedTo"`
+	Reason          string              `json:"reason,omitempty" bson:"reason,omitempty"`
+	Detailed        string              `json:"detailed,omitempty" bson:"detailed,omitempty"`
+	EscalationLevel int                 `json:"escalationLevel" bson:"escalationLevel"`
+}
+
+// Init creates the indexes used to read the history and the comments of an alert and records every change of an alert from now on,
+// it must be called before outbox.Start
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.AlertHistoryCollection).EnsureIndex(mgo.Index{Key: []string{"alertId", "at"}})
+	if err == nil {
+		err = session.DB("").C(common.AlertCommentCollection).EnsureIndex(mgo.Index{Key: []string{"alertId", "createdAt"}})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create alert history indexes, error: %v", err)
+	}
+
+	outbox.RegisterHandler(outbox.KindHistory, recordQueued)
+	alerting.RegisterRecorder("history", record)
+}
+
+// Of returns the history of the alert from the oldest change on. Alerts are raised by other services,
+// so the creation is taken from the alert time when it was not recorded
+func Of(alert *common.Alert) ([]Entry, error) {
+	entries, err := ofAlerts([]*common.Alert{alert})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[alert.ID.Hex()], nil
+}
+
+// OfAlerts returns the histories of the alerts by alert id like Of does
+func OfAlerts(alerts []common.Alert) (map[string][]Entry, error) {
+	list := []*common.Alert{}
+	for i := range alerts {
+		list = append(list, &alerts[i])
+	}
+
+	return ofAlerts(list)
+}
+
+// ofAlerts loads the entries of the alerts in one query
+func ofAlerts(alerts []*common.Alert) (map[string][]Entry, error) {
+	ids := []string{}
+	for _, alert := range alerts {
+		ids = append(ids, alert.ID.Hex())
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	entries := []Entry{}
+	err := session.DB("").C(common.AlertHistoryCollection).Find(bson.M{"alertId": bson.M{"$in": ids}}).Sort("at", "recordedAt").All(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]Entry{}
+	for _, entry := range entries {
+		result[entry.AlertID] = append(result[entry.AlertID], entry)
+	}
+
+	for _, alert := range alerts {
+		id := alert.ID.Hex()
+		list := result[id]
+		if len(list) > 0 && list[0].Type == alerting.EventCreated {
+			continue
+		}
+		created := Entry{
+			AlertID:  id,
+			SiteID:   alert.SiteID,
+			ClientID: alert.ClientID,
+			Type:     alerting.EventCreated,
+			After:    &State{Status: common.AlertStatusNew, Priority: alert.Priority, AssignedTo: []common.SimpleUser{}},
+			At:       alert.AlertTime,
+		}
+		result[id] = append([]Entry{created}, list...)
+	}
+
+	return result, nil
+}
+
+// record appends the change to the history of the alert
+func record(event *alerting.Event) error {
+	entry := Entry{
+		ID:         bson.NewObjectId(),
+		AlertID:    event.Alert.ID.Hex(),
+		SiteID:     event.Alert.SiteID,
+		ClientID:   event.Alert.ClientID,
+		Type:       event.Type,
+		ActorID:    event.ActorID,
+		After:      stateOf(event.Alert),
+		Details:    detailsOf(event),
+		At:         event.At,
+		RecordedAt: time.Now().UTC(),
+	}
+	if event.Previous != nil {
+		entry.Before = stateOf(event.Previous)
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	if bson.IsObjectIdHex(event.ActorID) {
+		actor := common.User{}
+		err := session.DB("").C(common.UserCollection).FindId(bson.ObjectIdHex(event.ActorID)).Select(bson.M{"firstName": 1, "familyName": 1}).One(&actor)
+		if err == nil {
+			entry.ActorName = strings.TrimSpace(actor.FirstName + " " + actor.FamilyName)
+		}
+	}
+
+	// the entry is written again from the outbox when it cannot be written now, so no change goes missing
+	err := insert(session, &entry)
+	if err != nil {
+		log.Warnf("Queueing the %s history entry of alert %s, error: %v", entry.Type, entry.AlertID, err)
+		_, err = outbox.Enqueue(outbox.KindHistory, "alert:"+entry.AlertID, entry)
+	}
+
+	return err
+}
+
+// recordQueued writes an entry which could not be written when the alert changed
+func recordQueued(message *outbox.Message) error {
+	entry := Entry{}
+	err := message.Decode(&entry)
+	if err != nil {
+		return outbox.Permanent(err)
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	return insert(session, &entry)
+}
+
+// insert writes the entry once, its id is set when it is built so a retried write does not duplicate it
+func insert(session *mgo.Session, entry *Entry) error {
+	err := session.DB("").C(common.AlertHistoryCollection).Insert(entry)
+	if mgo.IsDup(err) {
+		return nil
+	}
+
+	return err
+}
+
+// stateOf returns the values of the alert kept in the history
+func stateOf(alert *common.Alert) *State {
+	state := State{
+		Status:          alert.Status,
+		Priority:        alert.Priority,
+		AssignedTo:      alert.AssignedTo,
+		Reason:          alert.Reason,
+		Detailed:        alert.Detailed,
+		EscalationLevel: alert.EscalationLevel,
+	}
+	if state.AssignedTo == nil {
+		state.AssignedTo = []common.SimpleUser{}
+	}
+
+	return &state
+}
+
+// detailsOf returns what the change carries besides the values of the alert,
+// the tiers reached by an escalation, the comment left and the rule which routed an alert
+func detailsOf(event *alerting.Event) map[string]interface{} {
+	switch {
+	case event.Type == alerting.EventEscalated && event.Previous != nil:
+		tiers := []common.AlertEscalation{}
+		if len(event.Alert.Escalations) > len(event.Previous.Escalations) {
+			tiers = event.Alert.Escalations[len(event.Previous.Escalations):]
+		}
+		return map[string]interface{}{"tiers": tiers}
+	case event.Type == alerting.EventCommented:
+		return map[string]interface{}{"commentId": event.CommentID}
+	case event.Type == alerting.EventSuppressed || event.Type == alerting.EventDowngraded:
+		return map[string]interface{}{"maintenanceWindowId": event.Alert.MaintenanceWindowID}
+	case event.Type == alerting.EventAssigned && len(event.ActorID) == 0 && len(event.Alert.RoutingRuleID) > 0:
+		return map[string]interface{}{"routingRuleId": event.Alert.RoutingRuleID}
+	}
+
+	return nil
+}
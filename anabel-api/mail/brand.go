@@ -0,0 +1,86 @@
+package mail
+
+import (
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/storage"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// brandLogoSize is the thumbnail preferred for the logo in the email header
+const brandLogoSize = 256
+
+// defaultLogoExpiry is used when mail.logo_url_expiry_in_hours is not configured,
+// it is the longest period s3 accepts for presigned urls
+const defaultLogoExpiry = 7 * 24 * time.Hour
+
+// DefaultBrand returns the branding of emails not sent on behalf of a client
+func DefaultBrand() Brand {
+	name := config.GetConfig().GetString("email.brand_name")
+	if len(name) == 0 {
+		name = "Anacove"
+	}
+
+	return Brand{
+		Name:    name,
+		LogoURL: config.GetConfig().GetString("email.logo_url"),
+	}
+}
+
+// GetBrand returns the name and logo of the client, the default branding when the client cannot be found
+func GetBrand(clientID string) Brand {
+	brand := DefaultBrand()
+	if !bson.IsObjectIdHex(clientID) {
+		return brand
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.ClientCollection)
+
+	client := common.Client{}
+	err := c.FindId(bson.ObjectIdHex(clientID)).One(&client)
+	if err != nil {
+		log.Errorf("cannot find the client with id: %s for branding, error: %v\n", clientID, err)
+		return brand
+	}
+
+	return BrandOf(&client)
+}
+
+// BrandOf returns the name and logo of the client,
+// stored logos are private so the logo thumbnail is presigned for as long as possible
+func BrandOf(client *common.Client) Brand {
+	brand := DefaultBrand()
+	if len(client.Name) > 0 {
+		brand.Name = client.Name
+	}
+
+	var logo *common.ImageVariant
+	for i, variant := range client.LogoVariants {
+		if variant.Size <= brandLogoSize && (logo == nil || variant.Size > logo.Size) {
+			logo = &client.LogoVariants[i]
+		}
+	}
+	if logo == nil || storage.GetStorage() == nil {
+		return brand
+	}
+
+	expiry := defaultLogoExpiry
+	if hours := config.GetConfig().GetInt("mail.logo_url_expiry_in_hours"); hours > 0 && time.Duration(hours)*time.Hour < expiry {
+		expiry = time.Duration(hours) * time.Hour
+	}
+
+	url, err := storage.GetStorage().Presign(logo.Name, expiry)
+	if err != nil {
+		log.Errorf("error occurred during presigning logo %s, error: %v\n", logo.Name, err)
+		return brand
+	}
+	brand.LogoURL = url
+
+	return brand
+}
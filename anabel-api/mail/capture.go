@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"anacove.com/backend/config"
+	"github.com/google/uuid"
+)
+
+// CaptureMailer keeps every sent message in memory instead of delivering it,
+// when a directory is set the raw messages are also written there as .eml files
+type CaptureMailer struct {
+	dir      string
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewCaptureMailer creates a mailer capturing into the directory, an empty directory keeps the messages in memory only
+func NewCaptureMailer(dir string) (*CaptureMailer, error) {
+	if len(dir) > 0 {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &CaptureMailer{dir: dir}, nil
+}
+
+// newCaptureMailer creates the mailer from the mail.capture configuration
+func newCaptureMailer() (Mailer, error) {
+	return NewCaptureMailer(config.GetConfig().GetString("mail.capture.dir"))
+}
+
+// Send records the message
+func (mailer *CaptureMailer) Send(message *Message) error {
+	if len(mailer.dir) > 0 {
+		data, err := message.Bytes()
+		if err != nil {
+			return err
+		}
+
+		name := time.Now().UTC().Format("20060102T150405") + "-" + uuid.New().String() + ".eml"
+		err = ioutil.WriteFile(filepath.Join(mailer.dir, name), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	mailer.messages = append(mailer.messages, *message)
+
+	return nil
+}
+
+// Messages returns the captured messages in the order they were sent
+func (mailer *CaptureMailer) Messages() []Message {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+
+	return append([]Message{}, mailer.messages...)
+}
+
+// Reset forgets the captured messages
+func (mailer *CaptureMailer) Reset() {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+
+	mailer.messages = nil
+}
@@ -0,0 +1,156 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DriverSES sends emails through aws ses
+	DriverSES = "ses"
+	// DriverSMTP sends emails to a plain smtp server like MailHog
+	DriverSMTP = "smtp"
+	// DriverCapture keeps the emails in memory and optionally writes them to a directory
+	DriverCapture = "capture"
+)
+
+// Attachment godoc
+// describes a file attached to an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Message godoc
+// describes an email, at least one of HTML and Text is required
+type Message struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	ReplyTo     []string
+	Subject     string
+	HTML        string
+	Text        string
+	Attachments []Attachment
+}
+
+// Mailer godoc
+// defines the operations of an email backend
+type Mailer interface {
+	// Send delivers the message to all of its recipients
+	Send(message *Message) error
+}
+
+var mailer Mailer = nil
+
+// Init initializes the mailer selected from configuration
+func Init() error {
+	driver := config.GetConfig().GetString("mail.driver")
+	if len(driver) == 0 {
+		driver = DriverSES
+	}
+
+	var err error
+	switch driver {
+	case DriverSES:
+		mailer, err = newSESMailer()
+	case DriverSMTP:
+		mailer, err = newSMTPMailer()
+	case DriverCapture:
+		mailer, err = newCaptureMailer()
+	default:
+		err = fmt.Errorf("unknown mail driver %s", driver)
+	}
+
+	if err != nil {
+		log.Errorf("Failed to initialize %s mailer, error: %v", driver, err)
+		return err
+	}
+
+	err = LoadTemplates()
+	if err != nil {
+		log.Errorf("Failed to load email templates, error: %v", err)
+		return err
+	}
+
+	session := utils.NewDBSession()
+	err = session.DB("").C(common.SuppressionCollection).EnsureIndex(mgo.Index{Key: []string{"email"}, Unique: true})
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create suppression index, error: %v", err)
+	}
+
+	outbox.RegisterHandler(outbox.KindEmail, deliverQueued)
+
+	return nil
+}
+
+// GetMailer returns the configured mailer
+func GetMailer() Mailer {
+	return mailer
+}
+
+// SetMailer replaces the configured mailer, tests use it with a CaptureMailer
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// Send fills the sender and delivers the message with the configured mailer,
+// suppressed recipients are dropped and ErrSuppressed is returned when none is left
+func Send(message *Message) error {
+	if mailer == nil {
+		return errors.New("mailer is not initialized")
+	}
+
+	if len(message.From) == 0 {
+		message.From = config.GetConfig().GetString("email.sender")
+	}
+
+	err := message.validate()
+	if err != nil {
+		return err
+	}
+
+	err = removeSuppressed(message)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(message)
+}
+
+// validate checks the message has a sender, a recipient and a body
+func (message *Message) validate() error {
+	if len(message.From) == 0 {
+		return errors.New("email sender is not configured")
+	}
+
+	if len(message.Recipients()) == 0 {
+		return errors.New("email has no recipient")
+	}
+
+	if len(message.HTML) == 0 && len(message.Text) == 0 {
+		return errors.New("email has no body")
+	}
+
+	return nil
+}
+
+// Recipients returns the to, cc and bcc addresses of the message
+func (message *Message) Recipients() []string {
+	recipients := []string{}
+	recipients = append(recipients, message.To...)
+	recipients = append(recipients, message.Cc...)
+	recipients = append(recipients, message.Bcc...)
+
+	return recipients
+}
@@ -0,0 +1,162 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"anacove.com/backend/config"
+	"github.com/spf13/viper"
+)
+
+// headersOf returns the header lines of the raw message
+func headersOf(t *testing.T, message *Message) []string {
+	data, err := message.Bytes()
+	if err != nil {
+		t.Fatalf("cannot build message: %v", err)
+	}
+
+	raw := string(data)
+	index := strings.Index(raw, "\r\n\r\n")
+	if index < 0 {
+		t.Fatalf("message has no body: %q", raw)
+	}
+
+	return strings.Split(raw[:index], "\r\n")
+}
+
+// findHeader returns the value of the first header line with the key
+func findHeader(headers []string, key string) (string, bool) {
+	for _, line := range headers {
+		if strings.HasPrefix(line, key+": ") {
+			return strings.TrimPrefix(line, key+": "), true
+		}
+	}
+
+	return "", false
+}
+
+func TestBytesStripsHeaderInjection(t *testing.T) {
+	message := &Message{
+		From:    "noreply@anacove.com",
+		To:      []string{"user@example.com\r\nBcc: attacker@example.com"},
+		ReplyTo: []string{"reply@example.com\nX-Injected: yes"},
+		Subject: "Hello\r\nBcc: attacker@example.com",
+		Text:    "body",
+	}
+
+	headers := headersOf(t, message)
+	for _, line := range headers {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+
+	to, _ := findHeader(headers, "To")
+	if to != "user@example.comBcc: attacker@example.com" {
+		t.Errorf("unexpected To header %q", to)
+	}
+	subject, _ := findHeader(headers, "Subject")
+	if strings.ContainsAny(subject, "\r\n") {
+		t.Errorf("subject keeps line breaks %q", subject)
+	}
+}
+
+func TestBytesLeavesOutBcc(t *testing.T) {
+	message := &Message{
+		From:    "noreply@anacove.com",
+		To:      []string{"to@example.com"},
+		Cc:      []string{"cc@example.com"},
+		Bcc:     []string{"hidden@example.com"},
+		Subject: "Hello",
+		HTML:    "<p>body</p>",
+		Text:    "body",
+	}
+
+	data, err := message.Bytes()
+	if err != nil {
+		t.Fatalf("cannot build message: %v", err)
+	}
+	if strings.Contains(string(data), "hidden@example.com") {
+		t.Errorf("bcc recipient is written to the message:\n%s", data)
+	}
+
+	headers := headersOf(t, message)
+	if cc, _ := findHeader(headers, "Cc"); cc != "cc@example.com" {
+		t.Errorf("unexpected Cc header %q", cc)
+	}
+	if _, ok := findHeader(headers, "Bcc"); ok {
+		t.Error("message has a Bcc header")
+	}
+
+	recipients := strings.Join(message.Recipients(), ",")
+	if recipients != "to@example.com,cc@example.com,hidden@example.com" {
+		t.Errorf("unexpected recipients %s", recipients)
+	}
+}
+
+func TestRenderTemplateWithCaptureMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := viper.New()
+	v.Set("mail.templates_dir", "../templates/email")
+	v.Set("mail.default_locale", "en")
+	config.SetConfig(v)
+	err = LoadTemplates()
+	if err != nil {
+		t.Fatalf("cannot load templates: %v", err)
+	}
+
+	capture, err := NewCaptureMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetMailer(capture)
+
+	email := Email{
+		Template: TemplateInvitation,
+		Locale:   "fr-CA",
+		To:       []string{"user@example.com"},
+		Brand:    Brand{Name: "Acme <Hotels>"},
+		Data:     map[string]interface{}{"FirstName": "Kim", "URL": "https://app.anacove.com/activate?code=abc"},
+	}
+	message, err := email.Render()
+	if err != nil {
+		t.Fatalf("cannot render template: %v", err)
+	}
+	message.From = "noreply@anacove.com"
+	err = GetMailer().Send(message)
+	if err != nil {
+		t.Fatalf("cannot send message: %v", err)
+	}
+
+	messages := capture.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("captured %d messages instead of 1", len(messages))
+	}
+	sent := messages[0]
+	if sent.Subject != "Please activate your Acme <Hotels> account" {
+		t.Errorf("unexpected subject %q", sent.Subject)
+	}
+	if !strings.Contains(sent.Text, "Hello Kim,") || !strings.Contains(sent.Text, "https://app.anacove.com/activate?code=abc") {
+		t.Errorf("unexpected text body %q", sent.Text)
+	}
+	if !strings.Contains(sent.HTML, "Acme &lt;Hotels&gt;") || strings.Contains(sent.HTML, "Acme <Hotels>") {
+		t.Errorf("brand is not escaped in the html body %q", sent.HTML)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one captured .eml file, got %d, error: %v", len(files), err)
+	}
+
+	capture.Reset()
+	if len(capture.Messages()) != 0 {
+		t.Error("reset keeps the captured messages")
+	}
+}
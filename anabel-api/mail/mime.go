@@ -0,0 +1,181 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// headerReplacer drops line breaks so values cannot inject headers
+var headerReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// Bytes builds the raw MIME message, bcc recipients are not written to the headers.
+// Bodies are sent as multipart/alternative when both are set and
+// wrapped in multipart/mixed when there are attachments
+func (message *Message) Bytes() ([]byte, error) {
+	buffer := bytes.Buffer{}
+
+	writeHeader(&buffer, "From", message.From)
+	writeHeader(&buffer, "To", strings.Join(message.To, ", "))
+	writeHeader(&buffer, "Cc", strings.Join(message.Cc, ", "))
+	writeHeader(&buffer, "Reply-To", strings.Join(message.ReplyTo, ", "))
+	writeHeader(&buffer, "Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	writeHeader(&buffer, "Date", time.Now().UTC().Format(time.RFC1123Z))
+	writeHeader(&buffer, "Message-ID", fmt.Sprintf("<%s@%s>", uuid.New().String(), senderDomain(message.From)))
+	writeHeader(&buffer, "MIME-Version", "1.0")
+
+	if len(message.Attachments) == 0 {
+		err := message.writeBody(&buffer, true)
+		return buffer.Bytes(), err
+	}
+
+	writer := multipart.NewWriter(&buffer)
+	writeHeader(&buffer, "Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	buffer.WriteString("\r\n")
+
+	body := bytes.Buffer{}
+	err := message.writeBody(&body, false)
+	if err != nil {
+		return nil, err
+	}
+	headers, content := splitPart(body.Bytes())
+	part, err := writer.CreatePart(headers)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(content)
+
+	for _, attachment := range message.Attachments {
+		contentType := attachment.ContentType
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+
+		headers := textproto.MIMEHeader{}
+		headers.Set("Content-Type", contentType)
+		headers.Set("Content-Transfer-Encoding", "base64")
+		headers.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+		part, err := writer.CreatePart(headers)
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeBase64(part, attachment.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writer.Close()
+
+	return buffer.Bytes(), err
+}
+
+// writeBody writes the content headers and the text and html bodies,
+// topLevel adds the blank line between the message headers and the body
+func (message *Message) writeBody(buffer *bytes.Buffer, topLevel bool) error {
+	if len(message.HTML) == 0 || len(message.Text) == 0 {
+		contentType := "text/html; charset=UTF-8"
+		content := message.HTML
+		if len(message.HTML) == 0 {
+			contentType = "text/plain; charset=UTF-8"
+			content = message.Text
+		}
+
+		writeHeader(buffer, "Content-Type", contentType)
+		writeHeader(buffer, "Content-Transfer-Encoding", "quoted-printable")
+		buffer.WriteString("\r\n")
+		return writeQuotedPrintable(buffer, content)
+	}
+
+	writer := multipart.NewWriter(buffer)
+	writeHeader(buffer, "Content-Type", "multipart/alternative; boundary="+writer.Boundary())
+	buffer.WriteString("\r\n")
+
+	// clients show the last alternative they support, html goes last
+	for _, alternative := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", message.Text},
+		{"text/html; charset=UTF-8", message.HTML},
+	} {
+		headers := textproto.MIMEHeader{}
+		headers.Set("Content-Type", alternative.contentType)
+		headers.Set("Content-Transfer-Encoding", "quoted-printable")
+		part, err := writer.CreatePart(headers)
+		if err != nil {
+			return err
+		}
+
+		err = writeQuotedPrintable(part, alternative.content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// writeHeader writes the header line, empty values are skipped
+func writeHeader(buffer *bytes.Buffer, key string, value string) {
+	if len(value) == 0 {
+		return
+	}
+
+	buffer.WriteString(key + ": " + headerReplacer.Replace(value) + "\r\n")
+}
+
+// splitPart separates the headers written by writeBody from its content
+func splitPart(data []byte) (textproto.MIMEHeader, []byte) {
+	headers := textproto.MIMEHeader{}
+	index := bytes.Index(data, []byte("\r\n\r\n"))
+	for _, line := range strings.Split(string(data[:index]), "\r\n") {
+		pair := strings.SplitN(line, ": ", 2)
+		headers.Set(pair[0], pair[1])
+	}
+
+	return headers, data[index+4:]
+}
+
+// writeQuotedPrintable encodes the content as quoted-printable
+func writeQuotedPrintable(writer io.Writer, content string) error {
+	encoder := quotedprintable.NewWriter(writer)
+	_, err := encoder.Write([]byte(content))
+	if err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// writeBase64 encodes the data as base64 in lines of 76 characters
+func writeBase64(writer io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		_, err := io.WriteString(writer, encoded[:76]+"\r\n")
+		if err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+
+	_, err := io.WriteString(writer, encoded+"\r\n")
+	return err
+}
+
+// senderDomain returns the domain of the sender address for the message id
+func senderDomain(from string) string {
+	index := strings.LastIndex(from, "@")
+	if index < 0 {
+		return "localhost"
+	}
+
+	return strings.Trim(from[index+1:], "> ")
+}
@@ -0,0 +1,89 @@
+package mail
+
+import (
+	"encoding/json"
+	"strings"
+
+	"anacove.com/backend/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// sesNotification describes the bounce, complaint and delivery notifications ses publishes to sns,
+// topics fed by a configuration set name the type eventType instead of notificationType
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			Status         string `json:"status"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+	Delivery struct {
+		Recipients []string `json:"recipients"`
+	} `json:"delivery"`
+}
+
+// HandleSESNotification applies an ses notification to the suppression list and the users,
+// permanent bounces and complaints suppress the address, transient bounces and deliveries only mark the users
+func HandleSESNotification(body string) error {
+	notification := sesNotification{}
+	err := json.Unmarshal([]byte(body), &notification)
+	if err != nil {
+		return err
+	}
+
+	notificationType := notification.NotificationType
+	if len(notificationType) == 0 {
+		notificationType = notification.EventType
+	}
+
+	switch notificationType {
+	case "Bounce":
+		bounce := notification.Bounce
+		for _, recipient := range bounce.BouncedRecipients {
+			diagnostic := strings.TrimSpace(bounce.BounceType + " " + bounce.BounceSubType + ": " + recipient.DiagnosticCode)
+			if bounce.BounceType == "Permanent" {
+				log.Warnf("Suppressing %s after a permanent bounce, %s", recipient.EmailAddress, diagnostic)
+				err = Suppress(recipient.EmailAddress, SuppressionBounce, diagnostic)
+			} else {
+				err = SetDeliverability(recipient.EmailAddress, common.DeliverabilitySoftBounced, diagnostic)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case "Complaint":
+		complaint := notification.Complaint
+		if complaint.ComplaintFeedbackType == "not-spam" {
+			return nil
+		}
+		for _, recipient := range complaint.ComplainedRecipients {
+			log.Warnf("Suppressing %s after a complaint", recipient.EmailAddress)
+			err = Suppress(recipient.EmailAddress, SuppressionComplaint, strings.TrimSpace("Complaint "+complaint.ComplaintFeedbackType))
+			if err != nil {
+				return err
+			}
+		}
+	case "Delivery":
+		for _, recipient := range notification.Delivery.Recipients {
+			err = SetDeliverability(recipient, common.DeliverabilityDelivered, "")
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		log.Infof("Ignoring ses notification of type %s", notificationType)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"anacove.com/backend/outbox"
+)
+
+// Queue stores the email in the outbox, the dispatcher sends it in the background with retries.
+// The email is rendered once so a broken template fails the caller instead of the dispatcher
+func Queue(email *Email, reference string) (*outbox.Message, error) {
+	_, err := email.Render()
+	if err != nil {
+		return nil, err
+	}
+
+	return outbox.Enqueue(outbox.KindEmail, reference, email)
+}
+
+// QueueHeld stores the email in the outbox before the write it belongs to, see outbox.EnqueueHeld
+func QueueHeld(email *Email, reference string) (*outbox.Message, error) {
+	_, err := email.Render()
+	if err != nil {
+		return nil, err
+	}
+
+	return outbox.EnqueueHeld(outbox.KindEmail, reference, email)
+}
+
+// deliverQueued sends the email of an outbox message
+func deliverQueued(message *outbox.Message) error {
+	email := Email{}
+	err := message.Decode(&email)
+	if err != nil {
+		return err
+	}
+
+	err = SendTemplate(&email)
+	if err == ErrSuppressed {
+		return outbox.Permanent(err)
+	}
+
+	return err
+}
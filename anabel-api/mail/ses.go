@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"errors"
+
+	"anacove.com/backend/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+// sesMailer sends raw MIME messages through aws ses, so attachments and bcc are supported
+type sesMailer struct {
+}
+
+// newSESMailer creates the mailer, it requires the global aws session
+func newSESMailer() (Mailer, error) {
+	if utils.AwsSession() == nil {
+		return nil, errors.New("aws session is not initialized")
+	}
+
+	return &sesMailer{}, nil
+}
+
+// Send delivers the message to the to, cc and bcc recipients
+func (mailer *sesMailer) Send(message *Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	input := &ses.SendRawEmailInput{
+		Source:       aws.String(message.From),
+		Destinations: aws.StringSlice(message.Recipients()),
+		RawMessage:   &ses.RawMessage{Data: data},
+	}
+
+	_, err = ses.New(utils.AwsSession()).SendRawEmail(input)
+
+	return err
+}
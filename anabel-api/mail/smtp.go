@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"errors"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+
+	"anacove.com/backend/config"
+)
+
+// smtpMailer sends emails to a plain smtp server, like MailHog during development
+type smtpMailer struct {
+	address  string
+	host     string
+	username string
+	password string
+}
+
+// newSMTPMailer creates the mailer from the mail.smtp configuration
+func newSMTPMailer() (Mailer, error) {
+	host := config.GetConfig().GetString("mail.smtp.host")
+	if len(host) == 0 {
+		return nil, errors.New("mail.smtp.host is not configured")
+	}
+
+	port := config.GetConfig().GetInt("mail.smtp.port")
+	if port <= 0 {
+		port = 25
+	}
+
+	return &smtpMailer{
+		address:  net.JoinHostPort(host, strconv.Itoa(port)),
+		host:     host,
+		username: config.GetConfig().GetString("mail.smtp.username"),
+		password: config.GetConfig().GetString("mail.smtp.password"),
+	}, nil
+}
+
+// Send delivers the message to the to, cc and bcc recipients,
+// the connection is upgraded with STARTTLS when the server offers it
+func (mailer *smtpMailer) Send(message *Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if len(mailer.username) > 0 {
+		auth = smtp.PlainAuth("", mailer.username, mailer.password, mailer.host)
+	}
+
+	from, err := netmail.ParseAddress(message.From)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(mailer.address, auth, from.Address, message.Recipients(), data)
+}
@@ -0,0 +1,197 @@
+package mail
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"anacove.com/backend/config"
+	"anacove.com/backend/utils"
+)
+
+const (
+	// SNSTypeNotification carries an ses bounce, complaint or delivery notification
+	SNSTypeNotification = "Notification"
+	// SNSTypeSubscriptionConfirmation asks to confirm the subscription of the endpoint to the topic
+	SNSTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	// SNSTypeUnsubscribeConfirmation tells the endpoint was unsubscribed from the topic
+	SNSTypeUnsubscribeConfirmation = "UnsubscribeConfirmation"
+)
+
+// snsHost matches the hosts sns serves signing certificates and subscription urls from
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsClient fetches signing certificates and confirms subscriptions
+var snsClient = &http.Client{Timeout: 10 * time.Second}
+
+// certificates caches the signing certificates by url
+var certificates = map[string]*x509.Certificate{}
+
+var certificatesMu sync.Mutex
+
+// SNSMessage godoc
+// describes the envelope sns posts to the endpoint
+type SNSMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// Verify checks the message is signed by sns for one of the configured topics,
+// every message is rejected while no topic is configured as anybody can sign messages of an own topic
+func (message *SNSMessage) Verify() error {
+	topics := config.GetConfig().GetStringSlice("mail.sns.topic_arns")
+	if len(topics) == 0 {
+		return errors.New("no sns topic is configured in mail.sns.topic_arns")
+	}
+	if !utils.Contains(topics, message.TopicArn) {
+		return errors.New("unexpected topic " + message.TopicArn)
+	}
+
+	var hash crypto.Hash
+	switch message.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return errors.New("unsupported signature version " + message.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := signingCertificate(message.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	key, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate has no rsa key")
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(message.stringToSign()))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(message.stringToSign()))
+		digest = sum[:]
+	}
+
+	return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+}
+
+// ConfirmSubscription visits the subscribe url of a verified subscription confirmation
+func (message *SNSMessage) ConfirmSubscription() error {
+	if !isSNSURL(message.SubscribeURL) {
+		return errors.New("subscribe url is not an sns url")
+	}
+
+	resp, err := snsClient.Get(message.SubscribeURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("subscription confirmation failed with status " + resp.Status)
+	}
+
+	return nil
+}
+
+// stringToSign builds the canonical string sns signs, the fields depend on the message type
+func (message *SNSMessage) stringToSign() string {
+	fields := [][2]string{{"Message", message.Message}, {"MessageId", message.MessageID}}
+	if message.Type == SNSTypeNotification {
+		if len(message.Subject) > 0 {
+			fields = append(fields, [2]string{"Subject", message.Subject})
+		}
+		fields = append(fields, [2]string{"Timestamp", message.Timestamp})
+	} else {
+		fields = append(fields,
+			[2]string{"SubscribeURL", message.SubscribeURL},
+			[2]string{"Timestamp", message.Timestamp},
+			[2]string{"Token", message.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", message.TopicArn}, [2]string{"Type", message.Type})
+
+	builder := strings.Builder{}
+	for _, field := range fields {
+		builder.WriteString(field[0] + "\n" + field[1] + "\n")
+	}
+
+	return builder.String()
+}
+
+// signingCertificate downloads the certificate from sns, only sns hosts over https are trusted
+func signingCertificate(certURL string) (*x509.Certificate, error) {
+	if !isSNSURL(certURL) {
+		return nil, errors.New("signing certificate url is not an sns url")
+	}
+
+	certificatesMu.Lock()
+	defer certificatesMu.Unlock()
+
+	if certificate, ok := certificates[certURL]; ok {
+		return certificate, nil
+	}
+
+	resp, err := snsClient.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing certificate is not pem encoded")
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	certificates[certURL] = certificate
+
+	return certificate, nil
+}
+
+// isSNSURL checks the url points to sns over https
+func isSNSURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	return parsed.Scheme == "https" && snsHost.MatchString(parsed.Hostname())
+}
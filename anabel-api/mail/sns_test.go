@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"anacove.com/backend/config"
+	"github.com/spf13/viper"
+)
+
+func TestVerifyRejectsWithoutTopics(t *testing.T) {
+	config.SetConfig(viper.New())
+
+	message := SNSMessage{Type: "Notification", TopicArn: "arn:aws:sns:us-east-1:999999999999:anything", SignatureVersion: "1"}
+	err := message.Verify()
+	if err == nil || !strings.Contains(err.Error(), "no sns topic") {
+		t.Errorf("a message was not rejected without configured topics: %v", err)
+	}
+}
+
+func TestVerifyRejectsOtherTopics(t *testing.T) {
+	v := viper.New()
+	v.Set("mail.sns.topic_arns", []string{"arn:aws:sns:us-east-1:123456789012:ses-notifications"})
+	config.SetConfig(v)
+
+	message := SNSMessage{Type: "Notification", TopicArn: "arn:aws:sns:us-east-1:999999999999:anything", SignatureVersion: "1"}
+	err := message.Verify()
+	if err == nil || !strings.Contains(err.Error(), "unexpected topic") {
+		t.Errorf("a message of another topic was not rejected: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package mail
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SuppressionBounce the address bounced permanently
+	SuppressionBounce = "bounce"
+	// SuppressionComplaint the recipient marked an email as spam
+	SuppressionComplaint = "complaint"
+)
+
+// ErrSuppressed is returned when every recipient of a message is on the suppression list
+var ErrSuppressed = errors.New("every recipient of the email is suppressed")
+
+// Suppression godoc
+// describes an address no email is sent to anymore
+type Suppression struct {
+	ID         bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	Email      string        `json:"email" bson:"email"`
+	Reason     string        `json:"reason" bson:"reason"`
+	Diagnostic string        `json:"diagnostic" bson:"diagnostic"`
+	Count      int           `json:"count" bson:"count"`
+	CreatedAt  time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// normalizeAddress lowers the address, the suppression list is matched case insensitively
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// Suppress adds the address to the suppression list and marks the users of the address,
+// an address already on the list keeps its first reason unless it is a complaint
+func Suppress(address string, reason string, diagnostic string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SuppressionCollection)
+
+	now := time.Now().UTC()
+	set := bson.M{"diagnostic": diagnostic, "updatedAt": now}
+	setOnInsert := bson.M{"_id": bson.NewObjectId(), "createdAt": now}
+	if reason == SuppressionComplaint {
+		set["reason"] = reason
+	} else {
+		setOnInsert["reason"] = reason
+	}
+
+	_, err := c.Upsert(bson.M{"email": normalizeAddress(address)}, bson.M{
+		"$set":         set,
+		"$setOnInsert": setOnInsert,
+		"$inc":         bson.M{"count": 1},
+	})
+	if err != nil {
+		log.Errorf("error occurred during suppressing %s, error: %v\n", address, err)
+		return err
+	}
+
+	status := common.DeliverabilityBounced
+	if reason == SuppressionComplaint {
+		status = common.DeliverabilityComplained
+	}
+
+	return SetDeliverability(address, status, diagnostic)
+}
+
+// Unsuppress removes the address from the suppression list so emails are sent to it again
+func Unsuppress(address string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SuppressionCollection)
+
+	err := c.Remove(bson.M{"email": normalizeAddress(address)})
+	if err != nil {
+		return err
+	}
+
+	return SetDeliverability(address, "", "")
+}
+
+// FindSuppression returns the suppression of the address, nil when the address is not suppressed
+func FindSuppression(address string) (*Suppression, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SuppressionCollection)
+
+	suppression := Suppression{}
+	err := c.Find(bson.M{"email": normalizeAddress(address)}).One(&suppression)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &suppression, nil
+}
+
+// DeliverabilityOf returns the user deliverability status matching the suppression reason
+func (suppression *Suppression) DeliverabilityOf() string {
+	if suppression.Reason == SuppressionComplaint {
+		return common.DeliverabilityComplained
+	}
+
+	return common.DeliverabilityBounced
+}
+
+// SetDeliverability records the outcome of the last email on the users of the address
+func SetDeliverability(address string, status string, reason string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	pattern := "^" + regexp.QuoteMeta(strings.TrimSpace(address)) + "$"
+	_, err := c.UpdateAll(bson.M{"email": bson.RegEx{Pattern: pattern, Options: "i"}}, bson.M{"$set": bson.M{
+		"deliverability":       status,
+		"deliverabilityReason": reason,
+		"deliverabilityAt":     time.Now().UTC(),
+	}})
+	if err != nil {
+		log.Errorf("error occurred during updating deliverability of %s, error: %v\n", address, err)
+	}
+
+	return err
+}
+
+// removeSuppressed drops the suppressed recipients from the message, ErrSuppressed when none is left
+func removeSuppressed(message *Message) error {
+	recipients := message.Recipients()
+	addresses := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		addresses = append(addresses, normalizeAddress(recipient))
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SuppressionCollection)
+
+	suppressions := []Suppression{}
+	err := c.Find(bson.M{"email": bson.M{"$in": addresses}}).All(&suppressions)
+	if err != nil {
+		return err
+	}
+	if len(suppressions) == 0 {
+		return nil
+	}
+
+	suppressed := map[string]bool{}
+	for _, suppression := range suppressions {
+		suppressed[suppression.Email] = true
+	}
+
+	filter := func(list []string) []string {
+		kept := []string{}
+		for _, address := range list {
+			if suppressed[normalizeAddress(address)] {
+				log.Warnf("Not sending email to suppressed address %s", address)
+				continue
+			}
+			kept = append(kept, address)
+		}
+		return kept
+	}
+	message.To = filter(message.To)
+	message.Cc = filter(message.Cc)
+	message.Bcc = filter(message.Bcc)
+
+	if len(message.Recipients()) == 0 {
+		return ErrSuppressed
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"anacove.com/backend/config"
+)
+
+const (
+	// TemplateInvitation invites a new user to activate the account
+	TemplateInvitation = "invitation"
+	// TemplatePasswordReset sends the link to choose a new password
+	TemplatePasswordReset = "passwordReset"
+	// TemplatePasswordChanged confirms the password of the account was changed
+	TemplatePasswordChanged = "passwordChanged"
+	// TemplateAccountArchived tells the users of an archived client they lost access
+	TemplateAccountArchived = "accountArchived"
+	// TemplateAlertCreated tells the user a new alert was raised
+	TemplateAlertCreated = "alertCreated"
+	// TemplateAlertAssigned tells the user an alert was assigned to them
+	TemplateAlertAssigned = "alertAssigned"
+	// TemplateAlertEscalated tells the user an alert stayed unassigned past a tier of its escalation policy
+	TemplateAlertEscalated = "alertEscalated"
+	// TemplateAlertMentioned tells the user a comment on an alert mentions them
+	TemplateAlertMentioned = "alertMentioned"
+	// TemplateDailyDigest summarizes the alerts of the day
+	TemplateDailyDigest = "dailyDigest"
+	// TemplateWeeklyDigest summarizes the alerts of the week
+	TemplateWeeklyDigest = "weeklyDigest"
+)
+
+// Templates lists the names of the transactional email templates
+var Templates = []string{
+	TemplateInvitation,
+	TemplatePasswordReset,
+	TemplatePasswordChanged,
+	TemplateAccountArchived,
+	TemplateAlertCreated,
+	TemplateAlertAssigned,
+	TemplateAlertEscalated,
+	TemplateAlertMentioned,
+	TemplateDailyDigest,
+	TemplateWeeklyDigest,
+}
+
+// Brand godoc
+// describes the client an email is sent on behalf of
+type Brand struct {
+	Name    string `bson:"name"`
+	LogoURL string `bson:"logoUrl"`
+}
+
+// Email godoc
+// describes an email rendered from a named template in the language of the recipient
+type Email struct {
+	Template string                 `bson:"template"`
+	Locale   string                 `bson:"locale"`
+	To       []string               `bson:"to"`
+	Brand    Brand                  `bson:"brand"`
+	Data     map[string]interface{} `bson:"data"`
+}
+
+// templateSet keeps the html body and the text subject and fallback body of a template
+type templateSet struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// templates keeps the parsed templates by locale and name
+var templates = map[string]map[string]*templateSet{}
+
+var defaultLocale string
+
+// LoadTemplates parses the templates of every locale directory under mail.templates_dir, Init calls it.
+// Every locale has a layout.html and layout.txt, and every template a <name>.html defining "content"
+// and a <name>.txt defining "subject", "content" and optionally "sms". The default locale must have all templates,
+// the others fall back to it
+func LoadTemplates() error {
+	dir := config.GetConfig().GetString("mail.templates_dir")
+	if len(dir) == 0 {
+		dir = "templates/email"
+	}
+
+	defaultLocale = config.GetConfig().GetString("mail.default_locale")
+	if len(defaultLocale) == 0 {
+		defaultLocale = "en"
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	templates = map[string]map[string]*templateSet{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		localeDir := filepath.Join(dir, entry.Name())
+		sets := map[string]*templateSet{}
+		for _, name := range Templates {
+			set, err := parseTemplate(localeDir, name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("cannot parse template %s/%s: %v", entry.Name(), name, err)
+			}
+			sets[name] = set
+		}
+		templates[normalizeLocale(entry.Name())] = sets
+	}
+
+	for _, name := range Templates {
+		if _, ok := templates[defaultLocale][name]; !ok {
+			return fmt.Errorf("template %s is missing for default locale %s", name, defaultLocale)
+		}
+	}
+
+	return nil
+}
+
+// parseTemplate parses the html and text files of the template with the layouts of its locale
+func parseTemplate(localeDir string, name string) (*templateSet, error) {
+	htmlPath := filepath.Join(localeDir, name+".html")
+	textPath := filepath.Join(localeDir, name+".txt")
+	for _, path := range []string{htmlPath, textPath} {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+
+	html, err := htmltemplate.ParseFiles(filepath.Join(localeDir, "layout.html"), htmlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := texttemplate.ParseFiles(filepath.Join(localeDir, "layout.txt"), textPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &templateSet{html: html, text: text}, nil
+}
+
+// normalizeLocale turns ja_JP or JA-jp into ja-jp
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// lookupTemplate finds the template in the locale, its language or the default locale, in that order
+func lookupTemplate(name string, locale string) (*templateSet, bool) {
+	locale = normalizeLocale(locale)
+	candidates := []string{locale, strings.Split(locale, "-")[0], defaultLocale}
+	for _, candidate := range candidates {
+		if set, ok := templates[candidate][name]; ok {
+			return set, true
+		}
+	}
+
+	return nil, false
+}
+
+// TemplateLocales returns the locales the template is translated to
+func TemplateLocales(name string) []string {
+	locales := []string{}
+	for locale, sets := range templates {
+		if _, ok := sets[name]; ok {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales)
+
+	return locales
+}
+
+// Render executes the template into a message,
+// templates reach the branding as .Brand and the values as .Data
+func (email *Email) Render() (*Message, error) {
+	set, ok := lookupTemplate(email.Template, email.Locale)
+	if !ok {
+		return nil, errors.New("unknown email template " + email.Template)
+	}
+
+	data := struct {
+		Brand Brand
+		Data  map[string]interface{}
+	}{email.Brand, email.Data}
+
+	subject := bytes.Buffer{}
+	err := set.text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	html := bytes.Buffer{}
+	err = set.html.ExecuteTemplate(&html, "layout", data)
+	if err != nil {
+		return nil, err
+	}
+
+	text := bytes.Buffer{}
+	err = set.text.ExecuteTemplate(&text, "layout", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:      email.To,
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    text.String(),
+	}, nil
+}
+
+// RenderSMS executes the "sms" definition of the template into a text message body,
+// templates without one are sent as their subject
+func (email *Email) RenderSMS() (string, error) {
+	set, ok := lookupTemplate(email.Template, email.Locale)
+	if !ok {
+		return "", errors.New("unknown email template " + email.Template)
+	}
+
+	name := "sms"
+	if set.text.Lookup(name) == nil {
+		name = "subject"
+	}
+
+	body := bytes.Buffer{}
+	err := set.text.ExecuteTemplate(&body, name, struct {
+		Brand Brand
+		Data  map[string]interface{}
+	}{email.Brand, email.Data})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(body.String()), nil
+}
+
+// SendTemplate renders the email and delivers it with the configured mailer
+func SendTemplate(email *Email) error {
+	message, err := email.Render()
+	if err != nil {
+		return err
+	}
+
+	return Send(message)
+}
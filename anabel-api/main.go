@@ -4,15 +4,38 @@ import (
 	"net/http"
 	"os"
 
+	"anacove.com/backend/rest/alert"
 	"anacove.com/backend/rest/dummy"
+	"anacove.com/backend/rest/escalation"
+	"anacove.com/backend/rest/site"
+	"anacove.com/backend/rest/sla"
 	"anacove.com/backend/rest/user"
+	"anacove.com/backend/rest/webhook"
 
+	"anacove.com/backend/rest/admin"
 	"anacove.com/backend/rest/client"
+	"anacove.com/backend/rest/email"
 	"anacove.com/backend/rest/file"
 
+	"anacove.com/backend/chat"
 	"anacove.com/backend/config"
+	"anacove.com/backend/correlation"
+	"anacove.com/backend/dedup"
+	"anacove.com/backend/digest"
+	escalations "anacove.com/backend/escalation"
+	"anacove.com/backend/history"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/maintenance"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/outbox"
 	"anacove.com/backend/rest/security"
+	"anacove.com/backend/routing"
+	"anacove.com/backend/rules"
+	slas "anacove.com/backend/sla"
+	"anacove.com/backend/sms"
+	"anacove.com/backend/storage"
 	"anacove.com/backend/utils"
+	hooks "anacove.com/backend/webhook"
 	"github.com/emicklei/go-restful"
 	log "github.com/sirupsen/logrus"
 )
@@ -48,12 +71,70 @@ func main() {
 		return
 	}
 
+	// aws is optional when storage runs on local disk or an s3 compatible service
 	err = utils.InitAWS()
 	if err != nil {
-		log.Fatalf("failed to initialize aws: %v", err)
+		log.Warnf("failed to initialize aws, aws services are not available: %v", err)
+	}
+
+	// init blob storage
+	err = storage.Init()
+	if err != nil {
+		log.Fatalf("failed to initialize storage: %v", err)
 		return
 	}
 
+	// init mail delivery
+	err = mail.Init()
+	if err != nil {
+		log.Fatalf("failed to initialize mailer: %v", err)
+		return
+	}
+
+	// text messages fall back to email when no sms provider is available
+	err = sms.Init()
+	if err != nil {
+		log.Warnf("failed to initialize sms, notifications are sent by email: %v", err)
+	}
+	file.Init()
+	// the history listens first so entries keep the order of the changes
+	history.Init()
+	notification.Init()
+	hooks.Init()
+	chat.Init()
+	digest.Init()
+	escalations.Init()
+	routing.Init()
+	maintenance.Init()
+	dedup.Init()
+	correlation.Init()
+	rules.Init()
+	slas.Init()
+
+	// deliver the outbox messages in the background
+	outbox.Start()
+
+	// discard the upload sessions which expired before they were completed
+	file.Start()
+
+	// send the alert summaries when their period ends
+	digest.Start()
+
+	// notify the escalation tiers of the alerts nobody took
+	escalations.Start()
+
+	// raise the alerts of the alert rules of the sites on the readings of their devices
+	rules.Start()
+
+	// apply the maintenance windows, merge the identical alerts and raise one alert for the flapping sources
+	dedup.Start()
+
+	// assign the new alerts by the routing rules of their site
+	routing.Start()
+
+	// group the related alerts into incidents by the correlation rules of their site
+	correlation.Start()
+
 	// init routing
 	wsContainer := restful.NewContainer()
 	ws := new(restful.WebService)
@@ -64,12 +145,20 @@ func main() {
 	user.Controller{}.AddRouters(ws)
 	client.Controller{}.AddRouters(ws)
 	file.Controller{}.AddRouters(ws)
+	email.Controller{}.AddRouters(ws)
+	admin.Controller{}.AddRouters(ws)
+	webhook.Controller{}.AddRouters(ws)
+	alert.Controller{}.AddRouters(ws)
+	site.Controller{}.AddRouters(ws)
+	escalation.Controller{}.AddRouters(ws)
+	sla.Controller{}.AddRouters(ws)
 	dummy.Controller{}.AddRouters(ws)
 	wsContainer.Add(ws)
 
 	// Add container filter to enable CORS
 	cors := restful.CrossOriginResourceSharing{
 		AllowedHeaders: []string{"Content-Type", "Accept", "Authorization"},
+		ExposeHeaders:  []string{"ETag"},
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		CookiesAllowed: false,
 		Container:      wsContainer}
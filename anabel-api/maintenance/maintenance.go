@@ -0,0 +1,291 @@
+package maintenance
+
+import (
+	"errors"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ActionSuppress clears the alerts as soon as they are raised, they are kept with suppressed set
+	ActionSuppress = "suppress"
+	// ActionDowngrade lowers the priority of the alerts to the priority of the window
+	ActionDowngrade = "downgrade"
+)
+
+// Actions lists what a window can do with the alerts it matches
+var Actions = []string{ActionSuppress, ActionDowngrade}
+
+// clockLayout is the format of the start and end of a recurrence
+const clockLayout = "15:04"
+
+// Recurrence godoc
+// repeats the window on the weekdays (0 is sunday) from start to end, both HH:MM in the time zone of the window.
+// A recurrence ending before it starts ends the next day
+type Recurrence struct {
+	Weekdays []int  `json:"weekdays" bson:"weekdays"`
+	Start    string `json:"start" bson:"start"`
+	End      string `json:"end" bson:"end"`
+}
+
+// Window godoc
+// suppresses or downgrades the alerts of the alert types raised at a site while it lasts, only the ones of the rooms
+// or devices when it has any. A one-off window lasts from start to end, a recurring one repeats from start on,
+// until end when it has one. Empty lists match everything
+type Window struct {
+	ID         bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID   string        `json:"clientId" bson:"clientId"`
+	SiteID     string        `json:"siteId" bson:"siteId"`
+	Name       string        `json:"name" bson:"name"`
+	Action     string        `json:"action" bson:"action"`
+	Priority   string        `json:"priority" bson:"priority"`
+	AlertTypes []string      `json:"alertTypes" bson:"alertTypes"`
+	Rooms      []string      `json:"rooms" bson:"rooms"`
+	Devices    []string      `json:"devices" bson:"devices"`
+	Start      time.Time     `json:"start" bson:"start"`
+	End        time.Time     `json:"end" bson:"end,omitempty"`
+	Recurrence *Recurrence   `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	TimeZone   string        `json:"timeZone" bson:"timeZone"`
+	Enabled    bool          `json:"enabled" bson:"enabled"`
+	CreatedBy  string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt  time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Init creates the index used to find the windows of a site
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.MaintenanceWindowCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "enabled"}})
+	if err == nil {
+		err = session.DB("").C(common.AlertCollection).EnsureIndex(mgo.Index{Key: []string{"maintenanceWindowId"}, Sparse: true})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create maintenance indexes, error: %v", err)
+	}
+}
+
+// Validate checks the action, priority, alert types, time zone and times of the window
+func (window *Window) Validate() error {
+	if len(window.Name) == 0 {
+		return errors.New("a window needs a name")
+	}
+	if !utils.Contains(Actions, window.Action) {
+		return errors.New("unknown action " + window.Action)
+	}
+	if window.Action == ActionDowngrade && (len(window.Priority) == 0 || !notification.ValidPriority(window.Priority)) {
+		return errors.New("a downgrade needs a known priority")
+	}
+	for _, alertType := range window.AlertTypes {
+		if !utils.Contains(notification.AlertTypes, alertType) {
+			return errors.New("unknown alert type " + alertType)
+		}
+	}
+	if _, err := time.LoadLocation(window.TimeZone); len(window.TimeZone) > 0 && err != nil {
+		return errors.New("unknown time zone " + window.TimeZone)
+	}
+	if window.Start.IsZero() {
+		return errors.New("a window needs a start")
+	}
+	if window.Recurrence == nil && window.End.IsZero() {
+		return errors.New("a one-off window needs an end")
+	}
+	if !window.End.IsZero() && !window.End.After(window.Start) {
+		return errors.New("a window must end after it starts")
+	}
+
+	if recurrence := window.Recurrence; recurrence != nil {
+		if len(recurrence.Weekdays) == 0 {
+			return errors.New("a recurrence needs weekdays")
+		}
+		for _, weekday := range recurrence.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return errors.New("the weekday must be between 0 and 6")
+			}
+		}
+		start, err := parseClock(recurrence.Start)
+		if err != nil {
+			return errors.New("the recurrence start must be a HH:MM time")
+		}
+		end, err := parseClock(recurrence.End)
+		if err != nil {
+			return errors.New("the recurrence end must be a HH:MM time")
+		}
+		if start == end {
+			return errors.New("a recurrence must end at another time than it starts")
+		}
+	}
+
+	return nil
+}
+
+// ActiveAt checks the window lasts at the time, recurrences are checked in the time zone of the window
+func (window *Window) ActiveAt(at time.Time) bool {
+	if !window.Enabled || at.Before(window.Start) || (!window.End.IsZero() && !at.Before(window.End)) {
+		return false
+	}
+	if window.Recurrence == nil {
+		return true
+	}
+
+	start, err := parseClock(window.Recurrence.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(window.Recurrence.End)
+	if err != nil {
+		return false
+	}
+
+	local := at.In(window.location())
+	minute := local.Hour()*60 + local.Minute()
+	today := int(local.Weekday())
+	yesterday := (today + 6) % 7
+	for _, weekday := range window.Recurrence.Weekdays {
+		if start < end && weekday == today && minute >= start && minute < end {
+			return true
+		}
+		if start > end && ((weekday == today && minute >= start) || (weekday == yesterday && minute < end)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches checks the window applies to the alert type, room and device of the alert
+func (window *Window) Matches(alert *common.Alert) bool {
+	return (len(window.AlertTypes) == 0 || utils.Contains(window.AlertTypes, alert.Type)) &&
+		(len(window.Rooms) == 0 || utils.Contains(window.Rooms, alert.Location)) &&
+		(len(window.Devices) == 0 || utils.Contains(window.Devices, alert.Device))
+}
+
+// location returns the time zone of the window, the default time zone of the notifications when it has none
+func (window *Window) location() *time.Location {
+	for _, name := range []string{window.TimeZone, config.GetConfig().GetString("notification.default_time_zone")} {
+		if len(name) == 0 {
+			continue
+		}
+		location, err := time.LoadLocation(name)
+		if err == nil {
+			return location
+		}
+	}
+
+	return time.UTC
+}
+
+// Apply suppresses or downgrades the New alert when a window of its site lasts at its alert time,
+// suppressing windows go first. It tells whether the alert was suppressed
+func Apply(session *mgo.Session, alert *common.Alert, now time.Time) (bool, error) {
+	windows := []Window{}
+	err := session.DB("").C(common.MaintenanceWindowCollection).Find(bson.M{"siteId": alert.SiteID, "enabled": true}).Sort("createdAt").All(&windows)
+	if err != nil || len(windows) == 0 || alert.Status != common.AlertStatusNew {
+		return false, err
+	}
+
+	var downgrade *Window
+	for i := range windows {
+		window := &windows[i]
+		if !window.ActiveAt(alert.AlertTime) || !window.Matches(alert) {
+			continue
+		}
+		if window.Action == ActionSuppress {
+			return suppress(session, alert, window, now)
+		}
+		if downgrade == nil && !notification.MeetsPriority(window.Priority, alert.Priority) {
+			downgrade = window
+		}
+	}
+
+	if downgrade != nil {
+		return false, lower(session, alert, downgrade, now)
+	}
+
+	return false, nil
+}
+
+// suppress clears the alert while it is still New and not routed, it is kept with the window which suppressed it
+func suppress(session *mgo.Session, alert *common.Alert, window *Window, now time.Time) (bool, error) {
+	previous := *alert
+	alert.Status = common.AlertStatusCleared
+	alert.ClearTime = alert.AlertTime
+	alert.Reason = "Maintenance"
+	alert.Detailed = window.Name
+	alert.Suppressed = true
+	alert.MaintenanceWindowID = window.ID.Hex()
+	alert.UpdatedAt = now
+
+	err := session.DB("").C(common.AlertCollection).Update(
+		bson.M{"_id": alert.ID, "status": common.AlertStatusNew, "routedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"status":              alert.Status,
+			"clearTime":           alert.ClearTime,
+			"reason":              alert.Reason,
+			"detailed":            alert.Detailed,
+			"suppressed":          alert.Suppressed,
+			"maintenanceWindowId": alert.MaintenanceWindowID,
+			"updatedAt":           alert.UpdatedAt,
+		}},
+	)
+	if err == mgo.ErrNotFound {
+		*alert = previous
+		return false, nil
+	}
+	if err != nil {
+		*alert = previous
+		return false, err
+	}
+
+	log.Debugf("Maintenance window %s suppressed alert %s", window.ID.Hex(), alert.ID.Hex())
+	alerting.Emit(&alerting.Event{Type: alerting.EventSuppressed, Alert: alert, Previous: &previous, At: now})
+	return true, nil
+}
+
+// lower sets the priority of the alert to the priority of the window and keeps the priority it was raised with
+func lower(session *mgo.Session, alert *common.Alert, window *Window, now time.Time) error {
+	previous := *alert
+	alert.DowngradedFrom = alert.Priority
+	alert.Priority = window.Priority
+	alert.MaintenanceWindowID = window.ID.Hex()
+	alert.UpdatedAt = now
+
+	err := session.DB("").C(common.AlertCollection).Update(
+		bson.M{"_id": alert.ID, "priority": previous.Priority},
+		bson.M{"$set": bson.M{
+			"priority":            alert.Priority,
+			"downgradedFrom":      alert.DowngradedFrom,
+			"maintenanceWindowId": alert.MaintenanceWindowID,
+			"updatedAt":           alert.UpdatedAt,
+		}},
+	)
+	if err == mgo.ErrNotFound {
+		*alert = previous
+		return nil
+	}
+	if err != nil {
+		*alert = previous
+		return err
+	}
+
+	alerting.Emit(&alerting.Event{Type: alerting.EventDowngraded, Alert: alert, Previous: &previous, At: now})
+	return nil
+}
+
+// parseClock returns the minute of the day of an HH:MM clock time
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse(clockLayout, clock)
+	if err != nil {
+		return 0, err
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
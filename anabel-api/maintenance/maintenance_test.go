@@ -0,0 +1,87 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"github.com/spf13/viper"
+)
+
+// at parses the times of the tests
+func at(value string) time.Time {
+	parsed, _ := time.Parse(time.RFC3339, value)
+	return parsed
+}
+
+func TestActiveAtOneOff(t *testing.T) {
+	config.SetConfig(viper.New())
+	window := &Window{Enabled: true, Start: at("2026-10-19T01:00:00Z"), End: at("2026-10-19T03:00:00Z")}
+
+	for value, active := range map[string]bool{
+		"2026-10-19T00:59:00Z": false,
+		"2026-10-19T01:00:00Z": true,
+		"2026-10-19T02:59:00Z": true,
+		"2026-10-19T03:00:00Z": false,
+	} {
+		if window.ActiveAt(at(value)) != active {
+			t.Errorf("the window active at %s should be %v", value, active)
+		}
+	}
+
+	window.Enabled = false
+	if window.ActiveAt(at("2026-10-19T02:00:00Z")) {
+		t.Error("a disabled window is active")
+	}
+}
+
+func TestActiveAtRecurring(t *testing.T) {
+	config.SetConfig(viper.New())
+	window := &Window{
+		Enabled:    true,
+		TimeZone:   "Asia/Tokyo",
+		Start:      at("2026-10-01T00:00:00Z"),
+		Recurrence: &Recurrence{Weekdays: []int{1}, Start: "23:00", End: "02:00"},
+	}
+
+	for value, active := range map[string]bool{
+		"2026-10-19T13:59:00Z": false, // monday 22:59 in Tokyo
+		"2026-10-19T14:00:00Z": true,  // monday 23:00
+		"2026-10-19T16:30:00Z": true,  // tuesday 01:30, the monday night goes on
+		"2026-10-19T17:00:00Z": false, // tuesday 02:00
+		"2026-10-20T14:30:00Z": false, // tuesday 23:30
+		"2026-09-28T14:30:00Z": false, // a monday before the window starts
+	} {
+		if window.ActiveAt(at(value)) != active {
+			t.Errorf("the window active at %s should be %v", value, active)
+		}
+	}
+
+	window.End = at("2026-10-26T00:00:00Z")
+	if window.ActiveAt(at("2026-10-26T14:30:00Z")) {
+		t.Error("a recurring window is active after its end")
+	}
+
+	window.Recurrence = &Recurrence{Weekdays: []int{1}, Start: "09:00", End: "12:00"}
+	if !window.ActiveAt(at("2026-10-19T01:00:00Z")) || window.ActiveAt(at("2026-10-19T03:00:00Z")) {
+		t.Error("a recurrence within the day is not active from its start to its end")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	window := &Window{AlertTypes: []string{common.AlertTypeSystemAlert}, Rooms: []string{"101"}}
+
+	if !window.Matches(&common.Alert{Type: common.AlertTypeSystemAlert, Location: "101", Device: "ac"}) {
+		t.Error("the window does not apply to an alert of its type and room")
+	}
+	if window.Matches(&common.Alert{Type: common.AlertTypeSystemAlert, Location: "102"}) {
+		t.Error("the window applies to another room")
+	}
+	if window.Matches(&common.Alert{Type: common.AlertTypeStaffAlert, Location: "101"}) {
+		t.Error("the window applies to another alert type")
+	}
+	if !(&Window{}).Matches(&common.Alert{Type: common.AlertTypeStaffAlert}) {
+		t.Error("a window without lists does not apply to every alert")
+	}
+}
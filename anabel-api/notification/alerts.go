@@ -0,0 +1,103 @@
+package notification
+
+import (
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// notifyAlert tells the users of the site about raised alerts and the users an alert was assigned to about the assignment,
+// only the outbox messages are queued here so the change is not slowed down by the delivery
+func notifyAlert(event *alerting.Event) error {
+	switch event.Type {
+	case alerting.EventCreated:
+		return notifyCreated(event.Alert)
+	case alerting.EventAssigned, alerting.EventReassigned:
+		return notifyAssigned(event)
+	}
+
+	return nil
+}
+
+// notifyCreated notifies the active users scoped to the site or the client of the alert on the channels of their notification matrix
+func notifyCreated(alert *common.Alert) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	users := []common.User{}
+	err := session.DB("").C(common.UserCollection).Find(bson.M{
+		"status":                 common.Active,
+		"permissions.scopes.ids": bson.M{"$in": []string{alert.SiteID, alert.ClientID}},
+	}).All(&users)
+	if err != nil || len(users) == 0 {
+		return err
+	}
+
+	return NotifyAlert(users, alert.Type, alert.Priority, alert.ID.Hex(), mail.TemplateAlertCreated, mail.GetBrand(alert.ClientID), alertData(session, alert))
+}
+
+// notifyAssigned notifies the users who were added to the assignees of the alert,
+// users who assigned the alert to themselves are not notified
+func notifyAssigned(event *alerting.Event) error {
+	previous := map[string]bool{}
+	if event.Previous != nil {
+		for _, user := range event.Previous.AssignedTo {
+			previous[user.ID] = true
+		}
+	}
+
+	ids := []bson.ObjectId{}
+	for _, user := range event.Alert.AssignedTo {
+		if previous[user.ID] || user.ID == event.ActorID || !bson.IsObjectIdHex(user.ID) {
+			continue
+		}
+		ids = append(ids, bson.ObjectIdHex(user.ID))
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	users := []common.User{}
+	err := session.DB("").C(common.UserCollection).Find(bson.M{"_id": bson.M{"$in": ids}, "status": common.Active}).All(&users)
+	if err != nil || len(users) == 0 {
+		return err
+	}
+
+	alert := event.Alert
+	return NotifyAlert(users, alert.Type, alert.Priority, alert.ID.Hex(), mail.TemplateAlertAssigned, mail.GetBrand(alert.ClientID), alertData(session, alert))
+}
+
+// alertData returns the values the alert templates show
+func alertData(session *mgo.Session, alert *common.Alert) map[string]interface{} {
+	return map[string]interface{}{
+		"AlertType": alert.Type,
+		"SiteName":  siteName(session, alert.SiteID),
+		"Room":      alert.Location,
+		"CreatedAt": alert.AlertTime.Format("2006-01-02 15:04 MST"),
+		"URL":       config.GetConfig().GetString("app.alert_url") + alert.ID.Hex(),
+	}
+}
+
+// siteName returns the name of the site shown in the notification, its id when it cannot be found
+func siteName(session *mgo.Session, siteID string) string {
+	if !bson.IsObjectIdHex(siteID) {
+		return siteID
+	}
+
+	site := struct {
+		Name string `bson:"name"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).Select(bson.M{"name": 1}).One(&site)
+	if err != nil {
+		return siteID
+	}
+
+	return site.Name
+}
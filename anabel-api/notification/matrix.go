@@ -0,0 +1,178 @@
+package notification
+
+import (
+	"fmt"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/utils"
+)
+
+// AlertTypes lists the alert types a notification matrix can have rows for
+var AlertTypes = []string{
+	common.AlertTypeStaffAlert,
+	common.AlertTypeNotification,
+	common.AlertTypeSystemAlert,
+}
+
+// Channels lists the channels a notification matrix can select
+var Channels = []string{
+	ChannelInApp,
+	ChannelEmail,
+	ChannelSMS,
+	ChannelWebhook,
+}
+
+// priorityRanks orders the alert priorities, rows without a minimum priority take every alert
+var priorityRanks = map[string]int{
+	"":                         0,
+	common.AlertPriorityLow:    1,
+	common.AlertPriorityMedium: 2,
+	common.AlertPriorityHigh:   3,
+}
+
+// ChannelsOf returns the channels the user is notified on about an alert of the type and priority.
+// Users without a matrix, and notifications not about an alert, follow the notification preference
+func ChannelsOf(user *common.User, alertType string, priority string) []string {
+	if len(alertType) == 0 || len(user.NotificationMatrix) == 0 {
+		if user.NotificationPreference == common.NotificationPhone && len(user.Phone) > 0 {
+			return []string{ChannelSMS}
+		}
+		return []string{ChannelEmail}
+	}
+
+	for _, rule := range user.NotificationMatrix {
+		if rule.AlertType != alertType {
+			continue
+		}
+		if !MeetsPriority(priority, rule.MinPriority) {
+			return nil
+		}
+		return rule.Channels
+	}
+
+	return nil
+}
+
+// MeetsPriority checks the priority is at least the minimum priority, an empty minimum takes every priority
+func MeetsPriority(priority string, minPriority string) bool {
+	return priorityRanks[priority] >= priorityRanks[minPriority]
+}
+
+// ValidPriority checks the priority is known, empty stands for no minimum
+func ValidPriority(priority string) bool {
+	_, ok := priorityRanks[priority]
+	return ok
+}
+
+// GroupRoles lists the roles of the site staff the alert type flags of the client groups are meant to limit,
+// users of these roles belong to at least one group
+var GroupRoles = []string{"SM", "SU"}
+
+// RequiresGroup checks the user has one of GroupRoles
+func RequiresGroup(user *common.User) bool {
+	for _, p := range user.Permission {
+		if utils.Contains(GroupRoles, p.Role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateGroups checks every group is an enabled group of the client and that users of GroupRoles have one
+func ValidateGroups(client *common.Client, groupIDs []string, role string) error {
+	for _, id := range groupIDs {
+		enabled := false
+		for _, group := range client.Groups {
+			if group.ID == id {
+				enabled = group.Enable
+				break
+			}
+		}
+		if !enabled {
+			return fmt.Errorf("unknown or disabled group %s", id)
+		}
+	}
+
+	if len(groupIDs) == 0 && utils.Contains(GroupRoles, role) {
+		return fmt.Errorf("users of role %s need a group", role)
+	}
+
+	return nil
+}
+
+// AllowedAlertTypes returns the alert types the groups of the user may be notified about,
+// users outside of any group like the admins may be notified about every type, users of GroupRoles about none
+func AllowedAlertTypes(client *common.Client, user *common.User) []string {
+	if len(user.UserGroups) == 0 {
+		if RequiresGroup(user) {
+			return []string{}
+		}
+		return AlertTypes
+	}
+
+	allowed := map[string]bool{}
+	for _, group := range client.Groups {
+		if !group.Enable || !utils.Contains(user.UserGroups, group.ID) {
+			continue
+		}
+		allowed[common.AlertTypeStaffAlert] = allowed[common.AlertTypeStaffAlert] || group.StaffAlert
+		allowed[common.AlertTypeNotification] = allowed[common.AlertTypeNotification] || group.Notifications
+		allowed[common.AlertTypeSystemAlert] = allowed[common.AlertTypeSystemAlert] || group.SystemAlert
+	}
+
+	types := []string{}
+	for _, alertType := range AlertTypes {
+		if allowed[alertType] {
+			types = append(types, alertType)
+		}
+	}
+
+	return types
+}
+
+// RestrictMatrix clears the channels of the rows for alert types the user may no longer be notified about,
+// the rows stay so the user is not notified on the notification preference instead
+func RestrictMatrix(matrix []common.NotificationRule, allowedAlertTypes []string) []common.NotificationRule {
+	for i := range matrix {
+		if !utils.Contains(allowedAlertTypes, matrix[i].AlertType) {
+			matrix[i].Channels = []string{}
+		}
+	}
+
+	return matrix
+}
+
+// ValidateMatrix checks every row names a known alert type the user may be notified about,
+// known channels and a known priority, with at most one row per alert type
+func ValidateMatrix(matrix []common.NotificationRule, allowedAlertTypes []string, user *common.User) error {
+	seen := map[string]bool{}
+	for _, rule := range matrix {
+		if !utils.Contains(AlertTypes, rule.AlertType) {
+			return fmt.Errorf("unknown alert type %s", rule.AlertType)
+		}
+		if seen[rule.AlertType] {
+			return fmt.Errorf("alert type %s has more than one row", rule.AlertType)
+		}
+		seen[rule.AlertType] = true
+
+		if len(rule.Channels) > 0 && !utils.Contains(allowedAlertTypes, rule.AlertType) {
+			return fmt.Errorf("the user groups are not notified about %s", rule.AlertType)
+		}
+
+		if !ValidPriority(rule.MinPriority) {
+			return fmt.Errorf("unknown priority %s", rule.MinPriority)
+		}
+
+		for _, channel := range rule.Channels {
+			if !utils.Contains(Channels, channel) {
+				return fmt.Errorf("unknown channel %s", channel)
+			}
+			if channel == ChannelSMS && len(user.Phone) == 0 {
+				return fmt.Errorf("sms needs a phone number")
+			}
+		}
+	}
+
+	return nil
+}
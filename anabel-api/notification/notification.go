@@ -0,0 +1,284 @@
+package notification
+
+import (
+	"errors"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/sms"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ChannelInApp shows the notification in the notification list of the app
+	ChannelInApp = "inApp"
+	// ChannelEmail delivers the notification as an email
+	ChannelEmail = "email"
+	// ChannelSMS delivers the notification as a text message
+	ChannelSMS = "sms"
+	// ChannelWebhook hands the notification to a registered channel handler
+	ChannelWebhook = "webhook"
+)
+
+// Notification godoc
+// describes a notification to a user on one channel, rendered from a mail template as an email or as its text message
+type Notification struct {
+	UserID    string                 `bson:"userId"`
+	Channel   string                 `bson:"channel"`
+	Fallback  string                 `bson:"fallback"`
+	AlertType string                 `bson:"alertType"`
+	Priority  string                 `bson:"priority"`
+	EntityID  string                 `bson:"entityId"`
+	Template  string                 `bson:"template"`
+	Brand     mail.Brand             `bson:"brand"`
+	Data      map[string]interface{} `bson:"data"`
+}
+
+// InAppNotification godoc
+// describes a notification shown in the notification list of the app
+type InAppNotification struct {
+	ID         bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ReceiverID string        `json:"receiverId" bson:"receiverId"`
+	Type       string        `json:"type" bson:"type"`
+	EntityID   string        `json:"entityId" bson:"entityId"`
+	Title      string        `json:"title" bson:"title"`
+	CreatedAt  time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ChannelHandler delivers a notification to the user on a channel implemented outside of this package
+type ChannelHandler func(user *common.User, notification *Notification) error
+
+var channelHandlers = map[string]ChannelHandler{}
+
+// Init registers the delivery of the queued notifications and the notifications about raised and assigned alerts,
+// it must be called before outbox.Start
+func Init() {
+	outbox.RegisterHandler(outbox.KindNotification, deliverQueued)
+	alerting.RegisterListener("notification", notifyAlert)
+}
+
+// RegisterChannel sets the handler of a channel like the webhook, it must be called before outbox.Start
+func RegisterChannel(channel string, handler ChannelHandler) {
+	channelHandlers[channel] = handler
+}
+
+// NotifyAlert queues the notification about an alert for every user on the channels of the notification matrix
+// of the user, one outbox message per channel so a failing channel does not repeat the others
+func NotifyAlert(users []common.User, alertType string, priority string, entityID string,
+	template string, brand mail.Brand, data map[string]interface{}) error {
+	email := mail.Email{Template: template, Brand: brand, Data: data}
+	_, err := email.Render()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err = enqueue(&user, ChannelsOf(&user, alertType, priority), alertType, priority, entityID, template, brand, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Page queues an urgent notification about an alert for every user whatever the notification matrix says,
+// as a text message which goes by email when it cannot be sent, and in the app
+func Page(users []common.User, alertType string, priority string, entityID string,
+	template string, brand mail.Brand, data map[string]interface{}) error {
+	email := mail.Email{Template: template, Brand: brand, Data: data}
+	_, err := email.Render()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		channels := []string{ChannelEmail, ChannelInApp}
+		if len(user.Phone) > 0 {
+			channels = []string{ChannelSMS, ChannelInApp}
+		}
+
+		err = enqueue(&user, channels, alertType, priority, entityID, template, brand, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueue queues one outbox message per channel of the notification to the user
+func enqueue(user *common.User, channels []string, alertType string, priority string, entityID string,
+	template string, brand mail.Brand, data map[string]interface{}) error {
+	userData := map[string]interface{}{}
+	for key, value := range data {
+		userData[key] = value
+	}
+	userData["FirstName"] = user.FirstName
+
+	for _, channel := range channels {
+		// text messages which cannot be sent go by email, unless the user gets the email anyway
+		fallback := ""
+		if channel == ChannelSMS && !utils.Contains(channels, ChannelEmail) {
+			fallback = ChannelEmail
+		}
+
+		_, err := outbox.Enqueue(outbox.KindNotification, "user:"+user.ID.Hex(), Notification{
+			UserID:    user.ID.Hex(),
+			Channel:   channel,
+			Fallback:  fallback,
+			AlertType: alertType,
+			Priority:  priority,
+			EntityID:  entityID,
+			Template:  template,
+			Brand:     brand,
+			Data:      userData,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliverQueued sends the notification of an outbox message on its channel
+func deliverQueued(message *outbox.Message) error {
+	notification := Notification{}
+	err := message.Decode(&notification)
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(notification.UserID)
+	if err == mgo.ErrNotFound {
+		return outbox.Permanent(errors.New("user " + notification.UserID + " does not exist"))
+	}
+	if err != nil {
+		return err
+	}
+
+	email := mail.Email{
+		Template: notification.Template,
+		Locale:   user.Locale,
+		To:       []string{user.Email},
+		Brand:    notification.Brand,
+		Data:     notification.Data,
+	}
+
+	switch notification.Channel {
+	case ChannelSMS:
+		return deliverSMS(user, &notification, &email)
+	case ChannelEmail:
+		return deliverEmail(&email)
+	case ChannelInApp:
+		return deliverInApp(user, &notification, &email)
+	}
+
+	handler, ok := channelHandlers[notification.Channel]
+	if !ok {
+		return outbox.Permanent(errors.New("no handler for channel " + notification.Channel))
+	}
+
+	return handler(user, &notification)
+}
+
+// deliverSMS sends the text message outside the quiet hours of the user,
+// in the quiet hours or when the text message fails the notification goes to the fallback channel,
+// without one the message is deferred to the end of the quiet hours or retried
+func deliverSMS(user *common.User, notification *Notification, email *mail.Email) error {
+	var err error
+	until, quiet := QuietHoursEnd(user, time.Now())
+	if quiet {
+		err = errors.New("quiet hours")
+	} else {
+		err = sendSMS(user, email)
+		if err == nil {
+			return nil
+		}
+	}
+
+	if notification.Fallback != ChannelEmail {
+		if quiet {
+			return outbox.Defer(err, until)
+		}
+		return err
+	}
+
+	log.Infof("Not sending text message to user %s, falling back to email, reason: %v", notification.UserID, err)
+	return deliverEmail(email)
+}
+
+// deliverEmail sends the email, suppressed addresses are not retried
+func deliverEmail(email *mail.Email) error {
+	err := mail.SendTemplate(email)
+	if err == mail.ErrSuppressed {
+		return outbox.Permanent(err)
+	}
+
+	return err
+}
+
+// deliverInApp stores the notification for the notification list of the user, titled with the email subject
+func deliverInApp(user *common.User, notification *Notification, email *mail.Email) error {
+	message, err := email.Render()
+	if err != nil {
+		return err
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.NotificationCollection)
+
+	notificationType := "alert"
+	if len(notification.AlertType) == 0 {
+		notificationType = notification.Template
+	}
+
+	now := time.Now().UTC()
+	return c.Insert(&InAppNotification{
+		ID:         bson.NewObjectId(),
+		ReceiverID: user.ID.Hex(),
+		Type:       notificationType,
+		EntityID:   notification.EntityID,
+		Title:      message.Subject,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+}
+
+// sendSMS renders the text message of the email and sends it to the phone of the user
+func sendSMS(user *common.User, email *mail.Email) error {
+	body, err := email.RenderSMS()
+	if err != nil {
+		return err
+	}
+
+	return sms.Send(&sms.Message{To: user.Phone, Body: body})
+}
+
+// findUser loads the user at delivery time so changed preferences are honored
+func findUser(id string) (*common.User, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, mgo.ErrNotFound
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	user := common.User{}
+	err := c.FindId(bson.ObjectIdHex(id)).One(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
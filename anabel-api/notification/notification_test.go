@@ -0,0 +1,216 @@
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/sms"
+	"github.com/spf13/viper"
+)
+
+// setup loads the email templates and replaces the sms provider with a fake one
+func setup(t *testing.T) *sms.FakeSender {
+	v := viper.New()
+	v.Set("mail.templates_dir", "../templates/email")
+	config.SetConfig(v)
+	err := mail.LoadTemplates()
+	if err != nil {
+		t.Fatalf("cannot load templates: %v", err)
+	}
+
+	fake := sms.NewFakeSender()
+	sms.SetSender(fake)
+
+	return fake
+}
+
+// alertEmail returns the assignment email of an alert to the user
+func alertEmail(user *common.User) *mail.Email {
+	return &mail.Email{
+		Template: mail.TemplateAlertAssigned,
+		To:       []string{user.Email},
+		Brand:    mail.Brand{Name: "Acme"},
+		Data:     map[string]interface{}{"AlertType": common.AlertTypeStaffAlert, "SiteName": "Tokyo", "Room": "101", "URL": "https://app/alerts/1"},
+	}
+}
+
+func TestDeliverSMSWithFakeSender(t *testing.T) {
+	fake := setup(t)
+	user := &common.User{Email: "kim@example.com", Phone: "+819012345678"}
+
+	err := deliverSMS(user, &Notification{Channel: ChannelSMS}, alertEmail(user))
+	if err != nil {
+		t.Fatalf("cannot deliver: %v", err)
+	}
+
+	messages := fake.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d text messages instead of 1", len(messages))
+	}
+	if messages[0].To != user.Phone || messages[0].Body != "Acme: Staff Alert assigned to you at Tokyo room 101 https://app/alerts/1" {
+		t.Errorf("unexpected text message %+v", messages[0])
+	}
+}
+
+func TestDeliverSMSFailureWithoutFallback(t *testing.T) {
+	fake := setup(t)
+	fake.Fail(errors.New("provider down"))
+	user := &common.User{Email: "kim@example.com", Phone: "+819012345678"}
+
+	err := deliverSMS(user, &Notification{Channel: ChannelSMS}, alertEmail(user))
+	if err == nil || !strings.Contains(err.Error(), "provider down") {
+		t.Fatalf("the failure is not returned for a retry: %v", err)
+	}
+}
+
+func TestDeliverSMSInQuietHours(t *testing.T) {
+	fake := setup(t)
+	now := time.Now().UTC()
+	user := &common.User{
+		Email:      "kim@example.com",
+		Phone:      "+819012345678",
+		QuietHours: &common.QuietHours{Start: now.Add(-time.Hour).Format(clockLayout), End: now.Add(time.Hour).Format(clockLayout)},
+	}
+
+	err := deliverSMS(user, &Notification{Channel: ChannelSMS}, alertEmail(user))
+	until, deferred := outbox.DeferredUntil(err)
+	if !deferred {
+		t.Fatalf("the text message is not deferred in the quiet hours: %v", err)
+	}
+	if end := now.Add(time.Hour).Truncate(time.Minute); !until.Equal(end) {
+		t.Errorf("the text message is deferred until %v instead of %v", until, end)
+	}
+	if len(fake.Messages()) != 0 {
+		t.Errorf("text messages were sent in the quiet hours: %+v", fake.Messages())
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	config.SetConfig(viper.New())
+	user := &common.User{TimeZone: "Asia/Tokyo", QuietHours: &common.QuietHours{Start: "22:00", End: "07:00"}}
+
+	for at, quiet := range map[string]bool{
+		"2026-10-19T12:30:00Z": false, // 21:30 in Tokyo
+		"2026-10-19T13:00:00Z": true,  // 22:00
+		"2026-10-19T18:00:00Z": true,  // 03:00 the next day
+		"2026-10-19T22:00:00Z": false, // 07:00
+	} {
+		parsed, _ := time.Parse(time.RFC3339, at)
+		if InQuietHours(user, parsed) != quiet {
+			t.Errorf("quiet hours at %s should be %v", at, quiet)
+		}
+	}
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	config.SetConfig(viper.New())
+	user := &common.User{TimeZone: "Asia/Tokyo", QuietHours: &common.QuietHours{Start: "22:00", End: "07:00"}}
+
+	for at, end := range map[string]string{
+		"2026-10-19T13:00:00Z": "2026-10-19T22:00:00Z", // 22:00 in Tokyo ends at 07:00 the next day
+		"2026-10-19T18:00:00Z": "2026-10-19T22:00:00Z", // 03:00 the next day
+		"2026-10-19T12:30:00Z": "",                     // 21:30 is not in the quiet hours
+	} {
+		parsed, _ := time.Parse(time.RFC3339, at)
+		until, quiet := QuietHoursEnd(user, parsed)
+		if !quiet {
+			if len(end) > 0 {
+				t.Errorf("quiet hours at %s have no end", at)
+			}
+			continue
+		}
+		if until.UTC().Format(time.RFC3339) != end {
+			t.Errorf("quiet hours at %s end at %v instead of %s", at, until.UTC(), end)
+		}
+	}
+}
+
+// groupsClient returns a client with a housekeeping group notified about staff alerts and a disabled group
+func groupsClient(t *testing.T) *common.Client {
+	client := common.Client{}
+	err := json.Unmarshal([]byte(`{"groups": [
+		{"id": "housekeeping", "enable": true, "staffAlert": true},
+		{"id": "engineering", "enable": false, "systemAlert": true}
+	]}`), &client)
+	if err != nil {
+		t.Fatalf("cannot decode the client: %v", err)
+	}
+
+	return &client
+}
+
+func TestValidateGroups(t *testing.T) {
+	client := groupsClient(t)
+
+	if err := ValidateGroups(client, []string{"housekeeping"}, "SU"); err != nil {
+		t.Errorf("an enabled group is refused: %v", err)
+	}
+	if err := ValidateGroups(client, []string{"engineering"}, "SU"); err == nil {
+		t.Error("a disabled group is accepted")
+	}
+	if err := ValidateGroups(client, []string{"front desk"}, "CSA"); err == nil {
+		t.Error("an unknown group is accepted")
+	}
+	if err := ValidateGroups(client, nil, "SM"); err == nil {
+		t.Error("site staff without a group is accepted")
+	}
+	if err := ValidateGroups(client, nil, "CSA"); err != nil {
+		t.Errorf("an admin without a group is refused: %v", err)
+	}
+}
+
+func TestAllowedAlertTypes(t *testing.T) {
+	client := groupsClient(t)
+	staff := []common.Permission{{Role: "SU"}}
+
+	if types := AllowedAlertTypes(client, &common.User{Permission: staff, UserGroups: []string{"housekeeping", "engineering"}}); strings.Join(types, ",") != common.AlertTypeStaffAlert {
+		t.Errorf("housekeeping is notified about %v", types)
+	}
+	if types := AllowedAlertTypes(client, &common.User{Permission: staff}); len(types) != 0 {
+		t.Errorf("site staff without a group is notified about %v", types)
+	}
+	if types := AllowedAlertTypes(client, &common.User{Permission: []common.Permission{{Role: "CSA"}}}); len(types) != len(AlertTypes) {
+		t.Errorf("an admin without a group is notified about %v", types)
+	}
+}
+
+func TestRestrictMatrix(t *testing.T) {
+	matrix := RestrictMatrix([]common.NotificationRule{
+		{AlertType: common.AlertTypeStaffAlert, Channels: []string{ChannelSMS}},
+		{AlertType: common.AlertTypeSystemAlert, Channels: []string{ChannelEmail}},
+	}, []string{common.AlertTypeStaffAlert})
+
+	if len(matrix) != 2 || len(matrix[0].Channels) != 1 || len(matrix[1].Channels) != 0 {
+		t.Errorf("unexpected restricted matrix %+v", matrix)
+	}
+}
+
+func TestChannelsOf(t *testing.T) {
+	user := &common.User{
+		NotificationPreference: common.NotificationPhone,
+		Phone:                  "+819012345678",
+		NotificationMatrix: []common.NotificationRule{
+			{AlertType: common.AlertTypeStaffAlert, Channels: []string{ChannelSMS, ChannelInApp}, MinPriority: common.AlertPriorityMedium},
+		},
+	}
+
+	if channels := ChannelsOf(user, common.AlertTypeStaffAlert, common.AlertPriorityHigh); strings.Join(channels, ",") != "sms,inApp" {
+		t.Errorf("unexpected channels %v", channels)
+	}
+	if channels := ChannelsOf(user, common.AlertTypeStaffAlert, common.AlertPriorityLow); len(channels) != 0 {
+		t.Errorf("low priority alerts are notified on %v", channels)
+	}
+	if channels := ChannelsOf(user, common.AlertTypeSystemAlert, common.AlertPriorityHigh); len(channels) != 0 {
+		t.Errorf("alert types without a row are notified on %v", channels)
+	}
+	if channels := ChannelsOf(user, "", ""); strings.Join(channels, ",") != "sms" {
+		t.Errorf("notifications which are not about an alert go on %v instead of the preference", channels)
+	}
+}
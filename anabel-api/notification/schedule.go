@@ -0,0 +1,114 @@
+package notification
+
+import (
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+)
+
+// clockLayout is the format of the quiet hours boundaries
+const clockLayout = "15:04"
+
+// Location returns the time zone of the user, the configured default or utc when the user has none
+func Location(user *common.User) *time.Location {
+	for _, name := range []string{user.TimeZone, config.GetConfig().GetString("notification.default_time_zone")} {
+		if len(name) == 0 {
+			continue
+		}
+		location, err := time.LoadLocation(name)
+		if err == nil {
+			return location
+		}
+	}
+
+	return time.UTC
+}
+
+// quietHoursOf returns the quiet hours of the user, the configured default when the user has none
+func quietHoursOf(user *common.User) *common.QuietHours {
+	if user.QuietHours != nil {
+		return user.QuietHours
+	}
+
+	start := config.GetConfig().GetString("notification.quiet_hours.start")
+	end := config.GetConfig().GetString("notification.quiet_hours.end")
+	if len(start) == 0 || len(end) == 0 {
+		return nil
+	}
+
+	return &common.QuietHours{Start: start, End: end}
+}
+
+// InQuietHours checks the time falls into the quiet hours of the user in the time zone of the user,
+// the period wraps around midnight when it ends before it starts
+func InQuietHours(user *common.User, at time.Time) bool {
+	quietHours := quietHoursOf(user)
+	if quietHours == nil {
+		return false
+	}
+
+	start, err := parseClock(quietHours.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(quietHours.End)
+	if err != nil || start == end {
+		return false
+	}
+
+	local := at.In(Location(user))
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+
+	return minute >= start || minute < end
+}
+
+// QuietHoursEnd returns the time the quiet hours of the user the time falls into end, in the time zone of the user
+func QuietHoursEnd(user *common.User, at time.Time) (time.Time, bool) {
+	if !InQuietHours(user, at) {
+		return time.Time{}, false
+	}
+
+	end, _ := parseClock(quietHoursOf(user).End)
+	local := at.In(Location(user))
+	until := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, local.Location())
+	if !until.After(local) {
+		until = time.Date(local.Year(), local.Month(), local.Day()+1, end/60, end%60, 0, 0, local.Location())
+	}
+
+	return until, true
+}
+
+// ValidateSchedule checks the time zone is known and the quiet hours are HH:MM clock times,
+// empty quiet hours clear them
+func ValidateSchedule(timeZone string, quietHours *common.QuietHours) bool {
+	if len(timeZone) > 0 {
+		if _, err := time.LoadLocation(timeZone); err != nil {
+			return false
+		}
+	}
+
+	if quietHours != nil && (len(quietHours.Start) > 0 || len(quietHours.End) > 0) {
+		if _, err := parseClock(quietHours.Start); err != nil {
+			return false
+		}
+		if _, err := parseClock(quietHours.End); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseClock returns the minute of the day of an HH:MM clock time
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse(clockLayout, clock)
+	if err != nil {
+		return 0, err
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
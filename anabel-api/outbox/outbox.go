@@ -0,0 +1,381 @@
+package outbox
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// KindEmail messages carry a templated email
+	KindEmail = "email"
+	// KindNotification messages carry a notification delivered on the channel the user prefers
+	KindNotification = "notification"
+	// KindWebhook messages carry a delivery of an event to a client webhook
+	KindWebhook = "webhook"
+	// KindChat messages carry a post of an alert to a chat integration of a site
+	KindChat = "chat"
+	// KindHistory messages carry a history entry of an alert which could not be written when the alert changed
+	KindHistory = "history"
+)
+
+const (
+	// StatusPending the message waits for its next attempt
+	StatusPending = "pending"
+	// StatusProcessing the message is claimed by a dispatcher
+	StatusProcessing = "processing"
+	// StatusDelivered the message was handled successfully
+	StatusDelivered = "delivered"
+	// StatusDead the message failed every attempt and waits for a replay
+	StatusDead = "dead"
+	// StatusHeld the message was stored before the write it belongs to and waits to be released
+	StatusHeld = "held"
+)
+
+const (
+	// defaultPollInterval is used when outbox.poll_interval_in_seconds is not configured
+	defaultPollInterval = 5 * time.Second
+	// defaultBatchSize is used when outbox.batch_size is not configured
+	defaultBatchSize = 50
+	// defaultMaxAttempts is used when outbox.max_attempts is not configured
+	defaultMaxAttempts = 8
+	// defaultBaseBackoff is used when outbox.base_backoff_in_seconds is not configured
+	defaultBaseBackoff = 30 * time.Second
+	// defaultMaxBackoff is used when outbox.max_backoff_in_minutes is not configured
+	defaultMaxBackoff = time.Hour
+	// lockPeriod is how long a claimed message is reserved, messages of a crashed dispatcher are retried after it
+	lockPeriod = 5 * time.Minute
+	// holdPeriod is how long a held message waits to be released, a message still held after it
+	// is delivered when its record was written and dropped otherwise
+	holdPeriod = 5 * time.Minute
+)
+
+// referenceCollections maps the records the references of held messages name to their collections
+var referenceCollections = map[string]string{
+	"user": common.UserCollection,
+}
+
+// Message godoc
+// describes a message stored with the write that produced it and delivered in the background
+type Message struct {
+	ID            bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	Kind          string        `json:"kind" bson:"kind"`
+	Reference     string        `json:"reference" bson:"reference"`
+	Payload       interface{}   `json:"payload" bson:"payload"`
+	Status        string        `json:"status" bson:"status"`
+	Attempts      int           `json:"attempts" bson:"attempts"`
+	MaxAttempts   int           `json:"maxAttempts" bson:"maxAttempts"`
+	LastError     string        `json:"lastError" bson:"lastError"`
+	NextAttemptAt time.Time     `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LockedUntil   time.Time     `json:"-" bson:"lockedUntil"`
+	CreatedAt     time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt" bson:"updatedAt"`
+	DeliveredAt   time.Time     `json:"deliveredAt" bson:"deliveredAt,omitempty"`
+	Held          bool          `json:"-" bson:"held,omitempty"`
+}
+
+// Handler delivers the message of a kind, a returned error schedules a retry unless it is Permanent
+type Handler func(message *Message) error
+
+// permanentError marks a failure retrying cannot fix
+type permanentError struct {
+	error
+}
+
+// Permanent wraps the error of a handler so the message is dead right away instead of retried
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsPermanent checks the handler error was wrapped by Permanent
+func IsPermanent(err error) bool {
+	_, permanent := err.(permanentError)
+	return permanent
+}
+
+// deferredError postpones the message to a time without counting a failed attempt
+type deferredError struct {
+	error
+	until time.Time
+}
+
+// Defer wraps the error of a handler so the message is retried at the time instead of after the backoff,
+// the attempt is not counted as it was not made
+func Defer(err error, until time.Time) error {
+	return deferredError{err, until}
+}
+
+// DeferredUntil returns the time the handler error wrapped by Defer postpones the message to
+func DeferredUntil(err error) (time.Time, bool) {
+	deferred, ok := err.(deferredError)
+	return deferred.until, ok
+}
+
+var handlers = map[string]Handler{}
+
+// RegisterHandler sets the handler of the message kind, it must be called before Start
+func RegisterHandler(kind string, handler Handler) {
+	handlers[kind] = handler
+}
+
+// Decode unmarshals the payload into the value
+func (message *Message) Decode(value interface{}) error {
+	data, err := bson.Marshal(message.Payload)
+	if err != nil {
+		return err
+	}
+
+	return bson.Unmarshal(data, value)
+}
+
+// Enqueue stores the message for delivery, the reference names the record it belongs to like user:<id>
+func Enqueue(kind string, reference string, payload interface{}) (*Message, error) {
+	return insert(kind, reference, payload, false)
+}
+
+// EnqueueHeld stores the message before the record it belongs to is written, Release lets it be delivered once
+// the record is written and Discard drops it when the write fails. When neither is called, like after a crash,
+// the message is delivered after holdPeriod if the record of the reference exists and dropped otherwise
+func EnqueueHeld(kind string, reference string, payload interface{}) (*Message, error) {
+	return insert(kind, reference, payload, true)
+}
+
+// Release lets the held message be delivered right away
+func Release(id bson.ObjectId) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	now := time.Now().UTC()
+	err := session.DB("").C(common.OutboxCollection).Update(bson.M{"_id": id, "status": StatusHeld}, bson.M{
+		"$set":   bson.M{"status": StatusPending, "nextAttemptAt": now, "updatedAt": now},
+		"$unset": bson.M{"held": ""},
+	})
+	if err == mgo.ErrNotFound {
+		// the dispatcher took it after the hold period
+		return nil
+	}
+
+	return err
+}
+
+// Discard drops the held message of a write which failed
+func Discard(id bson.ObjectId) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	err := session.DB("").C(common.OutboxCollection).Remove(bson.M{"_id": id, "status": StatusHeld})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// insert stores the message, a held one is due at the end of the hold period
+func insert(kind string, reference string, payload interface{}, held bool) (*Message, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.OutboxCollection)
+
+	now := time.Now().UTC()
+	message := Message{
+		ID:            bson.NewObjectId(),
+		Kind:          kind,
+		Reference:     reference,
+		Payload:       payload,
+		Status:        StatusPending,
+		MaxAttempts:   getMaxAttempts(),
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if held {
+		message.Status = StatusHeld
+		message.NextAttemptAt = now.Add(holdPeriod)
+		message.Held = true
+	}
+
+	err := c.Insert(&message)
+	if err != nil {
+		log.Errorf("Failed to insert outbox message, error: %v", err)
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// Start runs the dispatcher in the background,
+// every instance of the api can run one as messages are claimed atomically
+func Start() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.OutboxCollection).EnsureIndex(mgo.Index{Key: []string{"status", "nextAttemptAt"}})
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create outbox index, error: %v", err)
+	}
+
+	interval := defaultPollInterval
+	if seconds := config.GetConfig().GetInt("outbox.poll_interval_in_seconds"); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			dispatch()
+		}
+	}()
+}
+
+// dispatch delivers the due messages, up to one batch per run
+func dispatch() {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.OutboxCollection)
+
+	batchSize := config.GetConfig().GetInt("outbox.batch_size")
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	for i := 0; i < batchSize; i++ {
+		message, err := claim(c)
+		if err != nil {
+			if err != mgo.ErrNotFound {
+				log.Errorf("Failed to claim outbox message, error: %v", err)
+			}
+			return
+		}
+
+		deliver(c, message)
+	}
+}
+
+// claim reserves the next due message, including the ones a crashed dispatcher left behind
+// and the held ones nobody released
+func claim(c *mgo.Collection) (*Message, error) {
+	now := time.Now().UTC()
+	message := Message{}
+	_, err := c.Find(bson.M{"$or": []bson.M{
+		{"status": StatusPending, "nextAttemptAt": bson.M{"$lte": now}},
+		{"status": StatusHeld, "nextAttemptAt": bson.M{"$lte": now}},
+		{"status": StatusProcessing, "lockedUntil": bson.M{"$lt": now}},
+	}}).Sort("nextAttemptAt").Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": StatusProcessing, "lockedUntil": now.Add(lockPeriod), "updatedAt": now}},
+		ReturnNew: true,
+	}, &message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// deliver runs the handler of the message and records the outcome,
+// failures are retried with exponential backoff until the message is dead
+func deliver(c *mgo.Collection, message *Message) {
+	if message.Held {
+		written, err := referenceWritten(c.Database, message.Reference)
+		if err != nil {
+			log.Errorf("Failed to look up %s of held outbox message %s, error: %v", message.Reference, message.ID.Hex(), err)
+			return
+		}
+		if !written {
+			log.Warnf("Dropping held outbox message %s as %s was never written", message.ID.Hex(), message.Reference)
+			err = c.Remove(bson.M{"_id": message.ID, "status": StatusProcessing})
+			if err != nil && err != mgo.ErrNotFound {
+				log.Errorf("Failed to remove outbox message %s, error: %v", message.ID.Hex(), err)
+			}
+			return
+		}
+	}
+
+	err := errors.New("no handler for message kind " + message.Kind)
+	if handler, ok := handlers[message.Kind]; ok {
+		err = handler(message)
+	}
+
+	now := time.Now().UTC()
+	update := bson.M{"updatedAt": now, "held": false}
+	if err == nil {
+		update["status"] = StatusDelivered
+		update["deliveredAt"] = now
+		update["lastError"] = ""
+	} else if until, deferred := DeferredUntil(err); deferred {
+		update["status"] = StatusPending
+		update["nextAttemptAt"] = until.UTC()
+		update["lastError"] = err.Error()
+		log.Infof("Outbox message %s of %s is deferred until %s, reason: %v", message.ID.Hex(), message.Reference, until.UTC().Format(time.RFC3339), err)
+	} else {
+		attempts := message.Attempts + 1
+		update["attempts"] = attempts
+		update["lastError"] = err.Error()
+		if IsPermanent(err) || attempts >= message.MaxAttempts {
+			update["status"] = StatusDead
+			log.Errorf("Outbox message %s of %s is dead after %d attempts, error: %v", message.ID.Hex(), message.Reference, attempts, err)
+		} else {
+			update["status"] = StatusPending
+			update["nextAttemptAt"] = now.Add(backoff(attempts))
+			log.Warnf("Outbox message %s of %s failed attempt %d, error: %v", message.ID.Hex(), message.Reference, attempts, err)
+		}
+	}
+
+	err = c.Update(bson.M{"_id": message.ID, "status": StatusProcessing}, bson.M{"$set": update})
+	if err != nil {
+		log.Errorf("Failed to update outbox message %s, error: %v", message.ID.Hex(), err)
+	}
+}
+
+// referenceWritten checks the record a reference like user:<id> names exists,
+// references of other records are taken as written
+func referenceWritten(db *mgo.Database, reference string) (bool, error) {
+	parts := strings.SplitN(reference, ":", 2)
+	collection, ok := referenceCollections[parts[0]]
+	if !ok || len(parts) < 2 || !bson.IsObjectIdHex(parts[1]) {
+		return true, nil
+	}
+
+	count, err := db.C(collection).FindId(bson.ObjectIdHex(parts[1])).Count()
+	return count > 0, err
+}
+
+// backoff doubles the delay with every failed attempt up to the configured maximum
+func backoff(attempts int) time.Duration {
+	base := defaultBaseBackoff
+	if seconds := config.GetConfig().GetInt("outbox.base_backoff_in_seconds"); seconds > 0 {
+		base = time.Duration(seconds) * time.Second
+	}
+
+	limit := defaultMaxBackoff
+	if minutes := config.GetConfig().GetInt("outbox.max_backoff_in_minutes"); minutes > 0 {
+		limit = time.Duration(minutes) * time.Minute
+	}
+
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+
+	return delay
+}
+
+// getMaxAttempts returns the configured number of attempts before a message is dead
+func getMaxAttempts() int {
+	attempts := config.GetConfig().GetInt("outbox.max_attempts")
+	if attempts <= 0 {
+		return defaultMaxAttempts
+	}
+
+	return attempts
+}
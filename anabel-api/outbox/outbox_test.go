@@ -0,0 +1,57 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"anacove.com/backend/config"
+	"github.com/spf13/viper"
+)
+
+func TestBackoff(t *testing.T) {
+	v := viper.New()
+	v.Set("outbox.base_backoff_in_seconds", 30)
+	v.Set("outbox.max_backoff_in_minutes", 10)
+	config.SetConfig(v)
+
+	for attempts, delay := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		4:  4 * time.Minute,
+		5:  8 * time.Minute,
+		6:  10 * time.Minute,
+		20: 10 * time.Minute,
+	} {
+		if got := backoff(attempts); got != delay {
+			t.Errorf("backoff after %d attempts is %v instead of %v", attempts, got, delay)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	err := errors.New("receiver responded 500")
+	if IsPermanent(err) {
+		t.Error("a plain error is permanent")
+	}
+	if !IsPermanent(Permanent(err)) {
+		t.Error("a wrapped error is not permanent")
+	}
+}
+
+func TestDeferredUntil(t *testing.T) {
+	err := errors.New("quiet hours")
+	if _, deferred := DeferredUntil(err); deferred {
+		t.Error("a plain error is deferred")
+	}
+
+	until := time.Date(2026, 10, 19, 22, 0, 0, 0, time.UTC)
+	wrapped := Defer(err, until)
+	if at, deferred := DeferredUntil(wrapped); !deferred || !at.Equal(until) {
+		t.Errorf("a wrapped error is deferred until %v instead of %v", at, until)
+	}
+	if IsPermanent(wrapped) || wrapped.Error() != "quiet hours" {
+		t.Errorf("unexpected deferred error %v", wrapped)
+	}
+}
@@ -0,0 +1,20 @@
+package admin
+
+// OutboxQuery godoc
+// defines the filters of the outbox message search
+type OutboxQuery struct {
+	PageNumber int
+	PageSize   int
+	Status     string
+	Kind       string
+	Reference  string
+}
+
+// SuppressionQuery godoc
+// defines the filters of the suppression list search
+type SuppressionQuery struct {
+	PageNumber int
+	PageSize   int
+	Reason     string
+	Keyword    string
+}
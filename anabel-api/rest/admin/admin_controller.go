@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller type
+type Controller struct {
+}
+
+// AddRouters allows the endpoints defined in this controller to be added to router
+func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
+	ws.Route(ws.GET("/admin/outbox").Filter(utils.BearerAuth).To(searchOutbox))
+	ws.Route(ws.GET("/admin/outbox/{id}").Filter(utils.BearerAuth).To(getOutboxMessage))
+	ws.Route(ws.POST("/admin/outbox/{id}/replay").Filter(utils.BearerAuth).To(replayOutboxMessage))
+	ws.Route(ws.GET("/admin/suppressions").Filter(utils.BearerAuth).To(searchSuppressions))
+	ws.Route(ws.DELETE("/admin/suppressions/{email}").Filter(utils.BearerAuth).To(removeSuppression))
+	return ws
+}
+
+// searchOutbox godoc
+// lists the outbox messages by status, kind and reference
+func searchOutbox(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	query, err := PrepareOutboxQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	res, err := GetService().SearchOutbox(query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// getOutboxMessage godoc
+// returns the outbox message with its payload and last error
+func getOutboxMessage(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	res, err := GetService().GetOutboxMessage(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// replayOutboxMessage godoc
+// puts a dead message back in the queue
+func replayOutboxMessage(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	res, err := GetService().ReplayOutboxMessage(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// searchSuppressions godoc
+// lists the suppressed email addresses by reason and keyword
+func searchSuppressions(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	query, err := PrepareSuppressionQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	res, err := GetService().SearchSuppressions(query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// removeSuppression godoc
+// takes the address off the suppression list, for example after the user fixed the mailbox
+func removeSuppression(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	email := req.PathParameter("email")
+	if !utils.IsValidEmail(email) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	err := GetService().RemoveSuppression(email)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
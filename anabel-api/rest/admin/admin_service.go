@@ -0,0 +1,178 @@
+package admin
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service godoc
+// defines the operational tasks of the super admin
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for admin service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// SearchOutbox godoc
+// lists the outbox messages matching the filters, the latest first
+func (Service *Service) SearchOutbox(query *OutboxQuery) (*common.PagedList, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.OutboxCollection)
+
+	dbQuery := bson.M{}
+	if len(query.Status) > 0 {
+		dbQuery["status"] = query.Status
+	}
+	if len(query.Kind) > 0 {
+		dbQuery["kind"] = query.Kind
+	}
+	if len(query.Reference) > 0 {
+		dbQuery["reference"] = query.Reference
+	}
+
+	count, err := c.Find(dbQuery).Count()
+	if err != nil {
+		log.Errorf("error occured during getting count: error: %v\n", err)
+		return nil, errors.CreateError(500, "query_execute_error")
+	}
+
+	messages := []outbox.Message{}
+	err = c.Find(dbQuery).Sort("-createdAt").Skip(query.PageSize * (query.PageNumber - 1)).Limit(query.PageSize).All(&messages)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return &common.PagedList{
+		Items: messages,
+		Page:  query.PageNumber,
+		Size:  query.PageSize,
+		Total: count,
+	}, nil
+}
+
+// GetOutboxMessage godoc
+// Find the outbox message by id
+func (Service *Service) GetOutboxMessage(id string) (*outbox.Message, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.OutboxCollection)
+
+	message := outbox.Message{}
+	err := c.FindId(bson.ObjectIdHex(id)).One(&message)
+	if err != nil {
+		log.Errorf("cannot find the outbox message with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_message_error")
+	}
+
+	return &message, nil
+}
+
+// ReplayOutboxMessage godoc
+// schedules a dead message for immediate delivery with a fresh set of attempts
+func (Service *Service) ReplayOutboxMessage(id string) (*outbox.Message, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.OutboxCollection)
+
+	now := time.Now().UTC()
+	message := outbox.Message{}
+	_, err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "status": outbox.StatusDead}).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{
+			"status":        outbox.StatusPending,
+			"attempts":      0,
+			"nextAttemptAt": now,
+			"updatedAt":     now,
+		}},
+		ReturnNew: true,
+	}, &message)
+	if err != nil {
+		log.Errorf("cannot replay the outbox message with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "replay_message_error")
+	}
+
+	return &message, nil
+}
+
+// SearchSuppressions godoc
+// lists the suppressed addresses matching the filters, the latest first
+func (Service *Service) SearchSuppressions(query *SuppressionQuery) (*common.PagedList, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SuppressionCollection)
+
+	dbQuery := bson.M{}
+	if len(query.Reason) > 0 {
+		dbQuery["reason"] = query.Reason
+	}
+	if len(query.Keyword) > 0 {
+		dbQuery["email"] = bson.RegEx{Pattern: regexp.QuoteMeta(query.Keyword), Options: "i"}
+	}
+
+	count, err := c.Find(dbQuery).Count()
+	if err != nil {
+		log.Errorf("error occured during getting count: error: %v\n", err)
+		return nil, errors.CreateError(500, "query_execute_error")
+	}
+
+	suppressions := []mail.Suppression{}
+	err = c.Find(dbQuery).Sort("-updatedAt").Skip(query.PageSize * (query.PageNumber - 1)).Limit(query.PageSize).All(&suppressions)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return &common.PagedList{
+		Items: suppressions,
+		Page:  query.PageNumber,
+		Size:  query.PageSize,
+		Total: count,
+	}, nil
+}
+
+// RemoveSuppression godoc
+// takes the address off the suppression list and clears the deliverability of its users
+func (Service *Service) RemoveSuppression(email string) error {
+	err := mail.Unsuppress(email)
+	if err != nil {
+		log.Errorf("cannot remove the suppression of %s, error: %v\n", email, err)
+		if err == mgo.ErrNotFound {
+			return errors.CreateError(404, "not_found")
+		}
+		return errors.CreateError(500, "remove_suppression_error")
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"strconv"
+
+	"anacove.com/backend/errors"
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// PrepareOutboxQuery reads the outbox search filters from the query parameters
+func PrepareOutboxQuery(req *restful.Request) (*OutboxQuery, error) {
+	pageNumber, pageSize, err := preparePaging(req)
+	if err != nil {
+		return nil, err
+	}
+
+	query := OutboxQuery{
+		PageNumber: pageNumber,
+		PageSize:   pageSize,
+	}
+
+	query.Status = req.QueryParameter("status")
+	query.Kind = req.QueryParameter("kind")
+	query.Reference = req.QueryParameter("reference")
+
+	return &query, nil
+}
+
+// PrepareSuppressionQuery reads the suppression search filters from the query parameters
+func PrepareSuppressionQuery(req *restful.Request) (*SuppressionQuery, error) {
+	pageNumber, pageSize, err := preparePaging(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SuppressionQuery{
+		PageNumber: pageNumber,
+		PageSize:   pageSize,
+		Reason:     req.QueryParameter("reason"),
+		Keyword:    req.QueryParameter("keyword"),
+	}, nil
+}
+
+// preparePaging reads the page number and size from the query parameters
+func preparePaging(req *restful.Request) (int, int, error) {
+	pageNumber := 1
+	pageSize := 20
+
+	val := req.QueryParameter("pageNumber")
+	if val != "" {
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 1 {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return 0, 0, errors.CreateError(400, "invalid_data")
+		}
+
+		pageNumber = i
+	}
+
+	val = req.QueryParameter("pageSize")
+	if val != "" {
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 1 {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return 0, 0, errors.CreateError(400, "invalid_data")
+		}
+
+		pageSize = i
+	}
+
+	return pageNumber, pageSize, nil
+}
@@ -0,0 +1,154 @@
+package alert
+
+import (
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/correlation"
+)
+
+// clearAttempts limits how often clearing the alert of an incident is tried when the alert changes meanwhile
+const clearAttempts = 3
+
+// UpdateAlertModel godoc
+// This is the alert update request model definition
+type UpdateAlertModel struct {
+	AssignedTo []string `json:"assignedTo"`
+	Status     string   `validate:"required" json:"status"`
+	Reason     string   `json:"reason"`
+	Detailed   string   `json:"detailed"`
+}
+
+// BulkUpdateAlertModel godoc
+// This is the bulk alert update request model definition, every alert is updated like by the alert update request
+type BulkUpdateAlertModel struct {
+	AlertIDs   []string `validate:"required,min=1,max=500" json:"alertIds"`
+	AssignedTo []string `json:"assignedTo"`
+	Status     string   `validate:"required" json:"status"`
+	Reason     string   `json:"reason"`
+	Detailed   string   `json:"detailed"`
+}
+
+// BulkResult godoc
+// is the outcome of the update of one alert of a bulk update, the status code and error key are the ones
+// the alert update request would have answered
+type BulkResult struct {
+	AlertID    string        `json:"alertId"`
+	StatusCode int           `json:"statusCode"`
+	Key        string        `json:"key,omitempty"`
+	Msg        string        `json:"msg,omitempty"`
+	Alert      *common.Alert `json:"alert,omitempty"`
+}
+
+// BulkResponse godoc
+// lists the results of a bulk update in the order of the request
+type BulkResponse struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+// CommentModel godoc
+// This is the alert comment create request model definition, mentions are user ids
+// and attachments the ids of the alertPhoto files uploaded for the site of the alert
+type CommentModel struct {
+	Text        string   `validate:"required,max=2000" json:"text"`
+	ParentID    string   `json:"parentId"`
+	Mentions    []string `validate:"max=20" json:"mentions"`
+	Attachments []string `validate:"max=10" json:"attachments"`
+}
+
+// ExportQuery godoc
+// defines the filters of the alert export, the alert time is from inclusive and to exclusive.
+// A missing suppressed exports the alerts suppressed by maintenance windows with the others
+type ExportQuery struct {
+	ClientID   string
+	SiteID     string
+	Status     string
+	Type       string
+	Suppressed *bool
+	From       time.Time
+	To         time.Time
+}
+
+// SLAQuery godoc
+// defines the filters and grouping of the sla report, the alert time is from inclusive and to exclusive.
+// Buckets start in the location
+type SLAQuery struct {
+	ClientID string
+	SiteID   string
+	Type     string
+	GroupBy  string
+	Bucket   string
+	Location *time.Location
+	From     time.Time
+	To       time.Time
+}
+
+// Query godoc
+// defines the filters and sort order of the alert search, the alert time is from inclusive and to exclusive.
+// The alerts suppressed by maintenance windows are only listed when suppressed is true
+type Query struct {
+	PageNumber          int
+	PageSize            int
+	SortBy              string
+	SortOrder           int
+	ClientID            string
+	SiteID              string
+	Status              string
+	Type                string
+	Suppressed          *bool
+	MaintenanceWindowID string
+	From                time.Time
+	To                  time.Time
+	Short               bool
+}
+
+// SearchResult godoc
+// is a page of the alert search with the totals of the alerts matching the filters
+type SearchResult struct {
+	Total          int             `json:"total"`
+	TotalOfCleared int             `json:"totalOfCleared"`
+	Metadata       *SearchMetadata `json:"metadata,omitempty"`
+	PageNumber     int             `json:"pageNumber"`
+	PageSize       int             `json:"pageSize"`
+	Items          []common.Alert  `json:"items"`
+}
+
+// SearchMetadata godoc
+// counts the alerts matching the filters of the search except the status and type, by type and by status
+type SearchMetadata struct {
+	TotalOfSummary      int `json:"totalOfSummary"`
+	TotalOfStaffAlert   int `json:"totalOfStaffAlert"`
+	TotalOfNotification int `json:"totalOfNotification"`
+	TotalOfSystemAlert  int `json:"totalOfSystemAlert"`
+	New                 int `json:"new"`
+	Active              int `json:"active"`
+	Cleared             int `json:"cleared"`
+}
+
+// IncidentQuery godoc
+// defines the filters of the incident search
+type IncidentQuery struct {
+	PageNumber int
+	PageSize   int
+	ClientID   string
+	SiteID     string
+	Status     string
+}
+
+// UpdateIncidentModel godoc
+// This is the incident update request model definition, clearing an incident needs the reason and details
+// which its alerts are cleared with too
+type UpdateIncidentModel struct {
+	Status   string `validate:"required" json:"status"`
+	Reason   string `json:"reason"`
+	Detailed string `json:"detailed"`
+}
+
+// IncidentDetail godoc
+// is the incident with its alerts
+type IncidentDetail struct {
+	*correlation.Incident
+	Alerts []common.Alert `json:"alerts"`
+}
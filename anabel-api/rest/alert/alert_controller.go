@@ -0,0 +1,372 @@
+package alert
+
+import (
+	"encoding/csv"
+
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller type
+type Controller struct {
+}
+
+// AddRouters allows the endpoints defined in this controller to be added to router
+func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
+	ws.Route(ws.GET("/alerts").Filter(utils.BearerAuth).To(searchAlerts))
+	ws.Route(ws.GET("/alerts/export").Filter(utils.BearerAuth).To(exportAlerts))
+	ws.Route(ws.POST("/alerts/bulk").Filter(utils.BearerAuth).To(bulkUpdateAlerts))
+	ws.Route(ws.GET("/alerts/sla-report").Filter(utils.BearerAuth).To(slaReport))
+	ws.Route(ws.GET("/alerts/sla-report/export").Filter(utils.BearerAuth).To(exportSLAReport))
+	ws.Route(ws.PUT("/alerts/{alertId}").Filter(utils.BearerAuth).To(updateAlert))
+	ws.Route(ws.GET("/alerts/{alertId}/history").Filter(utils.BearerAuth).To(getHistory))
+	ws.Route(ws.POST("/alerts/{alertId}/comments").Filter(utils.BearerAuth).To(createComment))
+	ws.Route(ws.GET("/alerts/{alertId}/comments").Filter(utils.BearerAuth).To(searchComments))
+	ws.Route(ws.GET("/incidents").Filter(utils.BearerAuth).To(searchIncidents))
+	ws.Route(ws.GET("/incidents/{incidentId}").Filter(utils.BearerAuth).To(getIncident))
+	ws.Route(ws.PUT("/incidents/{incidentId}").Filter(utils.BearerAuth).To(updateIncident))
+	return ws
+}
+
+// updateAlert assigns, clears or reopens an alert
+// and returns the updated alert if succeeds
+func updateAlert(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("alertId")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canAccessAlert(req, resp, id) {
+		return
+	}
+
+	request := UpdateAlertModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update alert")
+	alert, err := GetService().UpdateAlert(id, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, alert)
+}
+
+// bulkUpdateAlerts assigns, clears or reopens several alerts at once, the alerts the user cannot access fail on their own
+// and returns the result of every alert
+func bulkUpdateAlerts(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM", "SU") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	request := BulkUpdateAlertModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing bulk update of %d alerts", len(request.AlertIDs))
+	result := GetService().BulkUpdateAlerts(request, utils.GetUserID(req), func(siteID string) bool {
+		//Check weather user has permission to the resource
+		return bson.IsObjectIdHex(siteID) && utils.CanAccessResource(req, "site", siteID)
+	})
+
+	resp.WriteHeaderAndEntity(200, result)
+}
+
+// searchAlerts lists the alerts of a site or client, suppressed=true lists the alerts maintenance windows suppressed
+func searchAlerts(req *restful.Request, resp *restful.Response) {
+	query, err := PrepareSearchQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	if !canAccessScope(req, resp, query.SiteID, query.ClientID) {
+		return
+	}
+
+	alerts, err := GetService().SearchAlerts(query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, alerts)
+}
+
+// getHistory lists the changes of an alert from the oldest on
+// and returns them if succeeds
+func getHistory(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("alertId")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canAccessAlert(req, resp, id) {
+		return
+	}
+
+	entries, err := GetService().GetHistory(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, entries)
+}
+
+// createComment leaves a comment on an alert and notifies the users it mentions
+// and returns the comment if succeeds
+func createComment(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("alertId")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canAccessAlert(req, resp, id) {
+		return
+	}
+
+	request := CommentModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create comment")
+	comment, err := GetService().CreateComment(id, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, comment)
+}
+
+// searchComments lists the comments of an alert from the oldest on
+func searchComments(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("alertId")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canAccessAlert(req, resp, id) {
+		return
+	}
+
+	comments, err := GetService().SearchComments(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, comments)
+}
+
+// exportAlerts writes the alerts matching the filters as csv, one row per change of an alert
+func exportAlerts(req *restful.Request, resp *restful.Response) {
+	query, err := PrepareExportQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	if !canAccessScope(req, resp, query.SiteID, query.ClientID) {
+		return
+	}
+
+	rows, err := GetService().ExportAlerts(query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.AddHeader("Content-Type", "text/csv")
+	resp.AddHeader("Content-Disposition", `attachment; filename="alerts.csv"`)
+	resp.WriteHeader(200)
+	err = csv.NewWriter(resp).WriteAll(rows)
+	if err != nil {
+		log.Errorf("error occurred during writing the alert export, error: %v\n", err)
+	}
+}
+
+// slaReport reports the response times of the alerts of a site or client against their sla targets
+func slaReport(req *restful.Request, resp *restful.Response) {
+	query, err := PrepareSLAQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	if !canAccessScope(req, resp, query.SiteID, query.ClientID) {
+		return
+	}
+
+	report, err := GetService().SLAReport(query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, report)
+}
+
+// exportSLAReport writes the sla report as a csv attachment, one row per group and the total last
+func exportSLAReport(req *restful.Request, resp *restful.Response) {
+	query, err := PrepareSLAQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	if !canAccessScope(req, resp, query.SiteID, query.ClientID) {
+		return
+	}
+
+	report, err := GetService().SLAReport(query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.AddHeader("Content-Type", "text/csv")
+	resp.AddHeader("Content-Disposition", `attachment; filename="sla-report.csv"`)
+	resp.WriteHeader(200)
+	err = csv.NewWriter(resp).WriteAll(slaRows(report))
+	if err != nil {
+		log.Errorf("error occurred during writing the sla report, error: %v\n", err)
+	}
+}
+
+// searchIncidents lists the incidents of a site or client, newest first
+func searchIncidents(req *restful.Request, resp *restful.Response) {
+	query, err := PrepareIncidentQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	if !canAccessScope(req, resp, query.SiteID, query.ClientID) {
+		return
+	}
+
+	incidents, err := GetService().SearchIncidents(query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, incidents)
+}
+
+// getIncident find the incident by id
+// and returns it with its alerts if succeeds
+func getIncident(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("incidentId")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canAccessIncident(req, resp, id) {
+		return
+	}
+
+	incident, err := GetService().GetIncidentDetail(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, incident)
+}
+
+// updateIncident acknowledges, clears or reopens an incident
+// and returns the updated incident with its alerts if succeeds
+func updateIncident(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("incidentId")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canAccessIncident(req, resp, id) {
+		return
+	}
+
+	request := UpdateIncidentModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update incident")
+	incident, err := GetService().UpdateIncident(id, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, incident)
+}
@@ -0,0 +1,889 @@
+package alert
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/correlation"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/history"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/sla"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxExportAlerts limits the alerts of one export
+const maxExportAlerts = 5000
+
+// maxPageSize limits the alerts of a page of the alert search
+const maxPageSize = 100
+
+// sortFields lists the sorts of the alert search, the job age sorts by alert time the other way round
+var sortFields = []string{"jobName", "status", "location", "jobAge", "priority"}
+
+// maxReportAlerts limits the alerts of one sla report
+const maxReportAlerts = 50000
+
+// defaultReportPeriod is the period of an sla report without from
+const defaultReportPeriod = 30 * 24 * time.Hour
+
+// Service godoc
+// defines the operations on the alerts raised at the sites
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for alert service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// GetAlert godoc
+// Find the alert by id
+func (Service *Service) GetAlert(id string) (*common.Alert, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCollection)
+
+	alert := common.Alert{}
+	err := c.FindId(bson.ObjectIdHex(id)).One(&alert)
+	if err != nil {
+		log.Errorf("cannot find the alert with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_alert_error")
+	}
+
+	return &alert, nil
+}
+
+// UpdateAlert godoc
+// assigns the alert when it becomes Active, resolves it when it is Cleared and opens it again when it leaves Cleared.
+// The update only applies to the alert as it was read, concurrent changes fail with alert_changed
+func (Service *Service) UpdateAlert(id string, model UpdateAlertModel, currentUserID string) (*common.Alert, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCollection)
+
+	previous, err := Service.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+
+	alert := *previous
+	now := time.Now().UTC()
+	switch model.Status {
+	case common.AlertStatusNew:
+		alert.AssignedTo = []common.SimpleUser{}
+		alert.SiteManager = nil
+		alert.AssignTime = time.Time{}
+		// the escalation starts over when the alert is back to New
+		if previous.Status != common.AlertStatusNew {
+			alert.PendingSince = now
+			alert.EscalationLevel = 0
+		}
+	case common.AlertStatusActive:
+		if len(model.AssignedTo) == 0 {
+			log.Infof("Active alert %s needs assignees", id)
+			return nil, errors.CreateError(400, "assignee_required")
+		}
+		assignees, err := findAssignees(session, previous, model.AssignedTo)
+		if err != nil {
+			return nil, err
+		}
+		alert.AssignedTo = assignees
+		if !alerting.SameAssignees(previous.AssignedTo, assignees) {
+			manager, err := findCurrentUser(session, currentUserID)
+			if err != nil {
+				return nil, err
+			}
+			alert.SiteManager = manager
+		}
+		if alert.AssignTime.IsZero() {
+			alert.AssignTime = now
+		}
+	case common.AlertStatusCleared:
+		if len(model.Reason) == 0 || len(model.Detailed) == 0 {
+			log.Infof("Cleared alert %s needs reason and detailed", id)
+			return nil, errors.CreateError(400, "reason_required")
+		}
+		alert.Reason = model.Reason
+		alert.Detailed = model.Detailed
+	default:
+		log.Infof("Invalid alert status %s", model.Status)
+		return nil, errors.CreateError(400, "invalid_status")
+	}
+
+	alert.Status = model.Status
+	if alert.Status == common.AlertStatusCleared {
+		if previous.Status != common.AlertStatusCleared {
+			alert.ClearTime = now
+		}
+	} else {
+		alert.ClearTime = time.Time{}
+		alert.Reason = ""
+		alert.Detailed = ""
+	}
+	alert.JobAge = jobAgeOf(&alert, now)
+	alert.UpdatedAt = now
+
+	err = c.Update(versionQuery(previous), &alert)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(409, "alert_changed")
+		}
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	if event := alerting.EventOf(previous, &alert); len(event) > 0 {
+		alerting.Emit(&alerting.Event{Type: event, Alert: &alert, Previous: previous, ActorID: currentUserID, At: now})
+	}
+
+	return &alert, nil
+}
+
+// BulkUpdateAlerts godoc
+// updates the alerts one by one like UpdateAlert, so every change is recorded in the history of its alert.
+// An alert failing does not stop the others, canAccess tells whether the user may work on the alerts of a site
+func (Service *Service) BulkUpdateAlerts(model BulkUpdateAlertModel, currentUserID string, canAccess func(siteID string) bool) *BulkResponse {
+	update := UpdateAlertModel{AssignedTo: model.AssignedTo, Status: model.Status, Reason: model.Reason, Detailed: model.Detailed}
+
+	response := BulkResponse{Results: []BulkResult{}}
+	for _, id := range unique(model.AlertIDs) {
+		result := BulkResult{AlertID: id, StatusCode: 200}
+		alert, err := Service.bulkUpdateAlert(id, update, currentUserID, canAccess)
+		if err != nil {
+			result.StatusCode = 500
+			result.Key = err.Error()
+			if httpErr, ok := err.(*errors.HttpError); ok {
+				result.StatusCode = httpErr.StatusCode
+				result.Msg = httpErr.Msg
+			}
+			response.Failed++
+		} else {
+			result.Alert = alert
+			response.Succeeded++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	return &response
+}
+
+// bulkUpdateAlert checks the user may work on the alert and updates it
+func (Service *Service) bulkUpdateAlert(id string, model UpdateAlertModel, currentUserID string, canAccess func(siteID string) bool) (*common.Alert, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errors.CreateError(400, "invalid_alert_id")
+	}
+
+	alert, err := Service.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccess(alert.SiteID) {
+		log.Infof("User access forbidden for alert id %s", id)
+		return nil, errors.CreateError(403, "Forbidden")
+	}
+
+	return Service.UpdateAlert(id, model, currentUserID)
+}
+
+// GetHistory godoc
+// lists the changes of the alert from the oldest on
+func (Service *Service) GetHistory(id string) ([]history.Entry, error) {
+	alert, err := Service.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := history.Of(alert)
+	if err != nil {
+		log.Errorf("Error occured while reading the history of alert %s, error: %v", id, err)
+		return nil, errors.CreateError(500, "get_history_error")
+	}
+
+	return entries, nil
+}
+
+// CreateComment godoc
+// leaves a comment on the alert, a reply to a reply answers the comment that started the thread.
+// The mentioned users must be able to see the alert and are notified, the attachments must be photos
+// the current user uploaded for the site of the alert
+func (Service *Service) CreateComment(id string, model CommentModel, currentUserID string) (*common.AlertComment, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCommentCollection)
+
+	alert, err := Service.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+
+	author, err := findCurrentUser(session, currentUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	comment := common.AlertComment{
+		ID:        bson.NewObjectId(),
+		AlertID:   id,
+		SiteID:    alert.SiteID,
+		ClientID:  alert.ClientID,
+		Text:      model.Text,
+		Author:    *author,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if len(model.ParentID) > 0 {
+		parent := common.AlertComment{}
+		if bson.IsObjectIdHex(model.ParentID) {
+			err = c.Find(bson.M{"_id": bson.ObjectIdHex(model.ParentID), "alertId": id}).One(&parent)
+		}
+		if !bson.IsObjectIdHex(model.ParentID) || err == mgo.ErrNotFound {
+			log.Infof("Comment %s is not a comment of alert %s", model.ParentID, id)
+			return nil, errors.CreateError(400, "invalid_parent")
+		}
+		if err != nil {
+			log.Errorf("Error occured while finding the parent comment, error: %v", err)
+			return nil, errors.CreateError(500, "get_comment_error")
+		}
+		comment.ParentID = model.ParentID
+		if len(parent.ParentID) > 0 {
+			comment.ParentID = parent.ParentID
+		}
+	}
+
+	mentioned, err := findMentions(session, alert, model.Mentions)
+	if err != nil {
+		return nil, err
+	}
+	comment.Mentions = []common.SimpleUser{}
+	for _, user := range mentioned {
+		comment.Mentions = append(comment.Mentions, simpleUserOf(&user))
+	}
+
+	comment.Attachments, err = findAttachments(session, alert, model.Attachments, currentUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Insert(&comment)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		return nil, errors.CreateError(500, "create_comment_error")
+	}
+
+	if len(mentioned) > 0 {
+		data := map[string]interface{}{
+			"AuthorName": strings.TrimSpace(comment.Author.FirstName + " " + comment.Author.FamilyName),
+			"Comment":    comment.Text,
+			"AlertType":  alert.Type,
+			"SiteName":   siteNameOf(session, alert.SiteID),
+			"Room":       alert.Location,
+			"URL":        config.GetConfig().GetString("app.alert_url") + id,
+		}
+		// mentions reach the users on the channel they prefer whatever their notification matrix says
+		err = notification.NotifyAlert(mentioned, "", "", id, mail.TemplateAlertMentioned, mail.GetBrand(alert.ClientID), data)
+		if err != nil {
+			log.Errorf("Failed to notify the users mentioned in comment %s, error: %v", comment.ID.Hex(), err)
+		}
+	}
+
+	alerting.Emit(&alerting.Event{Type: alerting.EventCommented, Alert: alert, ActorID: currentUserID, CommentID: comment.ID.Hex(), At: comment.CreatedAt})
+	return &comment, nil
+}
+
+// SearchComments godoc
+// lists the comments of the alert from the oldest on, replies name their thread in parentId
+func (Service *Service) SearchComments(id string) ([]common.AlertComment, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCommentCollection)
+
+	comments := []common.AlertComment{}
+	err := c.Find(bson.M{"alertId": id}).Sort("createdAt").All(&comments)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return comments, nil
+}
+
+// SearchAlerts godoc
+// lists a page of the alerts matching the query, the newest first unless sorted otherwise.
+// The metadata counts the alerts by type and status, the short form has neither metadata nor details
+func (Service *Service) SearchAlerts(query *Query) (*SearchResult, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCollection)
+
+	// the alerts merged into an identical open alert are counted on it and left out
+	scope := bson.M{"mergedInto": bson.M{"$exists": false}}
+	if len(query.SiteID) > 0 {
+		scope["siteId"] = query.SiteID
+	}
+	if len(query.ClientID) > 0 {
+		scope["clientId"] = query.ClientID
+	}
+	// the alerts suppressed by maintenance windows are left out of the list, the counts and the metadata unless asked for
+	if query.Suppressed != nil && *query.Suppressed {
+		scope["suppressed"] = true
+	} else {
+		scope["suppressed"] = bson.M{"$ne": true}
+	}
+	if len(query.MaintenanceWindowID) > 0 {
+		scope["maintenanceWindowId"] = query.MaintenanceWindowID
+	}
+	if alertTime := alertTimeQuery(query.From, query.To); alertTime != nil {
+		scope["alertTime"] = alertTime
+	}
+
+	filter := bson.M{}
+	for key, value := range scope {
+		filter[key] = value
+	}
+	if len(query.Status) > 0 {
+		filter["status"] = query.Status
+	}
+	if len(query.Type) > 0 {
+		filter["type"] = query.Type
+	}
+
+	total, err := c.Find(filter).Count()
+	if err != nil {
+		log.Errorf("error occured during getting count: error: %v\n", err)
+		return nil, errors.CreateError(500, "query_execute_error")
+	}
+	// the alerts of another status are not counted as cleared
+	totalOfCleared := 0
+	if len(query.Status) == 0 || query.Status == common.AlertStatusCleared {
+		cleared := bson.M{"status": common.AlertStatusCleared}
+		for key, value := range scope {
+			cleared[key] = value
+		}
+		if len(query.Type) > 0 {
+			cleared["type"] = query.Type
+		}
+		totalOfCleared, err = c.Find(cleared).Count()
+		if err != nil {
+			log.Errorf("error occured during getting count: error: %v\n", err)
+			return nil, errors.CreateError(500, "query_execute_error")
+		}
+	}
+
+	find := c.Find(filter).Sort(sortOf(query)...).Skip(query.PageSize * (query.PageNumber - 1)).Limit(query.PageSize)
+	if query.Short {
+		find = find.Select(bson.M{"jobName": 1, "status": 1, "alertTime": 1, "description": 1})
+	}
+	alerts := []common.Alert{}
+	err = find.All(&alerts)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	result := SearchResult{
+		Total:          total,
+		TotalOfCleared: totalOfCleared,
+		PageNumber:     query.PageNumber,
+		PageSize:       query.PageSize,
+		Items:          alerts,
+	}
+	if !query.Short {
+		result.Metadata, err = searchMetadata(c, scope)
+		if err != nil {
+			log.Errorf("error occured during getting count: error: %v\n", err)
+			return nil, errors.CreateError(500, "query_execute_error")
+		}
+	}
+
+	return &result, nil
+}
+
+// ExportAlerts godoc
+// returns the csv rows of the alerts matching the query, with a header row and one row per change of an alert.
+// Up to maxExportAlerts alerts are exported, the newest first, without the alerts merged into identical ones
+func (Service *Service) ExportAlerts(query *ExportQuery) ([][]string, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCollection)
+
+	filter := bson.M{"mergedInto": bson.M{"$exists": false}}
+	if len(query.SiteID) > 0 {
+		filter["siteId"] = query.SiteID
+	}
+	if len(query.ClientID) > 0 {
+		filter["clientId"] = query.ClientID
+	}
+	if len(query.Status) > 0 {
+		filter["status"] = query.Status
+	}
+	if len(query.Type) > 0 {
+		filter["type"] = query.Type
+	}
+	if query.Suppressed != nil && *query.Suppressed {
+		filter["suppressed"] = true
+	} else if query.Suppressed != nil {
+		filter["suppressed"] = bson.M{"$ne": true}
+	}
+	if alertTime := alertTimeQuery(query.From, query.To); alertTime != nil {
+		filter["alertTime"] = alertTime
+	}
+
+	alerts := []common.Alert{}
+	err := c.Find(filter).Sort("-alertTime").Limit(maxExportAlerts).All(&alerts)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	histories, err := history.OfAlerts(alerts)
+	if err != nil {
+		log.Errorf("Error occured while reading the alert histories, error: %v", err)
+		return nil, errors.CreateError(500, "get_history_error")
+	}
+
+	return exportRows(alerts, histories), nil
+}
+
+// SLAReport godoc
+// reports the time to assign and to clear the alerts matching the query against the sla targets of their clients.
+// The alerts suppressed by maintenance windows or merged into identical alerts are left out, a report without from covers the 30 days before to
+// and one without to ends now. A period of more than maxReportAlerts alerts fails with report_too_large
+// rather than reporting part of it, a narrower period or filter has to be asked for
+func (Service *Service) SLAReport(query *SLAQuery) (*sla.Report, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCollection)
+
+	now := time.Now().UTC()
+	to := query.To
+	if to.IsZero() {
+		to = now
+	}
+	from := query.From
+	if from.IsZero() {
+		from = to.Add(-defaultReportPeriod)
+	}
+
+	filter := bson.M{"suppressed": bson.M{"$ne": true}, "mergedInto": bson.M{"$exists": false}, "alertTime": alertTimeQuery(from, to)}
+	if len(query.SiteID) > 0 {
+		filter["siteId"] = query.SiteID
+	}
+	if len(query.ClientID) > 0 {
+		filter["clientId"] = query.ClientID
+	}
+	if len(query.Type) > 0 {
+		filter["type"] = query.Type
+	}
+
+	count, err := c.Find(filter).Count()
+	if err != nil {
+		log.Errorf("error occured during getting count: error: %v\n", err)
+		return nil, errors.CreateError(500, "query_execute_error")
+	}
+	if count > maxReportAlerts {
+		log.Infof("SLA report from %v to %v has %d alerts, more than %d", from, to, count, maxReportAlerts)
+		return nil, errors.CreateError(400, "report_too_large")
+	}
+
+	alerts := []common.Alert{}
+	err = c.Find(filter).Select(bson.M{
+		"siteId": 1, "clientId": 1, "status": 1, "type": 1, "priority": 1, "assignedTo": 1, "alertTime": 1, "assginTime": 1, "clearTime": 1,
+	}).Sort("alertTime").Limit(maxReportAlerts).All(&alerts)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	clientIDs := []string{}
+	siteIDs := []string{}
+	for _, alert := range alerts {
+		clientIDs = append(clientIDs, alert.ClientID)
+		siteIDs = append(siteIDs, alert.SiteID)
+	}
+
+	targets := []sla.Target{}
+	err = session.DB("").C(common.SLATargetCollection).Find(bson.M{"clientId": bson.M{"$in": unique(clientIDs)}}).All(&targets)
+	if err != nil {
+		log.Errorf("Error occured while reading the sla targets, error: %v", err)
+		return nil, errors.CreateError(500, "get_sla_target_error")
+	}
+	byClient := map[string][]sla.Target{}
+	for _, target := range targets {
+		byClient[target.ClientID] = append(byClient[target.ClientID], target)
+	}
+
+	report := sla.Build(alerts, byClient, query.GroupBy, query.Bucket, query.Location, now)
+	report.From = from
+	report.To = to
+
+	if query.GroupBy == sla.GroupBySite && len(siteIDs) > 0 {
+		ids := []bson.ObjectId{}
+		for _, id := range unique(siteIDs) {
+			if bson.IsObjectIdHex(id) {
+				ids = append(ids, bson.ObjectIdHex(id))
+			}
+		}
+		sites := []struct {
+			ID   bson.ObjectId `bson:"_id"`
+			Name string        `bson:"name"`
+		}{}
+		err = session.DB("").C(common.SiteCollection).Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"name": 1}).All(&sites)
+		if err != nil {
+			log.Errorf("Error occured while reading the site names, error: %v", err)
+			return nil, errors.CreateError(500, "get_site_error")
+		}
+		names := map[string]string{}
+		for _, site := range sites {
+			names[site.ID.Hex()] = site.Name
+		}
+		for i := range report.Rows {
+			if name, ok := names[report.Rows[i].Key]; ok {
+				report.Rows[i].Name = name
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// SearchIncidents godoc
+// lists the incidents of the site or client by status, the ones with the latest alerts first
+func (Service *Service) SearchIncidents(query *IncidentQuery) (*common.PagedList, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IncidentCollection)
+
+	dbQuery := bson.M{}
+	if len(query.SiteID) > 0 {
+		dbQuery["siteId"] = query.SiteID
+	}
+	if len(query.ClientID) > 0 {
+		dbQuery["clientId"] = query.ClientID
+	}
+	if len(query.Status) > 0 {
+		dbQuery["status"] = query.Status
+	}
+
+	count, err := c.Find(dbQuery).Count()
+	if err != nil {
+		log.Errorf("error occured during getting count: error: %v\n", err)
+		return nil, errors.CreateError(500, "query_execute_error")
+	}
+
+	incidents := []correlation.Incident{}
+	err = c.Find(dbQuery).Sort("-lastAlertTime").Skip(query.PageSize * (query.PageNumber - 1)).Limit(query.PageSize).All(&incidents)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return &common.PagedList{
+		Items: incidents,
+		Page:  query.PageNumber,
+		Size:  query.PageSize,
+		Total: count,
+	}, nil
+}
+
+// GetIncident godoc
+// Find the incident by id
+func (Service *Service) GetIncident(id string) (*correlation.Incident, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IncidentCollection)
+
+	incident := correlation.Incident{}
+	err := c.FindId(bson.ObjectIdHex(id)).One(&incident)
+	if err != nil {
+		log.Errorf("cannot find the incident with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_incident_error")
+	}
+
+	return &incident, nil
+}
+
+// GetIncidentDetail godoc
+// Find the incident by id with its alerts from the oldest on
+func (Service *Service) GetIncidentDetail(id string) (*IncidentDetail, error) {
+	incident, err := Service.GetIncident(id)
+	if err != nil {
+		return nil, err
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertCollection)
+
+	alerts := []common.Alert{}
+	err = c.Find(bson.M{"incidentId": id}).Sort("alertTime").All(&alerts)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return &IncidentDetail{Incident: incident, Alerts: alerts}, nil
+}
+
+// UpdateIncident godoc
+// changes the status of the incident. Clearing it clears its open alerts with the same reason and details,
+// as if each was cleared by the current user, reopening it leaves its alerts as they are.
+// The update only applies to the incident as it was read, concurrent changes fail with incident_changed
+func (Service *Service) UpdateIncident(id string, model UpdateIncidentModel, currentUserID string) (*IncidentDetail, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IncidentCollection)
+
+	previous, err := Service.GetIncident(id)
+	if err != nil {
+		return nil, err
+	}
+
+	incident := *previous
+	now := time.Now().UTC()
+	switch model.Status {
+	case common.AlertStatusNew, common.AlertStatusActive:
+		incident.ClearTime = time.Time{}
+		incident.Reason = ""
+		incident.Detailed = ""
+	case common.AlertStatusCleared:
+		if len(model.Reason) == 0 || len(model.Detailed) == 0 {
+			log.Infof("Cleared incident %s needs reason and detailed", id)
+			return nil, errors.CreateError(400, "reason_required")
+		}
+		incident.Reason = model.Reason
+		incident.Detailed = model.Detailed
+		if previous.Status != common.AlertStatusCleared {
+			incident.ClearTime = now
+		}
+	default:
+		log.Infof("Invalid incident status %s", model.Status)
+		return nil, errors.CreateError(400, "invalid_status")
+	}
+	incident.Status = model.Status
+	incident.UpdatedBy = currentUserID
+	incident.UpdatedAt = now
+
+	err = c.Update(bson.M{"_id": previous.ID, "updatedAt": previous.UpdatedAt}, &incident)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(409, "incident_changed")
+		}
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	if incident.Status == common.AlertStatusCleared && previous.Status != common.AlertStatusCleared {
+		err = Service.clearIncidentAlerts(session, &incident, currentUserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return Service.GetIncidentDetail(id)
+}
+
+// clearIncidentAlerts clears the open alerts of the incident, an alert changed meanwhile is read again and retried.
+// Alerts which still cannot be cleared stay open and are logged
+func (Service *Service) clearIncidentAlerts(session *mgo.Session, incident *correlation.Incident, currentUserID string) error {
+	alerts := []common.Alert{}
+	err := session.DB("").C(common.AlertCollection).Find(bson.M{
+		"incidentId": incident.ID.Hex(),
+		"status":     bson.M{"$ne": common.AlertStatusCleared},
+	}).Select(bson.M{"_id": 1}).All(&alerts)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return errors.CreateError(500, "search_error")
+	}
+
+	model := UpdateAlertModel{Status: common.AlertStatusCleared, Reason: incident.Reason, Detailed: incident.Detailed}
+	for _, alert := range alerts {
+		for attempt := 1; attempt <= clearAttempts; attempt++ {
+			_, err = Service.UpdateAlert(alert.ID.Hex(), model, currentUserID)
+			if httpErr, ok := err.(*errors.HttpError); !ok || httpErr.StatusCode != 409 {
+				break
+			}
+		}
+		if err != nil {
+			log.Errorf("Failed to clear alert %s of incident %s, error: %v", alert.ID.Hex(), incident.ID.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+// findMentions loads the mentioned users, they must be active and able to see the site of the alert
+func findMentions(session *mgo.Session, alert *common.Alert, ids []string) ([]common.User, error) {
+	users := []common.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	objIDs := []bson.ObjectId{}
+	for _, id := range ids {
+		if !bson.IsObjectIdHex(id) {
+			log.Infof("Invalid mention %s", id)
+			return nil, errors.CreateError(400, "invalid_mention")
+		}
+		objIDs = append(objIDs, bson.ObjectIdHex(id))
+	}
+
+	err := session.DB("").C(common.UserCollection).Find(bson.M{
+		"_id":    bson.M{"$in": objIDs},
+		"status": common.Active,
+		"$or": []bson.M{
+			{"permissions.role": "SA"},
+			{"permissions.scopes.ids": bson.M{"$in": []string{alert.SiteID, alert.ClientID}}},
+		},
+	}).All(&users)
+	if err != nil {
+		log.Errorf("Error occured while finding mentions, error: %v", err)
+		return nil, errors.CreateError(500, "get_user_error")
+	}
+	if len(users) != len(unique(ids)) {
+		log.Infof("Mentions %v are not all active users of site %s", ids, alert.SiteID)
+		return nil, errors.CreateError(400, "invalid_mention")
+	}
+
+	return users, nil
+}
+
+// findAttachments loads the photos of the comment, they must be alert photos the user uploaded for the site of the alert
+func findAttachments(session *mgo.Session, alert *common.Alert, ids []string, currentUserID string) ([]common.CommentAttachment, error) {
+	attachments := []common.CommentAttachment{}
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+
+	objIDs := []bson.ObjectId{}
+	for _, id := range ids {
+		if !bson.IsObjectIdHex(id) {
+			log.Infof("Invalid attachment %s", id)
+			return nil, errors.CreateError(400, "invalid_attachment")
+		}
+		objIDs = append(objIDs, bson.ObjectIdHex(id))
+	}
+
+	files := []common.File{}
+	err := session.DB("").C(common.FileCollection).Find(bson.M{
+		"_id":        bson.M{"$in": objIDs},
+		"purpose":    common.FilePurposeAlertPhoto,
+		"siteId":     alert.SiteID,
+		"uploadedBy": currentUserID,
+	}).All(&files)
+	if err != nil {
+		log.Errorf("Error occured while finding attachments, error: %v", err)
+		return nil, errors.CreateError(500, "get_file_error")
+	}
+	if len(files) != len(unique(ids)) {
+		log.Infof("Attachments %v are not all photos uploaded for site %s", ids, alert.SiteID)
+		return nil, errors.CreateError(400, "invalid_attachment")
+	}
+
+	for _, file := range files {
+		attachments = append(attachments, common.CommentAttachment{
+			FileID:      file.ID.Hex(),
+			Name:        file.OriginalName,
+			URL:         file.URL,
+			ContentType: file.ContentType,
+			Variants:    file.Variants,
+		})
+	}
+
+	return attachments, nil
+}
+
+// findAssignees loads the active users by id in the short form shown on alerts, an assignee must be able to
+// see the alert like a mentioned user and is listed once however often the request repeats it
+func findAssignees(session *mgo.Session, alert *common.Alert, ids []string) ([]common.SimpleUser, error) {
+	ids = unique(ids)
+	objIDs := []bson.ObjectId{}
+	for _, id := range ids {
+		if !bson.IsObjectIdHex(id) {
+			log.Infof("Invalid assignee id %s", id)
+			return nil, errors.CreateError(400, "invalid_assignee")
+		}
+		objIDs = append(objIDs, bson.ObjectIdHex(id))
+	}
+
+	users := []common.User{}
+	err := session.DB("").C(common.UserCollection).Find(bson.M{
+		"_id":    bson.M{"$in": objIDs},
+		"status": common.Active,
+		"$or": []bson.M{
+			{"permissions.role": "SA"},
+			{"permissions.scopes.ids": bson.M{"$in": []string{alert.SiteID, alert.ClientID}}},
+		},
+	}).All(&users)
+	if err != nil {
+		log.Errorf("Error occured while finding assignees, error: %v", err)
+		return nil, errors.CreateError(500, "get_user_error")
+	}
+	if len(users) != len(objIDs) {
+		log.Infof("Assignees %v are not all active users of site %s", ids, alert.SiteID)
+		return nil, errors.CreateError(400, "invalid_assignee")
+	}
+
+	// keep the order of the request
+	byID := map[bson.ObjectId]common.User{}
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	assignees := []common.SimpleUser{}
+	for _, id := range objIDs {
+		user := byID[id]
+		assignees = append(assignees, simpleUserOf(&user))
+	}
+
+	return assignees, nil
+}
+
+// findCurrentUser loads the current user in the short form shown on alerts, the access to the alert
+// is already checked by the controller
+func findCurrentUser(session *mgo.Session, currentUserID string) (*common.SimpleUser, error) {
+	if !bson.IsObjectIdHex(currentUserID) {
+		log.Infof("Invalid current user id %s", currentUserID)
+		return nil, errors.CreateError(400, "invalid_assignee")
+	}
+
+	user := common.User{}
+	err := session.DB("").C(common.UserCollection).Find(bson.M{"_id": bson.ObjectIdHex(currentUserID), "status": common.Active}).One(&user)
+	if err == mgo.ErrNotFound {
+		log.Infof("Current user %s is not active", currentUserID)
+		return nil, errors.CreateError(400, "invalid_assignee")
+	}
+	if err != nil {
+		log.Errorf("Error occured while finding the current user, error: %v", err)
+		return nil, errors.CreateError(500, "get_user_error")
+	}
+	simple := simpleUserOf(&user)
+
+	return &simple, nil
+}
@@ -0,0 +1,513 @@
+package alert
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/history"
+	"anacove.com/backend/sla"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// canAccessAlert lets the users scoped to the site of the alert work on it, it writes the error response otherwise
+func canAccessAlert(req *restful.Request, resp *restful.Response, id string) bool {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM", "SU") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	alert, err := GetService().GetAlert(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	if !bson.IsObjectIdHex(alert.SiteID) || !utils.CanAccessResource(req, "site", alert.SiteID) {
+		log.Infof("User access forbidden for alert id %s", id)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
+
+// simpleUserOf returns the short form of the user shown on alerts
+func simpleUserOf(user *common.User) common.SimpleUser {
+	return common.SimpleUser{
+		ID:         user.ID.Hex(),
+		Email:      user.Email,
+		FirstName:  user.FirstName,
+		FamilyName: user.FamilyName,
+		ProfileURL: user.ProfileURL,
+	}
+}
+
+// siteNameOf returns the name of the site shown in notifications, its id when it cannot be found
+func siteNameOf(session *mgo.Session, siteID string) string {
+	if !bson.IsObjectIdHex(siteID) {
+		return siteID
+	}
+
+	site := struct {
+		Name string `bson:"name"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).Select(bson.M{"name": 1}).One(&site)
+	if err != nil {
+		return siteID
+	}
+
+	return site.Name
+}
+
+// unique returns the ids without repetitions
+func unique(ids []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+// jobAgeOf returns the seconds the alert is open, up to the clear time for cleared alerts
+func jobAgeOf(alert *common.Alert, now time.Time) float64 {
+	if alert.AlertTime.IsZero() {
+		return alert.JobAge
+	}
+
+	end := now
+	if !alert.ClearTime.IsZero() {
+		end = alert.ClearTime
+	}
+
+	return end.Sub(alert.AlertTime).Seconds()
+}
+
+// versionQuery matches the alert only as it was read, alerts raised by other services may have no update time yet
+func versionQuery(alert *common.Alert) bson.M {
+	if alert.UpdatedAt.IsZero() {
+		return bson.M{"_id": alert.ID, "updatedAt": bson.M{"$in": []interface{}{nil, time.Time{}}}}
+	}
+
+	return bson.M{"_id": alert.ID, "updatedAt": alert.UpdatedAt}
+}
+
+// PrepareExportQuery reads the alert export filters from the query parameters, the times are RFC 3339
+func PrepareExportQuery(req *restful.Request) (*ExportQuery, error) {
+	query := ExportQuery{
+		ClientID: req.QueryParameter("clientId"),
+		SiteID:   req.QueryParameter("siteId"),
+		Status:   req.QueryParameter("status"),
+		Type:     req.QueryParameter("type"),
+	}
+
+	val := req.QueryParameter("suppressed")
+	if val != "" {
+		suppressed, err := strconv.ParseBool(val)
+		if err != nil {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+		query.Suppressed = &suppressed
+	}
+
+	err := parseTimes(req, &query.From, &query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	return &query, nil
+}
+
+// PrepareSLAQuery reads the sla report filters and grouping from the query parameters, the times are RFC 3339.
+// The report is by site and daily buckets unless asked otherwise, buckets start in the default time zone without timeZone
+func PrepareSLAQuery(req *restful.Request) (*SLAQuery, error) {
+	query := SLAQuery{
+		ClientID: req.QueryParameter("clientId"),
+		SiteID:   req.QueryParameter("siteId"),
+		Type:     req.QueryParameter("type"),
+		GroupBy:  sla.GroupBySite,
+		Bucket:   sla.BucketDay,
+		Location: time.UTC,
+	}
+
+	if val := req.QueryParameter("groupBy"); val != "" {
+		query.GroupBy = val
+	}
+	if val := req.QueryParameter("bucket"); val != "" {
+		query.Bucket = val
+	}
+	if !utils.Contains(sla.GroupBys, query.GroupBy) || !utils.Contains(sla.Buckets, query.Bucket) {
+		log.Infof("Unknown sla report grouping %s %s", query.GroupBy, query.Bucket)
+		return nil, errors.CreateError(400, "invalid_data")
+	}
+
+	timeZone := req.QueryParameter("timeZone")
+	if timeZone == "" {
+		timeZone = config.GetConfig().GetString("notification.default_time_zone")
+	}
+	if timeZone != "" {
+		location, err := time.LoadLocation(timeZone)
+		if err != nil {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+		query.Location = location
+	}
+
+	err := parseTimes(req, &query.From, &query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	return &query, nil
+}
+
+// PrepareSearchQuery reads the alert search filters and sort order from the query parameters, the times are RFC 3339
+// and alertTime lists the alerts raised after it
+func PrepareSearchQuery(req *restful.Request) (*Query, error) {
+	query := Query{
+		PageNumber:          1,
+		PageSize:            20,
+		SortOrder:           -1,
+		SortBy:              req.QueryParameter("sortBy"),
+		ClientID:            req.QueryParameter("clientId"),
+		SiteID:              req.QueryParameter("siteId"),
+		Status:              req.QueryParameter("status"),
+		Type:                req.QueryParameter("type"),
+		MaintenanceWindowID: req.QueryParameter("maintenanceWindowId"),
+	}
+
+	for name, value := range map[string]*int{"pageNumber": &query.PageNumber, "pageSize": &query.PageSize} {
+		val := req.QueryParameter(name)
+		if val == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 1 {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+		*value = i
+	}
+	if query.PageSize > maxPageSize {
+		query.PageSize = maxPageSize
+	}
+
+	if len(query.SortBy) > 0 && !utils.Contains(sortFields, query.SortBy) {
+		log.Infof("Unknown alert sort %s", query.SortBy)
+		return nil, errors.CreateError(400, "invalid_data")
+	}
+	if req.QueryParameter("sortOrder") == "asc" {
+		query.SortOrder = 1
+	}
+
+	val := req.QueryParameter("suppressed")
+	if val != "" {
+		suppressed, err := strconv.ParseBool(val)
+		if err != nil {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+		query.Suppressed = &suppressed
+	}
+
+	val = req.QueryParameter("short")
+	if val != "" {
+		short, err := strconv.ParseBool(val)
+		if err != nil {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+		query.Short = short
+	}
+
+	err := parseTimes(req, &query.From, &query.To)
+	if err != nil {
+		return nil, err
+	}
+	if val := req.QueryParameter("alertTime"); val != "" {
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+		// alertTime is exclusive
+		if t.Add(time.Nanosecond).After(query.From) {
+			query.From = t.Add(time.Nanosecond)
+		}
+	}
+
+	return &query, nil
+}
+
+// sortOf returns the sort of the alert search, alerts sorted on the same value are the newest first
+func sortOf(query *Query) []string {
+	field := query.SortBy
+	order := query.SortOrder
+	switch field {
+	case "":
+		return []string{"-alertTime"}
+	case "jobAge":
+		field = "alertTime"
+		order = -order
+	}
+	if order < 0 {
+		field = "-" + field
+	}
+
+	return []string{field, "-alertTime"}
+}
+
+// searchMetadata counts the alerts in the scope of the search by type and by status
+func searchMetadata(c *mgo.Collection, scope bson.M) (*SearchMetadata, error) {
+	counts := []struct {
+		ID struct {
+			Type   string `bson:"type"`
+			Status string `bson:"status"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}{}
+	err := c.Pipe([]bson.M{
+		{"$match": scope},
+		{"$group": bson.M{"_id": bson.M{"type": "$type", "status": "$status"}, "count": bson.M{"$sum": 1}}},
+	}).All(&counts)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := SearchMetadata{}
+	for _, count := range counts {
+		metadata.TotalOfSummary += count.Count
+		switch count.ID.Type {
+		case common.AlertTypeStaffAlert:
+			metadata.TotalOfStaffAlert += count.Count
+		case common.AlertTypeNotification:
+			metadata.TotalOfNotification += count.Count
+		case common.AlertTypeSystemAlert:
+			metadata.TotalOfSystemAlert += count.Count
+		}
+		switch count.ID.Status {
+		case common.AlertStatusNew:
+			metadata.New += count.Count
+		case common.AlertStatusActive:
+			metadata.Active += count.Count
+		case common.AlertStatusCleared:
+			metadata.Cleared += count.Count
+		}
+	}
+
+	return &metadata, nil
+}
+
+// parseTimes reads the RFC 3339 from and to query parameters
+func parseTimes(req *restful.Request, from *time.Time, to *time.Time) error {
+	for name, value := range map[string]*time.Time{"from": from, "to": to} {
+		val := req.QueryParameter(name)
+		if val == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return errors.CreateError(400, "invalid_data")
+		}
+		*value = t
+	}
+
+	return nil
+}
+
+// alertTimeQuery matches the alert times from inclusive and to exclusive, nil when neither is set
+func alertTimeQuery(from time.Time, to time.Time) bson.M {
+	alertTime := bson.M{}
+	if !from.IsZero() {
+		alertTime["$gte"] = from
+	}
+	if !to.IsZero() {
+		alertTime["$lt"] = to
+	}
+	if len(alertTime) == 0 {
+		return nil
+	}
+
+	return alertTime
+}
+
+// canAccessScope lets the users list the alerts and incidents of the sites or clients they can access,
+// only super admins list them without a site or client. It writes the error response otherwise
+func canAccessScope(req *restful.Request, resp *restful.Response, siteID string, clientID string) bool {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM", "SU") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	allowed := false
+	switch {
+	case len(siteID) > 0:
+		allowed = bson.IsObjectIdHex(siteID) && utils.CanAccessResource(req, "site", siteID)
+	case len(clientID) > 0:
+		allowed = bson.IsObjectIdHex(clientID) && utils.CanAccessResource(req, "client", clientID)
+	default:
+		allowed = utils.HasRole(req, "SA")
+	}
+	if !allowed {
+		log.Infof("User access forbidden for site %s client %s", siteID, clientID)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
+
+// canAccessIncident lets the users scoped to the site of the incident work on it, it writes the error response otherwise
+func canAccessIncident(req *restful.Request, resp *restful.Response, id string) bool {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM", "SU") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	incident, err := GetService().GetIncident(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	if !bson.IsObjectIdHex(incident.SiteID) || !utils.CanAccessResource(req, "site", incident.SiteID) {
+		log.Infof("User access forbidden for incident id %s", id)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
+
+// PrepareIncidentQuery reads the incident search filters from the query parameters
+func PrepareIncidentQuery(req *restful.Request) (*IncidentQuery, error) {
+	query := IncidentQuery{
+		PageNumber: 1,
+		PageSize:   20,
+		ClientID:   req.QueryParameter("clientId"),
+		SiteID:     req.QueryParameter("siteId"),
+		Status:     req.QueryParameter("status"),
+	}
+
+	for name, value := range map[string]*int{"pageNumber": &query.PageNumber, "pageSize": &query.PageSize} {
+		val := req.QueryParameter(name)
+		if val == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 1 {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+		*value = i
+	}
+
+	return &query, nil
+}
+
+// exportHeader names the columns of the alert export
+var exportHeader = []string{
+	"alertId", "siteId", "type", "priority", "location", "jobName", "status", "alertTime", "clearTime",
+	"event", "eventTime", "actorId", "actorName", "statusBefore", "statusAfter", "assignedBefore", "assignedAfter",
+}
+
+// exportRows returns the csv rows of the alerts, one per change of an alert
+func exportRows(alerts []common.Alert, histories map[string][]history.Entry) [][]string {
+	rows := [][]string{exportHeader}
+	for _, alert := range alerts {
+		columns := []string{
+			alert.ID.Hex(), alert.SiteID, alert.Type, alert.Priority, alert.Location, alert.JobName, alert.Status,
+			formatTime(alert.AlertTime), formatTime(alert.ClearTime),
+		}
+		for _, entry := range histories[alert.ID.Hex()] {
+			row := append(append([]string{}, columns...), entry.Type, formatTime(entry.At), entry.ActorID, entry.ActorName)
+			before, after := &history.State{}, &history.State{}
+			if entry.Before != nil {
+				before = entry.Before
+			}
+			if entry.After != nil {
+				after = entry.After
+			}
+			row = append(row, before.Status, after.Status, assigneesOf(before), assigneesOf(after))
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+// assigneesOf returns the emails of the assignees separated by semicolons
+func assigneesOf(state *history.State) string {
+	emails := []string{}
+	for _, user := range state.AssignedTo {
+		emails = append(emails, user.Email)
+	}
+
+	return strings.Join(emails, ";")
+}
+
+// formatTime formats the time as RFC 3339, empty when it is not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// slaHeader names the columns of the sla report export, the times are in minutes
+var slaHeader = []string{
+	"group", "key", "name", "alerts", "assigned", "assignP50", "assignP90", "assignP95",
+	"cleared", "clearP50", "clearP90", "clearP95", "assignBreaches", "clearBreaches", "breached",
+}
+
+// slaRows returns the csv rows of the sla report, the total comes last
+func slaRows(report *sla.Report) [][]string {
+	rows := [][]string{slaHeader}
+	for _, row := range report.Rows {
+		rows = append(rows, slaColumns(report.GroupBy, &row))
+	}
+
+	return append(rows, slaColumns("total", &report.Total))
+}
+
+// slaColumns returns the columns of a row of the sla report
+func slaColumns(group string, row *sla.Row) []string {
+	return []string{
+		group, row.Key, row.Name, strconv.Itoa(row.Alerts), strconv.Itoa(row.Assigned),
+		formatMinutes(row.AssignP50), formatMinutes(row.AssignP90), formatMinutes(row.AssignP95), strconv.Itoa(row.Cleared),
+		formatMinutes(row.ClearP50), formatMinutes(row.ClearP90), formatMinutes(row.ClearP95),
+		strconv.Itoa(row.AssignBreaches), strconv.Itoa(row.ClearBreaches), strconv.FormatBool(row.Breached),
+	}
+}
+
+// formatMinutes formats the minutes with one decimal
+func formatMinutes(minutes float64) string {
+	return strconv.FormatFloat(minutes, 'f', 1, 64)
+}
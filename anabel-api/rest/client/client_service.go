@@ -6,7 +6,9 @@ import (
 
 	"anacove.com/backend/common"
 	"anacove.com/backend/errors"
+	"anacove.com/backend/mail"
 	"anacove.com/backend/utils"
+	"anacove.com/backend/webhook"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	log "github.com/sirupsen/logrus"
@@ -52,6 +54,17 @@ func (ClientService *Service) CreateClient(model CreateClientModel) (*common.Cli
 	client.UID = time.Now().Unix()
 	client.Status = common.Active
 
+	// the logo is uploaded before the client exists
+	var logo *common.File
+	if len(client.LogoURL) > 0 {
+		var err error
+		logo, err = resolveLogo(client.LogoURL, id.Hex())
+		if err != nil {
+			return nil, err
+		}
+		client.LogoVariants = logo.Variants
+	}
+
 	err := c.Insert(&client)
 	if err != nil {
 		log.Errorf("error occured during insert to client info: error: %v\n", err)
@@ -59,6 +72,10 @@ func (ClientService *Service) CreateClient(model CreateClientModel) (*common.Cli
 	}
 	log.Infof("client created")
 
+	if logo != nil {
+		claimLogo(session, logo, id.Hex())
+	}
+
 	return &client, nil
 }
 
@@ -268,8 +285,17 @@ func (ClientService *Service) ArchiveClient(id string) error {
 		return errors.CreateError(500, "get_client_error")
 	}
 
+	// users keep the client id as hex string, contacts cannot sign in and are not notified
+	users := []common.User{}
+	err = userCollection.Find(bson.M{"clientId": id, "status": bson.M{"$ne": common.Inactive},
+		"permissions.role": bson.M{"$ne": "CC"}}).All(&users)
+	if err != nil {
+		log.Errorf("error occurred during finding users, error: %v\n", err)
+		return errors.CreateError(500, "get_user_error")
+	}
+
 	// updating user by client with clear token
-	err = userCollection.Update(bson.M{"clientId": objID},
+	_, err = userCollection.UpdateAll(bson.M{"clientId": id},
 		bson.M{"$set": bson.M{"status": common.Inactive, "token": "", "updatedAt": time.Now().UTC()}})
 	if err != nil && err != mgo.ErrNotFound {
 		log.Errorf("error occurred during update user, error: %v\n", err)
@@ -283,6 +309,29 @@ func (ClientService *Service) ArchiveClient(id string) error {
 		return errors.CreateError(500, "update_client_error")
 	}
 
+	// the client is archived already, failed notifications are only logged
+	brand := mail.BrandOf(&client)
+	for _, user := range users {
+		_, err = mail.Queue(&mail.Email{
+			Template: mail.TemplateAccountArchived,
+			Locale:   user.Locale,
+			To:       []string{user.Email},
+			Brand:    brand,
+			Data:     map[string]interface{}{"FirstName": user.FirstName},
+		}, "user:"+user.ID.Hex())
+		if err != nil {
+			log.Errorf("error occurred during queueing email to %s, error: %v\n", user.Email, err)
+		}
+	}
+
+	err = webhook.Publish(id, webhook.EventClientArchived, map[string]interface{}{
+		"id":   id,
+		"name": client.Name,
+	})
+	if err != nil {
+		log.Errorf("error occurred during publishing %s, error: %v\n", webhook.EventClientArchived, err)
+	}
+
 	return nil
 }
 
@@ -342,12 +391,26 @@ func (ClientService *Service) UpdateClient(id string, model UpdateRequestModel,
 		return nil, errors.CreateError(500, "internal_error")
 	}
 
+	logoURL := client.LogoURL
 	client, err = model.ToClient(client, permissions)
 	if err != nil {
 		log.Errorf("error occurred during model conversion, error: %v\n", err)
 		return nil, err
 	}
 
+	// a changed logo must be an uploaded logo, its thumbnails are kept with the client
+	var logo *common.File
+	if client.LogoURL != logoURL {
+		client.LogoVariants = nil
+		if len(client.LogoURL) > 0 {
+			logo, err = resolveLogo(client.LogoURL, id)
+			if err != nil {
+				return nil, err
+			}
+			client.LogoVariants = logo.Variants
+		}
+	}
+
 	client.UpdatedOn = time.Now().UTC()
 	err = c.Update(bson.M{"_id": objID}, client)
 	if err != nil {
@@ -355,6 +418,10 @@ func (ClientService *Service) UpdateClient(id string, model UpdateRequestModel,
 		return nil, err
 	}
 
+	if logo != nil {
+		claimLogo(session, logo, id)
+	}
+
 	// create response
 	response := ToUpdateResponseModel(UpdateResponseModel{}, client)
 
@@ -374,3 +441,15 @@ func (ClientService *Service) UpdateClient(id string, model UpdateRequestModel,
 
 	return &response, nil
 }
+
+// claimLogo makes the client the owner of a logo uploaded without one
+func claimLogo(session *mgo.Session, logo *common.File, clientID string) {
+	if len(logo.ClientID) > 0 {
+		return
+	}
+
+	err := session.DB("").C(common.FileCollection).UpdateId(logo.ID, bson.M{"$set": bson.M{"clientId": clientID}})
+	if err != nil {
+		log.Errorf("error occurred during update logo %s, error: %v\n", logo.Name, err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"anacove.com/backend/errors"
 	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -171,6 +172,25 @@ func (model *CreateClientModel) toClient() common.Client {
 	return client
 }
 
+// resolveLogo checks the logo url references an uploaded logo which is not owned by another client
+func resolveLogo(logoURL string, clientID string) (*common.File, error) {
+	logo, err := utils.GetCommonService().GetFileByURL(logoURL)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			log.Infof("Logo %s is not uploaded", logoURL)
+			return nil, errors.CreateError(400, "invalid_logo")
+		}
+		return nil, errors.CreateError(500, "get_file_error")
+	}
+
+	if logo.Purpose != common.FilePurposeLogo || (len(logo.ClientID) > 0 && logo.ClientID != clientID) {
+		log.Infof("File %s cannot be the logo of client %s", logoURL, clientID)
+		return nil, errors.CreateError(400, "invalid_logo")
+	}
+
+	return logo, nil
+}
+
 //PrepareClientSearchQuery Get client search query
 func PrepareClientSearchQuery(req *restful.Request) (*Query, error) {
 	query := Query{
@@ -0,0 +1,118 @@
+package email
+
+import "anacove.com/backend/mail"
+
+// maxNotificationSize limits the body of the sns notifications
+const maxNotificationSize = 256 * 1024
+
+// PreviewModel godoc
+// defines the request to render an email template, data overrides the sample values
+type PreviewModel struct {
+	Template string                 `validate:"required" json:"template"`
+	Locale   string                 `json:"locale"`
+	ClientID string                 `json:"clientId"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// PreviewResponseModel godoc
+// defines the rendered email
+type PreviewResponseModel struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+	SMS     string `json:"sms"`
+}
+
+// TemplateModel godoc
+// defines a template and the locales it is translated to
+type TemplateModel struct {
+	Name    string   `json:"name"`
+	Locales []string `json:"locales"`
+}
+
+// sampleData defines the values the templates are previewed with
+var sampleData = map[string]map[string]interface{}{
+	mail.TemplateInvitation: {
+		"FirstName": "Jane",
+		"URL":       "https://example.com/activate/xxxx-xxxx",
+		"ExpiresAt": "2020-07-20 12:48 UTC",
+	},
+	mail.TemplatePasswordReset: {
+		"FirstName": "Jane",
+		"URL":       "https://example.com/reset/xxxx-xxxx",
+	},
+	mail.TemplatePasswordChanged: {
+		"FirstName": "Jane",
+		"ChangedAt": "2020-07-13 12:48 UTC",
+	},
+	mail.TemplateAccountArchived: {
+		"FirstName": "Jane",
+	},
+	mail.TemplateAlertCreated: {
+		"FirstName": "Jane",
+		"AlertType": "Staff Alert",
+		"SiteName":  "Sample Hotel",
+		"Room":      "1203",
+		"CreatedAt": "2020-07-13 12:48 UTC",
+		"URL":       "https://example.com/alerts/xxxx",
+	},
+	mail.TemplateAlertAssigned: {
+		"FirstName": "Jane",
+		"AlertType": "Staff Alert",
+		"SiteName":  "Sample Hotel",
+		"Room":      "1203",
+		"CreatedAt": "2020-07-13 12:48 UTC",
+		"URL":       "https://example.com/alerts/xxxx",
+	},
+	mail.TemplateAlertEscalated: {
+		"FirstName": "Jane",
+		"AlertType": "Staff Alert",
+		"Priority":  "High",
+		"SiteName":  "Sample Hotel",
+		"Room":      "1203",
+		"CreatedAt": "2020-07-13 12:48 UTC",
+		"Minutes":   15,
+		"URL":       "https://example.com/alerts/xxxx",
+	},
+	mail.TemplateAlertMentioned: {
+		"FirstName":  "Jane",
+		"AuthorName": "John Smith",
+		"Comment":    "The TV in this room is damaged, see the photo",
+		"AlertType":  "Staff Alert",
+		"SiteName":   "Sample Hotel",
+		"Room":       "1203",
+		"URL":        "https://example.com/alerts/xxxx",
+	},
+	mail.TemplateDailyDigest: {
+		"FirstName":      "Jane",
+		"Period":         "2020-07-13",
+		"NewAlerts":      16,
+		"ActiveAlerts":   4,
+		"ClearedAlerts":  15,
+		"AverageJobAge":  "38m",
+		"DevicesOffline": 1,
+		"NewUsers":       2,
+		"Sites": []map[string]interface{}{
+			{"SiteName": "Sample Hotel", "New": 12, "Active": 3, "Cleared": 10, "AverageJobAge": "24m", "DevicesOffline": 1},
+			{"SiteName": "Sample Resort", "New": 4, "Active": 1, "Cleared": 5, "AverageJobAge": "1h 10m", "DevicesOffline": 0},
+		},
+		"URL":            "https://example.com",
+		"UnsubscribeURL": "https://example.com/unsubscribe/xxxx",
+	},
+	mail.TemplateWeeklyDigest: {
+		"FirstName":      "Jane",
+		"Period":         "2020-07-06 - 2020-07-12",
+		"NewAlerts":      16,
+		"ActiveAlerts":   4,
+		"ClearedAlerts":  15,
+		"AverageJobAge":  "38m",
+		"DevicesOffline": 1,
+		"NewUsers":       2,
+		"Sites": []map[string]interface{}{
+			{"SiteName": "Sample Hotel", "New": 12, "Active": 3, "Cleared": 10, "AverageJobAge": "24m", "DevicesOffline": 1},
+			{"SiteName": "Sample Resort", "New": 4, "Active": 1, "Cleared": 5, "AverageJobAge": "1h 10m", "DevicesOffline": 0},
+		},
+		"URL":            "https://example.com",
+		"UnsubscribeURL": "https://example.com/unsubscribe/xxxx",
+	},
+}
@@ -0,0 +1,93 @@
+package email
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"anacove.com/backend/errors"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller type
+type Controller struct {
+}
+
+// AddRouters allows the endpoints defined in this controller to be added to router
+func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
+	ws.Route(ws.GET("/emails/templates").Filter(utils.BearerAuth).To(getTemplates))
+	ws.Route(ws.POST("/emails/preview").Filter(utils.BearerAuth).To(previewTemplate))
+	// sns posts the notifications as text/plain, they are authenticated by their signature
+	ws.Route(ws.POST("/emails/notifications").Consumes("text/plain", restful.MIME_JSON).To(receiveNotification))
+	return ws
+}
+
+// getTemplates godoc
+// lists the email templates and their locales
+func getTemplates(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, GetService().GetTemplates())
+}
+
+// previewTemplate godoc
+// renders an email template with sample data and returns its subject and bodies
+func previewTemplate(req *restful.Request, resp *restful.Response) {
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	model := PreviewModel{}
+	err := req.ReadEntity(&model)
+	if err != nil {
+		log.Errorf("Request data is not valid: error %v\n", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	res, err := GetService().Preview(model)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// receiveNotification godoc
+// consumes the ses bounce, complaint and delivery notifications sns posts to the endpoint
+func receiveNotification(req *restful.Request, resp *restful.Response) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Request.Body, maxNotificationSize))
+	if err != nil {
+		log.Errorf("Request data is not valid: error %v\n", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	message := mail.SNSMessage{}
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		log.Errorf("Request data is not valid: error %v\n", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	err = GetService().ReceiveNotification(&message)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
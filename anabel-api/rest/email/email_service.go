@@ -0,0 +1,124 @@
+package email
+
+import (
+	"sync"
+
+	"anacove.com/backend/errors"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service godoc
+// defines the email template operations
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for email service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// GetTemplates godoc
+// lists the templates with their locales
+func (Service *Service) GetTemplates() []TemplateModel {
+	templates := []TemplateModel{}
+	for _, name := range mail.Templates {
+		templates = append(templates, TemplateModel{Name: name, Locales: mail.TemplateLocales(name)})
+	}
+
+	return templates
+}
+
+// Preview godoc
+// renders the template with the sample data and the branding of the client, with the text message it is sent as
+func (Service *Service) Preview(model PreviewModel) (*PreviewResponseModel, error) {
+	err := utils.GetValidator().Struct(model)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		return nil, errors.CreateError(400, "invalid_request_data")
+	}
+
+	sample, ok := sampleData[model.Template]
+	if !ok {
+		log.Infof("Invalid email template %s", model.Template)
+		return nil, errors.CreateError(400, "invalid_template")
+	}
+
+	data := map[string]interface{}{}
+	for key, value := range sample {
+		data[key] = value
+	}
+	for key, value := range model.Data {
+		data[key] = value
+	}
+
+	email := mail.Email{
+		Template: model.Template,
+		Locale:   model.Locale,
+		Brand:    mail.GetBrand(model.ClientID),
+		Data:     data,
+	}
+	message, err := email.Render()
+	if err != nil {
+		log.Errorf("error occurred during rendering template %s, error: %v\n", model.Template, err)
+		return nil, errors.CreateErrorWithMsg(400, "render_template_error", err.Error())
+	}
+
+	sms, err := email.RenderSMS()
+	if err != nil {
+		log.Errorf("error occurred during rendering template %s, error: %v\n", model.Template, err)
+		return nil, errors.CreateErrorWithMsg(400, "render_template_error", err.Error())
+	}
+
+	return &PreviewResponseModel{
+		Subject: message.Subject,
+		HTML:    message.HTML,
+		Text:    message.Text,
+		SMS:     sms,
+	}, nil
+}
+
+// ReceiveNotification godoc
+// verifies the sns message, confirms the subscription of the endpoint and applies the ses notifications
+func (Service *Service) ReceiveNotification(message *mail.SNSMessage) error {
+	err := message.Verify()
+	if err != nil {
+		log.Errorf("error occurred during verifying sns message %s, error: %v\n", message.MessageID, err)
+		return errors.CreateError(403, "invalid_signature")
+	}
+
+	switch message.Type {
+	case mail.SNSTypeSubscriptionConfirmation:
+		log.Infof("Confirming sns subscription to %s", message.TopicArn)
+		err = message.ConfirmSubscription()
+		if err != nil {
+			log.Errorf("error occurred during confirming subscription to %s, error: %v\n", message.TopicArn, err)
+			return errors.CreateError(500, "confirm_subscription_error")
+		}
+	case mail.SNSTypeNotification:
+		err = mail.HandleSESNotification(message.Message)
+		if err != nil {
+			// a failure makes sns retry the notification
+			log.Errorf("error occurred during handling sns message %s, error: %v\n", message.MessageID, err)
+			return errors.CreateError(500, "handle_notification_error")
+		}
+	default:
+		log.Infof("Ignoring sns message of type %s", message.Type)
+	}
+
+	return nil
+}
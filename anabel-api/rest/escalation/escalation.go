@@ -0,0 +1,19 @@
+package escalation
+
+import (
+	policies "anacove.com/backend/escalation"
+)
+
+// maxPoliciesPerClient limits the escalation policies a client can have
+const maxPoliciesPerClient = 100
+
+// PolicyModel godoc
+// This is the escalation policy create and update request model definition,
+// an empty site applies to every site of the client and an empty priority to every priority
+type PolicyModel struct {
+	SiteID    string          `json:"siteId"`
+	AlertType string          `validate:"required" json:"alertType"`
+	Priority  string          `json:"priority"`
+	Tiers     []policies.Tier `validate:"required" json:"tiers"`
+	Enabled   *bool           `json:"enabled"`
+}
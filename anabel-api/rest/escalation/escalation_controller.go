@@ -0,0 +1,143 @@
+package escalation
+
+import (
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller type
+type Controller struct {
+}
+
+// AddRouters allows the endpoints defined in this controller to be added to router
+func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
+	ws.Route(ws.POST("/clients/{clientId}/escalation-policies").Filter(utils.BearerAuth).To(createPolicy))
+	ws.Route(ws.GET("/clients/{clientId}/escalation-policies").Filter(utils.BearerAuth).To(searchPolicies))
+	ws.Route(ws.GET("/clients/{clientId}/escalation-policies/{id}").Filter(utils.BearerAuth).To(getPolicy))
+	ws.Route(ws.PUT("/clients/{clientId}/escalation-policies/{id}").Filter(utils.BearerAuth).To(updatePolicy))
+	ws.Route(ws.DELETE("/clients/{clientId}/escalation-policies/{id}").Filter(utils.BearerAuth).To(deletePolicy))
+	return ws
+}
+
+// createPolicy adds an escalation policy to the client
+// and returns it if succeeds
+func createPolicy(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	if !authorize(req, resp, clientID) {
+		return
+	}
+
+	request := PolicyModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create escalation policy")
+	policy, err := GetService().CreatePolicy(clientID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, policy)
+}
+
+// searchPolicies lists the escalation policies of the client, optionally of one site
+func searchPolicies(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	if !authorize(req, resp, clientID) {
+		return
+	}
+
+	policies, err := GetService().SearchPolicies(clientID, req.QueryParameter("siteId"))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, policies)
+}
+
+// getPolicy find escalation policy by id
+// and returns it if succeeds
+func getPolicy(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	policy, err := GetService().GetPolicy(clientID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, policy)
+}
+
+// updatePolicy find escalation policy by id and replace its settings
+// and returns updated policy if succeeds
+func updatePolicy(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	request := PolicyModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update escalation policy")
+	policy, err := GetService().UpdatePolicy(clientID, id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, policy)
+}
+
+// deletePolicy find an escalation policy by id and delete it
+// and returns nothing if succeeds
+func deletePolicy(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	err := GetService().DeletePolicy(clientID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
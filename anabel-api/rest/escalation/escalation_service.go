@@ -0,0 +1,207 @@
+package escalation
+
+import (
+	"sync"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/errors"
+	policies "anacove.com/backend/escalation"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service godoc
+// defines the escalation policies of the clients
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for escalation service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// CreatePolicy godoc
+// adds an escalation policy to the client, it is enabled unless the request says otherwise.
+// A client has one policy per site, alert type and priority
+func (Service *Service) CreatePolicy(clientID string, model PolicyModel, currentUserID string) (*policies.Policy, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.EscalationPolicyCollection)
+
+	count, err := c.Find(bson.M{"clientId": clientID}).Count()
+	if err != nil {
+		log.Errorf("Error occured while counting escalation policies, error: %v", err)
+		return nil, errors.CreateError(500, "server_error")
+	}
+	if count >= maxPoliciesPerClient {
+		log.Errorf("Escalation policy limit for client %s reached", clientID)
+		return nil, errors.CreateError(400, "escalation policy limit reached")
+	}
+
+	now := time.Now().UTC()
+	policy := policies.Policy{
+		ID:        bson.NewObjectId(),
+		ClientID:  clientID,
+		Enabled:   true,
+		CreatedBy: currentUserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	model.ToPolicy(&policy)
+
+	err = validatePolicy(session, &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Insert(&policy)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		if mgo.IsDup(err) {
+			return nil, errors.CreateError(400, "policy_exists")
+		}
+		return nil, errors.CreateError(500, "create_policy_error")
+	}
+
+	return &policy, nil
+}
+
+// SearchPolicies godoc
+// lists the escalation policies of the client, of one site and the ones of every site when the site is given
+func (Service *Service) SearchPolicies(clientID string, siteID string) ([]policies.Policy, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.EscalationPolicyCollection)
+
+	query := bson.M{"clientId": clientID}
+	if len(siteID) > 0 {
+		query["siteId"] = bson.M{"$in": []string{siteID, ""}}
+	}
+
+	result := []policies.Policy{}
+	err := c.Find(query).Sort("siteId", "alertType", "priority").All(&result)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return result, nil
+}
+
+// GetPolicy godoc
+// Find the escalation policy of the client by id
+func (Service *Service) GetPolicy(clientID string, id string) (*policies.Policy, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.EscalationPolicyCollection)
+
+	return findPolicy(c, clientID, id)
+}
+
+// UpdatePolicy godoc
+// replaces the site, alert type, priority and tiers of an escalation policy,
+// alerts which already reached a tier are not notified about it again
+func (Service *Service) UpdatePolicy(clientID string, id string, model PolicyModel) (*policies.Policy, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.EscalationPolicyCollection)
+
+	policy, err := findPolicy(c, clientID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	model.ToPolicy(policy)
+	err = validatePolicy(session, policy)
+	if err != nil {
+		return nil, err
+	}
+	policy.UpdatedAt = time.Now().UTC()
+
+	err = c.UpdateId(policy.ID, policy)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		if mgo.IsDup(err) {
+			return nil, errors.CreateError(400, "policy_exists")
+		}
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return policy, nil
+}
+
+// DeletePolicy godoc
+// removes an escalation policy of the client, the escalations already recorded on the alerts stay
+func (Service *Service) DeletePolicy(clientID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.EscalationPolicyCollection)
+
+	policy, err := findPolicy(c, clientID, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.RemoveId(policy.ID)
+	if err != nil {
+		log.Errorf("Error occurred during delete, error: %v\n", err)
+		return errors.CreateError(500, "delete_error")
+	}
+
+	return nil
+}
+
+// validatePolicy checks the tiers of the policy and that its site belongs to its client
+func validatePolicy(session *mgo.Session, policy *policies.Policy) error {
+	err := policy.Validate()
+	if err != nil {
+		log.Infof("Invalid escalation policy, error: %v", err)
+		return errors.CreateErrorWithMsg(400, "invalid_policy", err.Error())
+	}
+
+	if len(policy.SiteID) == 0 {
+		return nil
+	}
+
+	count := 0
+	if bson.IsObjectIdHex(policy.SiteID) {
+		count, err = session.DB("").C(common.SiteCollection).Find(bson.M{"_id": bson.ObjectIdHex(policy.SiteID), "clientId": policy.ClientID}).Count()
+	}
+	if err != nil || count == 0 {
+		log.Infof("Site %s is not a site of client %s, error: %v", policy.SiteID, policy.ClientID, err)
+		return errors.CreateError(400, "invalid_site")
+	}
+
+	return nil
+}
+
+// findPolicy loads the escalation policy when it belongs to the client
+func findPolicy(c *mgo.Collection, clientID string, id string) (*policies.Policy, error) {
+	policy := policies.Policy{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "clientId": clientID}).One(&policy)
+	if err != nil {
+		log.Errorf("cannot find the escalation policy with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_policy_error")
+	}
+
+	return &policy, nil
+}
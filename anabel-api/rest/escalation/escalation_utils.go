@@ -0,0 +1,48 @@
+package escalation
+
+import (
+	"anacove.com/backend/errors"
+	policies "anacove.com/backend/escalation"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// ToPolicy will convert to Policy domain model from PolicyModel, a missing enabled keeps the policy as it is
+func (model *PolicyModel) ToPolicy(policy *policies.Policy) {
+	policy.SiteID = model.SiteID
+	policy.AlertType = model.AlertType
+	policy.Priority = model.Priority
+	policy.Tiers = model.Tiers
+	if model.Enabled != nil {
+		policy.Enabled = *model.Enabled
+	}
+}
+
+// authorize lets the admins of the client manage its escalation policies, it writes the error response otherwise
+func authorize(req *restful.Request, resp *restful.Response, clientID string, ids ...string) bool {
+	for _, id := range append([]string{clientID}, ids...) {
+		if !bson.IsObjectIdHex(id) {
+			log.Infof("invalid path id %s", id)
+			utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+			return false
+		}
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "client", clientID) {
+		log.Infof("User access forbidden for client id %s", clientID)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
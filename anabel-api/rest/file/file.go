@@ -0,0 +1,175 @@
+package file
+
+import (
+	"image"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/storage"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Rule godoc
+// defines the upload constraints of a file purpose
+type Rule struct {
+	Purpose      string
+	MaxSize      int64
+	ContentTypes []string
+	Prefix       string
+	Roles        []string
+	SiteRequired bool
+	// ProcessImage re-encodes uploaded images and generates their thumbnails
+	ProcessImage bool
+}
+
+// CreateUploadModel godoc
+// defines the request to start an upload session
+type CreateUploadModel struct {
+	Purpose     string `validate:"required" json:"purpose"`
+	ClientID    string `json:"clientId"`
+	SiteID      string `json:"siteId"`
+	FileName    string `validate:"required" json:"fileName"`
+	Size        int64  `validate:"required,min=1" json:"size"`
+	ContentType string `validate:"required" json:"contentType"`
+}
+
+// CompleteUploadModel godoc
+// defines the request to confirm an upload session, parts are required for multipart uploads
+type CompleteUploadModel struct {
+	Parts []storage.Part `json:"parts"`
+}
+
+// UploadSession godoc
+// keeps track of a direct to bucket upload until it is confirmed
+type UploadSession struct {
+	ID          bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	Key         string        `json:"key" bson:"key"`
+	UploadID    string        `json:"-" bson:"uploadId"`
+	Purpose     string        `json:"purpose" bson:"purpose"`
+	ClientID    string        `json:"clientId" bson:"clientId"`
+	SiteID      string        `json:"siteId" bson:"siteId"`
+	FileName    string        `json:"fileName" bson:"fileName"`
+	ContentType string        `json:"contentType" bson:"contentType"`
+	Size        int64         `json:"size" bson:"size"`
+	PartSize    int64         `json:"partSize" bson:"partSize"`
+	Status      string        `json:"status" bson:"status"`
+	CreatedBy   string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt   time.Time     `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time     `json:"expiresAt" bson:"expiresAt"`
+}
+
+// PartURL godoc
+// defines where the browser uploads one part of a multipart upload
+type PartURL struct {
+	PartNumber int    `json:"partNumber"`
+	URL        string `json:"url"`
+}
+
+// UploadSessionModel godoc
+// defines the response of a started upload session
+type UploadSessionModel struct {
+	ID        string            `json:"id"`
+	Key       string            `json:"key"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	PartSize  int64             `json:"partSize,omitempty"`
+	Parts     []PartURL         `json:"parts,omitempty"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// decodedImage keeps the decoded upload with the format it was stored in
+type decodedImage struct {
+	Image  image.Image
+	Format string
+}
+
+// DownloadModel godoc
+// defines the short lived download url of a file
+type DownloadModel struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const (
+	// UploadPending the session is started and waits for the browser
+	UploadPending = "pending"
+	// UploadCompleting the session is claimed by a request verifying the upload
+	UploadCompleting = "completing"
+	// UploadCompleted the object is verified and recorded
+	UploadCompleted = "completed"
+	// UploadAborted the session is cancelled or failed verification
+	UploadAborted = "aborted"
+)
+
+const (
+	// defaultPresignExpiry is used when storage.presign_expiry_in_minutes is not configured
+	defaultPresignExpiry = 15 * time.Minute
+	// defaultUploadExpiry is used when storage.upload_expiry_in_minutes is not configured
+	defaultUploadExpiry = 60 * time.Minute
+	// defaultCleanupInterval is used when storage.upload_cleanup_interval_in_minutes is not configured
+	defaultCleanupInterval = 10 * time.Minute
+	// completionTimeout is how long after its expiry a session can still be completing,
+	// the request verifying it stopped before recording the upload when it takes longer
+	completionTimeout = 30 * time.Minute
+	// cleanupBatchSize is the most expired sessions discarded in one run
+	cleanupBatchSize = 100
+	// multipartPartSize is the size of every part but the last one, files above it are uploaded in parts
+	multipartPartSize int64 = 8 << 20
+	// maxLocalUploadSize is the upper limit of a body sent to a presigned local url
+	maxLocalUploadSize int64 = 100 << 20
+	// defaultMaxPixels is used when image.max_pixels is not configured
+	defaultMaxPixels = 40000000
+	// defaultJPEGQuality is used when image.jpeg_quality is not configured
+	defaultJPEGQuality = 85
+)
+
+// defaultThumbnailSizes is used when image.thumbnail_sizes is not configured
+var defaultThumbnailSizes = []int{64, 256, 1024}
+
+// rules defines the upload rules by file purpose,
+// logos of new clients are uploaded before the client exists and claimed when it is created
+var rules = map[string]Rule{
+	common.FilePurposeLogo: Rule{
+		Purpose:      common.FilePurposeLogo,
+		MaxSize:      2 << 20,
+		ContentTypes: []string{"image/png", "image/jpeg", "image/gif"},
+		Prefix:       "logos",
+		Roles:        []string{"SA", "AM", "CSA", "GA"},
+		ProcessImage: true,
+	},
+	common.FilePurposeFloorPlan: Rule{
+		Purpose:      common.FilePurposeFloorPlan,
+		MaxSize:      100 << 20,
+		ContentTypes: []string{"image/png", "image/jpeg", "application/pdf"},
+		Prefix:       "floor-plans",
+		Roles:        []string{"SA", "AM", "CSA", "GA", "SM"},
+		SiteRequired: true,
+		ProcessImage: true,
+	},
+	common.FilePurposeProfilePicture: Rule{
+		Purpose:      common.FilePurposeProfilePicture,
+		MaxSize:      2 << 20,
+		ContentTypes: []string{"image/png", "image/jpeg", "image/gif"},
+		Prefix:       "profiles",
+		ProcessImage: true,
+	},
+	common.FilePurposeTVTheftAudio: Rule{
+		Purpose:      common.FilePurposeTVTheftAudio,
+		MaxSize:      50 << 20,
+		ContentTypes: []string{"audio/mpeg", "audio/wave", "audio/aiff", "application/ogg"},
+		Prefix:       "tv-theft-audio",
+		Roles:        []string{"SA", "AM", "CSA", "GA", "SM"},
+		SiteRequired: true,
+	},
+	common.FilePurposeAlertPhoto: Rule{
+		Purpose:      common.FilePurposeAlertPhoto,
+		MaxSize:      10 << 20,
+		ContentTypes: []string{"image/png", "image/jpeg"},
+		Prefix:       "alert-photos",
+		Roles:        []string{"SA", "AM", "CSA", "GA", "SM", "SU"},
+		SiteRequired: true,
+		ProcessImage: true,
+	},
+}
@@ -1,9 +1,16 @@
 package file
 
 import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
 	"anacove.com/backend/errors"
+	"anacove.com/backend/storage"
 	"anacove.com/backend/utils"
 	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -13,27 +20,214 @@ type Controller struct {
 
 // AddRouters allows the endpoints defined in this controller to be added to router
 func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
-	ws.Route(ws.POST("/files").To(upload))
+	ws.Route(ws.POST("/files/uploads").Filter(utils.BearerAuth).To(createUpload))
+	ws.Route(ws.POST("/files/uploads/{id}/complete").Filter(utils.BearerAuth).To(completeUpload))
+	ws.Route(ws.DELETE("/files/uploads/{id}").Filter(utils.BearerAuth).To(abortUpload))
+	ws.Route(ws.GET("/files/{name:*}").Filter(utils.BearerAuth).To(getFile))
+
+	// objects on local disk are not reachable by the browser, serve them through the api
+	if storage.IsLocal() {
+		ws.Route(ws.GET(storage.LocalRoutePath + "/{name:*}").Filter(signedURLAuth).To(download))
+		ws.Route(ws.PUT(storage.LocalRoutePath + "/{name:*}").Consumes("*/*").To(localUpload))
+	}
 	return ws
 }
 
-// upload godoc
-// read multiple files and store to aws s3
-func upload(req *restful.Request, resp *restful.Response) {
-	response := []interface{}{}
-	r := req.Request
-	r.ParseMultipartForm(32 << 20)
-	fhs := r.MultipartForm.File["myfiles"]
-	for _, fh := range fhs {
-		file, _ := fh.Open()
-		res, err := utils.AddFileToS3(file, *fh)
+// createUpload godoc
+// validates the file against the purpose rule and returns the presigned urls
+// the browser uploads the file to, the api never receives the file bytes
+func createUpload(req *restful.Request, resp *restful.Response) {
+	model := CreateUploadModel{}
+	err := req.ReadEntity(&model)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	rule, err := ValidateUploadModel(&model)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	err = CheckUploadAccess(req, rule, &model)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
 
+	err = GetService().ResolveOwner(&model)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	res, err := GetService().CreateUploadSession(model, rule, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// completeUpload godoc
+// confirms the browser finished uploading, verifies the object and records it
+func completeUpload(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("invalid id property: id: %s\n", id)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	model := CompleteUploadModel{}
+	if req.Request.ContentLength != 0 {
+		err := req.ReadEntity(&model)
 		if err != nil {
-			log.Errorf("error occurred during file upload: error: %v\n", err)
-			utils.WriteError(resp, errors.CreateError(500, err.Error()))
+			log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+			utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+			return
 		}
+	}
+
+	res, err := GetService().CompleteUploadSession(id, model, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// abortUpload godoc
+// cancels an upload session and discards whatever was uploaded
+func abortUpload(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("invalid id property: id: %s\n", id)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	err := GetService().AbortUploadSession(id, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// getFile godoc
+// checks the access to the owning client or site and returns a short lived url of the file,
+// with redirect=true the client is redirected to that url
+func getFile(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	if len(strings.TrimSpace(name)) == 0 {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
 
-		response = append(response, res)
+	record, err := GetService().GetFile(name)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
 	}
-	resp.WriteEntity(response)
+
+	//Check weather user has permission to the owning resource
+	if !CanAccessFile(req, record) {
+		log.Infof("User access forbidden for file %s", name)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return
+	}
+
+	res, err := GetService().Presign(record, name)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	if req.QueryParameter("redirect") == "true" {
+		http.Redirect(resp.ResponseWriter, req.Request, res.URL, http.StatusTemporaryRedirect)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// signedURLAuth lets presigned local urls through and falls back to bearer authorization
+func signedURLAuth(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	signature := req.QueryParameter("signature")
+	if len(signature) > 0 && storage.VerifySignature(http.MethodGet, req.PathParameter("name"), req.Request.URL.Query()) {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+
+	utils.BearerAuth(req, resp, chain)
+}
+
+// download godoc
+// streams a locally stored object to the client
+func download(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	if len(strings.TrimSpace(name)) == 0 {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	record, err := GetService().GetFile(name)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	// bearer authorized requests are checked against the owner, presigned ones were checked on signing
+	if len(utils.GetUserID(req)) > 0 && !CanAccessFile(req, record) {
+		log.Infof("User access forbidden for file %s", name)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return
+	}
+
+	reader, object, err := GetService().Open(record, name)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+	defer reader.Close()
+
+	resp.AddHeader("Content-Type", object.ContentType)
+	resp.AddHeader("Content-Length", strconv.FormatInt(object.Size, 10))
+	resp.WriteHeader(200)
+	_, err = io.Copy(resp, reader)
+	if err != nil {
+		log.Errorf("error occurred during streaming object %s, error: %v\n", name, err)
+	}
+}
+
+// localUpload godoc
+// receives the body sent to a presigned local upload url, this plays the role of the bucket
+// so the upload is authorized by the signature only
+func localUpload(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	query := req.Request.URL.Query()
+	if !storage.VerifySignature(http.MethodPut, name, query) {
+		log.Infof("Invalid signature for upload of %s", name)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return
+	}
+
+	body := http.MaxBytesReader(resp.ResponseWriter, req.Request.Body, maxLocalUploadSize)
+	etag, err := storage.WriteLocal(name, query, body)
+	if err != nil {
+		log.Errorf("error occurred during writing object %s, error: %v\n", name, err)
+		utils.WriteError(resp, errors.CreateError(400, "upload_error"))
+		return
+	}
+
+	resp.AddHeader("ETag", etag)
+	resp.WriteHeader(200)
 }
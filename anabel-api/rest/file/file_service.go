@@ -0,0 +1,569 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/storage"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service godoc
+// defines all the file related operations
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for file service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// Init creates the index the cleanup of the expired upload sessions runs on
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.UploadSessionCollection).EnsureIndex(mgo.Index{Key: []string{"status", "expiresAt"}})
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create upload session indexes, error: %v", err)
+	}
+}
+
+// Start discards the expired upload sessions and their uploaded parts in the background,
+// every instance of the api can run it as the sessions are claimed atomically
+func Start() {
+	interval := defaultCleanupInterval
+	if minutes := config.GetConfig().GetInt("storage.upload_cleanup_interval_in_minutes"); minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cleanupUploads(time.Now().UTC())
+		}
+	}()
+}
+
+// cleanupUploads discards the pending sessions which expired and the completions which stopped before recording the upload
+func cleanupUploads(now time.Time) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UploadSessionCollection)
+
+	uploads := []UploadSession{}
+	err := c.Find(bson.M{"$or": []bson.M{
+		{"status": UploadPending, "expiresAt": bson.M{"$lt": now}},
+		{"status": UploadCompleting, "expiresAt": bson.M{"$lt": now.Add(-completionTimeout)}},
+	}}).Limit(cleanupBatchSize).All(&uploads)
+	if err != nil {
+		log.Errorf("Failed to find the expired upload sessions, error: %v", err)
+		return
+	}
+
+	for i := range uploads {
+		upload := &uploads[i]
+		// another instance or the browser may have taken the session meanwhile
+		err = c.Update(bson.M{"_id": upload.ID, "status": upload.Status}, bson.M{"$set": bson.M{"status": UploadAborted}})
+		if err != nil {
+			if err != mgo.ErrNotFound {
+				log.Errorf("Failed to abort the expired upload session %s, error: %v", upload.ID.Hex(), err)
+			}
+			continue
+		}
+
+		removeUpload(upload)
+		log.Infof("Discarded the expired upload session %s", upload.ID.Hex())
+	}
+}
+
+// ResolveOwner godoc
+// fills the client of a site owned upload and checks the site belongs to the given client
+func (Service *Service) ResolveOwner(model *CreateUploadModel) error {
+	if len(model.SiteID) == 0 {
+		return nil
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SiteCollection)
+
+	site := struct {
+		ClientID string `bson:"clientId"`
+	}{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(model.SiteID)}).One(&site)
+	if err != nil {
+		log.Errorf("cannot find the site with id: %s, error: %v\n", model.SiteID, err)
+		if err == mgo.ErrNotFound {
+			return errors.CreateError(400, "invalid_site")
+		}
+		return errors.CreateError(500, "get_site_error")
+	}
+
+	if len(model.ClientID) > 0 && model.ClientID != site.ClientID {
+		log.Infof("Site %s does not belong to client %s", model.SiteID, model.ClientID)
+		return errors.CreateError(400, "invalid_site")
+	}
+
+	model.ClientID = site.ClientID
+
+	return nil
+}
+
+// CreateUploadSession godoc
+// reserves an object key and hands out presigned urls the browser uploads the file to,
+// files larger than one part are uploaded in parts
+func (Service *Service) CreateUploadSession(model CreateUploadModel, rule *Rule, userID string) (*UploadSessionModel, error) {
+	expiry := getUploadExpiry()
+	upload := UploadSession{
+		ID:          bson.NewObjectId(),
+		Key:         buildObjectKey(rule.Prefix, model.FileName),
+		Purpose:     rule.Purpose,
+		ClientID:    model.ClientID,
+		SiteID:      model.SiteID,
+		FileName:    model.FileName,
+		ContentType: model.ContentType,
+		Size:        model.Size,
+		Status:      UploadPending,
+		CreatedBy:   userID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	upload.ExpiresAt = upload.CreatedAt.Add(expiry)
+
+	response := UploadSessionModel{
+		ID:        upload.ID.Hex(),
+		Key:       upload.Key,
+		Method:    http.MethodPut,
+		ExpiresAt: upload.ExpiresAt,
+	}
+
+	if model.Size > multipartPartSize {
+		uploadID, err := storage.GetStorage().CreateMultipartUpload(upload.Key, model.ContentType)
+		if err != nil {
+			log.Errorf("error occurred during creating multipart upload, error: %v\n", err)
+			return nil, errors.CreateError(500, "create_upload_error")
+		}
+		upload.UploadID = uploadID
+		upload.PartSize = multipartPartSize
+
+		count := int((model.Size + multipartPartSize - 1) / multipartPartSize)
+		for partNumber := 1; partNumber <= count; partNumber++ {
+			url, err := storage.GetStorage().PresignPart(upload.Key, uploadID, partNumber, expiry)
+			if err != nil {
+				log.Errorf("error occurred during presigning part %d, error: %v\n", partNumber, err)
+				return nil, errors.CreateError(500, "create_upload_error")
+			}
+			response.Parts = append(response.Parts, PartURL{PartNumber: partNumber, URL: url})
+		}
+		response.PartSize = multipartPartSize
+	} else {
+		url, err := storage.GetStorage().PresignPut(upload.Key, model.ContentType, expiry)
+		if err != nil {
+			log.Errorf("error occurred during presigning upload, error: %v\n", err)
+			return nil, errors.CreateError(500, "create_upload_error")
+		}
+		response.URL = url
+		response.Headers = map[string]string{"Content-Type": model.ContentType}
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UploadSessionCollection)
+
+	err := c.Insert(&upload)
+	if err != nil {
+		log.Errorf("error occurred during insert upload session, error: %v\n", err)
+		return nil, errors.CreateError(500, "create_upload_error")
+	}
+
+	return &response, nil
+}
+
+// getPendingUpload finds the pending upload session started by the user
+func getPendingUpload(c *mgo.Collection, id string, userID string) (*UploadSession, error) {
+	upload := UploadSession{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "status": UploadPending}).One(&upload)
+	if err != nil {
+		log.Errorf("cannot find the upload session with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_upload_error")
+	}
+
+	if upload.CreatedBy != userID {
+		log.Infof("Upload session %s was not started by user %s", id, userID)
+		return nil, errors.CreateError(403, "Forbidden")
+	}
+
+	return &upload, nil
+}
+
+// discardUpload removes whatever was uploaded for the session and marks it aborted
+func discardUpload(c *mgo.Collection, upload *UploadSession) {
+	removeUpload(upload)
+
+	err := c.UpdateId(upload.ID, bson.M{"$set": bson.M{"status": UploadAborted}})
+	if err != nil {
+		log.Errorf("error occurred during update upload session, error: %v\n", err)
+	}
+}
+
+// removeUpload removes the uploaded parts and object of the session from the storage
+func removeUpload(upload *UploadSession) {
+	var err error
+	if len(upload.UploadID) > 0 {
+		err = storage.GetStorage().AbortMultipartUpload(upload.Key, upload.UploadID)
+	}
+	if _, statErr := storage.GetStorage().Stat(upload.Key); statErr == nil {
+		err = storage.GetStorage().Delete(upload.Key)
+	}
+	if err != nil {
+		log.Errorf("error occurred during discarding upload %s, error: %v\n", upload.Key, err)
+	}
+}
+
+// releaseUpload makes a session which failed to complete pending again so the browser can retry,
+// sessions discarded meanwhile stay aborted
+func releaseUpload(c *mgo.Collection, upload *UploadSession) {
+	err := c.Update(bson.M{"_id": upload.ID, "status": UploadCompleting}, bson.M{"$set": bson.M{"status": UploadPending}})
+	if err != nil && err != mgo.ErrNotFound {
+		log.Errorf("error occurred during update upload session, error: %v\n", err)
+	}
+}
+
+// CompleteUploadSession godoc
+// assembles the uploaded parts, verifies the stored object against the rule and records it.
+// The session is claimed first, a concurrent completion of the same session fails with upload_completing
+func (Service *Service) CompleteUploadSession(id string, model CompleteUploadModel, userID string) (*common.File, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UploadSessionCollection)
+	fileCollection := session.DB("").C(common.FileCollection)
+
+	upload, err := getPendingUpload(c, id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.ExpiresAt.Before(time.Now().UTC()) {
+		log.Infof("Upload session %s expired", id)
+		discardUpload(c, upload)
+		return nil, errors.CreateError(400, "upload_expired")
+	}
+
+	err = c.Update(bson.M{"_id": upload.ID, "status": UploadPending}, bson.M{"$set": bson.M{"status": UploadCompleting}})
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			log.Infof("Upload session %s is completed by another request", id)
+			return nil, errors.CreateError(409, "upload_completing")
+		}
+		log.Errorf("error occurred during update upload session, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_upload_error")
+	}
+
+	record, err := Service.completeUpload(c, fileCollection, upload, model, userID)
+	if err != nil {
+		releaseUpload(c, upload)
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// completeUpload verifies and records the upload of a claimed session
+func (Service *Service) completeUpload(c *mgo.Collection, fileCollection *mgo.Collection, upload *UploadSession, model CompleteUploadModel, userID string) (*common.File, error) {
+	id := upload.ID.Hex()
+	if len(upload.UploadID) > 0 {
+		count := int((upload.Size + upload.PartSize - 1) / upload.PartSize)
+		if len(model.Parts) != count {
+			log.Infof("Upload session %s expects %d parts, got %d", id, count, len(model.Parts))
+			return nil, errors.CreateError(400, "invalid_parts")
+		}
+
+		sort.Slice(model.Parts, func(i, j int) bool { return model.Parts[i].PartNumber < model.Parts[j].PartNumber })
+		for i, part := range model.Parts {
+			if part.PartNumber != i+1 || len(part.ETag) == 0 {
+				log.Infof("Upload session %s has invalid part %d", id, part.PartNumber)
+				return nil, errors.CreateError(400, "invalid_parts")
+			}
+		}
+
+		err := storage.GetStorage().CompleteMultipartUpload(upload.Key, upload.UploadID, model.Parts)
+		if err != nil {
+			log.Errorf("error occurred during completing multipart upload, error: %v\n", err)
+			return nil, errors.CreateError(400, "upload_incomplete")
+		}
+	}
+
+	object, err := storage.GetStorage().Stat(upload.Key)
+	if err != nil {
+		log.Errorf("cannot find the uploaded object %s, error: %v\n", upload.Key, err)
+		return nil, errors.CreateError(400, "upload_incomplete")
+	}
+
+	if object.Size != upload.Size {
+		log.Infof("Uploaded object %s has size %d instead of %d", upload.Key, object.Size, upload.Size)
+		discardUpload(c, upload)
+		return nil, errors.CreateError(400, "size_mismatch")
+	}
+
+	// the declared content type is not trusted, sniff the stored bytes
+	reader, _, err := storage.GetStorage().Get(upload.Key)
+	if err != nil {
+		log.Errorf("error occurred during opening object %s, error: %v\n", upload.Key, err)
+		return nil, errors.CreateError(500, "complete_upload_error")
+	}
+	contentType, err := sniffContentType(reader)
+	reader.Close()
+	if err != nil {
+		log.Errorf("error occurred during reading object %s, error: %v\n", upload.Key, err)
+		return nil, errors.CreateError(500, "complete_upload_error")
+	}
+
+	rule, _ := GetRule(upload.Purpose)
+	if !rule.Allows(contentType) {
+		log.Infof("Uploaded object %s has unsupported type %s", upload.Key, contentType)
+		discardUpload(c, upload)
+		return nil, errors.CreateErrorWithMsg(400, "unsupported_file_type", upload.FileName)
+	}
+
+	if rule.ProcessImage && strings.HasPrefix(contentType, "image/") {
+		img, err := decodeUpload(upload.Key)
+		if err != nil {
+			log.Infof("Uploaded object %s is not a valid image, error: %v", upload.Key, err)
+			discardUpload(c, upload)
+			return nil, errors.CreateErrorWithMsg(400, "invalid_image", upload.FileName)
+		}
+
+		processed, err := processImage(upload.Key, img)
+		if err != nil {
+			log.Errorf("error occurred during processing image %s, error: %v\n", upload.Key, err)
+			return nil, errors.CreateError(500, "process_image_error")
+		}
+		processed.ID = bson.NewObjectId()
+		processed.OriginalName = upload.FileName
+		processed.Purpose = upload.Purpose
+		processed.UploadedBy = userID
+		processed.ClientID = upload.ClientID
+		processed.SiteID = upload.SiteID
+		processed.CreatedAt = time.Now().UTC()
+
+		return Service.recordUpload(c, fileCollection, upload, processed)
+	}
+
+	record := common.File{
+		ID:           bson.NewObjectId(),
+		Name:         upload.Key,
+		OriginalName: upload.FileName,
+		URL:          storage.GetStorage().URL(upload.Key),
+		Purpose:      upload.Purpose,
+		ContentType:  contentType,
+		Size:         object.Size,
+		UploadedBy:   userID,
+		ClientID:     upload.ClientID,
+		SiteID:       upload.SiteID,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	return Service.recordUpload(c, fileCollection, upload, &record)
+}
+
+// recordUpload inserts the file record and marks the claimed upload session completed,
+// the record is removed again when the session was discarded meanwhile
+func (Service *Service) recordUpload(c *mgo.Collection, fileCollection *mgo.Collection, upload *UploadSession, record *common.File) (*common.File, error) {
+	err := fileCollection.Insert(record)
+	if err != nil {
+		log.Errorf("error occurred during insert file record, error: %v\n", err)
+		return nil, errors.CreateError(500, "create_file_error")
+	}
+
+	err = c.Update(bson.M{"_id": upload.ID, "status": UploadCompleting}, bson.M{"$set": bson.M{"status": UploadCompleted}})
+	if err != nil {
+		log.Errorf("error occurred during update upload session, error: %v\n", err)
+		if removeErr := fileCollection.RemoveId(record.ID); removeErr != nil {
+			log.Errorf("error occurred during remove file record, error: %v\n", removeErr)
+		}
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(400, "upload_expired")
+		}
+		return nil, errors.CreateError(500, "update_upload_error")
+	}
+
+	return record, nil
+}
+
+// decodeUpload streams the uploaded object into the decoder, objects above the configured pixel limit
+// are rejected from their header before anything else is read
+func decodeUpload(key string) (*decodedImage, error) {
+	reader, _, err := storage.GetStorage().Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	img, format, err := utils.DecodeImage(reader, getMaxPixels())
+	if err != nil {
+		return nil, err
+	}
+
+	return &decodedImage{Image: img, Format: format}, nil
+}
+
+// processImage re-encodes the original, dropping metadata like EXIF,
+// and stores a thumbnail next to it for every configured size smaller than the image.
+// Everything but jpeg is re-encoded as png, the original moves to a .png key when its extension differs
+func processImage(uploadKey string, decoded *decodedImage) (*common.File, error) {
+	quality := getJPEGQuality()
+	data, contentType, err := utils.EncodeImage(decoded.Image, decoded.Format, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	key := encodedKey(uploadKey, contentType)
+	err = storage.GetStorage().Put(key, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		return nil, err
+	}
+	if key != uploadKey {
+		if err := storage.GetStorage().Delete(uploadKey); err != nil {
+			log.Errorf("error occurred during deleting the uploaded object %s, error: %v\n", uploadKey, err)
+		}
+	}
+
+	bounds := decoded.Image.Bounds()
+	record := common.File{
+		Name:        key,
+		URL:         storage.GetStorage().URL(key),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+	}
+
+	sizes := append([]int{}, getThumbnailSizes()...)
+	sort.Ints(sizes)
+	for _, size := range sizes {
+		if size <= 0 || (size >= record.Width && size >= record.Height) {
+			continue
+		}
+
+		thumbnail := utils.ResizeImage(decoded.Image, size)
+		data, _, err := utils.EncodeImage(thumbnail, decoded.Format, quality)
+		if err != nil {
+			return nil, err
+		}
+
+		name := variantKey(key, size, contentType)
+		err = storage.GetStorage().Put(name, bytes.NewReader(data), int64(len(data)), contentType)
+		if err != nil {
+			return nil, err
+		}
+
+		record.Variants = append(record.Variants, common.ImageVariant{
+			Size:   size,
+			Name:   name,
+			URL:    storage.GetStorage().URL(name),
+			Width:  thumbnail.Bounds().Dx(),
+			Height: thumbnail.Bounds().Dy(),
+		})
+	}
+
+	return &record, nil
+}
+
+// AbortUploadSession godoc
+// cancels a pending upload session and discards the uploaded parts
+func (Service *Service) AbortUploadSession(id string, userID string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UploadSessionCollection)
+
+	upload, err := getPendingUpload(c, id, userID)
+	if err != nil {
+		return err
+	}
+
+	discardUpload(c, upload)
+
+	return nil
+}
+
+// GetFile godoc
+// Find the file record by object name, thumbnails resolve to the record of their original
+func (Service *Service) GetFile(name string) (*common.File, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.FileCollection)
+
+	record := common.File{}
+	err := c.Find(bson.M{"$or": []bson.M{{"name": name}, {"variants.name": name}}}).One(&record)
+	if err != nil {
+		log.Errorf("cannot find the file with name: %s, error: %v\n", name, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_file_error")
+	}
+
+	return &record, nil
+}
+
+// Presign godoc
+// creates a short lived url to read the private object, the original or one of its thumbnails
+func (Service *Service) Presign(record *common.File, name string) (*DownloadModel, error) {
+	expiry := getPresignExpiry()
+	url, err := storage.GetStorage().Presign(name, expiry)
+	if err != nil {
+		log.Errorf("error occurred during presigning object %s, error: %v\n", name, err)
+		return nil, errors.CreateError(500, "presign_file_error")
+	}
+
+	return &DownloadModel{
+		Name:      name,
+		URL:       url,
+		ExpiresAt: time.Now().UTC().Add(expiry),
+	}, nil
+}
+
+// Open godoc
+// opens a stored object, the original or one of its thumbnails, with the content type of its file record
+func (Service *Service) Open(record *common.File, name string) (io.ReadCloser, *storage.Object, error) {
+	reader, object, err := storage.GetStorage().Get(name)
+	if err != nil {
+		log.Errorf("error occurred during opening object %s, error: %v\n", name, err)
+		if os.IsNotExist(err) {
+			return nil, nil, errors.CreateError(404, "not_found")
+		}
+		return nil, nil, errors.CreateError(500, "get_file_error")
+	}
+
+	object.ContentType = record.ContentType
+
+	return reader, object, nil
+}
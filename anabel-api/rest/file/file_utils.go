@@ -0,0 +1,221 @@
+package file
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// GetRule returns the upload rule of the given purpose
+func GetRule(purpose string) (Rule, bool) {
+	rule, ok := rules[purpose]
+	return rule, ok
+}
+
+// Allows checks weather the content type is allowed by the rule
+func (rule *Rule) Allows(contentType string) bool {
+	// DetectContentType may append parameters like charset
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return utils.Contains(rule.ContentTypes, contentType)
+}
+
+// ValidateUploadModel validates the upload request against the rule of its purpose
+func ValidateUploadModel(model *CreateUploadModel) (*Rule, error) {
+	err := utils.GetValidator().Struct(model)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		return nil, errors.CreateError(400, "invalid_request_data")
+	}
+
+	rule, ok := GetRule(model.Purpose)
+	if !ok {
+		log.Infof("Invalid file purpose %s", model.Purpose)
+		return nil, errors.CreateError(400, "invalid_purpose")
+	}
+
+	if len(model.ClientID) > 0 && !bson.IsObjectIdHex(model.ClientID) {
+		log.Infof("Invalid client id %s", model.ClientID)
+		return nil, errors.CreateError(400, "invalid_data")
+	}
+
+	if len(model.SiteID) > 0 && !bson.IsObjectIdHex(model.SiteID) {
+		log.Infof("Invalid site id %s", model.SiteID)
+		return nil, errors.CreateError(400, "invalid_data")
+	}
+
+	if rule.SiteRequired && len(model.SiteID) == 0 {
+		log.Infof("Site id is required for purpose %s", model.Purpose)
+		return nil, errors.CreateError(400, "invalid_data")
+	}
+
+	if model.Size > rule.MaxSize {
+		log.Infof("File %s exceeds the size limit of %s", model.FileName, model.Purpose)
+		return nil, errors.CreateErrorWithMsg(400, "file_too_large", model.FileName)
+	}
+
+	if !rule.Allows(model.ContentType) {
+		log.Infof("File %s has unsupported type %s", model.FileName, model.ContentType)
+		return nil, errors.CreateErrorWithMsg(400, "unsupported_file_type", model.FileName)
+	}
+
+	return &rule, nil
+}
+
+// CheckUploadAccess checks weather the current user can upload files for the owner of the request,
+// profile pictures are always owned by the current user
+func CheckUploadAccess(req *restful.Request, rule *Rule, model *CreateUploadModel) error {
+	//Check weather user has permission to upload files of this purpose
+	if len(rule.Roles) > 0 && !utils.HasRole(req, rule.Roles...) {
+		log.Infof("User not authorized")
+		return errors.CreateError(401, "Not Authorized")
+	}
+
+	claims := utils.GetClaims(req)
+	if rule.Purpose == common.FilePurposeProfilePicture {
+		model.ClientID = claims.ClientID
+		model.SiteID = claims.SiteID
+	} else if len(model.SiteID) > 0 {
+		if !utils.CanAccessResource(req, common.ReousrceSite, model.SiteID) {
+			log.Infof("User access forbidden for site id %s", model.SiteID)
+			return errors.CreateError(403, "Forbidden")
+		}
+	} else if len(model.ClientID) > 0 {
+		if !utils.CanAccessResource(req, common.ReousrceClient, model.ClientID) {
+			log.Infof("User access forbidden for client id %s", model.ClientID)
+			return errors.CreateError(403, "Forbidden")
+		}
+	}
+
+	return nil
+}
+
+// sniffContentType detects the content type from the first bytes of the content
+func sniffContentType(reader io.Reader) (string, error) {
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(reader, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return http.DetectContentType(buffer[:n]), nil
+}
+
+// buildObjectKey creates a unique object key under the prefix of the rule
+func buildObjectKey(prefix string, fileName string) string {
+	name := unsafeNameChars.ReplaceAllString(path.Base(fileName), "_")
+	return path.Join(prefix, uuid.New().String()+"-"+name)
+}
+
+// getPresignExpiry returns the configured validity period of download urls
+func getPresignExpiry() time.Duration {
+	minutes := config.GetConfig().GetInt("storage.presign_expiry_in_minutes")
+	if minutes <= 0 {
+		return defaultPresignExpiry
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// getUploadExpiry returns the configured validity period of upload sessions
+func getUploadExpiry() time.Duration {
+	minutes := config.GetConfig().GetInt("storage.upload_expiry_in_minutes")
+	if minutes <= 0 {
+		return defaultUploadExpiry
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// getThumbnailSizes returns the configured longest sides of image thumbnails
+func getThumbnailSizes() []int {
+	sizes := config.GetConfig().GetIntSlice("image.thumbnail_sizes")
+	if len(sizes) == 0 {
+		return defaultThumbnailSizes
+	}
+
+	return sizes
+}
+
+// getMaxPixels returns the configured largest accepted image
+func getMaxPixels() int {
+	pixels := config.GetConfig().GetInt("image.max_pixels")
+	if pixels <= 0 {
+		return defaultMaxPixels
+	}
+
+	return pixels
+}
+
+// getJPEGQuality returns the configured quality of re-encoded jpeg images
+func getJPEGQuality() int {
+	quality := config.GetConfig().GetInt("image.jpeg_quality")
+	if quality <= 0 || quality > 100 {
+		return defaultJPEGQuality
+	}
+
+	return quality
+}
+
+// imageExt returns the extension of an image re-encoded with the content type
+func imageExt(contentType string) string {
+	if contentType == "image/jpeg" {
+		return ".jpg"
+	}
+
+	return ".png"
+}
+
+// encodedKey names the re-encoded original, its extension follows the content type
+// so a gif re-encoded as png is stored with a .png key
+func encodedKey(key string, contentType string) string {
+	ext := path.Ext(key)
+	if strings.EqualFold(ext, imageExt(contentType)) || (contentType == "image/jpeg" && strings.EqualFold(ext, ".jpeg")) {
+		return key
+	}
+
+	return strings.TrimSuffix(key, ext) + imageExt(contentType)
+}
+
+// variantKey names the thumbnail next to the original object
+func variantKey(key string, size int, contentType string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "-" + strconv.Itoa(size) + imageExt(contentType)
+}
+
+// CanAccessFile checks weather the current user can read the file of the client or site owning it
+func CanAccessFile(req *restful.Request, record *common.File) bool {
+	claims := utils.GetClaims(req)
+	if record.UploadedBy == claims.ID {
+		return true
+	}
+
+	// profile pictures are visible to whoever can see the user
+	if record.Purpose == common.FilePurposeProfilePicture {
+		return bson.IsObjectIdHex(record.UploadedBy) && utils.CanAccessResource(req, common.ReousrceUser, record.UploadedBy)
+	}
+
+	if len(record.SiteID) > 0 && utils.CanAccessResource(req, common.ReousrceSite, record.SiteID) {
+		return true
+	}
+
+	if len(record.ClientID) > 0 && utils.CanAccessResource(req, common.ReousrceClient, record.ClientID) {
+		return true
+	}
+
+	// every user of a client can see its branding
+	return record.Purpose == common.FilePurposeLogo && len(record.ClientID) > 0 && record.ClientID == claims.ClientID
+}
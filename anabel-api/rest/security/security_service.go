@@ -9,6 +9,7 @@ import (
 	"anacove.com/backend/common"
 	"anacove.com/backend/config"
 	"anacove.com/backend/errors"
+	"anacove.com/backend/mail"
 	"anacove.com/backend/utils"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/globalsign/mgo"
@@ -193,6 +194,13 @@ func (Service *Service) ForgotPassword(email string) error {
 		return errors.CreateError(500, "user_find_error")
 	}
 
+	// the activation code of a pending invitation is still in the invitation link,
+	// the user activates the account through it or asks for a new invitation
+	if user.Invitation != nil && user.Invitation.Status == common.InvitationPending {
+		log.Infof("User %s has a pending invitation", user.ID.Hex())
+		return errors.CreateError(400, "invitation_pending")
+	}
+
 	activationCode := uuid.New().String()
 	err = c.Update(bson.M{"email": email}, bson.M{"$set": bson.M{
 		"activationCode": activationCode, "updatedAt": time.Now().UTC()}})
@@ -202,7 +210,20 @@ func (Service *Service) ForgotPassword(email string) error {
 		return errors.CreateErrorWithMsg(500, "update_user_error", err.Error())
 	}
 
-	utils.SendMailViaSES(user.Email, activationCode)
+	_, err = mail.Queue(&mail.Email{
+		Template: mail.TemplatePasswordReset,
+		Locale:   user.Locale,
+		To:       []string{user.Email},
+		Brand:    mail.GetBrand(user.ClientID),
+		Data: map[string]interface{}{
+			"FirstName": user.FirstName,
+			"URL":       config.GetConfig().GetString("app.forntend_url") + activationCode,
+		},
+	}, "user:"+user.ID.Hex())
+	if err != nil {
+		log.Errorf("error occurred during sending email to %s, error: %v\n", user.Email, err)
+		return errors.CreateError(500, "send_email_error")
+	}
 
 	return nil
 }
@@ -245,6 +266,8 @@ func (Service *Service) ChangePassword(oldPwd string, newPwd string, userID stri
 		return errors.CreateErrorWithMsg(500, "update_user_error", err.Error())
 	}
 
+	sendPasswordChanged(user)
+
 	return nil
 }
 
@@ -277,6 +300,25 @@ func (Service *Service) ConfirmUser(model UserConfirmationModel) error {
 		return errors.CreateError(400, "invalid_data")
 	}
 
+	// an invited user accepts the invitation by activating the account
+	if user.Invitation != nil && user.Invitation.Status != common.InvitationAccepted {
+		switch utils.InvitationStatus(user.Invitation) {
+		case common.InvitationExpired:
+			log.Infof("Invitation of user %s expired", user.ID.Hex())
+			return errors.CreateError(400, "invitation_expired")
+		case common.InvitationRevoked:
+			log.Infof("Invitation of user %s was revoked", user.ID.Hex())
+			return errors.CreateError(400, "invitation_revoked")
+		}
+
+		user.Invitation.Status = common.InvitationAccepted
+		user.Invitation.AcceptedAt = time.Now().UTC()
+		user.Status = common.Active
+	}
+
+	// a user with a password confirms a password reset
+	isReset := len(user.Password) > 0
+
 	user = model.ToUser(user)
 	user.UpdatedAt = time.Now().UTC()
 	user.Password, err = hashAndSalt(model.Password)
@@ -293,9 +335,31 @@ func (Service *Service) ConfirmUser(model UserConfirmationModel) error {
 		return errors.CreateErrorWithMsg(500, "update_user_error", err.Error())
 	}
 
+	if isReset {
+		sendPasswordChanged(user)
+	}
+
 	return nil
 }
 
+// sendPasswordChanged queues the notification about the new password,
+// the password is already changed so a failure is only logged
+func sendPasswordChanged(user common.User) {
+	_, err := mail.Queue(&mail.Email{
+		Template: mail.TemplatePasswordChanged,
+		Locale:   user.Locale,
+		To:       []string{user.Email},
+		Brand:    mail.GetBrand(user.ClientID),
+		Data: map[string]interface{}{
+			"FirstName": user.FirstName,
+			"ChangedAt": time.Now().UTC().Format("2006-01-02 15:04 MST"),
+		},
+	}, "user:"+user.ID.Hex())
+	if err != nil {
+		log.Errorf("error occurred during sending email to %s, error: %v\n", user.Email, err)
+	}
+}
+
 //generateToken create token and returns it
 func generateToken(user common.User) (*time.Time, *string, error) {
 	//Create jwt key from identity
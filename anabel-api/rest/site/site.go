@@ -3,21 +3,23 @@ package site
 import (
 	"time"
 
-	"anacove.com/backend/models/common"
+	"anacove.com/backend/common"
+	"anacove.com/backend/maintenance"
+	"anacove.com/backend/rules"
 	"github.com/globalsign/mgo/bson"
 )
 
-//Site godoc
+// Site godoc
 // @Summary The Site entity.
 type Site struct {
-	ID           string          `json:"id" bson:"_id,omitempty"`
-	UID          int             `json:"uid" bson:"uid"`
-	ClientID     string          `json:"clientId" bson:"clientId"`
-	GroupAdminID int             `json:"groupAdminId" bson:"groupAdminId"`
-	Name         string          `json:"name" bson:"name"`
-	LogoURL      string          `json:"logoUrl" bson:"logoUrl"`
+	ID           string         `json:"id" bson:"_id,omitempty"`
+	UID          int            `json:"uid" bson:"uid"`
+	ClientID     string         `json:"clientId" bson:"clientId"`
+	GroupAdminID int            `json:"groupAdminId" bson:"groupAdminId"`
+	Name         string         `json:"name" bson:"name"`
+	LogoURL      string         `json:"logoUrl" bson:"logoUrl"`
 	Address      common.Address `json:"address" bson:"address"`
-	FullAddress  string          `json:"fullAddress" bson:"fullAddress"`
+	FullAddress  string         `json:"fullAddress" bson:"fullAddress"`
 	Details      struct {
 		Building         int    `json:"building" bson:"building"`
 		Room             int    `json:"room" bson:"room"`
@@ -27,8 +29,9 @@ type Site struct {
 			Password string `json:"password" bson:"password"`
 		} `json:"wifi" bson:"wifi"`
 		FloorPlan []struct {
-			URL  string `json:"url" bson:"url"`
-			Name string `json:"name" bson:"name"`
+			URL      string                `json:"url" bson:"url"`
+			Name     string                `json:"name" bson:"name"`
+			Variants []common.ImageVariant `json:"variants" bson:"variants"`
 		} `json:"floorPlan" bson:"floorPlan"`
 	} `json:"details" bson:"details"`
 	Rooms []struct {
@@ -46,7 +49,7 @@ type Site struct {
 		SystemAlert   bool   `json:"systemAlert" bson:"systemAlert"`
 	} `json:"notificationSetup" bson:"notificationSetup"`
 	Configuration struct {
-		ID             string     `json:"id" bson:"id"`
+		ID             string    `json:"id" bson:"id"`
 		FS             common.FS `json:"FS" bson:"FS"`
 		TFS            common.FS `json:"TFS" bson:"TFS"`
 		RequiredDevice []struct {
@@ -74,13 +77,112 @@ type Site struct {
 		SiteUserGroup []string      `json:"siteUserGroup" bson:"siteUserGroup"`
 		ID            bson.ObjectId `json:"id" bson:"id"`
 
-		FirstName              string                         `json:"firstName" bson:"firstName"`
-		FamilyName             string                         `json:"familyName" bson:"familyName"`
-		ProfileURL             string                         `json:"profileUrl" bson:"profileUrl"`
-		Position               string                         `json:"position" bson:"position"`
-		Phone                  string                         `json:"phone" bson:"phone"`
-		NotificationPreference common.NotificationPreference `json:"notificationPreference" bson:"notificationPreference"`
-		SiteTagID              int                            `json:"siteTagId" bson:"siteTagId"`
-		SiteUserType           string                         `json:"siteUserType" bson:"siteUserType"`
+		FirstName              string `json:"firstName" bson:"firstName"`
+		FamilyName             string `json:"familyName" bson:"familyName"`
+		ProfileURL             string `json:"profileUrl" bson:"profileUrl"`
+		Position               string `json:"position" bson:"position"`
+		Phone                  string `json:"phone" bson:"phone"`
+		NotificationPreference string `json:"notificationPreference" bson:"notificationPreference"`
+		SiteTagID              int    `json:"siteTagId" bson:"siteTagId"`
+		SiteUserType           string `json:"siteUserType" bson:"siteUserType"`
 	} `json:"detailTeam" bson:"detailTeam"`
 }
+
+// IntegrationModel godoc
+// This is the chat integration create and update request model definition, an empty bot token keeps the current one while a channel is set
+type IntegrationModel struct {
+	Kind        string   `validate:"required" json:"kind"`
+	Name        string   `json:"name"`
+	WebhookURL  string   `json:"webhookUrl"`
+	BotToken    string   `json:"botToken"`
+	Channel     string   `json:"channel"`
+	AlertTypes  []string `json:"alertTypes"`
+	MinPriority string   `json:"minPriority"`
+	Enabled     *bool    `json:"enabled"`
+}
+
+// RoutingRuleModel godoc
+// This is the routing rule create and update request model definition, empty lists match everything
+type RoutingRuleModel struct {
+	Name       string   `validate:"required" json:"name"`
+	Order      int      `json:"order"`
+	AlertTypes []string `json:"alertTypes"`
+	Rooms      []string `json:"rooms"`
+	Floors     []string `json:"floors"`
+	Buildings  []string `json:"buildings"`
+	UserGroups []string `json:"userGroups"`
+	Strategy   string   `validate:"required" json:"strategy"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+// CorrelationRuleModel godoc
+// This is the correlation rule create and update request model definition, empty alert types match everything
+// and an empty group by groups the alerts of the whole site
+type CorrelationRuleModel struct {
+	Name          string   `validate:"required" json:"name"`
+	Order         int      `json:"order"`
+	AlertTypes    []string `json:"alertTypes"`
+	GroupBy       []string `json:"groupBy"`
+	WindowMinutes int      `validate:"required" json:"windowMinutes"`
+	MinAlerts     int      `validate:"required" json:"minAlerts"`
+	Enabled       *bool    `json:"enabled"`
+}
+
+// MaintenanceWindowModel godoc
+// This is the maintenance window create and update request model definition, empty lists match everything.
+// A window without recurrence is one-off and needs an end
+type MaintenanceWindowModel struct {
+	Name       string                  `validate:"required" json:"name"`
+	Action     string                  `validate:"required" json:"action"`
+	Priority   string                  `json:"priority"`
+	AlertTypes []string                `json:"alertTypes"`
+	Rooms      []string                `json:"rooms"`
+	Devices    []string                `json:"devices"`
+	Start      time.Time               `json:"start"`
+	End        time.Time               `json:"end"`
+	Recurrence *maintenance.Recurrence `json:"recurrence"`
+	TimeZone   string                  `json:"timeZone"`
+	Enabled    *bool                   `json:"enabled"`
+}
+
+// AlertRuleModel godoc
+// This is the alert rule request model definition, a rule without id gets a new one
+// and a missing enabled enables it
+type AlertRuleModel struct {
+	ID        string       `json:"id"`
+	Name      string       `validate:"required" json:"name"`
+	Option    string       `validate:"required" json:"option"`
+	Metric    string       `validate:"required" json:"metric"`
+	Condition string       `validate:"required" json:"condition"`
+	State     string       `json:"state"`
+	While     *rules.Guard `json:"while"`
+	AlertType string       `validate:"required" json:"alertType"`
+	Priority  string       `validate:"required" json:"priority"`
+	Enabled   *bool        `json:"enabled"`
+}
+
+// AlertRulesModel godoc
+// This is the alert rules publish request model definition, the base version is the version the rules were edited from
+type AlertRulesModel struct {
+	BaseVersion int              `json:"baseVersion"`
+	Note        string           `json:"note"`
+	Rules       []AlertRuleModel `validate:"dive" json:"rules"`
+}
+
+// SimulateAlertRulesModel godoc
+// This is the alert rules simulation request model definition. Missing rules simulate the version,
+// the current one when it is zero, and missing options take the options of the site
+type SimulateAlertRulesModel struct {
+	Version int              `json:"version"`
+	Rules   []AlertRuleModel `validate:"dive" json:"rules"`
+	Options []rules.Option   `json:"options"`
+	Events  []rules.Event    `validate:"required" json:"events"`
+	Until   time.Time        `json:"until"`
+}
+
+// AlertRuleSimulation godoc
+// This is the alert rules simulation response definition, the version is zero for rules sent with the request
+type AlertRuleSimulation struct {
+	Version int           `json:"version"`
+	Matches []rules.Match `json:"matches"`
+}
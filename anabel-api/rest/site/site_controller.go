@@ -0,0 +1,668 @@
+package site
+
+import (
+	"strconv"
+
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller type
+type Controller struct {
+}
+
+// AddRouters allows the endpoints defined in this controller to be added to router
+func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
+	ws.Route(ws.POST("/sites/{siteId}/integrations").Filter(utils.BearerAuth).To(createIntegration))
+	ws.Route(ws.GET("/sites/{siteId}/integrations").Filter(utils.BearerAuth).To(searchIntegrations))
+	ws.Route(ws.PUT("/sites/{siteId}/integrations/{id}").Filter(utils.BearerAuth).To(updateIntegration))
+	ws.Route(ws.DELETE("/sites/{siteId}/integrations/{id}").Filter(utils.BearerAuth).To(deleteIntegration))
+	ws.Route(ws.POST("/sites/{siteId}/integrations/{id}/test").Filter(utils.BearerAuth).To(testIntegration))
+	ws.Route(ws.POST("/sites/{siteId}/routing-rules").Filter(utils.BearerAuth).To(createRoutingRule))
+	ws.Route(ws.GET("/sites/{siteId}/routing-rules").Filter(utils.BearerAuth).To(searchRoutingRules))
+	ws.Route(ws.PUT("/sites/{siteId}/routing-rules/{id}").Filter(utils.BearerAuth).To(updateRoutingRule))
+	ws.Route(ws.DELETE("/sites/{siteId}/routing-rules/{id}").Filter(utils.BearerAuth).To(deleteRoutingRule))
+	ws.Route(ws.POST("/sites/{siteId}/correlation-rules").Filter(utils.BearerAuth).To(createCorrelationRule))
+	ws.Route(ws.GET("/sites/{siteId}/correlation-rules").Filter(utils.BearerAuth).To(searchCorrelationRules))
+	ws.Route(ws.PUT("/sites/{siteId}/correlation-rules/{id}").Filter(utils.BearerAuth).To(updateCorrelationRule))
+	ws.Route(ws.DELETE("/sites/{siteId}/correlation-rules/{id}").Filter(utils.BearerAuth).To(deleteCorrelationRule))
+	ws.Route(ws.POST("/sites/{siteId}/maintenance-windows").Filter(utils.BearerAuth).To(createMaintenanceWindow))
+	ws.Route(ws.GET("/sites/{siteId}/maintenance-windows").Filter(utils.BearerAuth).To(searchMaintenanceWindows))
+	ws.Route(ws.PUT("/sites/{siteId}/maintenance-windows/{id}").Filter(utils.BearerAuth).To(updateMaintenanceWindow))
+	ws.Route(ws.DELETE("/sites/{siteId}/maintenance-windows/{id}").Filter(utils.BearerAuth).To(deleteMaintenanceWindow))
+	ws.Route(ws.GET("/sites/{siteId}/alert-rules").Filter(utils.BearerAuth).To(getAlertRules))
+	ws.Route(ws.PUT("/sites/{siteId}/alert-rules").Filter(utils.BearerAuth).To(publishAlertRules))
+	ws.Route(ws.POST("/sites/{siteId}/alert-rules/simulate").Filter(utils.BearerAuth).To(simulateAlertRules))
+	ws.Route(ws.GET("/sites/{siteId}/alert-rules/versions").Filter(utils.BearerAuth).To(searchAlertRuleVersions))
+	ws.Route(ws.GET("/sites/{siteId}/alert-rules/versions/{version}").Filter(utils.BearerAuth).To(getAlertRuleVersion))
+	ws.Route(ws.POST("/sites/{siteId}/alert-rules/versions/{version}/restore").Filter(utils.BearerAuth).To(restoreAlertRules))
+	return ws
+}
+
+// createIntegration adds a slack or teams integration to the site
+// and returns it if succeeds
+func createIntegration(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := IntegrationModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create integration")
+	integration, err := GetService().CreateIntegration(siteID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, integration)
+}
+
+// searchIntegrations lists the chat integrations of the site
+func searchIntegrations(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	integrations, err := GetService().SearchIntegrations(siteID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, integrations)
+}
+
+// updateIntegration find integration by id an update the properties
+// and returns updated integration if succeeds
+func updateIntegration(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := IntegrationModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update integration")
+	integration, err := GetService().UpdateIntegration(siteID, id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, integration)
+}
+
+// deleteIntegration find an integration by id and delete it
+// and returns nothing if succeeds
+func deleteIntegration(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	err := GetService().DeleteIntegration(siteID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// testIntegration posts a sample alert through the integration
+// and returns nothing if succeeds
+func testIntegration(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	err := GetService().TestIntegration(siteID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// createRoutingRule adds a rule assigning the new alerts of the site
+// and returns it if succeeds
+func createRoutingRule(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := RoutingRuleModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create routing rule")
+	rule, err := GetService().CreateRoutingRule(siteID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, rule)
+}
+
+// searchRoutingRules lists the routing rules of the site in the order they apply
+func searchRoutingRules(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	rules, err := GetService().SearchRoutingRules(siteID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, rules)
+}
+
+// updateRoutingRule find routing rule by id and replace its settings
+// and returns updated rule if succeeds
+func updateRoutingRule(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := RoutingRuleModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update routing rule")
+	rule, err := GetService().UpdateRoutingRule(siteID, id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, rule)
+}
+
+// deleteRoutingRule find a routing rule by id and delete it
+// and returns nothing if succeeds
+func deleteRoutingRule(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	err := GetService().DeleteRoutingRule(siteID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// createCorrelationRule adds a rule grouping the alerts of the site into incidents
+// and returns it if succeeds
+func createCorrelationRule(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := CorrelationRuleModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create correlation rule")
+	rule, err := GetService().CreateCorrelationRule(siteID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, rule)
+}
+
+// searchCorrelationRules lists the correlation rules of the site in the order they apply
+func searchCorrelationRules(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	rules, err := GetService().SearchCorrelationRules(siteID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, rules)
+}
+
+// updateCorrelationRule find correlation rule by id and replace its settings
+// and returns updated rule if succeeds
+func updateCorrelationRule(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := CorrelationRuleModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update correlation rule")
+	rule, err := GetService().UpdateCorrelationRule(siteID, id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, rule)
+}
+
+// deleteCorrelationRule find a correlation rule by id and delete it
+// and returns nothing if succeeds
+func deleteCorrelationRule(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	err := GetService().DeleteCorrelationRule(siteID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// createMaintenanceWindow adds a maintenance window suppressing or downgrading alerts of the site
+// and returns it if succeeds
+func createMaintenanceWindow(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := MaintenanceWindowModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create maintenance window")
+	window, err := GetService().CreateMaintenanceWindow(siteID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, window)
+}
+
+// searchMaintenanceWindows lists the maintenance windows of the site
+func searchMaintenanceWindows(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	windows, err := GetService().SearchMaintenanceWindows(siteID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, windows)
+}
+
+// updateMaintenanceWindow find maintenance window by id and replace its settings
+// and returns updated window if succeeds
+func updateMaintenanceWindow(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := MaintenanceWindowModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update maintenance window")
+	window, err := GetService().UpdateMaintenanceWindow(siteID, id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, window)
+}
+
+// deleteMaintenanceWindow find a maintenance window by id and delete it
+// and returns nothing if succeeds
+func deleteMaintenanceWindow(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	err := GetService().DeleteMaintenanceWindow(siteID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// getAlertRules returns the current version of the alert rules of the site
+func getAlertRules(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	set, err := GetService().GetAlertRules(siteID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, set)
+}
+
+// publishAlertRules adds a version of the alert rules of the site which becomes the current one
+// and returns it if succeeds
+func publishAlertRules(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := AlertRulesModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing publish alert rules")
+	set, err := GetService().PublishAlertRules(siteID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, set)
+}
+
+// simulateAlertRules returns the alerts the rules would raise on the sample events
+func simulateAlertRules(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	request := SimulateAlertRulesModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	simulation, err := GetService().SimulateAlertRules(siteID, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, simulation)
+}
+
+// searchAlertRuleVersions lists the versions of the alert rules of the site
+func searchAlertRuleVersions(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	pageNumber, pageSize, err := pageOf(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	result, err := GetService().SearchAlertRuleVersions(siteID, pageNumber, pageSize)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, result)
+}
+
+// getAlertRuleVersion returns a version of the alert rules of the site
+func getAlertRuleVersion(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	version, err := strconv.Atoi(req.PathParameter("version"))
+	if err != nil || version < 1 {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	set, err := GetService().GetAlertRuleVersion(siteID, version)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, set)
+}
+
+// restoreAlertRules publishes a copy of a version of the alert rules of the site
+// and returns the new version if succeeds
+func restoreAlertRules(req *restful.Request, resp *restful.Response) {
+	siteID := req.PathParameter("siteId")
+	version, err := strconv.Atoi(req.PathParameter("version"))
+	if err != nil || version < 1 {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+	if !canManageSite(req, resp, siteID) {
+		return
+	}
+
+	log.Infof("Performing restore alert rules")
+	set, err := GetService().RestoreAlertRules(siteID, version, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, set)
+}
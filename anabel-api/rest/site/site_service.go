@@ -0,0 +1,881 @@
+package site
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"anacove.com/backend/chat"
+	"anacove.com/backend/common"
+	"anacove.com/backend/correlation"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/maintenance"
+	"anacove.com/backend/routing"
+	"anacove.com/backend/rules"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxIntegrationsPerSite limits the chat integrations a site can have
+	maxIntegrationsPerSite = 10
+	// maxRoutingRulesPerSite limits the routing rules a site can have
+	maxRoutingRulesPerSite = 50
+	// maxCorrelationRulesPerSite limits the correlation rules a site can have
+	maxCorrelationRulesPerSite = 50
+	// maxMaintenanceWindowsPerSite limits the maintenance windows a site can have
+	maxMaintenanceWindowsPerSite = 100
+	// maxSimulatedEvents limits the sample events of a simulation of the alert rules
+	maxSimulatedEvents = 1000
+)
+
+// Service godoc
+// defines the site integrations, routing, correlation and alert rules and maintenance windows
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for site service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// CreateIntegration godoc
+// adds a slack or teams integration to the site, it is enabled unless the request says otherwise
+func (Service *Service) CreateIntegration(siteID string, model IntegrationModel, currentUserID string) (*chat.Integration, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IntegrationCollection)
+
+	site := struct {
+		ClientID string `bson:"clientId"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).One(&site)
+	if err != nil {
+		log.Errorf("cannot find the site with id: %s, error: %v\n", siteID, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_site_error")
+	}
+
+	count, err := c.Find(bson.M{"siteId": siteID}).Count()
+	if err != nil {
+		log.Errorf("Error occured while counting integrations, error: %v", err)
+		return nil, errors.CreateError(500, "server_error")
+	}
+	if count >= maxIntegrationsPerSite {
+		log.Errorf("Integration limit for site %s reached", siteID)
+		return nil, errors.CreateError(400, "integration limit reached")
+	}
+
+	now := time.Now().UTC()
+	integration := chat.Integration{
+		ID:        bson.NewObjectId(),
+		SiteID:    siteID,
+		ClientID:  site.ClientID,
+		Enabled:   true,
+		CreatedBy: currentUserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	model.ToIntegration(&integration)
+
+	err = integration.Validate()
+	if err != nil {
+		log.Infof("Invalid integration, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_integration", err.Error())
+	}
+
+	err = c.Insert(&integration)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		return nil, errors.CreateError(500, "create_integration_error")
+	}
+	integration.BotToken = ""
+
+	return &integration, nil
+}
+
+// SearchIntegrations godoc
+// lists the chat integrations of the site without their bot tokens
+func (Service *Service) SearchIntegrations(siteID string) ([]chat.Integration, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IntegrationCollection)
+
+	integrations := []chat.Integration{}
+	err := c.Find(bson.M{"siteId": siteID}).Sort("createdAt").All(&integrations)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	for i := range integrations {
+		integrations[i].BotToken = ""
+	}
+
+	return integrations, nil
+}
+
+// UpdateIntegration godoc
+// replaces the settings of a chat integration of the site
+func (Service *Service) UpdateIntegration(siteID string, id string, model IntegrationModel) (*chat.Integration, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IntegrationCollection)
+
+	integration, err := findIntegration(c, siteID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	model.ToIntegration(integration)
+	err = integration.Validate()
+	if err != nil {
+		log.Infof("Invalid integration, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_integration", err.Error())
+	}
+	integration.UpdatedAt = time.Now().UTC()
+
+	err = c.UpdateId(integration.ID, integration)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+	integration.BotToken = ""
+
+	return integration, nil
+}
+
+// DeleteIntegration godoc
+// removes a chat integration of the site, the posted messages stay in the chat tool
+func (Service *Service) DeleteIntegration(siteID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IntegrationCollection)
+
+	integration, err := findIntegration(c, siteID, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.RemoveId(integration.ID)
+	if err != nil {
+		log.Errorf("Error occurred during delete, error: %v\n", err)
+		return errors.CreateError(500, "delete_error")
+	}
+
+	_, err = session.DB("").C(common.ChatMessageCollection).RemoveAll(bson.M{"integrationId": id})
+	if err != nil {
+		log.Errorf("Error occurred during delete of the messages of integration %s, error: %v\n", id, err)
+	}
+
+	return nil
+}
+
+// TestIntegration godoc
+// posts a sample alert through the integration, the error of the chat tool is returned as is
+func (Service *Service) TestIntegration(siteID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.IntegrationCollection)
+
+	integration, err := findIntegration(c, siteID, id)
+	if err != nil {
+		return err
+	}
+
+	alert := common.Alert{
+		ID:          bson.NewObjectId(),
+		SiteID:      siteID,
+		ClientID:    integration.ClientID,
+		Status:      common.AlertStatusNew,
+		Type:        common.AlertTypeStaffAlert,
+		Priority:    common.AlertPriorityHigh,
+		Location:    "101",
+		JobName:     "TEST",
+		Description: "This is a test alert",
+		AlertTime:   time.Now().UTC(),
+	}
+
+	err = chat.Post(integration, &alert)
+	if err != nil {
+		log.Infof("Test post of integration %s failed, error: %v", id, err)
+		return errors.CreateErrorWithMsg(400, "integration_failed", err.Error())
+	}
+
+	return nil
+}
+
+// findIntegration loads the integration when it belongs to the site
+func findIntegration(c *mgo.Collection, siteID string, id string) (*chat.Integration, error) {
+	integration := chat.Integration{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "siteId": siteID}).One(&integration)
+	if err != nil {
+		log.Errorf("cannot find the integration with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_integration_error")
+	}
+
+	return &integration, nil
+}
+
+// CreateRoutingRule godoc
+// adds a rule assigning the new alerts of the site, it is enabled unless the request says otherwise
+// and only routes the alerts raised from now on
+func (Service *Service) CreateRoutingRule(siteID string, model RoutingRuleModel, currentUserID string) (*routing.Rule, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.RoutingRuleCollection)
+
+	site := struct {
+		ClientID string `bson:"clientId"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).One(&site)
+	if err != nil {
+		log.Errorf("cannot find the site with id: %s, error: %v\n", siteID, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_site_error")
+	}
+
+	count, err := c.Find(bson.M{"siteId": siteID}).Count()
+	if err != nil {
+		log.Errorf("Error occured while counting routing rules, error: %v", err)
+		return nil, errors.CreateError(500, "server_error")
+	}
+	if count >= maxRoutingRulesPerSite {
+		log.Errorf("Routing rule limit for site %s reached", siteID)
+		return nil, errors.CreateError(400, "routing rule limit reached")
+	}
+
+	now := time.Now().UTC()
+	rule := routing.Rule{
+		ID:        bson.NewObjectId(),
+		SiteID:    siteID,
+		ClientID:  site.ClientID,
+		Enabled:   true,
+		CreatedBy: currentUserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	model.ToRule(&rule)
+
+	err = rule.Validate()
+	if err != nil {
+		log.Infof("Invalid routing rule, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_routing_rule", err.Error())
+	}
+
+	err = c.Insert(&rule)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		return nil, errors.CreateError(500, "create_routing_rule_error")
+	}
+
+	return &rule, nil
+}
+
+// SearchRoutingRules godoc
+// lists the routing rules of the site in the order they apply
+func (Service *Service) SearchRoutingRules(siteID string) ([]routing.Rule, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.RoutingRuleCollection)
+
+	rules := []routing.Rule{}
+	err := c.Find(bson.M{"siteId": siteID}).Sort("order", "createdAt").All(&rules)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return rules, nil
+}
+
+// UpdateRoutingRule godoc
+// replaces the settings of a routing rule of the site, the alerts it assigned keep their assignees
+func (Service *Service) UpdateRoutingRule(siteID string, id string, model RoutingRuleModel) (*routing.Rule, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.RoutingRuleCollection)
+
+	rule, err := findRoutingRule(c, siteID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	model.ToRule(rule)
+	err = rule.Validate()
+	if err != nil {
+		log.Infof("Invalid routing rule, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_routing_rule", err.Error())
+	}
+	rule.UpdatedAt = time.Now().UTC()
+
+	err = c.UpdateId(rule.ID, rule)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return rule, nil
+}
+
+// DeleteRoutingRule godoc
+// removes a routing rule of the site
+func (Service *Service) DeleteRoutingRule(siteID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.RoutingRuleCollection)
+
+	rule, err := findRoutingRule(c, siteID, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.RemoveId(rule.ID)
+	if err != nil {
+		log.Errorf("Error occurred during delete, error: %v\n", err)
+		return errors.CreateError(500, "delete_error")
+	}
+
+	return nil
+}
+
+// findRoutingRule loads the routing rule when it belongs to the site
+func findRoutingRule(c *mgo.Collection, siteID string, id string) (*routing.Rule, error) {
+	rule := routing.Rule{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "siteId": siteID}).One(&rule)
+	if err != nil {
+		log.Errorf("cannot find the routing rule with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_routing_rule_error")
+	}
+
+	return &rule, nil
+}
+
+// CreateCorrelationRule godoc
+// adds a rule grouping the alerts of the site into incidents, it is enabled unless the request says otherwise
+// and only groups the alerts raised from now on
+func (Service *Service) CreateCorrelationRule(siteID string, model CorrelationRuleModel, currentUserID string) (*correlation.Rule, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.CorrelationRuleCollection)
+
+	site := struct {
+		ClientID string `bson:"clientId"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).One(&site)
+	if err != nil {
+		log.Errorf("cannot find the site with id: %s, error: %v\n", siteID, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_site_error")
+	}
+
+	count, err := c.Find(bson.M{"siteId": siteID}).Count()
+	if err != nil {
+		log.Errorf("Error occured while counting correlation rules, error: %v", err)
+		return nil, errors.CreateError(500, "server_error")
+	}
+	if count >= maxCorrelationRulesPerSite {
+		log.Errorf("Correlation rule limit for site %s reached", siteID)
+		return nil, errors.CreateError(400, "correlation rule limit reached")
+	}
+
+	now := time.Now().UTC()
+	rule := correlation.Rule{
+		ID:        bson.NewObjectId(),
+		SiteID:    siteID,
+		ClientID:  site.ClientID,
+		Enabled:   true,
+		CreatedBy: currentUserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	model.ToCorrelationRule(&rule)
+
+	err = rule.Validate()
+	if err != nil {
+		log.Infof("Invalid correlation rule, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_correlation_rule", err.Error())
+	}
+
+	err = c.Insert(&rule)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		return nil, errors.CreateError(500, "create_correlation_rule_error")
+	}
+
+	return &rule, nil
+}
+
+// SearchCorrelationRules godoc
+// lists the correlation rules of the site in the order they apply
+func (Service *Service) SearchCorrelationRules(siteID string) ([]correlation.Rule, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.CorrelationRuleCollection)
+
+	rules := []correlation.Rule{}
+	err := c.Find(bson.M{"siteId": siteID}).Sort("order", "createdAt").All(&rules)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return rules, nil
+}
+
+// UpdateCorrelationRule godoc
+// replaces the settings of a correlation rule of the site, the incidents it raised keep their alerts
+func (Service *Service) UpdateCorrelationRule(siteID string, id string, model CorrelationRuleModel) (*correlation.Rule, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.CorrelationRuleCollection)
+
+	rule, err := findCorrelationRule(c, siteID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	model.ToCorrelationRule(rule)
+	err = rule.Validate()
+	if err != nil {
+		log.Infof("Invalid correlation rule, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_correlation_rule", err.Error())
+	}
+	rule.UpdatedAt = time.Now().UTC()
+
+	err = c.UpdateId(rule.ID, rule)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return rule, nil
+}
+
+// DeleteCorrelationRule godoc
+// removes a correlation rule of the site, its incidents stay
+func (Service *Service) DeleteCorrelationRule(siteID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.CorrelationRuleCollection)
+
+	rule, err := findCorrelationRule(c, siteID, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.RemoveId(rule.ID)
+	if err != nil {
+		log.Errorf("Error occurred during delete, error: %v\n", err)
+		return errors.CreateError(500, "delete_error")
+	}
+
+	return nil
+}
+
+// findCorrelationRule loads the correlation rule when it belongs to the site
+func findCorrelationRule(c *mgo.Collection, siteID string, id string) (*correlation.Rule, error) {
+	rule := correlation.Rule{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "siteId": siteID}).One(&rule)
+	if err != nil {
+		log.Errorf("cannot find the correlation rule with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_correlation_rule_error")
+	}
+
+	return &rule, nil
+}
+
+// CreateMaintenanceWindow godoc
+// adds a maintenance window to the site, it is enabled unless the request says otherwise
+// and applies to the alerts raised while it lasts from now on
+func (Service *Service) CreateMaintenanceWindow(siteID string, model MaintenanceWindowModel, currentUserID string) (*maintenance.Window, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.MaintenanceWindowCollection)
+
+	site := struct {
+		ClientID string `bson:"clientId"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).One(&site)
+	if err != nil {
+		log.Errorf("cannot find the site with id: %s, error: %v\n", siteID, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_site_error")
+	}
+
+	count, err := c.Find(bson.M{"siteId": siteID}).Count()
+	if err != nil {
+		log.Errorf("Error occured while counting maintenance windows, error: %v", err)
+		return nil, errors.CreateError(500, "server_error")
+	}
+	if count >= maxMaintenanceWindowsPerSite {
+		log.Errorf("Maintenance window limit for site %s reached", siteID)
+		return nil, errors.CreateError(400, "maintenance window limit reached")
+	}
+
+	now := time.Now().UTC()
+	window := maintenance.Window{
+		ID:        bson.NewObjectId(),
+		SiteID:    siteID,
+		ClientID:  site.ClientID,
+		Enabled:   true,
+		CreatedBy: currentUserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	model.ToWindow(&window)
+
+	err = window.Validate()
+	if err != nil {
+		log.Infof("Invalid maintenance window, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_maintenance_window", err.Error())
+	}
+
+	err = c.Insert(&window)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		return nil, errors.CreateError(500, "create_maintenance_window_error")
+	}
+
+	return &window, nil
+}
+
+// SearchMaintenanceWindows godoc
+// lists the maintenance windows of the site, the latest start first
+func (Service *Service) SearchMaintenanceWindows(siteID string) ([]maintenance.Window, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.MaintenanceWindowCollection)
+
+	windows := []maintenance.Window{}
+	err := c.Find(bson.M{"siteId": siteID}).Sort("-start").All(&windows)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return windows, nil
+}
+
+// UpdateMaintenanceWindow godoc
+// replaces the settings of a maintenance window of the site, the alerts it already suppressed or downgraded stay so
+func (Service *Service) UpdateMaintenanceWindow(siteID string, id string, model MaintenanceWindowModel) (*maintenance.Window, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.MaintenanceWindowCollection)
+
+	window, err := findMaintenanceWindow(c, siteID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	model.ToWindow(window)
+	err = window.Validate()
+	if err != nil {
+		log.Infof("Invalid maintenance window, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_maintenance_window", err.Error())
+	}
+	window.UpdatedAt = time.Now().UTC()
+
+	err = c.UpdateId(window.ID, window)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return window, nil
+}
+
+// DeleteMaintenanceWindow godoc
+// removes a maintenance window of the site, the alerts it suppressed stay suppressed
+func (Service *Service) DeleteMaintenanceWindow(siteID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.MaintenanceWindowCollection)
+
+	window, err := findMaintenanceWindow(c, siteID, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.RemoveId(window.ID)
+	if err != nil {
+		log.Errorf("Error occurred during delete, error: %v\n", err)
+		return errors.CreateError(500, "delete_error")
+	}
+
+	return nil
+}
+
+// findMaintenanceWindow loads the maintenance window when it belongs to the site
+func findMaintenanceWindow(c *mgo.Collection, siteID string, id string) (*maintenance.Window, error) {
+	window := maintenance.Window{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "siteId": siteID}).One(&window)
+	if err != nil {
+		log.Errorf("cannot find the maintenance window with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_maintenance_window_error")
+	}
+
+	return &window, nil
+}
+
+// GetAlertRules godoc
+// returns the current version of the alert rules of the site, version 0 without rules when none was published
+func (Service *Service) GetAlertRules(siteID string) (*rules.RuleSet, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	return findCurrentAlertRules(session.DB("").C(common.AlertRuleSetCollection), siteID)
+}
+
+// PublishAlertRules godoc
+// adds a version with the rules which becomes the current one, the rules must have been edited from the current version
+func (Service *Service) PublishAlertRules(siteID string, model AlertRulesModel, currentUserID string) (*rules.RuleSet, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	return addAlertRuleVersion(session, siteID, model.BaseVersion, ToRules(model.Rules), model.Note, 0, currentUserID)
+}
+
+// SearchAlertRuleVersions godoc
+// lists the versions of the alert rules of the site, the latest first
+func (Service *Service) SearchAlertRuleVersions(siteID string, pageNumber int, pageSize int) (*common.PagedList, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertRuleSetCollection)
+
+	count, err := c.Find(bson.M{"siteId": siteID}).Count()
+	if err != nil {
+		log.Errorf("error occured during getting count: error: %v\n", err)
+		return nil, errors.CreateError(500, "query_execute_error")
+	}
+
+	sets := []rules.RuleSet{}
+	err = c.Find(bson.M{"siteId": siteID}).Sort("-version").Skip(pageSize * (pageNumber - 1)).Limit(pageSize).All(&sets)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return &common.PagedList{
+		Items: sets,
+		Page:  pageNumber,
+		Size:  pageSize,
+		Total: count,
+	}, nil
+}
+
+// GetAlertRuleVersion godoc
+// returns a version of the alert rules of the site
+func (Service *Service) GetAlertRuleVersion(siteID string, version int) (*rules.RuleSet, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	return findAlertRuleVersion(session.DB("").C(common.AlertRuleSetCollection), siteID, version)
+}
+
+// RestoreAlertRules godoc
+// publishes a copy of a former version of the alert rules of the site as the current one
+func (Service *Service) RestoreAlertRules(siteID string, version int, currentUserID string) (*rules.RuleSet, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertRuleSetCollection)
+
+	restored, err := findAlertRuleVersion(c, siteID, version)
+	if err != nil {
+		return nil, err
+	}
+	current, err := findCurrentAlertRules(c, siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return addAlertRuleVersion(session, siteID, current.Version, restored.Rules, restored.Note, restored.Version, currentUserID)
+}
+
+// SimulateAlertRules godoc
+// returns the alerts the rules would raise on the sample events from no readings on, nothing is stored or raised.
+// The rules of the request are checked like published ones
+func (Service *Service) SimulateAlertRules(siteID string, model SimulateAlertRulesModel) (*AlertRuleSimulation, error) {
+	if len(model.Events) > maxSimulatedEvents {
+		log.Infof("Too many events to simulate: %d", len(model.Events))
+		return nil, errors.CreateErrorWithMsg(400, "invalid_alert_rules", fmt.Sprintf("at most %d events can be simulated", maxSimulatedEvents))
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.AlertRuleSetCollection)
+
+	options := model.Options
+	if options == nil {
+		var err error
+		options, err = rules.OptionsOf(session, siteID)
+		if err != nil {
+			log.Errorf("cannot find the site with id: %s, error: %v\n", siteID, err)
+			if err == mgo.ErrNotFound {
+				return nil, errors.CreateError(404, "not_found")
+			}
+			return nil, errors.CreateError(500, "get_site_error")
+		}
+	}
+
+	set := &rules.RuleSet{SiteID: siteID, Rules: ToRules(model.Rules)}
+	if model.Rules == nil {
+		var err error
+		if model.Version > 0 {
+			set, err = findAlertRuleVersion(c, siteID, model.Version)
+		} else {
+			set, err = findCurrentAlertRules(c, siteID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if err := set.Validate(options); err != nil {
+		log.Infof("Invalid alert rules, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_alert_rules", err.Error())
+	}
+
+	events := []rules.Event{}
+	for _, event := range model.Events {
+		event.SiteID = siteID
+		event.At = event.At.UTC()
+		events = append(events, event)
+	}
+
+	return &AlertRuleSimulation{
+		Version: set.Version,
+		Matches: rules.Simulate(set.Rules, options, events, model.Until.UTC()),
+	}, nil
+}
+
+// addAlertRuleVersion adds the version after the base version, a version published meanwhile fails it with alert_rules_changed
+func addAlertRuleVersion(session *mgo.Session, siteID string, baseVersion int, list []rules.Rule, note string, restoredFrom int, currentUserID string) (*rules.RuleSet, error) {
+	c := session.DB("").C(common.AlertRuleSetCollection)
+
+	site := struct {
+		ClientID string `bson:"clientId"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).One(&site)
+	if err != nil {
+		log.Errorf("cannot find the site with id: %s, error: %v\n", siteID, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_site_error")
+	}
+	options, err := rules.OptionsOf(session, siteID)
+	if err != nil {
+		log.Errorf("cannot find the options of the site with id: %s, error: %v\n", siteID, err)
+		return nil, errors.CreateError(500, "get_site_error")
+	}
+
+	current, err := findCurrentAlertRules(c, siteID)
+	if err != nil {
+		return nil, err
+	}
+	if baseVersion != current.Version {
+		log.Infof("Alert rules of site %s were edited from version %d, the current one is %d", siteID, baseVersion, current.Version)
+		return nil, errors.CreateError(409, "alert_rules_changed")
+	}
+
+	set := rules.RuleSet{
+		ID:           bson.NewObjectId(),
+		ClientID:     site.ClientID,
+		SiteID:       siteID,
+		Version:      current.Version + 1,
+		Current:      true,
+		Rules:        list,
+		Note:         note,
+		RestoredFrom: restoredFrom,
+		CreatedBy:    currentUserID,
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = set.Validate(options)
+	if err != nil {
+		log.Infof("Invalid alert rules, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_alert_rules", err.Error())
+	}
+
+	err = c.Insert(&set)
+	if mgo.IsDup(err) {
+		log.Infof("Version %d of the alert rules of site %s was published meanwhile", set.Version, siteID)
+		return nil, errors.CreateError(409, "alert_rules_changed")
+	}
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		return nil, errors.CreateError(500, "create_alert_rules_error")
+	}
+
+	_, err = c.UpdateAll(bson.M{"siteId": siteID, "current": true, "version": bson.M{"$ne": set.Version}}, bson.M{"$set": bson.M{"current": false}})
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return &set, nil
+}
+
+// findCurrentAlertRules loads the current version of the alert rules of the site, version 0 when none was published
+func findCurrentAlertRules(c *mgo.Collection, siteID string) (*rules.RuleSet, error) {
+	set := rules.RuleSet{}
+	err := c.Find(bson.M{"siteId": siteID, "current": true}).Sort("-version").One(&set)
+	if err == mgo.ErrNotFound {
+		return &rules.RuleSet{SiteID: siteID, Rules: []rules.Rule{}}, nil
+	}
+	if err != nil {
+		log.Errorf("cannot find the alert rules of site: %s, error: %v\n", siteID, err)
+		return nil, errors.CreateError(500, "get_alert_rules_error")
+	}
+
+	return &set, nil
+}
+
+// findAlertRuleVersion loads a version of the alert rules of the site
+func findAlertRuleVersion(c *mgo.Collection, siteID string, version int) (*rules.RuleSet, error) {
+	set := rules.RuleSet{}
+	err := c.Find(bson.M{"siteId": siteID, "version": version}).One(&set)
+	if err != nil {
+		log.Errorf("cannot find version %d of the alert rules of site: %s, error: %v\n", version, siteID, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_alert_rules_error")
+	}
+
+	return &set, nil
+}
@@ -0,0 +1,177 @@
+package site
+
+import (
+	"strconv"
+	"time"
+
+	"anacove.com/backend/chat"
+	"anacove.com/backend/correlation"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/maintenance"
+	"anacove.com/backend/routing"
+	"anacove.com/backend/rules"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// canManageSite lets the admins and managers of the site manage its integrations, rules and maintenance windows, it writes the error response otherwise
+func canManageSite(req *restful.Request, resp *restful.Response, siteID string) bool {
+	if !bson.IsObjectIdHex(siteID) {
+		log.Infof("invalid site id %s", siteID)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return false
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "site", siteID) {
+		log.Infof("User access forbidden for site id %s", siteID)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
+
+// ToIntegration applies the model to the integration, an empty bot token keeps the current one as long as a channel is set
+func (model *IntegrationModel) ToIntegration(integration *chat.Integration) {
+	integration.Kind = model.Kind
+	integration.Name = model.Name
+	integration.WebhookURL = model.WebhookURL
+	integration.Channel = model.Channel
+	integration.AlertTypes = model.AlertTypes
+	integration.MinPriority = model.MinPriority
+	if len(model.BotToken) > 0 {
+		integration.BotToken = model.BotToken
+	} else if len(model.Channel) == 0 || model.Kind != chat.KindSlack {
+		integration.BotToken = ""
+	}
+	if model.Enabled != nil {
+		integration.Enabled = *model.Enabled
+	}
+	if integration.AlertTypes == nil {
+		integration.AlertTypes = []string{}
+	}
+}
+
+// ToRule applies the model to the routing rule, a missing enabled keeps the rule as it is
+func (model *RoutingRuleModel) ToRule(rule *routing.Rule) {
+	rule.Name = model.Name
+	rule.Order = model.Order
+	rule.AlertTypes = model.AlertTypes
+	rule.Rooms = model.Rooms
+	rule.Floors = model.Floors
+	rule.Buildings = model.Buildings
+	rule.UserGroups = model.UserGroups
+	rule.Strategy = model.Strategy
+	if model.Enabled != nil {
+		rule.Enabled = *model.Enabled
+	}
+	for _, list := range []*[]string{&rule.AlertTypes, &rule.Rooms, &rule.Floors, &rule.Buildings, &rule.UserGroups} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+}
+
+// ToCorrelationRule applies the model to the correlation rule, a missing enabled keeps the rule as it is
+func (model *CorrelationRuleModel) ToCorrelationRule(rule *correlation.Rule) {
+	rule.Name = model.Name
+	rule.Order = model.Order
+	rule.AlertTypes = model.AlertTypes
+	rule.GroupBy = model.GroupBy
+	rule.WindowMinutes = model.WindowMinutes
+	rule.MinAlerts = model.MinAlerts
+	if model.Enabled != nil {
+		rule.Enabled = *model.Enabled
+	}
+	for _, list := range []*[]string{&rule.AlertTypes, &rule.GroupBy} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+}
+
+// ToWindow applies the model to the maintenance window, a missing enabled keeps the window as it is
+func (model *MaintenanceWindowModel) ToWindow(window *maintenance.Window) {
+	window.Name = model.Name
+	window.Action = model.Action
+	window.Priority = model.Priority
+	window.AlertTypes = model.AlertTypes
+	window.Rooms = model.Rooms
+	window.Devices = model.Devices
+	window.Start = model.Start.UTC()
+	window.End = model.End.UTC()
+	window.Recurrence = model.Recurrence
+	window.TimeZone = model.TimeZone
+	if model.End.IsZero() {
+		window.End = time.Time{}
+	}
+	if window.Action != maintenance.ActionDowngrade {
+		window.Priority = ""
+	}
+	if model.Enabled != nil {
+		window.Enabled = *model.Enabled
+	}
+	for _, list := range []*[]string{&window.AlertTypes, &window.Rooms, &window.Devices} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+}
+
+// ToRules turns the models into the rules of a version, the rules without valid id get a new one
+func ToRules(models []AlertRuleModel) []rules.Rule {
+	list := []rules.Rule{}
+	for _, model := range models {
+		rule := rules.Rule{
+			ID:        model.ID,
+			Name:      model.Name,
+			Option:    model.Option,
+			Metric:    model.Metric,
+			Condition: model.Condition,
+			State:     model.State,
+			While:     model.While,
+			AlertType: model.AlertType,
+			Priority:  model.Priority,
+			Enabled:   true,
+		}
+		if !bson.IsObjectIdHex(rule.ID) {
+			rule.ID = bson.NewObjectId().Hex()
+		}
+		if model.Enabled != nil {
+			rule.Enabled = *model.Enabled
+		}
+		list = append(list, rule)
+	}
+
+	return list
+}
+
+// pageOf reads the pageNumber and pageSize query parameters, 1 and 20 when missing
+func pageOf(req *restful.Request) (int, int, error) {
+	pageNumber, pageSize := 1, 20
+	for name, value := range map[string]*int{"pageNumber": &pageNumber, "pageSize": &pageSize} {
+		val := req.QueryParameter(name)
+		if val == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 1 {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return 0, 0, errors.CreateError(400, "invalid_data")
+		}
+		*value = i
+	}
+
+	return pageNumber, pageSize, nil
+}
@@ -0,0 +1,14 @@
+package sla
+
+// maxTargetsPerClient limits the sla targets a client can have
+const maxTargetsPerClient = 100
+
+// TargetModel godoc
+// This is the sla target create and update request model definition,
+// an empty alert type applies to every type and an empty priority to every priority
+type TargetModel struct {
+	AlertType     string `json:"alertType"`
+	Priority      string `json:"priority"`
+	AssignMinutes int    `json:"assignMinutes"`
+	ClearMinutes  int    `json:"clearMinutes"`
+}
@@ -0,0 +1,108 @@
+package sla
+
+import (
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller type
+type Controller struct {
+}
+
+// AddRouters allows the endpoints defined in this controller to be added to router
+func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
+	ws.Route(ws.POST("/clients/{clientId}/sla-targets").Filter(utils.BearerAuth).To(createTarget))
+	ws.Route(ws.GET("/clients/{clientId}/sla-targets").Filter(utils.BearerAuth).To(searchTargets))
+	ws.Route(ws.PUT("/clients/{clientId}/sla-targets/{id}").Filter(utils.BearerAuth).To(updateTarget))
+	ws.Route(ws.DELETE("/clients/{clientId}/sla-targets/{id}").Filter(utils.BearerAuth).To(deleteTarget))
+	return ws
+}
+
+// createTarget adds an sla target to the client
+// and returns it if succeeds
+func createTarget(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	if !authorize(req, resp, clientID) {
+		return
+	}
+
+	request := TargetModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create sla target")
+	target, err := GetService().CreateTarget(clientID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, target)
+}
+
+// searchTargets lists the sla targets of the client
+func searchTargets(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	if !authorize(req, resp, clientID) {
+		return
+	}
+
+	targets, err := GetService().SearchTargets(clientID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, targets)
+}
+
+// updateTarget find sla target by id and replace its settings
+// and returns updated target if succeeds
+func updateTarget(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	request := TargetModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update sla target")
+	target, err := GetService().UpdateTarget(clientID, id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, target)
+}
+
+// deleteTarget find an sla target by id and delete it
+// and returns nothing if succeeds
+func deleteTarget(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	err := GetService().DeleteTarget(clientID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
@@ -0,0 +1,167 @@
+package sla
+
+import (
+	"sync"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/errors"
+	targets "anacove.com/backend/sla"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service godoc
+// defines the sla targets of the clients
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for sla service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// CreateTarget godoc
+// adds an sla target to the client, a client has one target per alert type and priority
+func (Service *Service) CreateTarget(clientID string, model TargetModel, currentUserID string) (*targets.Target, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SLATargetCollection)
+
+	count, err := c.Find(bson.M{"clientId": clientID}).Count()
+	if err != nil {
+		log.Errorf("Error occured while counting sla targets, error: %v", err)
+		return nil, errors.CreateError(500, "server_error")
+	}
+	if count >= maxTargetsPerClient {
+		log.Errorf("SLA target limit for client %s reached", clientID)
+		return nil, errors.CreateError(400, "sla target limit reached")
+	}
+
+	now := time.Now().UTC()
+	target := targets.Target{
+		ID:        bson.NewObjectId(),
+		ClientID:  clientID,
+		CreatedBy: currentUserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	model.ToTarget(&target)
+
+	err = target.Validate()
+	if err != nil {
+		log.Infof("Invalid sla target, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_sla_target", err.Error())
+	}
+
+	err = c.Insert(&target)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		if mgo.IsDup(err) {
+			return nil, errors.CreateError(400, "sla_target_exists")
+		}
+		return nil, errors.CreateError(500, "create_sla_target_error")
+	}
+
+	return &target, nil
+}
+
+// SearchTargets godoc
+// lists the sla targets of the client
+func (Service *Service) SearchTargets(clientID string) ([]targets.Target, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SLATargetCollection)
+
+	result := []targets.Target{}
+	err := c.Find(bson.M{"clientId": clientID}).Sort("alertType", "priority").All(&result)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return result, nil
+}
+
+// UpdateTarget godoc
+// replaces the alert type, priority and minutes of an sla target, the reports use the targets as they are when requested
+func (Service *Service) UpdateTarget(clientID string, id string, model TargetModel) (*targets.Target, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SLATargetCollection)
+
+	target, err := findTarget(c, clientID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	model.ToTarget(target)
+	err = target.Validate()
+	if err != nil {
+		log.Infof("Invalid sla target, error: %v", err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_sla_target", err.Error())
+	}
+	target.UpdatedAt = time.Now().UTC()
+
+	err = c.UpdateId(target.ID, target)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		if mgo.IsDup(err) {
+			return nil, errors.CreateError(400, "sla_target_exists")
+		}
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return target, nil
+}
+
+// DeleteTarget godoc
+// removes an sla target of the client
+func (Service *Service) DeleteTarget(clientID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.SLATargetCollection)
+
+	target, err := findTarget(c, clientID, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.RemoveId(target.ID)
+	if err != nil {
+		log.Errorf("Error occurred during delete, error: %v\n", err)
+		return errors.CreateError(500, "delete_error")
+	}
+
+	return nil
+}
+
+// findTarget loads the sla target when it belongs to the client
+func findTarget(c *mgo.Collection, clientID string, id string) (*targets.Target, error) {
+	target := targets.Target{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "clientId": clientID}).One(&target)
+	if err != nil {
+		log.Errorf("cannot find the sla target with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_sla_target_error")
+	}
+
+	return &target, nil
+}
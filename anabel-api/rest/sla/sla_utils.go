@@ -0,0 +1,45 @@
+package sla
+
+import (
+	"anacove.com/backend/errors"
+	targets "anacove.com/backend/sla"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// ToTarget will convert to Target domain model from TargetModel
+func (model *TargetModel) ToTarget(target *targets.Target) {
+	target.AlertType = model.AlertType
+	target.Priority = model.Priority
+	target.AssignMinutes = model.AssignMinutes
+	target.ClearMinutes = model.ClearMinutes
+}
+
+// authorize lets the admins of the client manage its sla targets, it writes the error response otherwise
+func authorize(req *restful.Request, resp *restful.Response, clientID string, ids ...string) bool {
+	for _, id := range append([]string{clientID}, ids...) {
+		if !bson.IsObjectIdHex(id) {
+			log.Infof("invalid path id %s", id)
+			utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+			return false
+		}
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "client", clientID) {
+		log.Infof("User access forbidden for client id %s", clientID)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
@@ -1,46 +1,98 @@
 package user
 
+import (
+	"time"
+
+	"anacove.com/backend/common"
+)
+
+// defaultInvitationExpiry is used when invitation.expiry_in_hours is not configured
+const defaultInvitationExpiry = 7 * 24 * time.Hour
+
+// invitationStatuses lists the invitation statuses users can be searched by
+var invitationStatuses = []string{
+	common.InvitationPending,
+	common.InvitationAccepted,
+	common.InvitationExpired,
+	common.InvitationRevoked,
+}
+
 // CreateUserModel godoc
 // This is the user create request model definition
 type CreateUserModel struct {
-	Email                  string   `validate:"required" json:"email"`
-	FirstName              string   `json:"firstName"`
-	FamilyName             string   `json:"familyName"`
-	ProfileURL             string   `json:"profileUrl"`
-	Position               string   `json:"position"`
-	Phone                  string   `json:"phone"`
-	NotificationPreference string   `json:"notificationPreference"`
-	UserGroups             []string `json:"userGroups"`
-	SiteID                 string   `json:"siteId"`
-	SiteTagID              int      `json:"siteTagId"`
-	SiteUserType           string   `json:"siteUserType"`
-	AdminUserType          string   `json:"adminUserType"`
-	SiteGroupName          string   `json:"siteGroupName"`
-	ClientID               string   `json:"clientId"`
+	Email                  string             `validate:"required" json:"email"`
+	FirstName              string             `json:"firstName"`
+	FamilyName             string             `json:"familyName"`
+	ProfileURL             string             `json:"profileUrl"`
+	Position               string             `json:"position"`
+	Phone                  string             `json:"phone"`
+	NotificationPreference string             `json:"notificationPreference"`
+	Locale                 string             `json:"locale"`
+	TimeZone               string             `json:"timeZone"`
+	QuietHours             *common.QuietHours `json:"quietHours"`
+	UserGroups             []string           `json:"userGroups"`
+	SiteID                 string             `json:"siteId"`
+	SiteTagID              int                `json:"siteTagId"`
+	SiteUserType           string             `json:"siteUserType"`
+	AdminUserType          string             `json:"adminUserType"`
+	SiteGroupName          string             `json:"siteGroupName"`
+	ClientID               string             `json:"clientId"`
 }
 
 // UpdateUserModel godoc
 // This is the user update request model definition
 type UpdateUserModel struct {
-	Email                  string `json:"email"`
-	FirstName              string `json:"firstName"`
-	FamilyName             string `json:"familyName"`
-	Position               string `json:"position"`
-	Phone                  string `json:"phone"`
-	NotificationPreference string `json:"notificationPreference"`
-	SiteGroupName          string `json:"siteGroupName"`
+	Email                  string             `json:"email"`
+	FirstName              string             `json:"firstName"`
+	FamilyName             string             `json:"familyName"`
+	ProfileURL             string             `json:"profileUrl"`
+	Position               string             `json:"position"`
+	Phone                  string             `json:"phone"`
+	NotificationPreference string             `json:"notificationPreference"`
+	Locale                 string             `json:"locale"`
+	TimeZone               string             `json:"timeZone"`
+	QuietHours             *common.QuietHours `json:"quietHours"`
+	UserGroups             []string           `json:"userGroups"`
+	SiteGroupName          string             `json:"siteGroupName"`
+}
+
+// NotificationMatrixModel godoc
+// This is the notification matrix request and response model definition
+type NotificationMatrixModel struct {
+	Rules             []common.NotificationRule `json:"rules"`
+	AllowedAlertTypes []string                  `json:"allowedAlertTypes"`
+}
+
+// DigestModel godoc
+// This is the alert summary schedule request and response model definition,
+// the time zone and the default of the role are only returned
+type DigestModel struct {
+	Frequency   string `json:"frequency"`
+	Hour        int    `json:"hour"`
+	Weekday     int    `json:"weekday"`
+	TimeZone    string `json:"timeZone"`
+	RoleDefault string `json:"roleDefault"`
+}
+
+// ShiftsModel godoc
+// This is the working hours request and response model definition, the time zone is only returned
+type ShiftsModel struct {
+	Shifts   []common.Shift `json:"shifts"`
+	TimeZone string         `json:"timeZone"`
 }
 
 // Query godoc
 // This is the query request model definition
 type Query struct {
-	PageNumber int
-	PageSize   int
-	SortBy     string
-	SortOrder  int
-	Role       string
-	Status     string
-	Keyword    string
+	PageNumber     int
+	PageSize       int
+	SortBy         string
+	SortOrder      int
+	Role           string
+	Status         string
+	Deliverability string
+	Invitation     string
+	Keyword        string
 }
 
 const (
@@ -6,6 +6,7 @@ import (
 	"anacove.com/backend/errors"
 	"anacove.com/backend/utils"
 	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,6 +24,16 @@ func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebServ
 	ws.Route(ws.GET("/users/{id}").Filter(utils.BearerAuth).To(getUserByID))
 	ws.Route(ws.PUT("/users/{id}").Filter(utils.BearerAuth).To(updateUsers))
 	ws.Route(ws.DELETE("/users/{id}").Filter(utils.BearerAuth).To(deleteUser))
+	ws.Route(ws.POST("/users/{id}/resend-invite").Filter(utils.BearerAuth).To(resendInvitation))
+	ws.Route(ws.POST("/users/{id}/revoke-invite").Filter(utils.BearerAuth).To(revokeInvitation))
+	ws.Route(ws.GET("/users/{id}/notification-matrix").Filter(utils.BearerAuth).To(getNotificationMatrix))
+	ws.Route(ws.PUT("/users/{id}/notification-matrix").Filter(utils.BearerAuth).To(updateNotificationMatrix))
+	ws.Route(ws.GET("/users/{id}/digest").Filter(utils.BearerAuth).To(getDigest))
+	ws.Route(ws.PUT("/users/{id}/digest").Filter(utils.BearerAuth).To(updateDigest))
+	ws.Route(ws.GET("/users/{id}/shifts").Filter(utils.BearerAuth).To(getShifts))
+	ws.Route(ws.PUT("/users/{id}/shifts").Filter(utils.BearerAuth).To(updateShifts))
+	// the unsubscribe link of the summary emails is authenticated by its token
+	ws.Route(ws.POST("/digest/unsubscribe/{token}").To(unsubscribeDigest))
 	return ws
 }
 
@@ -108,14 +119,14 @@ func createUsers(req *restful.Request, resp *restful.Response) {
 
 	log.Infof("Performing create user")
 	// perform operations
-	err = GetService().CreateUser(request)
+	user, err := GetService().CreateUser(request, claims.ID)
 
 	if err != nil {
 		utils.WriteError(resp, err)
 		return
 	}
 
-	resp.WriteHeaderAndEntity(204, nil)
+	resp.WriteHeaderAndEntity(200, user)
 
 }
 
@@ -271,3 +282,291 @@ func deleteUser(req *restful.Request, resp *restful.Response) {
 
 	resp.WriteHeaderAndEntity(204, nil)
 }
+
+// resendInvitation sends a new invitation link to a user who did not activate the account
+// and returns the user if succeeds
+func resendInvitation(req *restful.Request, resp *restful.Response) {
+	// get path value
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "user", id) {
+		log.Infof("User access forbidden for user id %s", id)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return
+	}
+
+	log.Infof("Performing resend invitation")
+	user, err := GetService().ResendInvitation(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, user)
+}
+
+// revokeInvitation withdraws the pending invitation of a user
+// and returns the user if succeeds
+func revokeInvitation(req *restful.Request, resp *restful.Response) {
+	// get path value
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "user", id) {
+		log.Infof("User access forbidden for user id %s", id)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return
+	}
+
+	log.Infof("Performing revoke invitation")
+	user, err := GetService().RevokeInvitation(id, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, user)
+}
+
+// getNotificationMatrix finds the notification matrix of a user
+// and returns it with the alert types the user may be notified about if succeeds
+func getNotificationMatrix(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canManageNotifications(req, resp, id) {
+		return
+	}
+
+	matrix, err := GetService().GetNotificationMatrix(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, matrix)
+}
+
+// updateNotificationMatrix replaces the notification matrix of a user
+// and returns the updated matrix if succeeds
+func updateNotificationMatrix(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canManageNotifications(req, resp, id) {
+		return
+	}
+
+	request := NotificationMatrixModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update notification matrix")
+	matrix, err := GetService().UpdateNotificationMatrix(id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, matrix)
+}
+
+// getDigest finds the alert summary schedule of a user
+// and returns it if succeeds
+func getDigest(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canManageNotifications(req, resp, id) {
+		return
+	}
+
+	schedule, err := GetService().GetDigest(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, schedule)
+}
+
+// updateDigest replaces the alert summary schedule of a user
+// and returns the updated schedule if succeeds
+func updateDigest(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canManageNotifications(req, resp, id) {
+		return
+	}
+
+	request := DigestModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update digest")
+	schedule, err := GetService().UpdateDigest(id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, schedule)
+}
+
+// unsubscribeDigest turns off the alert summary of the user the link was sent to
+func unsubscribeDigest(req *restful.Request, resp *restful.Response) {
+	token := req.PathParameter("token")
+	if len(token) == 0 {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	log.Infof("Performing unsubscribe digest")
+	err := GetService().UnsubscribeDigest(token)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// getShifts finds the working hours of a user
+// and returns them if succeeds
+func getShifts(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	if !canManageNotifications(req, resp, id) {
+		return
+	}
+
+	shifts, err := GetService().GetShifts(id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, shifts)
+}
+
+// updateShifts replaces the working hours of a user, the alerts are only routed to users on shift
+// and returns the updated shifts if succeeds
+func updateShifts(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if !bson.IsObjectIdHex(id) {
+		log.Infof("Error occured during getting path value from request")
+		utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+		return
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "user", id) {
+		log.Infof("User access forbidden for user id %s", id)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return
+	}
+
+	request := ShiftsModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update shifts")
+	shifts, err := GetService().UpdateShifts(id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, shifts)
+}
+
+// canManageNotifications lets users manage their own notifications and the admins those of the users they can access,
+// it writes the error response otherwise
+func canManageNotifications(req *restful.Request, resp *restful.Response, id string) bool {
+	if id == utils.GetUserID(req) {
+		return true
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA", "GA", "SM") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "user", id) {
+		log.Infof("User access forbidden for user id %s", id)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
@@ -6,8 +6,15 @@ import (
 	"time"
 
 	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/digest"
 	"anacove.com/backend/errors"
+	"anacove.com/backend/mail"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/routing"
 	"anacove.com/backend/utils"
+	"anacove.com/backend/webhook"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/google/uuid"
@@ -38,8 +45,8 @@ func GetService() *Service {
 }
 
 // CreateUser godoc
-// perform operation to create a user
-func (Service *Service) CreateUser(model CreateUserModel) error {
+// perform operation to create a user, the deliverability of the created user tells whether the invitation can be delivered
+func (Service *Service) CreateUser(model CreateUserModel, currentUserID string) (*common.User, error) {
 	// preparing database connectivity
 	session := utils.NewDBSession()
 	defer session.Close()
@@ -52,19 +59,24 @@ func (Service *Service) CreateUser(model CreateUserModel) error {
 	err := c.Find(bson.M{"email": model.Email}).One(&user)
 	if err == nil || err != mgo.ErrNotFound {
 		log.Errorf("Error occured during getting user by email %s, error: %v", model.Email, err)
-		return errors.CreateError(400, "duplicate_user")
+		return nil, errors.CreateError(400, "duplicate_user")
 	}
 
 	// perform notification preference validation
 	if model.NotificationPreference == common.NotificationPhone && len(model.Phone) == 0 {
 		log.Infof("Invalid notification preference %s", model.NotificationPreference)
-		return errors.CreateError(400, "Invalid notification preference")
+		return nil, errors.CreateError(400, "Invalid notification preference")
+	}
+
+	if !notification.ValidateSchedule(model.TimeZone, model.QuietHours) {
+		log.Infof("Invalid time zone %s or quiet hours", model.TimeZone)
+		return nil, errors.CreateError(400, "invalid_schedule")
 	}
 
 	//validate client id
 	if len(model.ClientID) == 0 || !bson.IsObjectIdHex(model.ClientID) {
 		log.Infof("Invalid clientid %s", model.ClientID)
-		return errors.CreateError(400, "invalid_client")
+		return nil, errors.CreateError(400, "invalid_client")
 	}
 
 	objID := bson.ObjectIdHex(model.ClientID)
@@ -72,13 +84,29 @@ func (Service *Service) CreateUser(model CreateUserModel) error {
 	err = clientCollection.Find(bson.M{"_id": objID, "status": bson.M{"$ne": common.Archive}}).One(&client)
 	if err != nil {
 		log.Errorf("Error occured while checking for client, error: %v", err)
-		return errors.CreateError(400, "invalid_client")
+		return nil, errors.CreateError(400, "invalid_client")
+	}
+
+	// the groups limit the alert types the site staff is notified about
+	err = notification.ValidateGroups(&client, model.UserGroups, model.SiteUserType)
+	if err != nil {
+		log.Infof("Invalid user groups %v, error: %v", model.UserGroups, err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_user_groups", err.Error())
 	}
 
 	// preparing data
 	id := bson.NewObjectId()
 	user = model.ToUser()
 	user.ID = id
+
+	// the profile picture is uploaded by the creator before the user exists
+	if len(user.ProfileURL) > 0 {
+		picture, err := resolveProfilePicture(user.ProfileURL, currentUserID)
+		if err != nil {
+			return nil, err
+		}
+		user.ProfileVariants = picture.Variants
+	}
 	user.Status = common.Active
 	user.CreatedAt = time.Now().UTC()
 	user.ActivationCode = uuid.New().String()
@@ -113,7 +141,7 @@ func (Service *Service) CreateUser(model CreateUserModel) error {
 
 			if adminCount == 3 {
 				log.Errorf("CSA user limit for client %s reached", model.ClientID)
-				return errors.CreateError(400, "admin user limit reached")
+				return nil, errors.CreateError(400, "admin user limit reached")
 			}
 
 			// update client admin list
@@ -137,14 +165,14 @@ func (Service *Service) CreateUser(model CreateUserModel) error {
 	} else if len(model.SiteUserType) != 0 {
 		if len(model.SiteID) == 0 || !bson.IsObjectIdHex(model.SiteID) {
 			log.Infof("Invalid siteid %s", model.SiteID)
-			return errors.CreateError(400, "invalid_request_data")
+			return nil, errors.CreateError(400, "invalid_request_data")
 		}
 
 		objID := bson.ObjectIdHex(model.SiteID)
 		_, err := siteCollection.Find(bson.M{"_id": objID}).Count()
 		if err != nil {
 			log.Errorf("Error occured while checking for site, error: %v", err)
-			return errors.CreateError(400, "invalid_request_data")
+			return nil, errors.CreateError(400, "invalid_request_data")
 		}
 
 		user.Permission = []common.Permission{common.Permission{
@@ -161,19 +189,19 @@ func (Service *Service) CreateUser(model CreateUserModel) error {
 		err = siteCollection.Update(bson.M{"_id": objID}, bson.M{"$inc": bson.M{"numberOfUsers": 1}})
 		if err != nil {
 			log.Errorf("Error occured while update, error: %v", err)
-			return errors.CreateError(500, "update_site_error")
+			return nil, errors.CreateError(500, "update_site_error")
 		}
 	} else {
 		// checking number of admin
 		contactCount, err := c.Find(bson.M{"clientId": model.ClientID, "permissions.role": "CC"}).Count()
 		if err != nil {
 			log.Errorf("Error occured while checking for CC user count, error: %v", err)
-			return errors.CreateError(500, "server_error")
+			return nil, errors.CreateError(500, "server_error")
 		}
 
 		if contactCount == 10 {
 			log.Errorf("CC user limit for client %s reached", model.ClientID)
-			return errors.CreateError(400, "customer user limit reached")
+			return nil, errors.CreateError(400, "customer user limit reached")
 		}
 
 		// update client contact list
@@ -189,24 +217,124 @@ func (Service *Service) CreateUser(model CreateUserModel) error {
 		}}
 	}
 
+	// everyone but contacts is invited and stays inactive until the invitation is accepted,
+	// addresses which bounced or complained before get no invitation email, the user is marked instead
+	if !bypassEmail {
+		user.Status = common.Inactive
+		user.Invitation = &common.Invitation{
+			Status:    common.InvitationPending,
+			ExpiresAt: user.CreatedAt.Add(getInvitationExpiry()),
+		}
+
+		suppression, err := mail.FindSuppression(user.Email)
+		if err != nil {
+			log.Errorf("Error occured while checking suppression of %s, error: %v", user.Email, err)
+			return nil, errors.CreateError(500, "create_user_error")
+		}
+
+		if suppression != nil {
+			log.Warnf("Not inviting suppressed address %s", user.Email)
+			user.Deliverability = suppression.DeliverabilityOf()
+			user.DeliverabilityReason = suppression.Diagnostic
+			user.DeliverabilityAt = suppression.UpdatedAt
+			bypassEmail = true
+		} else {
+			user.Invitation.SentAt = user.CreatedAt
+			user.Invitation.SentCount = 1
+		}
+	}
+
+	// the invitation is stored before the user, held until the user and the client are written so
+	// it is neither lost nor sent for a user who was never created. When it cannot be stored
+	// the user is kept as not invited to be invited again. Contacts cannot log in, they get no activation email
+	var invitation *outbox.Message
+	if !bypassEmail {
+		log.Infof("Queueing ativation email")
+		invitation, err = queueInvitation(&user, mail.BrandOf(&client), true)
+		if err != nil {
+			log.Errorf("Error occured while queueing activation email to %s, error: %v", user.Email, err)
+			user.Invitation.SentAt = time.Time{}
+			user.Invitation.SentCount = 0
+		}
+	}
+
+	err = c.Insert(&user)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		discardInvitation(invitation)
+		return nil, errors.CreateError(500, "create_user_error")
+	}
+
 	err = clientCollection.Update(bson.M{"_id": objID}, client)
 	if err != nil {
 		log.Errorf("Error occured while update, error: %v", err)
-		return errors.CreateError(500, "update_client_error")
+		removeCreatedUser(c, user.ID)
+		discardInvitation(invitation)
+		return nil, errors.CreateError(500, "update_client_error")
 	}
 
-	err = c.Insert(&user)
+	// a release which fails only delays the invitation until the end of the hold period
+	if invitation != nil {
+		err = outbox.Release(invitation.ID)
+		if err != nil {
+			log.Errorf("Error occured while releasing activation email to %s, error: %v", user.Email, err)
+		}
+	}
+
+	// the user is stored already, failed events are only logged
+	err = webhook.Publish(model.ClientID, webhook.EventUserCreated, map[string]interface{}{
+		"id":         user.ID.Hex(),
+		"email":      user.Email,
+		"firstName":  user.FirstName,
+		"familyName": user.FamilyName,
+		"status":     user.Status,
+		"role":       roleOf(&user),
+	})
 	if err != nil {
-		log.Errorf("Error occured while insert, error: %v", err)
-		return errors.CreateError(500, "create_user_error")
+		log.Errorf("Error occured while publishing %s, error: %v", webhook.EventUserCreated, err)
 	}
 
-	if bypassEmail {
-		log.Infof("Sending ativation email")
-		utils.SendMailViaSES(user.Email, user.ActivationCode)
+	return &user, nil
+}
+
+// queueInvitation stores the invitation email of the user in the outbox, held until released when the user is not written yet
+func queueInvitation(user *common.User, brand mail.Brand, held bool) (*outbox.Message, error) {
+	email := &mail.Email{
+		Template: mail.TemplateInvitation,
+		Locale:   user.Locale,
+		To:       []string{user.Email},
+		Brand:    brand,
+		Data: map[string]interface{}{
+			"FirstName": user.FirstName,
+			"URL":       config.GetConfig().GetString("app.forntend_url") + user.ActivationCode,
+			"ExpiresAt": user.Invitation.ExpiresAt.Format("2006-01-02 15:04 MST"),
+		},
+	}
+	if held {
+		return mail.QueueHeld(email, "user:"+user.ID.Hex())
 	}
 
-	return nil
+	return mail.Queue(email, "user:"+user.ID.Hex())
+}
+
+// discardInvitation drops the held invitation of a user who could not be created
+func discardInvitation(invitation *outbox.Message) {
+	if invitation == nil {
+		return
+	}
+
+	err := outbox.Discard(invitation.ID)
+	if err != nil {
+		log.Errorf("Error occured while discarding invitation %s, error: %v", invitation.ID.Hex(), err)
+	}
+}
+
+// removeCreatedUser undoes the user insert when creating the user fails later on
+func removeCreatedUser(c *mgo.Collection, id bson.ObjectId) {
+	err := c.RemoveId(id)
+	if err != nil {
+		log.Errorf("Error occured while removing user %s, error: %v", id.Hex(), err)
+	}
 }
 
 // SearchUsers godoc
@@ -268,6 +396,14 @@ func (Service *Service) SearchUsers(query *Query, permissions []common.Permissio
 		queryAndPart = append(queryAndPart, bson.M{"permissions.role": query.Role})
 	}
 
+	if len(query.Deliverability) > 0 {
+		queryAndPart = append(queryAndPart, bson.M{"deliverability": query.Deliverability})
+	}
+
+	if len(query.Invitation) > 0 {
+		queryAndPart = append(queryAndPart, invitationQuery(query.Invitation))
+	}
+
 	// applying fuzzy search
 	if len(query.Keyword) > 0 {
 		words := strings.Fields(query.Keyword)
@@ -322,6 +458,10 @@ func (Service *Service) SearchUsers(query *Query, permissions []common.Permissio
 		}
 	}
 
+	for i := range users {
+		refreshInvitation(&users[i])
+	}
+
 	response := common.PagedList{
 		Items: users,
 		Page:  query.PageNumber,
@@ -350,6 +490,7 @@ func (Service *Service) GetUser(id string, permissions []common.Permission, curr
 		}
 		return nil, errors.CreateError(500, "get_by_id_error")
 	}
+	refreshInvitation(&user)
 
 	if id == currentUserID {
 		return &user, nil
@@ -505,6 +646,11 @@ func (Service *Service) UpdateUser(id string, model UpdateUserModel, permissions
 		return nil, errors.CreateError(400, "invalid_data")
 	}
 
+	if !notification.ValidateSchedule(model.TimeZone, model.QuietHours) {
+		log.Infof("Invalid time zone %s or quiet hours", model.TimeZone)
+		return nil, errors.CreateError(400, "invalid_schedule")
+	}
+
 	user := common.User{}
 	objID := bson.ObjectIdHex(id)
 	err := c.Find(bson.M{"_id": objID}).One(&user)
@@ -516,6 +662,9 @@ func (Service *Service) UpdateUser(id string, model UpdateUserModel, permissions
 		if !(p.Role == "SA" || p.Role == "AM") && (len(model.Email) > 0 || len(model.FirstName) > 0 || len(model.FamilyName) > 0) {
 			return nil, errors.CreateError(403, "forbidden_property_Access_error")
 		}
+		if !(p.Role == "SA" || p.Role == "AM" || p.Role == "CSA") && model.UserGroups != nil {
+			return nil, errors.CreateError(403, "forbidden_property_Access_error")
+		}
 	}
 
 	if err != nil {
@@ -526,13 +675,293 @@ func (Service *Service) UpdateUser(id string, model UpdateUserModel, permissions
 		return nil, errors.CreateError(500, "get_by_id_error")
 	}
 
+	// changed groups must be enabled groups of the client,
+	// the rows of the notification matrix for alert types the groups are not notified about are turned off
+	var client *common.Client
+	if model.UserGroups != nil {
+		_, client, err = findUserAndClient(id)
+		if err != nil {
+			return nil, err
+		}
+		err = notification.ValidateGroups(client, model.UserGroups, roleOf(&user))
+		if err != nil {
+			log.Infof("Invalid user groups %v, error: %v", model.UserGroups, err)
+			return nil, errors.CreateErrorWithMsg(400, "invalid_user_groups", err.Error())
+		}
+	}
+
+	profileURL := user.ProfileURL
 	model.ToUser(&user)
+	if model.UserGroups != nil {
+		user.NotificationMatrix = notification.RestrictMatrix(user.NotificationMatrix, notification.AllowedAlertTypes(client, &user))
+	}
+
+	// a changed profile picture must be uploaded by the user or the one updating it
+	if user.ProfileURL != profileURL {
+		picture, err := resolveProfilePicture(user.ProfileURL, id, currentUserID)
+		if err != nil {
+			return nil, err
+		}
+		user.ProfileVariants = picture.Variants
+	}
+
 	err = c.Update(bson.M{"_id": objID}, user)
 
 	if err != nil {
 		log.Errorf("Error occurred during update, error: %v\n", err)
 		return nil, errors.CreateError(500, "update_error")
 	}
+	refreshInvitation(&user)
+
+	return &user, nil
+}
+
+// ResendInvitation godoc
+// sends a new invitation link to a user who did not accept the invitation yet, the previous link stops working
+func (Service *Service) ResendInvitation(id string) (*common.User, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	user := common.User{}
+	objID := bson.ObjectIdHex(id)
+	err := c.Find(bson.M{"_id": objID}).One(&user)
+	if err != nil {
+		log.Errorf("cannot find the user with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_by_id_error")
+	}
+
+	if user.Invitation == nil || user.Invitation.Status == common.InvitationAccepted {
+		log.Infof("User %s has no invitation to resend", id)
+		return nil, errors.CreateError(400, "invitation_not_resendable")
+	}
+
+	suppression, err := mail.FindSuppression(user.Email)
+	if err != nil {
+		log.Errorf("Error occured while checking suppression of %s, error: %v", user.Email, err)
+		return nil, errors.CreateError(500, "send_email_error")
+	}
+	if suppression != nil {
+		log.Infof("Not inviting suppressed address %s", user.Email)
+		return nil, errors.CreateError(400, "email_suppressed")
+	}
+
+	now := time.Now().UTC()
+	user.Status = common.Inactive
+	user.ActivationCode = uuid.New().String()
+	user.UpdatedAt = now
+	user.Invitation = &common.Invitation{
+		Status:    common.InvitationPending,
+		SentCount: user.Invitation.SentCount + 1,
+		SentAt:    now,
+		ExpiresAt: now.Add(getInvitationExpiry()),
+	}
+
+	err = c.Update(bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"status":         user.Status,
+		"activationCode": user.ActivationCode,
+		"invitation":     user.Invitation,
+		"updatedAt":      now,
+	}})
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	_, err = queueInvitation(&user, mail.GetBrand(user.ClientID), false)
+	if err != nil {
+		log.Errorf("Error occured while queueing activation email to %s, error: %v", user.Email, err)
+		return nil, errors.CreateError(500, "send_email_error")
+	}
 
 	return &user, nil
 }
+
+// RevokeInvitation godoc
+// withdraws the invitation of a user who did not accept it yet, the invitation link stops working
+func (Service *Service) RevokeInvitation(id string, currentUserID string) (*common.User, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	now := time.Now().UTC()
+	user := common.User{}
+	_, err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "invitation.status": common.InvitationPending}).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{
+			"activationCode":       "",
+			"invitation.status":    common.InvitationRevoked,
+			"invitation.revokedAt": now,
+			"invitation.revokedBy": currentUserID,
+			"updatedAt":            now,
+		}},
+		ReturnNew: true,
+	}, &user)
+	if err != nil {
+		log.Errorf("cannot revoke the invitation of user with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(400, "invitation_not_revocable")
+		}
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return &user, nil
+}
+
+// GetNotificationMatrix godoc
+// returns the notification matrix of a user with the alert types the groups of the user are notified about
+func (Service *Service) GetNotificationMatrix(id string) (*NotificationMatrixModel, error) {
+	user, client, err := findUserAndClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotificationMatrixModel{
+		Rules:             user.NotificationMatrix,
+		AllowedAlertTypes: notification.AllowedAlertTypes(client, user),
+	}, nil
+}
+
+// UpdateNotificationMatrix godoc
+// replaces the notification matrix of a user, rows for alert types the groups of the user are not notified about are rejected
+func (Service *Service) UpdateNotificationMatrix(id string, model NotificationMatrixModel) (*NotificationMatrixModel, error) {
+	user, client, err := findUserAndClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := notification.AllowedAlertTypes(client, user)
+	err = notification.ValidateMatrix(model.Rules, allowed, user)
+	if err != nil {
+		log.Infof("Invalid notification matrix for user %s, error: %v", id, err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_notification_matrix", err.Error())
+	}
+
+	if model.Rules == nil {
+		model.Rules = []common.NotificationRule{}
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	err = c.Update(bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"notificationMatrix": model.Rules,
+		"updatedAt":          time.Now().UTC(),
+	}})
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return &NotificationMatrixModel{Rules: model.Rules, AllowedAlertTypes: allowed}, nil
+}
+
+// GetDigest godoc
+// returns the alert summary schedule the user chose, an empty frequency follows the default of the role
+func (Service *Service) GetDigest(id string) (*DigestModel, error) {
+	user, _, err := findUserAndClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return digestModelOf(user), nil
+}
+
+// UpdateDigest godoc
+// replaces the alert summary schedule of a user, the first summary is the one of the next period
+func (Service *Service) UpdateDigest(id string, model DigestModel) (*DigestModel, error) {
+	user, _, err := findUserAndClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := common.DigestSchedule{Frequency: model.Frequency, Hour: model.Hour, Weekday: model.Weekday}
+	err = digest.Validate(&schedule)
+	if err != nil {
+		log.Infof("Invalid digest for user %s, error: %v", id, err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_digest", err.Error())
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	now := time.Now().UTC()
+	err = c.Update(bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"digest.frequency":     schedule.Frequency,
+		"digest.hour":          schedule.Hour,
+		"digest.weekday":       schedule.Weekday,
+		"digest.lastPeriodEnd": now,
+		"updatedAt":            now,
+	}})
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	user.Digest = &schedule
+	return digestModelOf(user), nil
+}
+
+// GetShifts godoc
+// returns the working hours of the user, users without shifts are not routed any alerts
+func (Service *Service) GetShifts(id string) (*ShiftsModel, error) {
+	user, _, err := findUserAndClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return shiftsModelOf(user), nil
+}
+
+// UpdateShifts godoc
+// replaces the working hours of a user, empty shifts remove them
+func (Service *Service) UpdateShifts(id string, model ShiftsModel) (*ShiftsModel, error) {
+	user, _, err := findUserAndClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = routing.ValidateShifts(model.Shifts)
+	if err != nil {
+		log.Infof("Invalid shifts for user %s, error: %v", id, err)
+		return nil, errors.CreateErrorWithMsg(400, "invalid_shifts", err.Error())
+	}
+	if model.Shifts == nil {
+		model.Shifts = []common.Shift{}
+	}
+
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+
+	err = c.Update(bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"shifts":    model.Shifts,
+		"updatedAt": time.Now().UTC(),
+	}})
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	user.Shifts = model.Shifts
+	return shiftsModelOf(user), nil
+}
+
+// UnsubscribeDigest godoc
+// turns off the alert summary of the user the unsubscribe token was sent to
+func (Service *Service) UnsubscribeDigest(token string) error {
+	err := digest.Unsubscribe(token)
+	if err != nil {
+		log.Errorf("Error occurred during unsubscribe, error: %v\n", err)
+		if err == mgo.ErrNotFound {
+			return errors.CreateError(404, "not_found")
+		}
+		return errors.CreateError(500, "update_error")
+	}
+
+	return nil
+}
@@ -6,8 +6,14 @@ import (
 	"time"
 
 	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/digest"
 	"anacove.com/backend/errors"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
 	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,10 +27,35 @@ func (model *UpdateUserModel) ToUser(user *common.User) {
 		user.Phone = model.Phone
 	}
 
+	if len(model.Locale) > 0 {
+		user.Locale = model.Locale
+	}
+
+	if len(model.TimeZone) > 0 {
+		user.TimeZone = model.TimeZone
+	}
+
+	// empty quiet hours clear them
+	if model.QuietHours != nil {
+		user.QuietHours = model.QuietHours
+		if len(model.QuietHours.Start) == 0 && len(model.QuietHours.End) == 0 {
+			user.QuietHours = nil
+		}
+	}
+
+	// an empty list takes the user out of every group
+	if model.UserGroups != nil {
+		user.UserGroups = model.UserGroups
+	}
+
 	if len(model.Position) > 0 {
 		user.Position = model.Position
 	}
 
+	if len(model.ProfileURL) > 0 {
+		user.ProfileURL = model.ProfileURL
+	}
+
 	if len(model.SiteGroupName) > 0 {
 		user.SiteGroupName = model.SiteGroupName
 	}
@@ -46,6 +77,55 @@ func (model *CreateUserModel) ToUser() common.User {
 	return user
 }
 
+// resolveProfilePicture checks the profile url references a profile picture uploaded by one of the given users
+func resolveProfilePicture(profileURL string, uploaders ...string) (*common.File, error) {
+	picture, err := utils.GetCommonService().GetFileByURL(profileURL)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			log.Infof("Profile picture %s is not uploaded", profileURL)
+			return nil, errors.CreateError(400, "invalid_profile_picture")
+		}
+		return nil, errors.CreateError(500, "get_file_error")
+	}
+
+	if picture.Purpose != common.FilePurposeProfilePicture || !utils.Contains(uploaders, picture.UploadedBy) {
+		log.Infof("File %s cannot be used as profile picture", profileURL)
+		return nil, errors.CreateError(400, "invalid_profile_picture")
+	}
+
+	return picture, nil
+}
+
+// getInvitationExpiry returns how long an invitation can be accepted
+func getInvitationExpiry() time.Duration {
+	hours := config.GetConfig().GetInt("invitation.expiry_in_hours")
+	if hours <= 0 {
+		return defaultInvitationExpiry
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+// invitationQuery matches the users by invitation status, expired invitations are stored as pending
+func invitationQuery(status string) bson.M {
+	now := time.Now().UTC()
+	switch status {
+	case common.InvitationPending:
+		return bson.M{"invitation.status": common.InvitationPending, "invitation.expiresAt": bson.M{"$gte": now}}
+	case common.InvitationExpired:
+		return bson.M{"invitation.status": common.InvitationPending, "invitation.expiresAt": bson.M{"$lt": now}}
+	default:
+		return bson.M{"invitation.status": status}
+	}
+}
+
+// refreshInvitation shows pending invitations past their expiry as expired
+func refreshInvitation(user *common.User) {
+	if user.Invitation != nil {
+		user.Invitation.Status = utils.InvitationStatus(user.Invitation)
+	}
+}
+
 //PrepareUserSearchQuery will prepare the query model
 func PrepareUserSearchQuery(req *restful.Request) (*Query, error) {
 	query := Query{
@@ -80,8 +160,15 @@ func PrepareUserSearchQuery(req *restful.Request) (*Query, error) {
 	query.Role = req.QueryParameter("role")
 	query.SortBy = req.QueryParameter("sortBy")
 	query.Status = req.QueryParameter("status")
+	query.Deliverability = req.QueryParameter("deliverability")
 	query.Keyword = req.QueryParameter("keyword")
 
+	query.Invitation = req.QueryParameter("invitation")
+	if len(query.Invitation) > 0 && !utils.Contains(invitationStatuses, query.Invitation) {
+		log.Infof("Invalid invitation status %s", query.Invitation)
+		return nil, errors.CreateError(400, "invalid_data")
+	}
+
 	val = req.QueryParameter("sortOrder")
 	if len(val) > 0 {
 		if val == "asc" {
@@ -91,3 +178,69 @@ func PrepareUserSearchQuery(req *restful.Request) (*Query, error) {
 
 	return &query, nil
 }
+
+// findUserAndClient loads a user with the client the user belongs to, users outside of a client get an empty client
+func findUserAndClient(id string) (*common.User, *common.Client, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.UserCollection)
+	clientCollection := session.DB("").C(common.ClientCollection)
+
+	user := common.User{}
+	err := c.FindId(bson.ObjectIdHex(id)).One(&user)
+	if err != nil {
+		log.Errorf("cannot find the user with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, nil, errors.CreateError(404, "not_found")
+		}
+		return nil, nil, errors.CreateError(500, "get_by_id_error")
+	}
+
+	client := common.Client{}
+	if bson.IsObjectIdHex(user.ClientID) {
+		err = clientCollection.FindId(bson.ObjectIdHex(user.ClientID)).One(&client)
+		if err != nil && err != mgo.ErrNotFound {
+			log.Errorf("Error occured while getting client %s, error: %v", user.ClientID, err)
+			return nil, nil, errors.CreateError(500, "get_by_id_error")
+		}
+	}
+
+	return &user, &client, nil
+}
+
+// digestModelOf returns the alert summary schedule of the user with the time zone it is sent in
+func digestModelOf(user *common.User) *DigestModel {
+	model := DigestModel{
+		TimeZone:    notification.Location(user).String(),
+		RoleDefault: digest.RoleDefault(roleOf(user)),
+	}
+	if user.Digest != nil {
+		model.Frequency = user.Digest.Frequency
+		model.Hour = user.Digest.Hour
+		model.Weekday = user.Digest.Weekday
+	}
+
+	return &model
+}
+
+// shiftsModelOf returns the working hours of the user in the time zone they apply in
+func shiftsModelOf(user *common.User) *ShiftsModel {
+	model := ShiftsModel{
+		Shifts:   user.Shifts,
+		TimeZone: notification.Location(user).String(),
+	}
+	if model.Shifts == nil {
+		model.Shifts = []common.Shift{}
+	}
+
+	return &model
+}
+
+// roleOf returns the role of the user, users have one permission
+func roleOf(user *common.User) string {
+	for _, p := range user.Permission {
+		return p.Role
+	}
+
+	return ""
+}
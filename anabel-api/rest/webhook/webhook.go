@@ -0,0 +1,30 @@
+package webhook
+
+// maxWebhooksPerClient limits the webhooks a client can register
+const maxWebhooksPerClient = 10
+
+// CreateWebhookModel godoc
+// This is the webhook create request model definition
+type CreateWebhookModel struct {
+	URL         string   `validate:"required" json:"url"`
+	Description string   `json:"description"`
+	Events      []string `validate:"required" json:"events"`
+}
+
+// UpdateWebhookModel godoc
+// This is the webhook update request model definition, empty properties are kept
+type UpdateWebhookModel struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	Status      string   `json:"status"`
+}
+
+// DeliveryQuery godoc
+// defines the filters of the webhook delivery log search
+type DeliveryQuery struct {
+	PageNumber int
+	PageSize   int
+	Status     string
+	Event      string
+}
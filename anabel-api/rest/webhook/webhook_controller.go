@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller type
+type Controller struct {
+}
+
+// AddRouters allows the endpoints defined in this controller to be added to router
+func (controller Controller) AddRouters(ws *restful.WebService) *restful.WebService {
+	ws.Route(ws.POST("/clients/{clientId}/webhooks").Filter(utils.BearerAuth).To(createWebhook))
+	ws.Route(ws.GET("/clients/{clientId}/webhooks").Filter(utils.BearerAuth).To(searchWebhooks))
+	ws.Route(ws.GET("/clients/{clientId}/webhooks/{id}").Filter(utils.BearerAuth).To(getWebhook))
+	ws.Route(ws.PUT("/clients/{clientId}/webhooks/{id}").Filter(utils.BearerAuth).To(updateWebhook))
+	ws.Route(ws.DELETE("/clients/{clientId}/webhooks/{id}").Filter(utils.BearerAuth).To(deleteWebhook))
+	ws.Route(ws.POST("/clients/{clientId}/webhooks/{id}/rotate-secret").Filter(utils.BearerAuth).To(rotateSecret))
+	ws.Route(ws.GET("/clients/{clientId}/webhooks/{id}/deliveries").Filter(utils.BearerAuth).To(searchDeliveries))
+	ws.Route(ws.POST("/clients/{clientId}/webhooks/{id}/deliveries/{deliveryId}/replay").Filter(utils.BearerAuth).To(replayDelivery))
+	return ws
+}
+
+// createWebhook registers a webhook for the client
+// and returns it with its signing secret if succeeds
+func createWebhook(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	if !authorize(req, resp, clientID) {
+		return
+	}
+
+	request := CreateWebhookModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured while trying to read request model from request, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	// perform model validations
+	err = utils.GetValidator().Struct(request)
+	if err != nil {
+		log.Errorf("Failed validation, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing create webhook")
+	webhook, err := GetService().CreateWebhook(clientID, request, utils.GetUserID(req))
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, webhook)
+}
+
+// searchWebhooks lists the webhooks of the client
+func searchWebhooks(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	if !authorize(req, resp, clientID) {
+		return
+	}
+
+	webhooks, err := GetService().SearchWebhooks(clientID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, webhooks)
+}
+
+// getWebhook find webhook by id
+// and returns webhook if succeeds
+func getWebhook(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	webhook, err := GetService().GetWebhook(clientID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, webhook)
+}
+
+// updateWebhook find webhook by id an update the properties
+// and returns updated webhook if succeeds
+func updateWebhook(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	request := UpdateWebhookModel{}
+	err := req.ReadEntity(&request)
+	if err != nil {
+		log.Errorf("Error occured during getting request data, error: %v", err)
+		utils.WriteError(resp, errors.CreateError(400, "invalid_request_data"))
+		return
+	}
+
+	log.Infof("Performing update webhook")
+	webhook, err := GetService().UpdateWebhook(clientID, id, request)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, webhook)
+}
+
+// deleteWebhook find a webhook by id and delete it
+// and returns nothing if succeeds
+func deleteWebhook(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	err := GetService().DeleteWebhook(clientID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(204, nil)
+}
+
+// rotateSecret replaces the signing secret of a webhook
+// and returns the webhook with the new secret if succeeds
+func rotateSecret(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	log.Infof("Performing rotate webhook secret")
+	webhook, err := GetService().RotateSecret(clientID, id)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, webhook)
+}
+
+// searchDeliveries lists the delivery log of a webhook by status and event
+func searchDeliveries(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	if !authorize(req, resp, clientID, id) {
+		return
+	}
+
+	query, err := PrepareDeliveryQuery(req)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	res, err := GetService().SearchDeliveries(clientID, id, query)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, res)
+}
+
+// replayDelivery sends a delivery of a webhook again
+// and returns the delivery if succeeds
+func replayDelivery(req *restful.Request, resp *restful.Response) {
+	clientID := req.PathParameter("clientId")
+	id := req.PathParameter("id")
+	deliveryID := req.PathParameter("deliveryId")
+	if !authorize(req, resp, clientID, id, deliveryID) {
+		return
+	}
+
+	log.Infof("Performing replay webhook delivery")
+	delivery, err := GetService().ReplayDelivery(clientID, id, deliveryID)
+	if err != nil {
+		utils.WriteError(resp, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(200, delivery)
+}
@@ -0,0 +1,345 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	hooks "anacove.com/backend/webhook"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service godoc
+// defines the webhook subscriptions of the clients and their delivery log
+type Service struct {
+}
+
+// ServiceInstance Service instance
+var ServiceInstance *Service
+
+// ServiceMu mutex for webhook service
+var ServiceMu sync.Mutex
+
+// GetService returns the singleton instance of the Service
+func GetService() *Service {
+	ServiceMu.Lock()
+	defer ServiceMu.Unlock()
+
+	if ServiceInstance == nil {
+		ServiceInstance = &Service{}
+	}
+
+	return ServiceInstance
+}
+
+// CreateWebhook godoc
+// registers a webhook for the client, the signing secret is only returned here and when it is rotated
+func (Service *Service) CreateWebhook(clientID string, model CreateWebhookModel, currentUserID string) (*hooks.Webhook, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookCollection)
+	clientCollection := session.DB("").C(common.ClientCollection)
+
+	if !hooks.ValidateURL(model.URL) {
+		log.Infof("Invalid webhook url %s", model.URL)
+		return nil, errors.CreateError(400, "invalid_url")
+	}
+	if !hooks.ValidateEvents(model.Events) {
+		log.Infof("Invalid webhook events %v", model.Events)
+		return nil, errors.CreateError(400, "invalid_events")
+	}
+
+	count, err := clientCollection.Find(bson.M{"_id": bson.ObjectIdHex(clientID), "status": bson.M{"$ne": common.Archive}}).Count()
+	if err != nil || count == 0 {
+		log.Errorf("Error occured while checking for client %s, error: %v", clientID, err)
+		return nil, errors.CreateError(400, "invalid_client")
+	}
+
+	count, err = c.Find(bson.M{"clientId": clientID}).Count()
+	if err != nil {
+		log.Errorf("Error occured while counting webhooks, error: %v", err)
+		return nil, errors.CreateError(500, "server_error")
+	}
+	if count >= maxWebhooksPerClient {
+		log.Errorf("Webhook limit for client %s reached", clientID)
+		return nil, errors.CreateError(400, "webhook limit reached")
+	}
+
+	secret, err := hooks.GenerateSecret()
+	if err != nil {
+		log.Errorf("Error occured while generating webhook secret, error: %v", err)
+		return nil, errors.CreateError(500, "create_webhook_error")
+	}
+
+	now := time.Now().UTC()
+	webhook := hooks.Webhook{
+		ID:          bson.NewObjectId(),
+		ClientID:    clientID,
+		URL:         model.URL,
+		Description: model.Description,
+		Events:      model.Events,
+		Secret:      secret,
+		Status:      hooks.StatusActive,
+		CreatedBy:   currentUserID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err = c.Insert(&webhook)
+	if err != nil {
+		log.Errorf("Error occured while insert, error: %v", err)
+		return nil, errors.CreateError(500, "create_webhook_error")
+	}
+
+	return &webhook, nil
+}
+
+// SearchWebhooks godoc
+// lists the webhooks of the client without their secrets
+func (Service *Service) SearchWebhooks(clientID string) ([]hooks.Webhook, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookCollection)
+
+	webhooks := []hooks.Webhook{}
+	err := c.Find(bson.M{"clientId": clientID}).Sort("createdAt").All(&webhooks)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhook godoc
+// Find the webhook of the client by id, without its secret
+func (Service *Service) GetWebhook(clientID string, id string) (*hooks.Webhook, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookCollection)
+
+	webhook, err := findWebhook(c, clientID, id)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+
+	return webhook, nil
+}
+
+// UpdateWebhook godoc
+// changes the url, description, events or status of a webhook, enabling it again forgets the failures
+func (Service *Service) UpdateWebhook(clientID string, id string, model UpdateWebhookModel) (*hooks.Webhook, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookCollection)
+
+	webhook, err := findWebhook(c, clientID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"updatedAt": now}
+	if len(model.URL) > 0 {
+		if !hooks.ValidateURL(model.URL) {
+			log.Infof("Invalid webhook url %s", model.URL)
+			return nil, errors.CreateError(400, "invalid_url")
+		}
+		set["url"] = model.URL
+	}
+	if model.Events != nil {
+		if !hooks.ValidateEvents(model.Events) {
+			log.Infof("Invalid webhook events %v", model.Events)
+			return nil, errors.CreateError(400, "invalid_events")
+		}
+		set["events"] = model.Events
+	}
+	if len(model.Description) > 0 {
+		set["description"] = model.Description
+	}
+
+	switch model.Status {
+	case "":
+	case hooks.StatusActive:
+		if webhook.Status != hooks.StatusActive {
+			set["status"] = hooks.StatusActive
+			set["consecutiveFailures"] = 0
+			set["disabledReason"] = ""
+		}
+	case hooks.StatusDisabled:
+		if webhook.Status != hooks.StatusDisabled {
+			set["status"] = hooks.StatusDisabled
+			set["disabledReason"] = "disabled by user"
+			set["disabledAt"] = now
+		}
+	default:
+		log.Infof("Invalid webhook status %s", model.Status)
+		return nil, errors.CreateError(400, "invalid_status")
+	}
+
+	updated := hooks.Webhook{}
+	_, err = c.FindId(webhook.ID).Apply(mgo.Change{Update: bson.M{"$set": set}, ReturnNew: true}, &updated)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+	updated.Secret = ""
+
+	return &updated, nil
+}
+
+// DeleteWebhook godoc
+// removes the webhook with its delivery log, queued deliveries are dropped
+func (Service *Service) DeleteWebhook(clientID string, id string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookCollection)
+	deliveryCollection := session.DB("").C(common.WebhookDeliveryCollection)
+
+	webhook, err := findWebhook(c, clientID, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.RemoveId(webhook.ID)
+	if err != nil {
+		log.Errorf("Error occurred during delete, error: %v\n", err)
+		return errors.CreateError(500, "delete_error")
+	}
+
+	_, err = deliveryCollection.RemoveAll(bson.M{"webhookId": id})
+	if err != nil {
+		log.Errorf("Error occurred during delete of the deliveries of webhook %s, error: %v\n", id, err)
+	}
+
+	return nil
+}
+
+// RotateSecret godoc
+// replaces the signing secret of the webhook, deliveries are signed with the new secret from the next attempt on
+func (Service *Service) RotateSecret(clientID string, id string) (*hooks.Webhook, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookCollection)
+
+	webhook, err := findWebhook(c, clientID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := hooks.GenerateSecret()
+	if err != nil {
+		log.Errorf("Error occured while generating webhook secret, error: %v", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	updated := hooks.Webhook{}
+	_, err = c.FindId(webhook.ID).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"secret": secret, "updatedAt": time.Now().UTC()}},
+		ReturnNew: true,
+	}, &updated)
+	if err != nil {
+		log.Errorf("Error occurred during update, error: %v\n", err)
+		return nil, errors.CreateError(500, "update_error")
+	}
+
+	return &updated, nil
+}
+
+// SearchDeliveries godoc
+// lists the delivery log of the webhook, the latest first
+func (Service *Service) SearchDeliveries(clientID string, id string, query *DeliveryQuery) (*common.PagedList, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookDeliveryCollection)
+
+	_, err := findWebhook(session.DB("").C(common.WebhookCollection), clientID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	dbQuery := bson.M{"webhookId": id}
+	if len(query.Status) > 0 {
+		dbQuery["status"] = query.Status
+	}
+	if len(query.Event) > 0 {
+		dbQuery["event"] = query.Event
+	}
+
+	count, err := c.Find(dbQuery).Count()
+	if err != nil {
+		log.Errorf("error occured during getting count: error: %v\n", err)
+		return nil, errors.CreateError(500, "query_execute_error")
+	}
+
+	deliveries := []hooks.Delivery{}
+	err = c.Find(dbQuery).Sort("-createdAt").Skip(query.PageSize * (query.PageNumber - 1)).Limit(query.PageSize).All(&deliveries)
+	if err != nil {
+		log.Errorf("error occured during perform search: error: %v\n", err)
+		return nil, errors.CreateError(500, "search_error")
+	}
+
+	return &common.PagedList{
+		Items: deliveries,
+		Page:  query.PageNumber,
+		Size:  query.PageSize,
+		Total: count,
+	}, nil
+}
+
+// ReplayDelivery godoc
+// sends a delivery of the webhook again with the same event id, the webhook must be active
+func (Service *Service) ReplayDelivery(clientID string, id string, deliveryID string) (*hooks.Delivery, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.WebhookDeliveryCollection)
+
+	_, err := findWebhook(session.DB("").C(common.WebhookCollection), clientID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := c.Find(bson.M{"_id": bson.ObjectIdHex(deliveryID), "webhookId": id}).Count()
+	if err != nil {
+		log.Errorf("cannot find the delivery with id: %s, error: %v\n", deliveryID, err)
+		return nil, errors.CreateError(500, "get_delivery_error")
+	}
+	if count == 0 {
+		return nil, errors.CreateError(404, "not_found")
+	}
+
+	delivery, err := hooks.Replay(deliveryID)
+	if err != nil {
+		log.Errorf("cannot replay the delivery with id: %s, error: %v\n", deliveryID, err)
+		if err == hooks.ErrWebhookDisabled {
+			return nil, errors.CreateError(400, "webhook_disabled")
+		}
+		return nil, errors.CreateError(500, "replay_delivery_error")
+	}
+
+	return delivery, nil
+}
+
+// findWebhook loads the webhook when it belongs to the client
+func findWebhook(c *mgo.Collection, clientID string, id string) (*hooks.Webhook, error) {
+	webhook := hooks.Webhook{}
+	err := c.Find(bson.M{"_id": bson.ObjectIdHex(id), "clientId": clientID}).One(&webhook)
+	if err != nil {
+		log.Errorf("cannot find the webhook with id: %s, error: %v\n", id, err)
+		if err == mgo.ErrNotFound {
+			return nil, errors.CreateError(404, "not_found")
+		}
+		return nil, errors.CreateError(500, "get_webhook_error")
+	}
+
+	return &webhook, nil
+}
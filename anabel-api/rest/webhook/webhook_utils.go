@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"strconv"
+
+	"anacove.com/backend/errors"
+	"anacove.com/backend/utils"
+	"github.com/emicklei/go-restful"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+// PrepareDeliveryQuery reads the delivery search filters from the query parameters
+func PrepareDeliveryQuery(req *restful.Request) (*DeliveryQuery, error) {
+	query := DeliveryQuery{
+		PageNumber: 1,
+		PageSize:   20,
+		Status:     req.QueryParameter("status"),
+		Event:      req.QueryParameter("event"),
+	}
+
+	val := req.QueryParameter("pageNumber")
+	if val != "" {
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 1 {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+
+		query.PageNumber = i
+	}
+
+	val = req.QueryParameter("pageSize")
+	if val != "" {
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 1 {
+			log.Errorf("error occurred during conversion: error: %v\n", err)
+			return nil, errors.CreateError(400, "invalid_data")
+		}
+
+		query.PageSize = i
+	}
+
+	return &query, nil
+}
+
+// authorize lets the admins of the client manage its webhooks, it writes the error response otherwise
+func authorize(req *restful.Request, resp *restful.Response, clientID string, ids ...string) bool {
+	for _, id := range append([]string{clientID}, ids...) {
+		if !bson.IsObjectIdHex(id) {
+			log.Infof("invalid path id %s", id)
+			utils.WriteError(resp, errors.CreateError(400, "invalid_path_data"))
+			return false
+		}
+	}
+
+	//Check weather user has permission to perform this operation
+	if !utils.HasRole(req, "SA", "AM", "CSA") {
+		log.Infof("User not authorized")
+		utils.WriteError(resp, errors.CreateError(401, "Not Authorized"))
+		return false
+	}
+
+	//Check weather user has permission to the resource
+	if !utils.CanAccessResource(req, "client", clientID) {
+		log.Infof("User access forbidden for client id %s", clientID)
+		utils.WriteError(resp, errors.CreateError(403, "Forbidden"))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,409 @@
+package routing
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StrategyRoundRobin assigns the users of the rule in turn
+	StrategyRoundRobin = "roundRobin"
+	// StrategyLeastLoaded assigns the user with the fewest Active alerts, in turn when several have as few
+	StrategyLeastLoaded = "leastLoaded"
+)
+
+// Strategies lists the strategies a rule can pick the assignee with
+var Strategies = []string{StrategyRoundRobin, StrategyLeastLoaded}
+
+// Roles lists the roles of the site team alerts are assigned to
+var Roles = []string{"SM", "SU"}
+
+// defaultPollInterval is used when routing.poll_interval_in_seconds is not configured
+const defaultPollInterval = 15 * time.Second
+
+// Rule godoc
+// assigns the New alerts of a site matching the alert types and rooms, floors and buildings to an on shift,
+// active user of the site team in one of the user groups. Empty lists match everything, rules apply in their order
+type Rule struct {
+	ID             bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID       string        `json:"clientId" bson:"clientId"`
+	SiteID         string        `json:"siteId" bson:"siteId"`
+	Name           string        `json:"name" bson:"name"`
+	Order          int           `json:"order" bson:"order"`
+	AlertTypes     []string      `json:"alertTypes" bson:"alertTypes"`
+	Rooms          []string      `json:"rooms" bson:"rooms"`
+	Floors         []string      `json:"floors" bson:"floors"`
+	Buildings      []string      `json:"buildings" bson:"buildings"`
+	UserGroups     []string      `json:"userGroups" bson:"userGroups"`
+	Strategy       string        `json:"strategy" bson:"strategy"`
+	Enabled        bool          `json:"enabled" bson:"enabled"`
+	LastAssignedID string        `json:"lastAssignedId" bson:"lastAssignedId"`
+	CreatedBy      string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt      time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// site holds what routing needs to know about a site, where its rooms are and the user groups of its team
+type site struct {
+	ID    bson.ObjectId `bson:"_id"`
+	Name  string        `bson:"name"`
+	Team  []string      `bson:"team"`
+	Rooms []struct {
+		Room     int    `bson:"room"`
+		Floor    string `bson:"floor"`
+		Building string `bson:"building"`
+	} `bson:"rooms"`
+	DetailTeam []struct {
+		ID            bson.ObjectId `bson:"id"`
+		SiteUserGroup []string      `bson:"siteUserGroup"`
+	} `bson:"detailTeam"`
+}
+
+// Init creates the indexes used to find the rules of a site and the alerts to route
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.RoutingRuleCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "order"}})
+	if err == nil {
+		err = session.DB("").C(common.AlertCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "status"}})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create routing indexes, error: %v", err)
+	}
+}
+
+// Start runs the routing of the New alerts in the background,
+// every instance of the api can run one as an alert is only assigned while it is still New and not routed
+func Start() {
+	interval := defaultPollInterval
+	if seconds := config.GetConfig().GetInt("routing.poll_interval_in_seconds"); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(time.Now().UTC())
+		}
+	}()
+}
+
+// Validate checks the strategy and alert types are known
+func (rule *Rule) Validate() error {
+	if len(rule.Name) == 0 {
+		return errors.New("a rule needs a name")
+	}
+	if !utils.Contains(Strategies, rule.Strategy) {
+		return errors.New("unknown strategy " + rule.Strategy)
+	}
+	for _, alertType := range rule.AlertTypes {
+		if !utils.Contains(notification.AlertTypes, alertType) {
+			return errors.New("unknown alert type " + alertType)
+		}
+	}
+
+	return nil
+}
+
+// Matches checks the rule applies to the alert in the room on the floor and in the building,
+// rules only route the alerts raised after they were created
+func (rule *Rule) Matches(alert *common.Alert, floor string, building string) bool {
+	return rule.Enabled && !alert.AlertTime.Before(rule.CreatedAt) &&
+		(len(rule.AlertTypes) == 0 || utils.Contains(rule.AlertTypes, alert.Type)) &&
+		(len(rule.Rooms) == 0 || utils.Contains(rule.Rooms, alert.Location)) &&
+		(len(rule.Floors) == 0 || utils.Contains(rule.Floors, floor)) &&
+		(len(rule.Buildings) == 0 || utils.Contains(rule.Buildings, building))
+}
+
+// run routes the New alerts of every site with enabled rules
+func run(now time.Time) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	rules := []Rule{}
+	err := session.DB("").C(common.RoutingRuleCollection).Find(bson.M{"enabled": true}).Sort("siteId", "order", "createdAt").All(&rules)
+	if err != nil {
+		log.Errorf("Failed to load the routing rules, error: %v", err)
+		return
+	}
+
+	for start := 0; start < len(rules); {
+		end := start + 1
+		for end < len(rules) && rules[end].SiteID == rules[start].SiteID {
+			end++
+		}
+		err = routeSite(session, rules[start:end], now)
+		if err != nil {
+			log.Errorf("Failed to route the alerts of site %s, error: %v", rules[start].SiteID, err)
+		}
+		start = end
+	}
+}
+
+// routeSite assigns the New alerts of the site which were not routed yet, an alert no user is available for
+// stays New and is tried again on the next run
+func routeSite(session *mgo.Session, rules []Rule, now time.Time) error {
+	siteID := rules[0].SiteID
+	if !bson.IsObjectIdHex(siteID) {
+		return nil
+	}
+
+	since := rules[0].CreatedAt
+	for _, rule := range rules {
+		if rule.CreatedAt.Before(since) {
+			since = rule.CreatedAt
+		}
+	}
+
+	// alerts are routed once deduplication kept them
+	alerts := []common.Alert{}
+	err := session.DB("").C(common.AlertCollection).Find(bson.M{
+		"siteId":      siteID,
+		"status":      common.AlertStatusNew,
+		"routedAt":    bson.M{"$exists": false},
+		"alertTime":   bson.M{"$gte": since},
+		"fingerprint": bson.M{"$exists": true},
+	}).Sort("alertTime").All(&alerts)
+	if err != nil || len(alerts) == 0 {
+		return err
+	}
+
+	s := site{}
+	err = session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).One(&s)
+	if err != nil {
+		return err
+	}
+	users, err := teamOf(session, &s)
+	if err != nil {
+		return err
+	}
+	loads, err := loadsOf(session, users)
+	if err != nil {
+		return err
+	}
+
+	for i := range alerts {
+		alert := &alerts[i]
+		floor, building := placeOf(&s, alert.Location)
+		for j := range rules {
+			rule := &rules[j]
+			if !rule.Matches(alert, floor, building) {
+				continue
+			}
+
+			user := pick(rule, available(rule, &s, users, now), loads)
+			if user == nil {
+				log.Infof("No user of rule %s is available for alert %s", rule.ID.Hex(), alert.ID.Hex())
+				continue
+			}
+
+			err = assign(session, alert, rule, user, now)
+			if err != nil {
+				log.Errorf("Failed to assign alert %s to user %s, error: %v", alert.ID.Hex(), user.ID.Hex(), err)
+			}
+			loads[user.ID.Hex()]++
+			rule.LastAssignedID = user.ID.Hex()
+			break
+		}
+	}
+
+	return nil
+}
+
+// teamOf returns the active users of the site team, the site managers and users scoped to the site
+// and the members listed on the site
+func teamOf(session *mgo.Session, s *site) ([]common.User, error) {
+	ids := []bson.ObjectId{}
+	for _, id := range s.Team {
+		if bson.IsObjectIdHex(id) {
+			ids = append(ids, bson.ObjectIdHex(id))
+		}
+	}
+	for _, member := range s.DetailTeam {
+		if member.ID.Valid() {
+			ids = append(ids, member.ID)
+		}
+	}
+
+	users := []common.User{}
+	err := session.DB("").C(common.UserCollection).Find(bson.M{
+		"status":           common.Active,
+		"permissions.role": bson.M{"$in": Roles},
+		"$or": []bson.M{
+			{"permissions.scopes.ids": s.ID.Hex()},
+			{"_id": bson.M{"$in": ids}},
+		},
+	}).Sort("_id").All(&users)
+
+	return users, err
+}
+
+// loadsOf counts the Active alerts assigned to each user
+func loadsOf(session *mgo.Session, users []common.User) (map[string]int, error) {
+	ids := []string{}
+	for _, user := range users {
+		ids = append(ids, user.ID.Hex())
+	}
+
+	counts := []struct {
+		ID    string `bson:"_id"`
+		Count int    `bson:"count"`
+	}{}
+	err := session.DB("").C(common.AlertCollection).Pipe([]bson.M{
+		{"$match": bson.M{"status": common.AlertStatusActive, "assignedTo.id": bson.M{"$in": ids}}},
+		{"$unwind": "$assignedTo"},
+		{"$match": bson.M{"assignedTo.id": bson.M{"$in": ids}}},
+		{"$group": bson.M{"_id": "$assignedTo.id", "count": bson.M{"$sum": 1}}},
+	}).All(&counts)
+	if err != nil {
+		return nil, err
+	}
+
+	loads := map[string]int{}
+	for _, count := range counts {
+		loads[count.ID] = count.Count
+	}
+
+	return loads, nil
+}
+
+// placeOf returns the floor and building of the room of the alert, empty when the site does not list the room
+func placeOf(s *site, location string) (string, string) {
+	for _, room := range s.Rooms {
+		if strconv.Itoa(room.Room) == location {
+			return room.Floor, room.Building
+		}
+	}
+
+	return "", ""
+}
+
+// available returns the users of the rule who are on shift, in one of its user groups when it has any.
+// The group of a user is the site user group of the user or the ones the site lists for the user
+func available(rule *Rule, s *site, users []common.User, now time.Time) []common.User {
+	result := []common.User{}
+	for _, user := range users {
+		if !OnShift(&user, now) {
+			continue
+		}
+		if len(rule.UserGroups) > 0 && !inGroups(rule.UserGroups, s, &user) {
+			continue
+		}
+		result = append(result, user)
+	}
+
+	return result
+}
+
+// inGroups checks the user belongs to one of the user groups at the site
+func inGroups(groups []string, s *site, user *common.User) bool {
+	if utils.Contains(groups, user.SiteGroupName) {
+		return true
+	}
+	for _, member := range s.DetailTeam {
+		if member.ID != user.ID {
+			continue
+		}
+		for _, group := range member.SiteUserGroup {
+			if utils.Contains(groups, group) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// pick returns the user the strategy of the rule assigns next, nil when no user is available.
+// Users take turns after the one assigned last, least loaded only considers the ones with the fewest Active alerts
+func pick(rule *Rule, users []common.User, loads map[string]int) *common.User {
+	if len(users) == 0 {
+		return nil
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID.Hex() < users[j].ID.Hex()
+	})
+	next := 0
+	for i, user := range users {
+		if user.ID.Hex() > rule.LastAssignedID {
+			next = i
+			break
+		}
+	}
+	turns := append(append([]common.User{}, users[next:]...), users[:next]...)
+
+	best := &turns[0]
+	if rule.Strategy == StrategyLeastLoaded {
+		for i := range turns {
+			if loads[turns[i].ID.Hex()] < loads[best.ID.Hex()] {
+				best = &turns[i]
+			}
+		}
+	}
+
+	return best
+}
+
+// assign makes the user the assignee of the alert while it is still New and not routed,
+// then records the turn on the rule, the notification listener tells the user
+func assign(session *mgo.Session, alert *common.Alert, rule *Rule, user *common.User, now time.Time) error {
+	assigned := *alert
+	assigned.Status = common.AlertStatusActive
+	assigned.AssignedTo = []common.SimpleUser{{
+		ID:         user.ID.Hex(),
+		Email:      user.Email,
+		FirstName:  user.FirstName,
+		FamilyName: user.FamilyName,
+		ProfileURL: user.ProfileURL,
+	}}
+	assigned.AssignTime = now
+	assigned.RoutingRuleID = rule.ID.Hex()
+	assigned.RoutedAt = now
+	assigned.UpdatedAt = now
+	if !alert.AlertTime.IsZero() {
+		assigned.JobAge = now.Sub(alert.AlertTime).Seconds()
+	}
+
+	err := session.DB("").C(common.AlertCollection).Update(bson.M{
+		"_id":      alert.ID,
+		"status":   common.AlertStatusNew,
+		"routedAt": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{
+		"status":        assigned.Status,
+		"assignedTo":    assigned.AssignedTo,
+		"assginTime":    assigned.AssignTime,
+		"routingRuleId": assigned.RoutingRuleID,
+		"routedAt":      assigned.RoutedAt,
+		"jobAge":        assigned.JobAge,
+		"updatedAt":     assigned.UpdatedAt,
+	}})
+	if err == mgo.ErrNotFound {
+		// the alert was assigned by hand or by another instance
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = session.DB("").C(common.RoutingRuleCollection).UpdateId(rule.ID, bson.M{"$set": bson.M{"lastAssignedId": user.ID.Hex()}})
+	if err != nil {
+		log.Errorf("Failed to record the turn of rule %s, error: %v", rule.ID.Hex(), err)
+	}
+
+	log.Infof("Routed alert %s to user %s by rule %s", alert.ID.Hex(), user.ID.Hex(), rule.ID.Hex())
+	alerting.Emit(&alerting.Event{Type: alerting.EventAssigned, Alert: &assigned, Previous: alert, At: now})
+	return nil
+}
@@ -0,0 +1,84 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+)
+
+func TestOnShift(t *testing.T) {
+	config.SetConfig(viper.New())
+	user := &common.User{TimeZone: "Asia/Tokyo", Shifts: []common.Shift{
+		{Weekdays: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:00"},
+		{Weekdays: []int{5}, Start: "22:00", End: "06:00"},
+	}}
+
+	for at, onShift := range map[string]bool{
+		"2026-10-19T00:00:00Z": true,  // monday 09:00 in Tokyo
+		"2026-10-19T07:59:00Z": true,  // monday 16:59
+		"2026-10-19T08:00:00Z": false, // monday 17:00
+		"2026-10-18T02:00:00Z": false, // sunday 11:00
+		"2026-10-23T13:30:00Z": true,  // friday 22:30
+		"2026-10-23T20:00:00Z": true,  // saturday 05:00 after the friday night shift
+		"2026-10-23T21:00:00Z": false, // saturday 06:00
+		"2026-10-19T20:00:00Z": false, // tuesday 05:00, the night shift is on fridays only
+	} {
+		parsed, _ := time.Parse(time.RFC3339, at)
+		if OnShift(user, parsed) != onShift {
+			t.Errorf("on shift at %s should be %v", at, onShift)
+		}
+	}
+
+	if OnShift(&common.User{}, time.Now()) {
+		t.Error("a user without shifts is on shift")
+	}
+}
+
+func TestPick(t *testing.T) {
+	users := []common.User{
+		{ID: bson.ObjectIdHex("000000000000000000000003"), Email: "c"},
+		{ID: bson.ObjectIdHex("000000000000000000000001"), Email: "a"},
+		{ID: bson.ObjectIdHex("000000000000000000000002"), Email: "b"},
+	}
+
+	for _, test := range []struct {
+		name     string
+		rule     Rule
+		loads    map[string]int
+		assignee string
+	}{
+		{"round robin starts with the first", Rule{Strategy: StrategyRoundRobin}, nil, "a"},
+		{"round robin takes the next turn", Rule{Strategy: StrategyRoundRobin, LastAssignedID: "000000000000000000000001"}, nil, "b"},
+		{"round robin starts over", Rule{Strategy: StrategyRoundRobin, LastAssignedID: "000000000000000000000003"}, nil, "a"},
+		{
+			"least loaded",
+			Rule{Strategy: StrategyLeastLoaded},
+			map[string]int{"000000000000000000000001": 3, "000000000000000000000002": 1, "000000000000000000000003": 2},
+			"b",
+		},
+		{
+			"least loaded takes turns among the least loaded",
+			Rule{Strategy: StrategyLeastLoaded, LastAssignedID: "000000000000000000000002"},
+			map[string]int{"000000000000000000000001": 1, "000000000000000000000002": 1, "000000000000000000000003": 1},
+			"c",
+		},
+	} {
+		user := pick(&test.rule, append([]common.User{}, users...), test.loads)
+		if user == nil || user.Email != test.assignee {
+			t.Errorf("%s: assigned %+v instead of %s", test.name, user, test.assignee)
+		}
+	}
+
+	// the turn of a user who is no longer available goes to the next one
+	if user := pick(&Rule{Strategy: StrategyRoundRobin, LastAssignedID: "000000000000000000000001"}, []common.User{users[0]}, nil); user == nil || user.Email != "c" {
+		t.Errorf("assigned %+v instead of c", user)
+	}
+
+	if user := pick(&Rule{Strategy: StrategyRoundRobin}, nil, nil); user != nil {
+		t.Errorf("assigned %+v without users", user)
+	}
+}
@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"errors"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/notification"
+)
+
+// clockLayout is the HH:MM layout of the shift start and end
+const clockLayout = "15:04"
+
+// maxShifts limits the shifts of a user
+const maxShifts = 21
+
+// ValidateShifts checks the weekdays are sunday (0) to saturday (6) and the start and end are HH:MM clock times
+func ValidateShifts(shifts []common.Shift) error {
+	if len(shifts) > maxShifts {
+		return errors.New("too many shifts")
+	}
+
+	for _, shift := range shifts {
+		if len(shift.Weekdays) == 0 {
+			return errors.New("a shift needs weekdays")
+		}
+		for _, weekday := range shift.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return errors.New("the weekday must be between 0 and 6")
+			}
+		}
+		start, err := parseClock(shift.Start)
+		if err != nil {
+			return errors.New("the start must be a HH:MM time")
+		}
+		end, err := parseClock(shift.End)
+		if err != nil {
+			return errors.New("the end must be a HH:MM time")
+		}
+		if start == end {
+			return errors.New("a shift must end at another time than it starts")
+		}
+	}
+
+	return nil
+}
+
+// OnShift checks the time falls into a shift of the user in the time zone of the user,
+// a shift ending before it starts runs past midnight into the next day
+func OnShift(user *common.User, at time.Time) bool {
+	local := at.In(notification.Location(user))
+	minute := local.Hour()*60 + local.Minute()
+	today := int(local.Weekday())
+	yesterday := (today + 6) % 7
+
+	for _, shift := range user.Shifts {
+		start, err := parseClock(shift.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseClock(shift.End)
+		if err != nil {
+			continue
+		}
+
+		for _, weekday := range shift.Weekdays {
+			if start < end && weekday == today && minute >= start && minute < end {
+				return true
+			}
+			if start > end && ((weekday == today && minute >= start) || (weekday == yesterday && minute < end)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseClock returns the minute of the day of an HH:MM clock time
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse(clockLayout, clock)
+	if err != nil {
+		return 0, err
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
@@ -0,0 +1,275 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"anacove.com/backend/utils"
+)
+
+// Reading godoc
+// is the latest reading of a metric of a device, since is when it entered its state.
+// Raised lists the rules which raised an alert since their condition started to hold
+type Reading struct {
+	ID          string    `json:"-" bson:"_id"`
+	SiteID      string    `json:"siteId" bson:"siteId"`
+	Device      string    `json:"device" bson:"device"`
+	DeviceModel string    `json:"deviceModel" bson:"deviceModel"`
+	Location    string    `json:"location" bson:"location"`
+	Metric      string    `json:"metric" bson:"metric"`
+	Value       float64   `json:"value" bson:"value"`
+	State       string    `json:"state" bson:"state"`
+	Since       time.Time `json:"since" bson:"since"`
+	At          time.Time `json:"at" bson:"at"`
+	Raised      []string  `json:"raised" bson:"raised"`
+}
+
+// Match godoc
+// is an alert a rule raises on a reading, at is when the condition of the rule started to hold
+type Match struct {
+	RuleID      string    `json:"ruleId"`
+	RuleName    string    `json:"ruleName"`
+	Type        string    `json:"type"`
+	Priority    string    `json:"priority"`
+	Device      string    `json:"device"`
+	DeviceModel string    `json:"deviceModel"`
+	Location    string    `json:"location"`
+	Description string    `json:"description"`
+	At          time.Time `json:"at"`
+}
+
+// Engine evaluates the enabled rules of a site on the readings of its devices, it keeps the latest reading of every metric
+type Engine struct {
+	rules    []Rule
+	options  map[string]Option
+	readings map[string]*Reading
+	keys     []string
+	changed  map[string]bool
+	at       time.Time
+}
+
+// NewEngine starts the evaluation from the latest readings of the site
+func NewEngine(rules []Rule, options []Option, readings []Reading) *Engine {
+	engine := Engine{options: map[string]Option{}, readings: map[string]*Reading{}, changed: map[string]bool{}}
+	for _, rule := range rules {
+		if rule.Enabled {
+			engine.rules = append(engine.rules, rule)
+		}
+	}
+	for _, option := range options {
+		engine.options[option.Label] = option
+	}
+	for i := range readings {
+		reading := readings[i]
+		engine.readings[reading.ID] = &reading
+		engine.keys = append(engine.keys, reading.ID)
+		if reading.At.After(engine.at) {
+			engine.at = reading.At
+		}
+	}
+
+	return &engine
+}
+
+// Simulate evaluates the rules on the events in the order of their time from no readings on, the states lasting
+// until the end raise too. The end is the time of the last event when it is zero, nothing is stored
+func Simulate(rules []Rule, options []Option, events []Event, until time.Time) []Match {
+	sorted := append([]Event{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].At.Before(sorted[j].At) })
+
+	engine := NewEngine(rules, options, nil)
+	matches := []Match{}
+	for i := range sorted {
+		matches = append(matches, engine.Observe(&sorted[i])...)
+	}
+	if until.IsZero() && len(sorted) > 0 {
+		until = sorted[len(sorted)-1].At
+	}
+
+	return append(matches, engine.Tick(until)...)
+}
+
+// Observe applies the event to the latest reading of its device and metric and returns the alerts the rules raise on it,
+// the states which lasted long enough before the event raise first. Readings older than the latest one are ignored
+func (engine *Engine) Observe(event *Event) []Match {
+	matches := engine.Tick(event.At)
+
+	key := strings.Join([]string{event.SiteID, event.Device, event.Metric}, "\x00")
+	reading, ok := engine.readings[key]
+	if !ok {
+		reading = &Reading{ID: key, SiteID: event.SiteID, Device: event.Device, Metric: event.Metric, Raised: []string{}}
+		engine.readings[key] = reading
+		engine.keys = append(engine.keys, key)
+	}
+	if ok && event.At.Before(reading.At) {
+		return matches
+	}
+
+	if !ok || reading.State != event.State {
+		reading.Since = event.At
+	}
+	reading.DeviceModel = event.DeviceModel
+	reading.Location = event.Location
+	reading.Value = event.Value
+	reading.State = event.State
+	reading.At = event.At
+	engine.changed[key] = true
+
+	return append(matches, engine.evaluate(reading, event.At, false)...)
+}
+
+// Tick returns the alerts of the states which lasted long enough at the time. The time does not go back,
+// a late reading does not take back the rules its state raised already
+func (engine *Engine) Tick(at time.Time) []Match {
+	if at.Before(engine.at) {
+		at = engine.at
+	}
+	engine.at = at
+
+	matches := []Match{}
+	for _, key := range engine.keys {
+		matches = append(matches, engine.evaluate(engine.readings[key], at, true)...)
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].At.Before(matches[j].At) })
+
+	return matches
+}
+
+// Changed returns the readings the events changed
+func (engine *Engine) Changed() []Reading {
+	readings := []Reading{}
+	for _, key := range engine.keys {
+		if engine.changed[key] {
+			readings = append(readings, *engine.readings[key])
+		}
+	}
+
+	return readings
+}
+
+// evaluate raises the rules of the metric of the reading which start to hold, durations only checks the rules waiting for a state to last
+func (engine *Engine) evaluate(reading *Reading, at time.Time, durations bool) []Match {
+	matches := []Match{}
+	for i := range engine.rules {
+		rule := &engine.rules[i]
+		if rule.Metric != reading.Metric || (durations && rule.Condition != ConditionLasts) {
+			continue
+		}
+
+		since, holds := engine.holds(rule, reading, at)
+		raised := utils.Contains(reading.Raised, rule.ID)
+		if !holds && raised {
+			reading.Raised = remove(reading.Raised, rule.ID)
+			engine.changed[reading.ID] = true
+		}
+		if !holds || raised {
+			continue
+		}
+
+		reading.Raised = append(reading.Raised, rule.ID)
+		engine.changed[reading.ID] = true
+		matches = append(matches, Match{
+			RuleID:      rule.ID,
+			RuleName:    rule.Name,
+			Type:        rule.AlertType,
+			Priority:    rule.Priority,
+			Device:      reading.Device,
+			DeviceModel: reading.DeviceModel,
+			Location:    reading.Location,
+			Description: describe(rule, reading, engine.options[rule.Option]),
+			At:          since,
+		})
+	}
+
+	return matches
+}
+
+// holds checks the condition of the rule holds for the reading at the time and returns since when,
+// rules of a missing or disabled option never hold
+func (engine *Engine) holds(rule *Rule, reading *Reading, at time.Time) (time.Time, bool) {
+	option, ok := engine.options[rule.Option]
+	if !ok || !option.Enable || !engine.guarded(rule, reading) {
+		return time.Time{}, false
+	}
+
+	threshold := float64(option.Value)
+	switch rule.Condition {
+	case ConditionAbove:
+		return reading.At, reading.Value > threshold
+	case ConditionBelow:
+		return reading.At, reading.Value < threshold
+	case ConditionAtMost:
+		return reading.At, reading.Value <= threshold
+	case ConditionState:
+		return reading.Since, reading.State == rule.State
+	case ConditionLasts:
+		due := reading.Since.Add(time.Duration(option.Value) * time.Minute)
+		return due, reading.State == rule.State && !at.Before(due)
+	}
+
+	return time.Time{}, false
+}
+
+// guarded checks the latest reading of the guard metric in the room of the reading is in the guard state
+func (engine *Engine) guarded(rule *Rule, reading *Reading) bool {
+	if rule.While == nil {
+		return true
+	}
+
+	var latest *Reading
+	for _, key := range engine.keys {
+		other := engine.readings[key]
+		if other.Location == reading.Location && other.Metric == rule.While.Metric && (latest == nil || other.At.After(latest.At)) {
+			latest = other
+		}
+	}
+
+	return latest != nil && latest.State == rule.While.State
+}
+
+// describe tells what the device reported in the description of the alert
+func describe(rule *Rule, reading *Reading, option Option) string {
+	device := "A device"
+	if len(reading.Device) > 0 {
+		device = "Device " + reading.Device
+	}
+
+	description := ""
+	switch rule.Condition {
+	case ConditionLasts:
+		description = fmt.Sprintf("%s: %s in room %s was %s for more than %d minutes", rule.Name, device, reading.Location, rule.State, option.Value)
+	case ConditionState:
+		description = fmt.Sprintf("%s: %s in room %s reported %s", rule.Name, device, reading.Location, rule.State)
+	default:
+		description = fmt.Sprintf("%s: %s in room %s reported %s %s, the limit is %s", rule.Name, device, reading.Location, reading.Metric,
+			measure(fmt.Sprintf("%g", reading.Value), option.Unit), measure(fmt.Sprintf("%d", option.Value), option.Unit))
+	}
+	if rule.While != nil {
+		description += fmt.Sprintf(" while %s was %s", rule.While.Metric, rule.While.State)
+	}
+
+	return description
+}
+
+// measure appends the unit to the value when there is one
+func measure(value string, unit string) string {
+	if len(unit) == 0 {
+		return value
+	}
+
+	return value + " " + unit
+}
+
+// remove returns the values without the value
+func remove(values []string, value string) []string {
+	kept := []string{}
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+
+	return kept
+}
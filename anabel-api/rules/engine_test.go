@@ -0,0 +1,176 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+)
+
+// start is the time of the first reading of the tests
+var start = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+// testOptions are the options of the site the rules of the tests take their thresholds from
+var testOptions = []Option{
+	{Label: "max temperature", Value: 30, Unit: "°C", Enable: true},
+	{Label: "min temperature", Value: 10, Unit: "°C", Enable: true},
+	{Label: "no power", Value: 0, Unit: "W", Enable: true},
+	{Label: "door open", Value: 15, Enable: true},
+	{Label: "disabled", Value: 1, Enable: false},
+}
+
+// reading returns the event of the device in room 101 the minutes after start
+func reading(device string, metric string, value float64, state string, minutes int) Event {
+	return Event{SiteID: "tokyo", Device: device, Location: "101", Metric: metric, Value: value, State: state, At: start.Add(time.Duration(minutes) * time.Minute)}
+}
+
+// ruleOf returns an enabled staff alert rule
+func ruleOf(id string, option string, metric string, condition string, state string) Rule {
+	return Rule{ID: id, Name: id, Option: option, Metric: metric, Condition: condition, State: state, AlertType: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh, Enabled: true}
+}
+
+// summary lists the matches as rule@minutes after start
+func summary(matches []Match) string {
+	raised := []string{}
+	for _, match := range matches {
+		raised = append(raised, match.RuleID+"@"+match.At.Sub(start).String())
+	}
+
+	return strings.Join(raised, ",")
+}
+
+func TestSimulate(t *testing.T) {
+	guarded := ruleOf("unoccupied", "no power", "power", ConditionAtMost, "")
+	guarded.While = &Guard{Metric: "occupancy", State: "vacant"}
+	disabled := ruleOf("disabled", "max temperature", "temperature", ConditionAbove, "")
+	disabled.Enabled = false
+
+	for _, test := range []struct {
+		name    string
+		rules   []Rule
+		events  []Event
+		until   time.Time
+		matches string
+	}{
+		{
+			name:    "above raises once until it stops holding",
+			rules:   []Rule{ruleOf("hot", "max temperature", "temperature", ConditionAbove, "")},
+			events:  []Event{reading("ac", "temperature", 25, "", 0), reading("ac", "temperature", 31, "", 1), reading("ac", "temperature", 32, "", 2), reading("ac", "temperature", 29, "", 3), reading("ac", "temperature", 35, "", 4)},
+			matches: "hot@1m0s,hot@4m0s",
+		},
+		{
+			name:    "below",
+			rules:   []Rule{ruleOf("cold", "min temperature", "temperature", ConditionBelow, "")},
+			events:  []Event{reading("ac", "temperature", 10, "", 0), reading("ac", "temperature", 9, "", 1)},
+			matches: "cold@1m0s",
+		},
+		{
+			name:    "at most takes the threshold",
+			rules:   []Rule{ruleOf("off", "no power", "power", ConditionAtMost, "")},
+			events:  []Event{reading("fridge", "power", 120, "", 0), reading("fridge", "power", 0, "", 1)},
+			matches: "off@1m0s",
+		},
+		{
+			name:    "state raises from when the state was entered",
+			rules:   []Rule{ruleOf("entered", "door open", "door", ConditionState, "open")},
+			events:  []Event{reading("door", "door", 0, "closed", 0), reading("door", "door", 0, "open", 2), reading("door", "door", 0, "open", 3)},
+			matches: "entered@2m0s",
+		},
+		{
+			name:    "lasts raises once the state lasted longer than the option",
+			rules:   []Rule{ruleOf("left open", "door open", "door", ConditionLasts, "open")},
+			events:  []Event{reading("door", "door", 0, "open", 0), reading("door", "door", 0, "open", 10)},
+			until:   start.Add(20 * time.Minute),
+			matches: "left open@15m0s",
+		},
+		{
+			name:    "lasts does not raise when the state ends in time",
+			rules:   []Rule{ruleOf("left open", "door open", "door", ConditionLasts, "open")},
+			events:  []Event{reading("door", "door", 0, "open", 0), reading("door", "door", 0, "closed", 10)},
+			until:   start.Add(30 * time.Minute),
+			matches: "",
+		},
+		{
+			name:  "guards limit the rule to the state of another metric of the room",
+			rules: []Rule{guarded},
+			events: []Event{
+				reading("room", "occupancy", 0, "occupied", 0), reading("tv", "power", 0, "", 1),
+				reading("room", "occupancy", 0, "vacant", 2), reading("tv", "power", 80, "", 3), reading("tv", "power", 0, "", 4),
+			},
+			matches: "unoccupied@4m0s",
+		},
+		{
+			name:    "disabled rules and options never hold",
+			rules:   []Rule{disabled, ruleOf("never", "disabled", "temperature", ConditionAbove, "")},
+			events:  []Event{reading("ac", "temperature", 40, "", 0)},
+			matches: "",
+		},
+		{
+			name:    "every device is evaluated on its own",
+			rules:   []Rule{ruleOf("hot", "max temperature", "temperature", ConditionAbove, "")},
+			events:  []Event{reading("ac-1", "temperature", 31, "", 0), reading("ac-2", "temperature", 31, "", 1)},
+			matches: "hot@0s,hot@1m0s",
+		},
+	} {
+		if matches := summary(Simulate(test.rules, testOptions, test.events, test.until)); matches != test.matches {
+			t.Errorf("%s: raised %q instead of %q", test.name, matches, test.matches)
+		}
+	}
+}
+
+func TestObserveAndTick(t *testing.T) {
+	engine := NewEngine([]Rule{ruleOf("left open", "door open", "door", ConditionLasts, "open")}, testOptions, nil)
+
+	event := reading("door", "door", 0, "open", 0)
+	if matches := engine.Observe(&event); len(matches) != 0 {
+		t.Errorf("a state raised before it lasted: %s", summary(matches))
+	}
+	if matches := engine.Tick(start.Add(14 * time.Minute)); len(matches) != 0 {
+		t.Errorf("a state raised before it lasted: %s", summary(matches))
+	}
+	matches := engine.Tick(start.Add(16 * time.Minute))
+	if summary(matches) != "left open@15m0s" {
+		t.Fatalf("unexpected matches %s", summary(matches))
+	}
+	if matches[0].Location != "101" || matches[0].Device != "door" || !strings.Contains(matches[0].Description, "for more than 15 minutes") {
+		t.Errorf("unexpected match %+v", matches[0])
+	}
+	if matches := engine.Tick(start.Add(20 * time.Minute)); len(matches) != 0 {
+		t.Errorf("a lasting state raised twice: %s", summary(matches))
+	}
+
+	changed := engine.Changed()
+	if len(changed) != 1 || strings.Join(changed[0].Raised, ",") != "left open" {
+		t.Errorf("the raised rule is not kept on the reading %+v", changed)
+	}
+
+	// the readings stored after a batch carry on the raised rules
+	again := NewEngine([]Rule{ruleOf("left open", "door open", "door", ConditionLasts, "open")}, testOptions, changed)
+	if matches := again.Tick(start.Add(30 * time.Minute)); len(matches) != 0 {
+		t.Errorf("a raised rule raised again from the stored readings: %s", summary(matches))
+	}
+
+	older := reading("door", "door", 0, "closed", -5)
+	again.Observe(&older)
+	if matches := again.Tick(start.Add(40 * time.Minute)); len(matches) != 0 {
+		t.Errorf("an older reading reset the state: %s", summary(matches))
+	}
+}
+
+func TestAlertIDOf(t *testing.T) {
+	match := Match{RuleID: "hot", Device: "ac", At: start}
+	id := alertIDOf("tokyo", &match)
+
+	if again := alertIDOf("tokyo", &Match{RuleID: "hot", Device: "ac", At: start}); again != id {
+		t.Errorf("the alert of the same match has ids %s and %s", id.Hex(), again.Hex())
+	}
+	if !id.Time().Equal(start) {
+		t.Errorf("the id starts with %v instead of the time of the match", id.Time())
+	}
+	for _, other := range []Match{{RuleID: "cold", Device: "ac", At: start}, {RuleID: "hot", Device: "fan", At: start}, {RuleID: "hot", Device: "ac", At: start.Add(time.Second)}} {
+		if alertIDOf("tokyo", &other) == id {
+			t.Errorf("the match %+v has the id of another one", other)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package rules
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ConditionAbove holds while the value of the reading is above the value of the option
+	ConditionAbove = "above"
+	// ConditionBelow holds while the value of the reading is below the value of the option
+	ConditionBelow = "below"
+	// ConditionAtMost holds while the value of the reading is at most the value of the option, a power draw dropped to zero with 0
+	ConditionAtMost = "atMost"
+	// ConditionState holds while the reading is in the state of the rule, a room entered with entered
+	ConditionState = "state"
+	// ConditionLasts holds once the reading stayed in the state of the rule for longer than the value of the option in minutes
+	ConditionLasts = "lasts"
+)
+
+// Conditions lists what a rule can check the readings of its metric for
+var Conditions = []string{ConditionAbove, ConditionBelow, ConditionAtMost, ConditionState, ConditionLasts}
+
+// maxRules limits the rules of a version
+const maxRules = 50
+
+// defaultPollInterval is used when rules.poll_interval_in_seconds is not configured
+const defaultPollInterval = 10 * time.Second
+
+// batchSize limits the readings evaluated in one pass, the rest wait for the next one
+const batchSize = 1000
+
+// leaseDuration is how long an instance evaluates a site before another one may take it over
+const leaseDuration = time.Minute
+
+// Option godoc
+// is an item of the options of a site, the rules take their threshold from its value and only apply while it is enabled
+type Option struct {
+	Label  string `json:"label" bson:"label"`
+	Value  int    `json:"value" bson:"value"`
+	Unit   string `json:"unit" bson:"unit"`
+	Enable bool   `json:"enable" bson:"enable"`
+}
+
+// Guard godoc
+// limits a rule to the time the latest reading of the metric in the same room is in the state, a room in the state unused
+type Guard struct {
+	Metric string `json:"metric" bson:"metric"`
+	State  string `json:"state" bson:"state"`
+}
+
+// Rule godoc
+// raises an alert of the alert type and priority once the readings of the metric meet the condition with the value of the option,
+// again only after the condition stopped holding. The id stays the same across the versions of the rules
+type Rule struct {
+	ID        string `json:"id" bson:"id"`
+	Name      string `json:"name" bson:"name"`
+	Option    string `json:"option" bson:"option"`
+	Metric    string `json:"metric" bson:"metric"`
+	Condition string `json:"condition" bson:"condition"`
+	State     string `json:"state,omitempty" bson:"state,omitempty"`
+	While     *Guard `json:"while,omitempty" bson:"while,omitempty"`
+	AlertType string `json:"alertType" bson:"alertType"`
+	Priority  string `json:"priority" bson:"priority"`
+	Enabled   bool   `json:"enabled" bson:"enabled"`
+}
+
+// RuleSet godoc
+// is a version of the alert rules of a site, publishing the rules adds a version and only the current one applies.
+// RestoredFrom names the version a restored one copies
+type RuleSet struct {
+	ID           bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID     string        `json:"clientId" bson:"clientId"`
+	SiteID       string        `json:"siteId" bson:"siteId"`
+	Version      int           `json:"version" bson:"version"`
+	Current      bool          `json:"current" bson:"current"`
+	Rules        []Rule        `json:"rules" bson:"rules"`
+	Note         string        `json:"note" bson:"note"`
+	RestoredFrom int           `json:"restoredFrom,omitempty" bson:"restoredFrom,omitempty"`
+	CreatedBy    string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt    time.Time     `json:"createdAt" bson:"createdAt"`
+}
+
+// Event godoc
+// is a reading of a metric of a device other services report, numeric metrics have a value and the others a state
+type Event struct {
+	ID          bson.ObjectId `json:"-" bson:"_id,omitempty"`
+	SiteID      string        `json:"siteId" bson:"siteId"`
+	Device      string        `json:"device" bson:"device"`
+	DeviceModel string        `json:"deviceModel" bson:"deviceModel"`
+	Location    string        `json:"location" bson:"location"`
+	Metric      string        `json:"metric" bson:"metric"`
+	Value       float64       `json:"value" bson:"value"`
+	State       string        `json:"state" bson:"state"`
+	At          time.Time     `json:"at" bson:"at"`
+	ProcessedAt time.Time     `json:"-" bson:"processedAt,omitempty"`
+}
+
+// Init creates the indexes used to find the readings to evaluate, the readings of a site and the versions of its rules
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.TelemetryCollection).EnsureIndex(mgo.Index{Key: []string{"processedAt", "at"}})
+	if err == nil {
+		err = session.DB("").C(common.TelemetryCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "processedAt", "at"}})
+	}
+	if err == nil {
+		err = session.DB("").C(common.TelemetryStateCollection).EnsureIndex(mgo.Index{Key: []string{"siteId"}})
+	}
+	if err == nil {
+		err = session.DB("").C(common.AlertRuleSetCollection).EnsureIndex(mgo.Index{Key: []string{"siteId", "version"}, Unique: true})
+	}
+	if err == nil {
+		err = session.DB("").C(common.AlertRuleSetCollection).EnsureIndex(mgo.Index{Key: []string{"current"}})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create rules indexes, error: %v", err)
+	}
+}
+
+// Start evaluates the readings reported by other services in the background,
+// every instance of the api can run one as a site is only evaluated by the instance holding its lease
+func Start() {
+	interval := defaultPollInterval
+	if seconds := config.GetConfig().GetInt("rules.poll_interval_in_seconds"); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(time.Now().UTC())
+		}
+	}()
+}
+
+// Validate checks the rules of the version against the options of the site
+func (set *RuleSet) Validate(options []Option) error {
+	if len(set.Rules) > maxRules {
+		return fmt.Errorf("a version has at most %d rules", maxRules)
+	}
+
+	labels := map[string]bool{}
+	for _, option := range options {
+		labels[option.Label] = true
+	}
+	seen := map[string]bool{}
+	for i := range set.Rules {
+		rule := &set.Rules[i]
+		if seen[rule.ID] {
+			return errors.New("rule " + rule.ID + " is repeated")
+		}
+		seen[rule.ID] = true
+
+		err := rule.Validate()
+		if err != nil {
+			return err
+		}
+		if !labels[rule.Option] {
+			return errors.New("the site has no option " + rule.Option)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the condition, state, guard, alert type and priority of the rule
+func (rule *Rule) Validate() error {
+	if !bson.IsObjectIdHex(rule.ID) {
+		return errors.New("a rule needs an id")
+	}
+	if len(rule.Name) == 0 {
+		return errors.New("a rule needs a name")
+	}
+	if len(rule.Option) == 0 {
+		return errors.New("a rule needs an option")
+	}
+	if len(rule.Metric) == 0 {
+		return errors.New("a rule needs a metric")
+	}
+	if !utils.Contains(Conditions, rule.Condition) {
+		return errors.New("unknown condition " + rule.Condition)
+	}
+	if (rule.Condition == ConditionState || rule.Condition == ConditionLasts) && len(rule.State) == 0 {
+		return errors.New("the condition " + rule.Condition + " needs a state")
+	}
+	if rule.While != nil && (len(rule.While.Metric) == 0 || len(rule.While.State) == 0) {
+		return errors.New("a guard needs a metric and a state")
+	}
+	if !utils.Contains(notification.AlertTypes, rule.AlertType) {
+		return errors.New("unknown alert type " + rule.AlertType)
+	}
+	if len(rule.Priority) == 0 || !notification.ValidPriority(rule.Priority) {
+		return errors.New("unknown priority " + rule.Priority)
+	}
+
+	return nil
+}
+
+// OptionsOf returns the options of the site the rules take their thresholds from
+func OptionsOf(session *mgo.Session, siteID string) ([]Option, error) {
+	if !bson.IsObjectIdHex(siteID) {
+		return []Option{}, nil
+	}
+
+	site := struct {
+		Options struct {
+			Items []Option `bson:"items"`
+		} `bson:"options"`
+	}{}
+	err := session.DB("").C(common.SiteCollection).FindId(bson.ObjectIdHex(siteID)).Select(bson.M{"options.items": 1}).One(&site)
+	if err != nil {
+		return nil, err
+	}
+
+	return site.Options.Items, nil
+}
+
+// run evaluates the readings of the sites with unevaluated readings from the oldest on and the durations of the sites with rules
+func run(now time.Time) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	sets := []RuleSet{}
+	err := session.DB("").C(common.AlertRuleSetCollection).Find(bson.M{"current": true}).Sort("version").All(&sets)
+	if err != nil {
+		log.Errorf("Failed to load the alert rules, error: %v", err)
+		return
+	}
+
+	events := []Event{}
+	err = session.DB("").C(common.TelemetryCollection).Find(bson.M{"processedAt": bson.M{"$exists": false}}).Sort("at").Limit(batchSize).Select(bson.M{"siteId": 1}).All(&events)
+	if err != nil {
+		log.Errorf("Failed to load the readings to evaluate, error: %v", err)
+		return
+	}
+
+	// the latest version wins while a publish is replacing the current one
+	bySite := map[string]*RuleSet{}
+	for i := range sets {
+		bySite[sets[i].SiteID] = &sets[i]
+	}
+	siteIDs := []string{}
+	pending := map[string]bool{}
+	for _, event := range events {
+		if !pending[event.SiteID] {
+			siteIDs = append(siteIDs, event.SiteID)
+		}
+		pending[event.SiteID] = true
+	}
+	for siteID, set := range bySite {
+		if !pending[siteID] && hasDurations(set) {
+			siteIDs = append(siteIDs, siteID)
+		}
+	}
+
+	for _, siteID := range siteIDs {
+		err = evaluateSite(session, siteID, bySite[siteID], now)
+		if err != nil {
+			log.Errorf("Failed to evaluate the readings of site %s, error: %v", siteID, err)
+		}
+	}
+}
+
+// evaluateSite applies the readings to the latest readings of the site and raises the alerts of its current rules,
+// a site without rules only keeps its latest readings for the rules published later. The readings are loaded once
+// the instance holds the lease of the site, so no reading is evaluated twice, and they are only marked processed
+// once their alerts were raised, a failing batch is evaluated again
+func evaluateSite(session *mgo.Session, siteID string, set *RuleSet, now time.Time) error {
+	until, err := lease(session, siteID, now)
+	if until.IsZero() || err != nil {
+		return err
+	}
+	defer release(session, siteID, until)
+
+	events := []Event{}
+	err = session.DB("").C(common.TelemetryCollection).Find(bson.M{"siteId": siteID, "processedAt": bson.M{"$exists": false}}).Sort("at").Limit(batchSize).All(&events)
+	if err != nil {
+		return err
+	}
+
+	rules := []Rule{}
+	if set != nil {
+		rules = set.Rules
+	}
+	options, err := OptionsOf(session, siteID)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	readings := []Reading{}
+	err = session.DB("").C(common.TelemetryStateCollection).Find(bson.M{"siteId": siteID}).All(&readings)
+	if err != nil {
+		return err
+	}
+
+	engine := NewEngine(rules, options, readings)
+	matches := []Match{}
+	ids := []bson.ObjectId{}
+	for i := range events {
+		matches = append(matches, engine.Observe(&events[i])...)
+		ids = append(ids, events[i].ID)
+	}
+	// durations only run out once every reading of the site up to now was evaluated
+	if len(events) < batchSize {
+		matches = append(matches, engine.Tick(now)...)
+	}
+
+	for i := range matches {
+		err = raise(session, set, &matches[i], now)
+		if err != nil {
+			return fmt.Errorf("cannot raise the alert of rule %s: %v", matches[i].RuleID, err)
+		}
+	}
+
+	for _, reading := range engine.Changed() {
+		_, err = session.DB("").C(common.TelemetryStateCollection).UpsertId(reading.ID, reading)
+		if err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		_, err = session.DB("").C(common.TelemetryCollection).UpdateAll(bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"processedAt": now}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasDurations checks a rule of the version waits for a state to last
+func hasDurations(set *RuleSet) bool {
+	for _, rule := range set.Rules {
+		if rule.Enabled && rule.Condition == ConditionLasts {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lease takes the site for the instance unless another one is evaluating it, it returns until when the lease
+// holds and a zero time when another instance holds it
+func lease(session *mgo.Session, siteID string, now time.Time) (time.Time, error) {
+	until := now.Add(leaseDuration)
+	_, err := session.DB("").C(common.TelemetryLeaseCollection).Upsert(
+		bson.M{"_id": siteID, "leaseUntil": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"leaseUntil": until}},
+	)
+	if mgo.IsDup(err) {
+		// the lease of another instance still holds
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return until, nil
+}
+
+// release lets the other instances evaluate the site unless the lease ran out and another instance took it over
+func release(session *mgo.Session, siteID string, until time.Time) {
+	err := session.DB("").C(common.TelemetryLeaseCollection).Remove(bson.M{"_id": siteID, "leaseUntil": until})
+	if err != nil && err != mgo.ErrNotFound {
+		log.Errorf("Failed to release the readings of site %s, error: %v", siteID, err)
+	}
+}
+
+// raise creates the New alert of the match, deduplication announces it and routing takes it from there.
+// A batch evaluated again after a failure raises the same matches, their alerts exist already and are not raised twice
+func raise(session *mgo.Session, set *RuleSet, match *Match, now time.Time) error {
+	alert := common.Alert{
+		ID:               alertIDOf(set.SiteID, match),
+		SiteID:           set.SiteID,
+		ClientID:         set.ClientID,
+		Status:           common.AlertStatusNew,
+		Type:             match.Type,
+		AssignedTo:       []common.SimpleUser{},
+		Priority:         match.Priority,
+		Location:         match.Location,
+		Device:           match.Device,
+		DeviceModel:      match.DeviceModel,
+		JobName:          match.RuleName,
+		Description:      match.Description,
+		AlertTime:        match.At,
+		Escalations:      []common.AlertEscalation{},
+		AlertRuleID:      match.RuleID,
+		AlertRuleVersion: set.Version,
+		UpdatedAt:        now,
+	}
+
+	err := session.DB("").C(common.AlertCollection).Insert(&alert)
+	if mgo.IsDup(err) {
+		log.Debugf("Rule %s of site %s raised alert %s already", match.RuleID, set.SiteID, alert.ID.Hex())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Rule %s of site %s raised alert %s", match.RuleID, set.SiteID, alert.ID.Hex())
+	return nil
+}
+
+// alertIDOf returns the same id for the alert of a rule on a device from the same time on, it starts with the time
+// like the generated ids so the alerts still sort by creation
+func alertIDOf(siteID string, match *Match) bson.ObjectId {
+	sum := sha1.Sum([]byte(strings.Join([]string{siteID, match.RuleID, match.Device, match.At.UTC().Format(time.RFC3339Nano)}, "\x00")))
+	id := make([]byte, 12)
+	binary.BigEndian.PutUint32(id, uint32(match.At.Unix()))
+	copy(id[4:], sum[:8])
+
+	return bson.ObjectId(id)
+}
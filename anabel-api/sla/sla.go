@@ -0,0 +1,308 @@
+package sla
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// GroupBySite reports every site
+	GroupBySite = "site"
+	// GroupByStaff reports every staff member the alerts were assigned to, an alert counts for each of its assignees
+	GroupByStaff = "staff"
+	// GroupByType reports every alert type
+	GroupByType = "type"
+	// GroupByBucket reports every day, week or month the alerts were raised in
+	GroupByBucket = "bucket"
+)
+
+// GroupBys lists what a report can be grouped by
+var GroupBys = []string{GroupBySite, GroupByStaff, GroupByType, GroupByBucket}
+
+const (
+	// BucketDay starts a bucket at midnight
+	BucketDay = "day"
+	// BucketWeek starts a bucket on monday
+	BucketWeek = "week"
+	// BucketMonth starts a bucket on the first day of the month
+	BucketMonth = "month"
+)
+
+// Buckets lists the time buckets a report can be grouped by
+var Buckets = []string{BucketDay, BucketWeek, BucketMonth}
+
+// bucketLayout is the format of the start of a bucket
+const bucketLayout = "2006-01-02"
+
+// Target godoc
+// is how many minutes the alerts of a type and priority at the sites of a client may take to be assigned and to be cleared.
+// A target without alert type applies to every type and one without priority to every priority, zero minutes set no target
+type Target struct {
+	ID            bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID      string        `json:"clientId" bson:"clientId"`
+	AlertType     string        `json:"alertType" bson:"alertType"`
+	Priority      string        `json:"priority" bson:"priority"`
+	AssignMinutes int           `json:"assignMinutes" bson:"assignMinutes"`
+	ClearMinutes  int           `json:"clearMinutes" bson:"clearMinutes"`
+	CreatedBy     string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt     time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Row godoc
+// reports the alerts of a group, the times are in minutes from the alert time. Percentiles are of the assigned
+// and cleared alerts, breaches count the alerts which took or are taking longer than their target
+type Row struct {
+	Key            string  `json:"key"`
+	Name           string  `json:"name"`
+	Alerts         int     `json:"alerts"`
+	Assigned       int     `json:"assigned"`
+	AssignP50      float64 `json:"assignP50"`
+	AssignP90      float64 `json:"assignP90"`
+	AssignP95      float64 `json:"assignP95"`
+	Cleared        int     `json:"cleared"`
+	ClearP50       float64 `json:"clearP50"`
+	ClearP90       float64 `json:"clearP90"`
+	ClearP95       float64 `json:"clearP95"`
+	AssignBreaches int     `json:"assignBreaches"`
+	ClearBreaches  int     `json:"clearBreaches"`
+	Breached       bool    `json:"breached"`
+	assignTimes    []float64
+	clearTimes     []float64
+}
+
+// Report godoc
+// reports the response times of the alerts raised from inclusive to exclusive by group, Total reports all of them
+type Report struct {
+	GroupBy string    `json:"groupBy"`
+	Bucket  string    `json:"bucket,omitempty"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Rows    []Row     `json:"rows"`
+	Total   Row       `json:"total"`
+}
+
+// measure holds the response times of an alert and whether they breach its target
+type measure struct {
+	assign       float64
+	assigned     bool
+	clear        float64
+	cleared      bool
+	assignBreach bool
+	clearBreach  bool
+}
+
+// Init creates the index, a client has one target per alert type and priority
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.SLATargetCollection).EnsureIndex(mgo.Index{
+		Key:    []string{"clientId", "alertType", "priority"},
+		Unique: true,
+	})
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create sla indexes, error: %v", err)
+	}
+}
+
+// Validate checks the alert type, priority and minutes of the target
+func (target *Target) Validate() error {
+	if len(target.AlertType) > 0 && !utils.Contains(notification.AlertTypes, target.AlertType) {
+		return errors.New("unknown alert type " + target.AlertType)
+	}
+	if !notification.ValidPriority(target.Priority) {
+		return errors.New("unknown priority " + target.Priority)
+	}
+	if target.AssignMinutes < 0 || target.ClearMinutes < 0 {
+		return errors.New("the minutes cannot be negative")
+	}
+	if target.AssignMinutes == 0 && target.ClearMinutes == 0 {
+		return errors.New("a target needs assign or clear minutes")
+	}
+
+	return nil
+}
+
+// TargetOf returns the target of the alert among the targets of its client: the one of its type and priority first,
+// then the one of its type, the one of its priority and the one of every alert
+func TargetOf(targets []Target, alert *common.Alert) *Target {
+	var best *Target
+	bestRank := -1
+	for i := range targets {
+		target := &targets[i]
+		if (len(target.AlertType) > 0 && target.AlertType != alert.Type) || (len(target.Priority) > 0 && target.Priority != alert.Priority) {
+			continue
+		}
+
+		rank := 0
+		if len(target.AlertType) > 0 {
+			rank += 2
+		}
+		if len(target.Priority) > 0 {
+			rank++
+		}
+		if rank > bestRank {
+			best = target
+			bestRank = rank
+		}
+	}
+
+	return best
+}
+
+// Build reports the alerts by the group, the targets are by client. Buckets start in the location,
+// alerts still waiting at the time breach their targets once they wait longer
+func Build(alerts []common.Alert, targets map[string][]Target, groupBy string, bucket string, location *time.Location, now time.Time) *Report {
+	report := Report{GroupBy: groupBy, Rows: []Row{}, Total: Row{Name: "Total"}}
+	if groupBy == GroupByBucket {
+		report.Bucket = bucket
+	}
+
+	rows := map[string]*Row{}
+	keys := []string{}
+	for i := range alerts {
+		alert := &alerts[i]
+		m := measureOf(alert, TargetOf(targets[alert.ClientID], alert), now)
+		for _, group := range groupsOf(alert, groupBy, bucket, location) {
+			row, ok := rows[group[0]]
+			if !ok {
+				row = &Row{Key: group[0], Name: group[1]}
+				rows[group[0]] = row
+				keys = append(keys, group[0])
+			}
+			row.add(m)
+		}
+		report.Total.add(m)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		rows[key].finish()
+		report.Rows = append(report.Rows, *rows[key])
+	}
+	report.Total.finish()
+
+	return &report
+}
+
+// measureOf returns the response times of the alert and checks them against the target
+func measureOf(alert *common.Alert, target *Target, now time.Time) measure {
+	m := measure{}
+	if !alert.AssignTime.IsZero() {
+		m.assigned = true
+		m.assign = alert.AssignTime.Sub(alert.AlertTime).Minutes()
+	}
+	if alert.Status == common.AlertStatusCleared && !alert.ClearTime.IsZero() {
+		m.cleared = true
+		m.clear = alert.ClearTime.Sub(alert.AlertTime).Minutes()
+	}
+	if target == nil {
+		return m
+	}
+
+	waiting := now.Sub(alert.AlertTime).Minutes()
+	if limit := float64(target.AssignMinutes); limit > 0 {
+		m.assignBreach = (m.assigned && m.assign > limit) || (!m.assigned && alert.Status == common.AlertStatusNew && waiting > limit)
+	}
+	if limit := float64(target.ClearMinutes); limit > 0 {
+		m.clearBreach = (m.cleared && m.clear > limit) || (alert.Status != common.AlertStatusCleared && waiting > limit)
+	}
+
+	return m
+}
+
+// groupsOf returns the key and name of the groups the alert counts in
+func groupsOf(alert *common.Alert, groupBy string, bucket string, location *time.Location) [][2]string {
+	switch groupBy {
+	case GroupByStaff:
+		groups := [][2]string{}
+		for _, user := range alert.AssignedTo {
+			name := strings.TrimSpace(user.FirstName + " " + user.FamilyName)
+			if len(name) == 0 {
+				name = user.Email
+			}
+			groups = append(groups, [2]string{user.ID, name})
+		}
+		if len(groups) == 0 {
+			groups = append(groups, [2]string{"", "Unassigned"})
+		}
+		return groups
+	case GroupByType:
+		return [][2]string{{alert.Type, alert.Type}}
+	case GroupByBucket:
+		start := StartOf(alert.AlertTime, bucket, location).Format(bucketLayout)
+		return [][2]string{{start, start}}
+	}
+
+	return [][2]string{{alert.SiteID, alert.SiteID}}
+}
+
+// StartOf returns the start of the bucket the time is in, weeks start on monday
+func StartOf(at time.Time, bucket string, location *time.Location) time.Time {
+	local := at.In(location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	switch bucket {
+	case BucketWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case BucketMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+
+	return day
+}
+
+// add counts the measure of an alert in the row
+func (row *Row) add(m measure) {
+	row.Alerts++
+	if m.assigned {
+		row.Assigned++
+		row.assignTimes = append(row.assignTimes, m.assign)
+	}
+	if m.cleared {
+		row.Cleared++
+		row.clearTimes = append(row.clearTimes, m.clear)
+	}
+	if m.assignBreach {
+		row.AssignBreaches++
+	}
+	if m.clearBreach {
+		row.ClearBreaches++
+	}
+}
+
+// finish computes the percentiles of the row
+func (row *Row) finish() {
+	sort.Float64s(row.assignTimes)
+	sort.Float64s(row.clearTimes)
+	row.AssignP50 = Percentile(row.assignTimes, 50)
+	row.AssignP90 = Percentile(row.assignTimes, 90)
+	row.AssignP95 = Percentile(row.assignTimes, 95)
+	row.ClearP50 = Percentile(row.clearTimes, 50)
+	row.ClearP90 = Percentile(row.clearTimes, 90)
+	row.ClearP95 = Percentile(row.clearTimes, 95)
+	row.Breached = row.AssignBreaches > 0 || row.ClearBreaches > 0
+}
+
+// Percentile returns the nearest rank percentile of the sorted values rounded to a tenth, 0 without values
+func Percentile(sorted []float64, percent float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(percent / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return math.Round(sorted[rank-1]*10) / 10
+}
@@ -0,0 +1,140 @@
+package sla
+
+import (
+	"testing"
+	"time"
+
+	"anacove.com/backend/common"
+)
+
+// raised is the time the first alert of the tests was raised
+var raised = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+// alertOf returns an alert of the site raised the minutes after raised, assigned and cleared the minutes after it was raised
+// unless they are negative
+func alertOf(siteID string, minutes int, assign int, clear int) common.Alert {
+	alert := common.Alert{ClientID: "acme", SiteID: siteID, Type: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh, Status: common.AlertStatusNew}
+	alert.AlertTime = raised.Add(time.Duration(minutes) * time.Minute)
+	if assign >= 0 {
+		alert.Status = common.AlertStatusActive
+		alert.AssignTime = alert.AlertTime.Add(time.Duration(assign) * time.Minute)
+		alert.AssignedTo = []common.SimpleUser{{ID: "kim", FirstName: "Kim"}}
+	}
+	if clear >= 0 {
+		alert.Status = common.AlertStatusCleared
+		alert.ClearTime = alert.AlertTime.Add(time.Duration(clear) * time.Minute)
+	}
+
+	return alert
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	for percent, expected := range map[float64]float64{0: 1, 50: 5, 90: 9, 95: 10, 100: 10} {
+		if got := Percentile(values, percent); got != expected {
+			t.Errorf("percentile %v is %v instead of %v", percent, got, expected)
+		}
+	}
+	if got := Percentile([]float64{1.24, 3.26}, 100); got != 3.3 {
+		t.Errorf("the percentile is not rounded to a tenth: %v", got)
+	}
+	if got := Percentile(nil, 50); got != 0 {
+		t.Errorf("the percentile without values is %v", got)
+	}
+}
+
+func TestTargetOf(t *testing.T) {
+	targets := []Target{
+		{AssignMinutes: 60},
+		{Priority: common.AlertPriorityHigh, AssignMinutes: 30},
+		{AlertType: common.AlertTypeStaffAlert, AssignMinutes: 20},
+		{AlertType: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh, AssignMinutes: 10},
+	}
+
+	for _, test := range []struct {
+		alert   common.Alert
+		minutes int
+	}{
+		{common.Alert{Type: common.AlertTypeStaffAlert, Priority: common.AlertPriorityHigh}, 10},
+		{common.Alert{Type: common.AlertTypeStaffAlert, Priority: common.AlertPriorityLow}, 20},
+		{common.Alert{Type: common.AlertTypeSystemAlert, Priority: common.AlertPriorityHigh}, 30},
+		{common.Alert{Type: common.AlertTypeSystemAlert, Priority: common.AlertPriorityLow}, 60},
+	} {
+		if target := TargetOf(targets, &test.alert); target == nil || target.AssignMinutes != test.minutes {
+			t.Errorf("the %s %s alert gets the target %+v instead of the one of %d minutes", test.alert.Priority, test.alert.Type, target, test.minutes)
+		}
+	}
+	if target := TargetOf(nil, &common.Alert{}); target != nil {
+		t.Errorf("an alert without targets gets %+v", target)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	targets := map[string][]Target{"acme": {{AssignMinutes: 10, ClearMinutes: 60}}}
+	alerts := []common.Alert{
+		alertOf("tokyo", 0, 5, 30),
+		alertOf("tokyo", 10, 20, 90),
+		alertOf("osaka", 20, -1, -1),
+		alertOf("osaka", 30, 2, -1),
+	}
+	now := raised.Add(2 * time.Hour)
+
+	report := Build(alerts, targets, GroupBySite, "", time.UTC, now)
+	if len(report.Rows) != 2 || report.Rows[0].Key != "osaka" || report.Rows[1].Key != "tokyo" {
+		t.Fatalf("unexpected rows %+v", report.Rows)
+	}
+
+	tokyo := report.Rows[1]
+	if tokyo.Alerts != 2 || tokyo.Assigned != 2 || tokyo.Cleared != 2 || tokyo.AssignP50 != 5 || tokyo.AssignP95 != 20 || tokyo.ClearP50 != 30 || tokyo.ClearP90 != 90 {
+		t.Errorf("unexpected times %+v", tokyo)
+	}
+	if tokyo.AssignBreaches != 1 || tokyo.ClearBreaches != 1 || !tokyo.Breached {
+		t.Errorf("unexpected breaches %+v", tokyo)
+	}
+
+	// the alert still New breaches both targets, the Active one its clear target as it is open for longer than an hour
+	osaka := report.Rows[0]
+	if osaka.Alerts != 2 || osaka.Assigned != 1 || osaka.Cleared != 0 || osaka.AssignBreaches != 1 || osaka.ClearBreaches != 2 {
+		t.Errorf("unexpected row %+v", osaka)
+	}
+
+	if report.Total.Alerts != 4 || report.Total.AssignBreaches != 2 || report.Total.ClearBreaches != 3 || report.Total.Name != "Total" {
+		t.Errorf("unexpected total %+v", report.Total)
+	}
+}
+
+func TestBuildByStaffAndBucket(t *testing.T) {
+	alerts := []common.Alert{alertOf("tokyo", 0, 5, -1), alertOf("tokyo", 24*60, -1, -1)}
+
+	report := Build(alerts, nil, GroupByStaff, "", time.UTC, raised.Add(48*time.Hour))
+	if len(report.Rows) != 2 || report.Rows[0].Name != "Unassigned" || report.Rows[1].Name != "Kim" {
+		t.Errorf("unexpected staff rows %+v", report.Rows)
+	}
+	if report.Total.AssignBreaches != 0 || report.Total.ClearBreaches != 0 {
+		t.Errorf("alerts without targets breach %+v", report.Total)
+	}
+
+	report = Build(alerts, nil, GroupByBucket, BucketWeek, time.UTC, raised.Add(48*time.Hour))
+	if len(report.Rows) != 1 || report.Rows[0].Key != "2026-10-19" || report.Rows[0].Alerts != 2 || report.Bucket != BucketWeek {
+		t.Errorf("unexpected bucket rows %+v", report.Rows)
+	}
+}
+
+func TestStartOf(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("cannot load the time zone: %v", err)
+	}
+	at := time.Date(2026, 10, 21, 16, 0, 0, 0, time.UTC) // thursday 01:00 in Tokyo
+
+	for bucket, start := range map[string]string{
+		BucketDay:   "2026-10-22",
+		BucketWeek:  "2026-10-19",
+		BucketMonth: "2026-10-01",
+	} {
+		if got := StartOf(at, bucket, tokyo).Format(bucketLayout); got != start {
+			t.Errorf("the %s starts on %s instead of %s", bucket, got, start)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sms
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FakeSender keeps every sent message in memory instead of delivering it,
+// a configured error makes every send fail so fallbacks can be exercised
+type FakeSender struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error
+}
+
+// NewFakeSender creates an empty fake sender
+func NewFakeSender() *FakeSender {
+	return &FakeSender{}
+}
+
+// Send records the message or returns the configured error
+func (sender *FakeSender) Send(message *Message) error {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	if sender.err != nil {
+		return sender.err
+	}
+
+	log.Infof("Fake sms to %s: %s", message.To, message.Body)
+	sender.messages = append(sender.messages, *message)
+
+	return nil
+}
+
+// Fail makes the following sends return the error, nil makes them succeed again
+func (sender *FakeSender) Fail(err error) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.err = err
+}
+
+// Messages returns the sent messages in the order they were sent
+func (sender *FakeSender) Messages() []Message {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	return append([]Message{}, sender.messages...)
+}
+
+// Reset forgets the sent messages
+func (sender *FakeSender) Reset() {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.messages = nil
+}
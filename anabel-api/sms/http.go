@@ -0,0 +1,92 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"anacove.com/backend/config"
+)
+
+// httpSender posts the text messages as json to an sms gateway,
+// the field names are configurable so most gateways can be used without code
+type httpSender struct {
+	url          string
+	token        string
+	from         string
+	toField      string
+	fromField    string
+	messageField string
+	client       *http.Client
+}
+
+// newHTTPSender creates the sender from the sms.http configuration
+func newHTTPSender() (Sender, error) {
+	conf := config.GetConfig()
+	sender := &httpSender{
+		url:          conf.GetString("sms.http.url"),
+		token:        conf.GetString("sms.http.token"),
+		from:         conf.GetString("sms.http.from"),
+		toField:      conf.GetString("sms.http.to_field"),
+		fromField:    conf.GetString("sms.http.from_field"),
+		messageField: conf.GetString("sms.http.message_field"),
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if len(sender.url) == 0 {
+		return nil, errors.New("sms.http.url is not configured")
+	}
+	if len(sender.toField) == 0 {
+		sender.toField = "to"
+	}
+	if len(sender.fromField) == 0 {
+		sender.fromField = "from"
+	}
+	if len(sender.messageField) == 0 {
+		sender.messageField = "message"
+	}
+
+	return sender, nil
+}
+
+// Send posts the message to the gateway, any status other than 2xx is a failure
+func (sender *httpSender) Send(message *Message) error {
+	body := map[string]string{
+		sender.toField:      message.To,
+		sender.messageField: message.Body,
+	}
+	if len(sender.from) > 0 {
+		body[sender.fromField] = sender.from
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sender.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(sender.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+sender.token)
+	}
+
+	resp, err := sender.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sms gateway responded %s: %s", resp.Status, reply)
+	}
+
+	return nil
+}
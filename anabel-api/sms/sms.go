@@ -0,0 +1,90 @@
+package sms
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"anacove.com/backend/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DriverSNS sends text messages through aws sns
+	DriverSNS = "sns"
+	// DriverHTTP posts text messages to a generic http sms gateway
+	DriverHTTP = "http"
+	// DriverFake keeps the text messages in memory
+	DriverFake = "fake"
+)
+
+// Message godoc
+// describes a text message to a phone number in E.164 format
+type Message struct {
+	To   string
+	Body string
+}
+
+// Sender godoc
+// defines the operations of an sms provider
+type Sender interface {
+	// Send delivers the text message to its phone number
+	Send(message *Message) error
+}
+
+var sender Sender = nil
+
+// Init initializes the sms provider selected from configuration
+func Init() error {
+	driver := config.GetConfig().GetString("sms.driver")
+	if len(driver) == 0 {
+		driver = DriverSNS
+	}
+
+	var err error
+	switch driver {
+	case DriverSNS:
+		sender, err = newSNSSender()
+	case DriverHTTP:
+		sender, err = newHTTPSender()
+	case DriverFake:
+		sender = NewFakeSender()
+	default:
+		err = fmt.Errorf("unknown sms driver %s", driver)
+	}
+
+	if err != nil {
+		log.Errorf("Failed to initialize %s sms sender, error: %v", driver, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetSender returns the configured sms provider
+func GetSender() Sender {
+	return sender
+}
+
+// SetSender replaces the configured sms provider, tests use it with a FakeSender
+func SetSender(s Sender) {
+	sender = s
+}
+
+// Send validates the message and delivers it with the configured sms provider
+func Send(message *Message) error {
+	if sender == nil {
+		return errors.New("sms sender is not initialized")
+	}
+
+	message.To = strings.Replace(strings.TrimSpace(message.To), " ", "", -1)
+	if len(message.To) == 0 {
+		return errors.New("text message has no recipient")
+	}
+
+	if len(strings.TrimSpace(message.Body)) == 0 {
+		return errors.New("text message has no body")
+	}
+
+	return sender.Send(message)
+}
@@ -0,0 +1,89 @@
+package sms
+
+import (
+	"errors"
+	"testing"
+
+	"anacove.com/backend/config"
+	"github.com/spf13/viper"
+)
+
+func TestInitFakeDriver(t *testing.T) {
+	v := viper.New()
+	v.Set("sms.driver", DriverFake)
+	config.SetConfig(v)
+
+	err := Init()
+	if err != nil {
+		t.Fatalf("cannot init the fake driver: %v", err)
+	}
+	if _, ok := GetSender().(*FakeSender); !ok {
+		t.Fatalf("sender is %T instead of a fake sender", GetSender())
+	}
+}
+
+func TestInitUnknownDriver(t *testing.T) {
+	v := viper.New()
+	v.Set("sms.driver", "pigeon")
+	config.SetConfig(v)
+
+	if err := Init(); err == nil {
+		t.Fatal("an unknown driver is accepted")
+	}
+}
+
+func TestSendWithFakeSender(t *testing.T) {
+	fake := NewFakeSender()
+	SetSender(fake)
+
+	err := Send(&Message{To: " +81 90 1234 5678 ", Body: "Alert at Hotel"})
+	if err != nil {
+		t.Fatalf("cannot send: %v", err)
+	}
+
+	messages := fake.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages instead of 1", len(messages))
+	}
+	if messages[0].To != "+819012345678" || messages[0].Body != "Alert at Hotel" {
+		t.Errorf("unexpected message %+v", messages[0])
+	}
+
+	fake.Reset()
+	if len(fake.Messages()) != 0 {
+		t.Error("reset keeps the sent messages")
+	}
+}
+
+func TestSendRejectsIncompleteMessages(t *testing.T) {
+	fake := NewFakeSender()
+	SetSender(fake)
+
+	for _, message := range []Message{{To: " ", Body: "body"}, {To: "+819012345678", Body: " \n"}} {
+		if err := Send(&message); err == nil {
+			t.Errorf("message %+v is accepted", message)
+		}
+	}
+	if len(fake.Messages()) != 0 {
+		t.Errorf("incomplete messages were sent: %+v", fake.Messages())
+	}
+}
+
+func TestSendFailsWithFakeSender(t *testing.T) {
+	fake := NewFakeSender()
+	SetSender(fake)
+
+	failure := errors.New("provider down")
+	fake.Fail(failure)
+	if err := Send(&Message{To: "+819012345678", Body: "body"}); err != failure {
+		t.Fatalf("send returned %v instead of the configured error", err)
+	}
+	if len(fake.Messages()) != 0 {
+		t.Error("a failed message was recorded")
+	}
+
+	fake.Fail(nil)
+	if err := Send(&Message{To: "+819012345678", Body: "body"}); err != nil {
+		t.Fatalf("send still fails: %v", err)
+	}
+}
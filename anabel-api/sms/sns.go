@@ -0,0 +1,42 @@
+package sms
+
+import (
+	"errors"
+
+	"anacove.com/backend/config"
+	"anacove.com/backend/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// snsSender publishes transactional text messages straight to phone numbers through aws sns
+type snsSender struct {
+	senderID string
+}
+
+// newSNSSender creates the sender, it requires the global aws session
+func newSNSSender() (Sender, error) {
+	if utils.AwsSession() == nil {
+		return nil, errors.New("aws session is not initialized")
+	}
+
+	return &snsSender{senderID: config.GetConfig().GetString("sms.sns.sender_id")}, nil
+}
+
+// Send publishes the message to the phone number
+func (sender *snsSender) Send(message *Message) error {
+	attributes := map[string]*sns.MessageAttributeValue{
+		"AWS.SNS.SMS.SMSType": {DataType: aws.String("String"), StringValue: aws.String("Transactional")},
+	}
+	if len(sender.senderID) > 0 {
+		attributes["AWS.SNS.SMS.SenderID"] = &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(sender.senderID)}
+	}
+
+	_, err := sns.New(utils.AwsSession()).Publish(&sns.PublishInput{
+		PhoneNumber:       aws.String(message.To),
+		Message:           aws.String(message.Body),
+		MessageAttributes: attributes,
+	})
+
+	return err
+}
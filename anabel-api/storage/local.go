@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"anacove.com/backend/config"
+	"github.com/google/uuid"
+)
+
+// LocalRoutePath is the route serving local objects, relative to the api root
+const LocalRoutePath = "/storage"
+
+// multipartDir keeps the parts of unfinished multipart uploads inside the root directory
+const multipartDir = ".multipart"
+
+// localStorage stores objects as plain files under a root directory
+type localStorage struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+}
+
+// newLocalStorage creates the storage under the configured root directory
+func newLocalStorage() (Storage, error) {
+	root := config.GetConfig().GetString("storage.local.root")
+	if len(root) == 0 {
+		root = "uploads"
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localStorage{
+		root:       root,
+		baseURL:    strings.TrimRight(config.GetConfig().GetString("storage.local.base_url"), "/"),
+		signingKey: []byte(config.GetConfig().GetString("storage.local.signing_key")),
+	}, nil
+}
+
+// filePath maps the object key to a path inside the root directory
+func (storage *localStorage) filePath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.HasPrefix(cleaned, "/"+multipartDir) {
+		return "", errors.New("invalid object key")
+	}
+
+	return filepath.Join(storage.root, filepath.FromSlash(cleaned)), nil
+}
+
+// partPath maps a part of a multipart upload to a path inside the root directory
+func (storage *localStorage) partPath(uploadID string, partNumber int) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", errors.New("invalid upload id")
+	}
+
+	return filepath.Join(storage.root, multipartDir, uploadID, strconv.Itoa(partNumber)), nil
+}
+
+// writeFile streams the content into the file, creating its directory
+func writeFile(filePath string, body io.Reader) (string, error) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	_, err = io.Copy(file, io.TeeReader(body, hash))
+	if err != nil {
+		return "", err
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// Put writes the content to the file of the key
+func (storage *localStorage) Put(key string, body io.ReadSeeker, size int64, contentType string) error {
+	filePath, err := storage.filePath(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = body.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFile(filePath, body)
+	return err
+}
+
+// Get opens the file of the key
+func (storage *localStorage) Get(key string) (io.ReadCloser, *Object, error) {
+	filePath, err := storage.filePath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	object := storage.toObject(key, info)
+
+	return file, &object, nil
+}
+
+// Delete removes the file of the key
+func (storage *localStorage) Delete(key string) error {
+	filePath, err := storage.filePath(key)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filePath)
+}
+
+// Presign returns the download route of the object signed with an expiry,
+// so the browser can open it without an authorization header
+func (storage *localStorage) Presign(key string, expiry time.Duration) (string, error) {
+	return storage.presign(http.MethodGet, key, url.Values{}, expiry)
+}
+
+// PresignPut returns the upload route of the object signed with an expiry
+func (storage *localStorage) PresignPut(key string, contentType string, expiry time.Duration) (string, error) {
+	return storage.presign(http.MethodPut, key, url.Values{}, expiry)
+}
+
+// CreateMultipartUpload reserves a directory for the parts of the upload
+func (storage *localStorage) CreateMultipartUpload(key string, contentType string) (string, error) {
+	uploadID := uuid.New().String()
+	err := os.MkdirAll(filepath.Join(storage.root, multipartDir, uploadID), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// PresignPart returns the upload route of one part signed with an expiry
+func (storage *localStorage) PresignPart(key string, uploadID string, partNumber int, expiry time.Duration) (string, error) {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	return storage.presign(http.MethodPut, key, query, expiry)
+}
+
+// CompleteMultipartUpload concatenates the parts in order into the file of the key
+func (storage *localStorage) CompleteMultipartUpload(key string, uploadID string, parts []Part) error {
+	filePath, err := storage.filePath(key)
+	if err != nil {
+		return err
+	}
+
+	readers := []io.Reader{}
+	for _, part := range parts {
+		partPath, err := storage.partPath(uploadID, part.PartNumber)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		hash := md5.New()
+		_, err = io.Copy(hash, file)
+		if err != nil {
+			return err
+		}
+		if strings.Trim(part.ETag, `"`) != hex.EncodeToString(hash.Sum(nil)) {
+			return fmt.Errorf("etag of part %d does not match", part.PartNumber)
+		}
+
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		readers = append(readers, file)
+	}
+
+	_, err = writeFile(filePath, io.MultiReader(readers...))
+	if err != nil {
+		return err
+	}
+
+	return storage.AbortMultipartUpload(key, uploadID)
+}
+
+// AbortMultipartUpload removes the parts of the upload
+func (storage *localStorage) AbortMultipartUpload(key string, uploadID string) error {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return errors.New("invalid upload id")
+	}
+
+	return os.RemoveAll(filepath.Join(storage.root, multipartDir, uploadID))
+}
+
+// Stat returns the details of the file of the key
+func (storage *localStorage) Stat(key string) (*Object, error) {
+	filePath, err := storage.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	object := storage.toObject(key, info)
+
+	return &object, nil
+}
+
+// List walks the root directory and returns the files under the prefix
+func (storage *localStorage) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.Walk(storage.root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == multipartDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(storage.root, filePath)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.toObject(key, info))
+		}
+		return nil
+	})
+
+	return objects, err
+}
+
+// URL returns the download route of the object
+func (storage *localStorage) URL(key string) string {
+	return storage.baseURL + "/api/v1" + LocalRoutePath + "/" + key
+}
+
+func (storage *localStorage) toObject(key string, info os.FileInfo) Object {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+
+	return Object{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  contentType,
+		LastModified: info.ModTime().UTC(),
+	}
+}
+
+// presign adds the expiry and signature to the route of the object
+func (storage *localStorage) presign(method string, key string, query url.Values, expiry time.Duration) (string, error) {
+	if len(storage.signingKey) == 0 {
+		return "", errors.New("storage.local.signing_key is not configured")
+	}
+
+	query.Set("expires", strconv.FormatInt(time.Now().UTC().Add(expiry).Unix(), 10))
+	query.Set("signature", storage.sign(method, key, query))
+
+	return storage.URL(key) + "?" + query.Encode(), nil
+}
+
+// sign creates the hmac signature of the method, key, upload part and expiry
+func (storage *localStorage) sign(method string, key string, query url.Values) string {
+	mac := hmac.New(sha256.New, storage.signingKey)
+	mac.Write([]byte(strings.Join([]string{method, key, query.Get("uploadId"), query.Get("partNumber"), query.Get("expires")}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a presigned local url is genuine, issued for the method and not expired
+func VerifySignature(method string, key string, query url.Values) bool {
+	local, ok := storage.(*localStorage)
+	if !ok || len(local.signingKey) == 0 {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || expiresAt < time.Now().UTC().Unix() {
+		return false
+	}
+
+	return hmac.Equal([]byte(local.sign(method, key, query)), []byte(query.Get("signature")))
+}
+
+// WriteLocal stores the body uploaded through a presigned local url,
+// it returns the etag of the written content
+func WriteLocal(key string, query url.Values, body io.Reader) (string, error) {
+	local, ok := storage.(*localStorage)
+	if !ok {
+		return "", errors.New("storage is not local")
+	}
+
+	uploadID := query.Get("uploadId")
+	if len(uploadID) == 0 {
+		filePath, err := local.filePath(key)
+		if err != nil {
+			return "", err
+		}
+		return writeFile(filePath, body)
+	}
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 {
+		return "", errors.New("invalid part number")
+	}
+
+	partPath, err := local.partPath(uploadID, partNumber)
+	if err != nil {
+		return "", err
+	}
+
+	// the upload must have been started by CreateMultipartUpload
+	if _, err := os.Stat(filepath.Dir(partPath)); err != nil {
+		return "", err
+	}
+
+	return writeFile(partPath, body)
+}
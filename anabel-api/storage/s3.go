@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"anacove.com/backend/config"
+	"anacove.com/backend/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3Storage stores objects in aws s3 or any s3 compatible service
+type s3Storage struct {
+	session *session.Session
+	bucket  string
+	baseURL string
+	encrypt bool
+}
+
+// newS3Storage creates the storage on top of the global aws session
+func newS3Storage() (Storage, error) {
+	if utils.AwsSession() == nil {
+		return nil, errors.New("aws session is not initialized")
+	}
+
+	bucket := config.GetConfig().GetString("aws.s3_bucket")
+	region := config.GetConfig().GetString("aws.s3_region")
+
+	return &s3Storage{
+		session: utils.AwsSession(),
+		bucket:  bucket,
+		baseURL: fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region),
+		encrypt: true,
+	}, nil
+}
+
+// newS3CompatibleStorage creates the storage for a self hosted s3 compatible service like MinIO
+func newS3CompatibleStorage() (Storage, error) {
+	endpoint := strings.TrimRight(config.GetConfig().GetString("storage.s3compatible.endpoint"), "/")
+	bucket := config.GetConfig().GetString("storage.s3compatible.bucket")
+
+	session, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(config.GetConfig().GetString("storage.s3compatible.region")),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials: credentials.NewStaticCredentials(
+			config.GetConfig().GetString("storage.s3compatible.access_key_id"),
+			config.GetConfig().GetString("storage.s3compatible.secret_access_key"),
+			""),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Storage{
+		session: session,
+		bucket:  bucket,
+		baseURL: endpoint + "/" + bucket,
+		encrypt: false,
+	}, nil
+}
+
+func (storage *s3Storage) client() *s3.S3 {
+	return s3.New(storage.session.Copy())
+}
+
+// Put uploads the content to the bucket as a private object
+func (storage *s3Storage) Put(key string, body io.ReadSeeker, size int64, contentType string) error {
+	// Make sure we always stream from the beginning of the content
+	_, err := body.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:             aws.String(storage.bucket),
+		Key:                aws.String(key),
+		ACL:                aws.String("private"),
+		Body:               body,
+		ContentLength:      aws.Int64(size),
+		ContentType:        aws.String(contentType),
+		ContentDisposition: aws.String("attachment"),
+	}
+	if storage.encrypt {
+		input.ServerSideEncryption = aws.String("AES256")
+	}
+
+	_, err = storage.client().PutObject(input)
+	return err
+}
+
+// Get opens the object from the bucket
+func (storage *s3Storage) Get(key string) (io.ReadCloser, *Object, error) {
+	output, err := storage.client().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	object := Object{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ContentType:  aws.StringValue(output.ContentType),
+		LastModified: aws.TimeValue(output.LastModified),
+	}
+
+	return output.Body, &object, nil
+}
+
+// Delete removes the object from the bucket
+func (storage *s3Storage) Delete(key string) error {
+	_, err := storage.client().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// Presign creates a time limited GET url of the private object
+func (storage *s3Storage) Presign(key string, expiry time.Duration) (string, error) {
+	req, _ := storage.client().GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(expiry)
+}
+
+// PresignPut creates a time limited PUT url, the browser must send the same content type
+func (storage *s3Storage) PresignPut(key string, contentType string, expiry time.Duration) (string, error) {
+	req, _ := storage.client().PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(storage.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	return req.Presign(expiry)
+}
+
+// CreateMultipartUpload starts a multipart upload in the bucket
+func (storage *s3Storage) CreateMultipartUpload(key string, contentType string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(storage.bucket),
+		Key:                aws.String(key),
+		ACL:                aws.String("private"),
+		ContentType:        aws.String(contentType),
+		ContentDisposition: aws.String("attachment"),
+	}
+	if storage.encrypt {
+		input.ServerSideEncryption = aws.String("AES256")
+	}
+
+	output, err := storage.client().CreateMultipartUpload(input)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.UploadId), nil
+}
+
+// PresignPart creates a time limited PUT url of one part
+func (storage *s3Storage) PresignPart(key string, uploadID string, partNumber int, expiry time.Duration) (string, error) {
+	req, _ := storage.client().UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(storage.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(int64(partNumber)),
+	})
+	return req.Presign(expiry)
+}
+
+// CompleteMultipartUpload assembles the parts into the object
+func (storage *s3Storage) CompleteMultipartUpload(key string, uploadID string, parts []Part) error {
+	completed := []*s3.CompletedPart{}
+	for _, part := range parts {
+		completed = append(completed, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(int64(part.PartNumber)),
+		})
+	}
+
+	_, err := storage.client().CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(storage.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+// AbortMultipartUpload discards the uploaded parts
+func (storage *s3Storage) AbortMultipartUpload(key string, uploadID string) error {
+	_, err := storage.client().AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(storage.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// Stat returns the details of the object without reading it
+func (storage *s3Storage) Stat(key string) (*Object, error) {
+	output, err := storage.client().HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ContentType:  aws.StringValue(output.ContentType),
+		LastModified: aws.TimeValue(output.LastModified),
+	}, nil
+}
+
+// List returns all objects under the prefix
+func (storage *s3Storage) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := storage.client().ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(storage.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.StringValue(item.Key),
+				Size:         aws.Int64Value(item.Size),
+				LastModified: aws.TimeValue(item.LastModified),
+			})
+		}
+		return true
+	})
+
+	return objects, err
+}
+
+// URL returns the bucket location of the object
+func (storage *s3Storage) URL(key string) string {
+	return storage.baseURL + "/" + key
+}
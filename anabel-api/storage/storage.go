@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"anacove.com/backend/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DriverS3 stores objects in an aws s3 bucket
+	DriverS3 = "s3"
+	// DriverS3Compatible stores objects in any s3 compatible service like MinIO
+	DriverS3Compatible = "s3compatible"
+	// DriverLocal stores objects on the local file system
+	DriverLocal = "local"
+)
+
+// Object godoc
+// describes a stored object
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Part godoc
+// describes an uploaded part of a multipart upload
+type Part struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// Storage godoc
+// defines the operations of a blob storage backend
+type Storage interface {
+	// Put stores the content under the given key
+	Put(key string, body io.ReadSeeker, size int64, contentType string) error
+	// Get opens the object of the given key, the caller must close the reader
+	Get(key string) (io.ReadCloser, *Object, error)
+	// Delete removes the object of the given key
+	Delete(key string) error
+	// Presign returns a url that grants access to the object for the given period
+	Presign(key string, expiry time.Duration) (string, error)
+	// PresignPut returns a url the browser can upload the whole object to
+	PresignPut(key string, contentType string, expiry time.Duration) (string, error)
+	// CreateMultipartUpload starts a multipart upload and returns its id
+	CreateMultipartUpload(key string, contentType string) (string, error)
+	// PresignPart returns a url the browser can upload one part of a multipart upload to
+	PresignPart(key string, uploadID string, partNumber int, expiry time.Duration) (string, error)
+	// CompleteMultipartUpload assembles the uploaded parts into the object
+	CompleteMultipartUpload(key string, uploadID string, parts []Part) error
+	// AbortMultipartUpload discards the uploaded parts
+	AbortMultipartUpload(key string, uploadID string) error
+	// Stat returns the details of the object
+	Stat(key string) (*Object, error)
+	// List returns the objects whose key starts with the prefix
+	List(prefix string) ([]Object, error)
+	// URL returns the permanent location of the object
+	URL(key string) string
+}
+
+var storage Storage = nil
+
+var driver string
+
+// Init initializes the storage backend selected from configuration
+func Init() error {
+	driver = config.GetConfig().GetString("storage.driver")
+	if len(driver) == 0 {
+		driver = DriverS3
+	}
+
+	var err error
+	switch driver {
+	case DriverS3:
+		storage, err = newS3Storage()
+	case DriverS3Compatible:
+		storage, err = newS3CompatibleStorage()
+	case DriverLocal:
+		storage, err = newLocalStorage()
+	default:
+		err = fmt.Errorf("unknown storage driver %s", driver)
+	}
+
+	if err != nil {
+		log.Errorf("Failed to initialize %s storage, error: %v", driver, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetStorage returns the configured storage backend
+func GetStorage() Storage {
+	return storage
+}
+
+// IsLocal tells weather objects are stored on the local file system
+func IsLocal() bool {
+	return driver == DriverLocal
+}
@@ -89,6 +89,22 @@ func (CommonService *CommonService) GetUserByToken(token string) (*common.User,
 	return &user, nil
 }
 
+// GetFileByURL return the uploaded file record by its url
+func (CommonService *CommonService) GetFileByURL(url string) (*common.File, error) {
+	session := NewDBSession()
+	defer session.Close()
+	c := session.DB("").C(common.FileCollection)
+	file := common.File{}
+	err := c.Find(bson.M{"url": url}).One(&file)
+
+	if err != nil {
+		log.Errorf("Failed to get file by url, error: %v", err)
+		return nil, err
+	}
+
+	return &file, nil
+}
+
 // isUserExistsInScope checks user has permission to resource user
 func isUserExistsInScope(scopes []common.Scope, userID string, collections *mgo.Collection) bool {
 	objUserID := bson.ObjectIdHex(userID)
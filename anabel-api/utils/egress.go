@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// privateNetworks lists the address ranges outgoing requests to urls set by users must not reach:
+// this host, the private networks, the link-local ones with the cloud metadata endpoints and the multicast ones
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// ErrPrivateAddress is returned for urls resolving to an address of privateNetworks
+var ErrPrivateAddress = errors.New("the host resolves to a private, loopback or link-local address")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// IsPrivateIP checks the address is in one of privateNetworks, ipv4 addresses mapped to ipv6 included
+func IsPrivateIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckPublicURL resolves the host of the url and fails when any of its addresses is private
+func CheckPublicURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	ips, err := net.LookupIP(parsed.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if IsPrivateIP(ip) {
+			return ErrPrivateAddress
+		}
+	}
+
+	return nil
+}
+
+// NewPublicClient returns a client for urls set by users which does not follow redirects and,
+// unless allowPrivate is set for local setups, refuses to connect to private addresses.
+// The address is checked when dialing, so a host resolving to another address after it was validated is refused too
+func NewPublicClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || IsPrivateIP(ip) {
+				return ErrPrivateAddress
+			}
+
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	// registers the gif decoder, animated gifs are flattened to their first frame
+	_ "image/gif"
+)
+
+// DecodeImage validates the image dimensions from its header before decoding it,
+// images above maxPixels are rejected to protect against decompression bombs.
+// Only the header is buffered, the rest of the image is decoded as it is read
+func DecodeImage(reader io.Reader, maxPixels int) (image.Image, string, error) {
+	header := bytes.Buffer{}
+	cfg, format, err := image.DecodeConfig(io.TeeReader(reader, &header))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, "", errors.New("image dimensions are out of range")
+	}
+
+	img, format, err := image.Decode(io.MultiReader(&header, reader))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return img, format, nil
+}
+
+// EncodeImage encodes the image as jpeg or png, no metadata like EXIF is written.
+// It returns the encoded bytes and their content type
+func EncodeImage(img image.Image, format string, quality int) ([]byte, string, error) {
+	buffer := bytes.Buffer{}
+	if format == "jpeg" {
+		err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality})
+		return buffer.Bytes(), "image/jpeg", err
+	}
+
+	err := png.Encode(&buffer, img)
+	return buffer.Bytes(), "image/png", err
+}
+
+// ResizeImage scales the image down to fit in a maxSide x maxSide box keeping its ratio,
+// every target pixel is the average of the source pixels it covers
+func ResizeImage(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+
+	targetWidth, targetHeight := maxSide, maxSide
+	if width > height {
+		targetHeight = maxInt(1, height*maxSide/width)
+	} else {
+		targetWidth = maxInt(1, width*maxSide/height)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0 := y * height / targetHeight
+		y1 := maxInt(y0+1, (y+1)*height/targetHeight)
+		for x := 0; x < targetWidth; x++ {
+			x0 := x * width / targetWidth
+			x1 := maxInt(x0+1, (x+1)*width/targetWidth)
+
+			var r, g, b, a, count int
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					a += int(src.Pix[offset+3])
+					offset += 4
+					count++
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / count)
+			dst.Pix[offset+1] = uint8(g / count)
+			dst.Pix[offset+2] = uint8(b / count)
+			dst.Pix[offset+3] = uint8(a / count)
+		}
+	}
+
+	return dst
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
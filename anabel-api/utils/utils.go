@@ -95,3 +95,11 @@ func Contains(arr []string, val string) bool {
 	}
 	return false
 }
+
+// InvitationStatus returns the status of the invitation, pending invitations past their expiry are expired
+func InvitationStatus(invitation *common.Invitation) string {
+	if invitation.Status == common.InvitationPending && time.Now().UTC().After(invitation.ExpiresAt) {
+		return common.InvitationExpired
+	}
+	return invitation.Status
+}
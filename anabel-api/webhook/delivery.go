@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"anacove.com/backend/config"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader carries the timestamp and the hmac-sha256 of the body, like t=1600000000,v1=<hex>
+	SignatureHeader = "X-Anacove-Signature"
+	// EventHeader carries the event type
+	EventHeader = "X-Anacove-Event"
+	// DeliveryHeader carries the delivery id, it stays the same when the delivery is retried or replayed
+	DeliveryHeader = "X-Anacove-Delivery"
+)
+
+const (
+	// defaultTimeout is used when webhook.timeout_in_seconds is not configured
+	defaultTimeout = 10 * time.Second
+	// maxAttemptsLogged limits the attempts kept in the delivery log, the oldest are dropped
+	maxAttemptsLogged = 20
+)
+
+// Sign returns the signature header value of the body sent at the timestamp,
+// receivers compute the hmac-sha256 of "<timestamp>.<body>" with the secret and compare it to v1
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// deliverQueued posts the delivery of an outbox message and records the attempt,
+// the outbox retries failed attempts with backoff
+func deliverQueued(message *outbox.Message) error {
+	payload := queued{}
+	err := message.Decode(&payload)
+	if err != nil {
+		return err
+	}
+	if !bson.IsObjectIdHex(payload.DeliveryID) {
+		return outbox.Permanent(errors.New("invalid delivery id " + payload.DeliveryID))
+	}
+
+	delivery, err := store.FindDelivery(bson.ObjectIdHex(payload.DeliveryID))
+	if err == mgo.ErrNotFound {
+		return outbox.Permanent(errors.New("delivery " + payload.DeliveryID + " does not exist"))
+	}
+	if err != nil {
+		return err
+	}
+	if delivery.Status == DeliveryDelivered {
+		return nil
+	}
+
+	webhook := &Webhook{}
+	err = mgo.ErrNotFound
+	if bson.IsObjectIdHex(delivery.WebhookID) {
+		webhook, err = store.FindWebhook(bson.ObjectIdHex(delivery.WebhookID))
+	}
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if err == mgo.ErrNotFound || webhook.Status != StatusActive {
+		recordAttempt(delivery, Attempt{At: time.Now().UTC(), Error: ErrWebhookDisabled.Error()}, DeliveryFailed)
+		return outbox.Permanent(ErrWebhookDisabled)
+	}
+
+	attempt := post(webhook, delivery)
+	if len(attempt.Error) == 0 {
+		recordAttempt(delivery, attempt, DeliveryDelivered)
+		err = store.RecordSuccess(webhook.ID, attempt.At)
+		if err != nil {
+			log.Errorf("Failed to update webhook %s, error: %v", webhook.ID.Hex(), err)
+		}
+		return nil
+	}
+
+	// the outbox gives up after this attempt, the delivery waits for a replay
+	status := DeliveryPending
+	if message.Attempts+1 >= message.MaxAttempts {
+		status = DeliveryFailed
+	}
+
+	disabled := recordFailure(webhook, attempt)
+	if disabled {
+		status = DeliveryFailed
+	}
+	recordAttempt(delivery, attempt, status)
+
+	err = errors.New(attempt.Error)
+	if disabled {
+		return outbox.Permanent(err)
+	}
+
+	return err
+}
+
+// post sends the signed body of the delivery to the webhook, any status other than 2xx is a failure.
+// Redirects are not followed so the signed body only goes to the registered url, and only the status
+// of the response is logged as the clients can read the delivery log
+func post(webhook *Webhook, delivery *Delivery) Attempt {
+	start := time.Now()
+	attempt := Attempt{At: start.UTC()}
+
+	body := []byte(delivery.Body)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Anacove-Webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, start.Unix(), body))
+
+	client := utils.NewPublicClient(getTimeout(), config.GetConfig().GetBool("webhook.allow_private_addresses"))
+	resp, err := client.Do(req)
+	attempt.DurationMs = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("receiver responded %d", resp.StatusCode)
+	}
+
+	return attempt
+}
+
+// recordAttempt appends the attempt to the delivery log and sets the status of the delivery
+func recordAttempt(delivery *Delivery, attempt Attempt, status string) {
+	err := store.RecordAttempt(delivery.ID, attempt, status)
+	if err != nil {
+		log.Errorf("Failed to record attempt of webhook delivery %s, error: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// recordFailure counts the failed attempt against the webhook and disables it once too many attempts failed in a row,
+// it returns whether the webhook is disabled
+func recordFailure(webhook *Webhook, attempt Attempt) bool {
+	failures, err := store.RecordFailure(webhook.ID, attempt.At)
+	if err != nil {
+		log.Errorf("Failed to count failure of webhook %s, error: %v", webhook.ID.Hex(), err)
+		return false
+	}
+
+	if failures < getDisableAfter() {
+		return false
+	}
+
+	reason := fmt.Sprintf("%d failed attempts in a row, last: %s", failures, attempt.Error)
+	err = store.Disable(webhook.ID, reason, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Failed to disable webhook %s, error: %v", webhook.ID.Hex(), err)
+		return false
+	}
+
+	log.Warnf("Webhook %s of client %s is disabled after %d failed attempts", webhook.ID.Hex(), webhook.ClientID, failures)
+	return true
+}
+
+// getTimeout returns the configured time a receiver has to answer
+func getTimeout() time.Duration {
+	if seconds := config.GetConfig().GetInt("webhook.timeout_in_seconds"); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	return defaultTimeout
+}
+
+// getDisableAfter returns the configured number of failed attempts in a row which disable a webhook
+func getDisableAfter() int {
+	if failures := config.GetConfig().GetInt("webhook.disable_after_failures"); failures > 0 {
+		return failures
+	}
+
+	return defaultDisableAfter
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"time"
+
+	"anacove.com/backend/common"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Store godoc
+// defines how the webhooks and their deliveries are kept while events are published and delivered
+type Store interface {
+	// Subscribed returns the active webhooks of the client subscribed to the event
+	Subscribed(clientID string, event string) ([]Webhook, error)
+	// FindWebhook returns the webhook, mgo.ErrNotFound when it does not exist
+	FindWebhook(id bson.ObjectId) (*Webhook, error)
+	// FindDelivery returns the delivery, mgo.ErrNotFound when it does not exist
+	FindDelivery(id bson.ObjectId) (*Delivery, error)
+	// InsertDelivery stores a new delivery
+	InsertDelivery(delivery *Delivery) error
+	// Queue stores the delivery in the outbox, which attempts it and retries it with backoff
+	Queue(delivery *Delivery) error
+	// Reopen sets the delivery pending again and counts the replay
+	Reopen(id bson.ObjectId, now time.Time) (*Delivery, error)
+	// RecordAttempt appends the attempt to the delivery log and sets the status of the delivery
+	RecordAttempt(id bson.ObjectId, attempt Attempt, status string) error
+	// RecordSuccess clears the failures in a row of the webhook
+	RecordSuccess(id bson.ObjectId, at time.Time) error
+	// RecordFailure counts a failed attempt against the webhook and returns its failures in a row
+	RecordFailure(id bson.ObjectId, at time.Time) (int, error)
+	// Disable disables the webhook with the reason, a webhook which is not active anymore stays as it is
+	Disable(id bson.ObjectId, reason string, now time.Time) error
+}
+
+var store Store = mongoStore{}
+
+// SetStore replaces the store of the webhooks, tests use it with a store in memory
+func SetStore(s Store) {
+	store = s
+}
+
+// mongoStore keeps the webhooks and deliveries in their collections and queues the deliveries in the outbox
+type mongoStore struct {
+}
+
+// Subscribed returns the active webhooks of the client subscribed to the event
+func (mongoStore) Subscribed(clientID string, event string) ([]Webhook, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	webhooks := []Webhook{}
+	err := session.DB("").C(common.WebhookCollection).Find(bson.M{"clientId": clientID, "status": StatusActive, "events": event}).All(&webhooks)
+
+	return webhooks, err
+}
+
+// FindWebhook returns the webhook
+func (mongoStore) FindWebhook(id bson.ObjectId) (*Webhook, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	webhook := Webhook{}
+	err := session.DB("").C(common.WebhookCollection).FindId(id).One(&webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// FindDelivery returns the delivery
+func (mongoStore) FindDelivery(id bson.ObjectId) (*Delivery, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	delivery := Delivery{}
+	err := session.DB("").C(common.WebhookDeliveryCollection).FindId(id).One(&delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// InsertDelivery stores a new delivery
+func (mongoStore) InsertDelivery(delivery *Delivery) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	return session.DB("").C(common.WebhookDeliveryCollection).Insert(delivery)
+}
+
+// Queue stores the delivery in the outbox
+func (mongoStore) Queue(delivery *Delivery) error {
+	_, err := outbox.Enqueue(outbox.KindWebhook, "webhook:"+delivery.WebhookID, queued{DeliveryID: delivery.ID.Hex()})
+	return err
+}
+
+// Reopen sets the delivery pending again and counts the replay
+func (mongoStore) Reopen(id bson.ObjectId, now time.Time) (*Delivery, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	delivery := Delivery{}
+	_, err := session.DB("").C(common.WebhookDeliveryCollection).FindId(id).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": DeliveryPending, "updatedAt": now}, "$inc": bson.M{"replays": 1}},
+		ReturnNew: true,
+	}, &delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// RecordAttempt appends the attempt to the delivery log, keeping the latest attempts only
+func (mongoStore) RecordAttempt(id bson.ObjectId, attempt Attempt, status string) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	set := bson.M{"status": status, "updatedAt": time.Now().UTC()}
+	if status == DeliveryDelivered {
+		set["deliveredAt"] = attempt.At
+	}
+
+	return session.DB("").C(common.WebhookDeliveryCollection).UpdateId(id, bson.M{
+		"$set":  set,
+		"$push": bson.M{"attempts": bson.M{"$each": []Attempt{attempt}, "$slice": -maxAttemptsLogged}},
+	})
+}
+
+// RecordSuccess clears the failures in a row of the webhook
+func (mongoStore) RecordSuccess(id bson.ObjectId, at time.Time) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	return session.DB("").C(common.WebhookCollection).UpdateId(id, bson.M{"$set": bson.M{
+		"consecutiveFailures": 0,
+		"lastDeliveryAt":      at,
+	}})
+}
+
+// RecordFailure counts the failed attempt atomically so concurrent deliveries do not lose failures
+func (mongoStore) RecordFailure(id bson.ObjectId, at time.Time) (int, error) {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	updated := Webhook{}
+	_, err := session.DB("").C(common.WebhookCollection).FindId(id).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"consecutiveFailures": 1}, "$set": bson.M{"lastDeliveryAt": at}},
+		ReturnNew: true,
+	}, &updated)
+
+	return updated.ConsecutiveFailures, err
+}
+
+// Disable disables the webhook while it is active
+func (mongoStore) Disable(id bson.ObjectId, reason string, now time.Time) error {
+	session := utils.NewDBSession()
+	defer session.Close()
+
+	err := session.DB("").C(common.WebhookCollection).Update(bson.M{"_id": id, "status": StatusActive}, bson.M{"$set": bson.M{
+		"status":         StatusDisabled,
+		"disabledReason": reason,
+		"disabledAt":     now,
+		"updatedAt":      now,
+	}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+
+	return err
+}
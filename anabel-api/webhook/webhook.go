@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/notification"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// EventAlertCreated is published when an alert is raised at a site of the client
+	EventAlertCreated = "alert.created"
+	// EventAlertCleared is published when an alert of the client is cleared
+	EventAlertCleared = "alert.cleared"
+	// EventUserCreated is published when a user of the client is created
+	EventUserCreated = "user.created"
+	// EventClientArchived is published when the client is archived
+	EventClientArchived = "client.archived"
+	// EventNotificationCreated is published for users who selected the webhook channel in their notification matrix
+	EventNotificationCreated = "notification.created"
+)
+
+// Events lists the events a webhook can subscribe to
+var Events = []string{
+	EventAlertCreated,
+	EventAlertCleared,
+	EventUserCreated,
+	EventClientArchived,
+	EventNotificationCreated,
+}
+
+const (
+	// StatusActive the webhook receives its events
+	StatusActive = "active"
+	// StatusDisabled the webhook was disabled by a CSA or after too many failures and receives nothing
+	StatusDisabled = "disabled"
+)
+
+const (
+	// DeliveryPending the delivery waits for its first or next attempt
+	DeliveryPending = "pending"
+	// DeliveryDelivered the receiver answered with a 2xx status
+	DeliveryDelivered = "delivered"
+	// DeliveryFailed every attempt failed or the webhook was disabled, the delivery waits for a replay
+	DeliveryFailed = "failed"
+)
+
+// defaultDisableAfter is used when webhook.disable_after_failures is not configured
+const defaultDisableAfter = 15
+
+// Webhook godoc
+// describes the subscription of a client to events, delivered as signed json posts to the url
+type Webhook struct {
+	ID                  bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	ClientID            string        `json:"clientId" bson:"clientId"`
+	URL                 string        `json:"url" bson:"url"`
+	Description         string        `json:"description" bson:"description"`
+	Events              []string      `json:"events" bson:"events"`
+	Secret              string        `json:"secret,omitempty" bson:"secret"`
+	Status              string        `json:"status" bson:"status"`
+	ConsecutiveFailures int           `json:"consecutiveFailures" bson:"consecutiveFailures"`
+	DisabledReason      string        `json:"disabledReason" bson:"disabledReason"`
+	DisabledAt          time.Time     `json:"disabledAt" bson:"disabledAt,omitempty"`
+	LastDeliveryAt      time.Time     `json:"lastDeliveryAt" bson:"lastDeliveryAt,omitempty"`
+	CreatedBy           string        `json:"createdBy" bson:"createdBy"`
+	CreatedAt           time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt           time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Attempt godoc
+// records the outcome of one post of a delivery
+type Attempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"statusCode" bson:"statusCode"`
+	Error      string    `json:"error" bson:"error"`
+	DurationMs int64     `json:"durationMs" bson:"durationMs"`
+}
+
+// Delivery godoc
+// describes an event sent to a webhook, the body is kept as sent so replays carry the same event
+type Delivery struct {
+	ID          bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	WebhookID   string        `json:"webhookId" bson:"webhookId"`
+	ClientID    string        `json:"clientId" bson:"clientId"`
+	EventID     string        `json:"eventId" bson:"eventId"`
+	Event       string        `json:"event" bson:"event"`
+	Body        string        `json:"body" bson:"body"`
+	Status      string        `json:"status" bson:"status"`
+	Attempts    []Attempt     `json:"attempts" bson:"attempts"`
+	Replays     int           `json:"replays" bson:"replays"`
+	CreatedAt   time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time     `json:"updatedAt" bson:"updatedAt"`
+	DeliveredAt time.Time     `json:"deliveredAt" bson:"deliveredAt,omitempty"`
+}
+
+// Event godoc
+// is the json body posted to the webhooks, the id is shared by the deliveries of one event so receivers can drop duplicates
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	ClientID  string      `json:"clientId"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// queued is the outbox payload of a delivery
+type queued struct {
+	DeliveryID string `bson:"deliveryId"`
+}
+
+// ErrWebhookDisabled is returned when a delivery of a disabled webhook is replayed
+var ErrWebhookDisabled = errors.New("webhook is disabled")
+
+// Init creates the indexes and registers the delivery of the queued events and the webhook notification channel,
+// it must be called before outbox.Start
+func Init() {
+	session := utils.NewDBSession()
+	err := session.DB("").C(common.WebhookCollection).EnsureIndex(mgo.Index{Key: []string{"clientId", "status", "events"}})
+	if err == nil {
+		err = session.DB("").C(common.WebhookDeliveryCollection).EnsureIndex(mgo.Index{Key: []string{"webhookId", "-createdAt"}})
+	}
+	session.Close()
+	if err != nil {
+		log.Errorf("Failed to create webhook indexes, error: %v", err)
+	}
+
+	outbox.RegisterHandler(outbox.KindWebhook, deliverQueued)
+	notification.RegisterChannel(notification.ChannelWebhook, notifyWebhooks)
+	alerting.RegisterListener("webhook", publishAlert)
+}
+
+// Publish queues the event for every active webhook of the client subscribed to it
+func Publish(clientID string, event string, data interface{}) error {
+	webhooks, err := store.Subscribed(clientID, event)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	eventID := bson.NewObjectId().Hex()
+	body, err := json.Marshal(&Event{
+		ID:        eventID,
+		Type:      event,
+		ClientID:  clientID,
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		delivery := Delivery{
+			ID:        bson.NewObjectId(),
+			WebhookID: webhook.ID.Hex(),
+			ClientID:  clientID,
+			EventID:   eventID,
+			Event:     event,
+			Body:      string(body),
+			Status:    DeliveryPending,
+			Attempts:  []Attempt{},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		err = store.InsertDelivery(&delivery)
+		if err != nil {
+			return err
+		}
+
+		err = store.Queue(&delivery)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replay queues a delivery again with a fresh set of attempts, the body and event id stay the same
+func Replay(id string) (*Delivery, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, mgo.ErrNotFound
+	}
+
+	delivery, err := store.FindDelivery(bson.ObjectIdHex(id))
+	if err != nil {
+		return nil, err
+	}
+	if !bson.IsObjectIdHex(delivery.WebhookID) {
+		return nil, ErrWebhookDisabled
+	}
+
+	webhook, err := store.FindWebhook(bson.ObjectIdHex(delivery.WebhookID))
+	if err != nil {
+		return nil, err
+	}
+	if webhook.Status != StatusActive {
+		return nil, ErrWebhookDisabled
+	}
+
+	delivery, err = store.Reopen(delivery.ID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Queue(delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// ValidateURL checks the url is absolute and uses https, plain http is accepted when webhook.allow_http is set.
+// The host must resolve to public addresses unless webhook.allow_private_addresses is set
+func ValidateURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || len(parsed.Host) == 0 {
+		return false
+	}
+
+	if parsed.Scheme != "https" && (parsed.Scheme != "http" || !config.GetConfig().GetBool("webhook.allow_http")) {
+		return false
+	}
+	if config.GetConfig().GetBool("webhook.allow_private_addresses") {
+		return true
+	}
+
+	err = utils.CheckPublicURL(raw)
+	if err != nil {
+		log.Infof("Refused webhook url %s, error: %v", raw, err)
+		return false
+	}
+
+	return true
+}
+
+// ValidateEvents checks the events are known, a webhook needs at least one
+func ValidateEvents(events []string) bool {
+	if len(events) == 0 {
+		return false
+	}
+
+	for _, event := range events {
+		if !utils.Contains(Events, event) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GenerateSecret creates the random signing secret of a webhook
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// publishAlert publishes raised and cleared alerts to the webhooks of the client
+func publishAlert(event *alerting.Event) error {
+	switch event.Type {
+	case alerting.EventCreated:
+		return Publish(event.Alert.ClientID, EventAlertCreated, event.Alert)
+	case alerting.EventCleared:
+		return Publish(event.Alert.ClientID, EventAlertCleared, event.Alert)
+	}
+
+	return nil
+}
+
+// notifyWebhooks publishes the notification of a user who selected the webhook channel to the webhooks of the client
+func notifyWebhooks(user *common.User, notification *notification.Notification) error {
+	if len(user.ClientID) == 0 {
+		return nil
+	}
+
+	return Publish(user.ClientID, EventNotificationCreated, map[string]interface{}{
+		"userId":    notification.UserID,
+		"alertType": notification.AlertType,
+		"priority":  notification.Priority,
+		"entityId":  notification.EntityID,
+		"template":  notification.Template,
+	})
+}
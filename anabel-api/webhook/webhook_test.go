@@ -0,0 +1,512 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"anacove.com/backend/alerting"
+	"anacove.com/backend/common"
+	"anacove.com/backend/config"
+	"anacove.com/backend/outbox"
+	"anacove.com/backend/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+)
+
+// memoryStore keeps the webhooks and deliveries of a test, queued lists the deliveries waiting in the outbox
+type memoryStore struct {
+	mu         sync.Mutex
+	webhooks   map[bson.ObjectId]*Webhook
+	deliveries map[bson.ObjectId]*Delivery
+	queued     []string
+}
+
+func newMemoryStore(webhooks ...Webhook) *memoryStore {
+	s := &memoryStore{webhooks: map[bson.ObjectId]*Webhook{}, deliveries: map[bson.ObjectId]*Delivery{}}
+	for i := range webhooks {
+		webhook := webhooks[i]
+		s.webhooks[webhook.ID] = &webhook
+	}
+
+	return s
+}
+
+func (s *memoryStore) Subscribed(clientID string, event string) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks := []Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.ClientID == clientID && webhook.Status == StatusActive && utils.Contains(webhook.Events, event) {
+			webhooks = append(webhooks, *webhook)
+		}
+	}
+
+	return webhooks, nil
+}
+
+func (s *memoryStore) FindWebhook(id bson.ObjectId) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return nil, mgo.ErrNotFound
+	}
+	found := *webhook
+
+	return &found, nil
+}
+
+func (s *memoryStore) FindDelivery(id bson.ObjectId) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, ok := s.deliveries[id]
+	if !ok {
+		return nil, mgo.ErrNotFound
+	}
+	found := *delivery
+
+	return &found, nil
+}
+
+func (s *memoryStore) InsertDelivery(delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inserted := *delivery
+	s.deliveries[delivery.ID] = &inserted
+
+	return nil
+}
+
+func (s *memoryStore) Queue(delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queued = append(s.queued, delivery.ID.Hex())
+
+	return nil
+}
+
+func (s *memoryStore) Reopen(id bson.ObjectId, now time.Time) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery := s.deliveries[id]
+	delivery.Status = DeliveryPending
+	delivery.Replays++
+	delivery.UpdatedAt = now
+	reopened := *delivery
+
+	return &reopened, nil
+}
+
+func (s *memoryStore) RecordAttempt(id bson.ObjectId, attempt Attempt, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery := s.deliveries[id]
+	delivery.Status = status
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if len(delivery.Attempts) > maxAttemptsLogged {
+		delivery.Attempts = delivery.Attempts[len(delivery.Attempts)-maxAttemptsLogged:]
+	}
+	if status == DeliveryDelivered {
+		delivery.DeliveredAt = attempt.At
+	}
+
+	return nil
+}
+
+func (s *memoryStore) RecordSuccess(id bson.ObjectId, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.webhooks[id].ConsecutiveFailures = 0
+	s.webhooks[id].LastDeliveryAt = at
+
+	return nil
+}
+
+func (s *memoryStore) RecordFailure(id bson.ObjectId, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.webhooks[id].ConsecutiveFailures++
+	s.webhooks[id].LastDeliveryAt = at
+
+	return s.webhooks[id].ConsecutiveFailures, nil
+}
+
+func (s *memoryStore) Disable(id bson.ObjectId, reason string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.webhooks[id].Status == StatusActive {
+		s.webhooks[id].Status = StatusDisabled
+		s.webhooks[id].DisabledReason = reason
+		s.webhooks[id].DisabledAt = now
+	}
+
+	return nil
+}
+
+// next takes the oldest queued delivery off the outbox
+func (s *memoryStore) next(t *testing.T) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queued) == 0 {
+		t.Fatal("no delivery is queued")
+	}
+	id := s.queued[0]
+	s.queued = s.queued[1:]
+
+	return id
+}
+
+// received is a request the receiver got
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a local webhook receiver answering with the statuses in turn, the last one repeats
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+	server   *httptest.Server
+}
+
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+
+		r.mu.Lock()
+		status := r.statuses[0]
+		if len(r.statuses) > 1 {
+			r.statuses = r.statuses[1:]
+		}
+		r.requests = append(r.requests, received{header: req.Header, body: body})
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte("ok"))
+	}))
+
+	return r
+}
+
+func (r *receiver) received() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]received{}, r.requests...)
+}
+
+// setup configures the package with a receiver and one webhook of the client subscribed to the alert events
+func setup(t *testing.T, disableAfter int, statuses ...int) (*memoryStore, *receiver, *Webhook) {
+	v := viper.New()
+	v.Set("webhook.timeout_in_seconds", 5)
+	v.Set("webhook.disable_after_failures", disableAfter)
+	// the receiver listens on the loopback address
+	v.Set("webhook.allow_private_addresses", true)
+	config.SetConfig(v)
+
+	r := newReceiver(statuses...)
+	webhook := Webhook{
+		ID:       bson.NewObjectId(),
+		ClientID: "client-1",
+		URL:      r.server.URL,
+		Events:   []string{EventAlertCreated, EventAlertCleared},
+		Secret:   "whsec_test",
+		Status:   StatusActive,
+	}
+	s := newMemoryStore(webhook)
+	SetStore(s)
+
+	return s, r, s.webhooks[webhook.ID]
+}
+
+// attempt delivers the delivery like the outbox does on its attempts-th retry
+func attempt(deliveryID string, attempts int, maxAttempts int) error {
+	return deliverQueued(&outbox.Message{
+		Kind:        outbox.KindWebhook,
+		Payload:     queued{DeliveryID: deliveryID},
+		Attempts:    attempts,
+		MaxAttempts: maxAttempts,
+	})
+}
+
+// publishCreated publishes a raised alert of the client like the alerting listener does
+func publishCreated(t *testing.T) {
+	alert := common.Alert{ID: bson.NewObjectId(), ClientID: "client-1", SiteID: "site-1", Status: common.AlertStatusNew, Type: common.AlertTypeStaffAlert}
+	err := publishAlert(&alerting.Event{Type: alerting.EventCreated, Alert: &alert})
+	if err != nil {
+		t.Fatalf("cannot publish: %v", err)
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	s, r, webhook := setup(t, 15, http.StatusOK)
+	defer r.server.Close()
+
+	publishCreated(t)
+	id := s.next(t)
+	err := attempt(id, 0, 8)
+	if err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+
+	requests := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests instead of 1", len(requests))
+	}
+	request := requests[0]
+	if request.header.Get(EventHeader) != EventAlertCreated || request.header.Get(DeliveryHeader) != id {
+		t.Errorf("unexpected event headers %v", request.header)
+	}
+
+	// the receiver side check: hmac-sha256 of "<t>.<body>" keyed with the secret
+	parts := strings.Split(request.header.Get(SignatureHeader), ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") || !strings.HasPrefix(parts[1], "v1=") {
+		t.Fatalf("malformed signature header %q", request.header.Get(SignatureHeader))
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "t="), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		t.Errorf("signature timestamp %s is not the time of the post", parts[0])
+	}
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(strings.TrimPrefix(parts[0], "t=") + "."))
+	mac.Write(request.body)
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.TrimPrefix(parts[1], "v1="))) {
+		t.Error("signature does not match the body")
+	}
+
+	event := Event{}
+	err = json.Unmarshal(request.body, &event)
+	if err != nil || event.Type != EventAlertCreated || event.ClientID != "client-1" || len(event.ID) == 0 {
+		t.Errorf("unexpected event %+v, error: %v", event, err)
+	}
+
+	delivery, _ := s.FindDelivery(bson.ObjectIdHex(id))
+	if delivery.Status != DeliveryDelivered || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("unexpected delivery log %+v", delivery)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	s, r, webhook := setup(t, 15, http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK)
+	defer r.server.Close()
+
+	publishCreated(t)
+	id := s.next(t)
+	for attempts := 0; attempts < 2; attempts++ {
+		err := attempt(id, attempts, 8)
+		if err == nil || outbox.IsPermanent(err) {
+			t.Fatalf("attempt %d returned %v instead of a retry", attempts+1, err)
+		}
+		delivery, _ := s.FindDelivery(bson.ObjectIdHex(id))
+		if delivery.Status != DeliveryPending {
+			t.Errorf("delivery is %s after attempt %d", delivery.Status, attempts+1)
+		}
+	}
+	if webhook.ConsecutiveFailures != 2 {
+		t.Errorf("webhook counts %d failures instead of 2", webhook.ConsecutiveFailures)
+	}
+
+	err := attempt(id, 2, 8)
+	if err != nil {
+		t.Fatalf("third attempt failed: %v", err)
+	}
+	delivery, _ := s.FindDelivery(bson.ObjectIdHex(id))
+	if delivery.Status != DeliveryDelivered || len(delivery.Attempts) != 3 {
+		t.Errorf("unexpected delivery log %+v", delivery)
+	}
+	if webhook.ConsecutiveFailures != 0 {
+		t.Errorf("failures are not cleared by the delivery: %d", webhook.ConsecutiveFailures)
+	}
+
+	// the same body with the same delivery id is retried
+	requests := r.received()
+	for _, request := range requests[1:] {
+		if string(request.body) != string(requests[0].body) || request.header.Get(DeliveryHeader) != id {
+			t.Error("a retry changed the body or the delivery id")
+		}
+	}
+}
+
+func TestLastAttemptFailsDelivery(t *testing.T) {
+	s, r, _ := setup(t, 15, http.StatusBadGateway)
+	defer r.server.Close()
+
+	publishCreated(t)
+	id := s.next(t)
+	err := attempt(id, 7, 8)
+	if err == nil {
+		t.Fatal("a failed attempt succeeded")
+	}
+	delivery, _ := s.FindDelivery(bson.ObjectIdHex(id))
+	if delivery.Status != DeliveryFailed {
+		t.Errorf("delivery is %s after the last attempt", delivery.Status)
+	}
+}
+
+func TestDisableAfterFailures(t *testing.T) {
+	s, r, webhook := setup(t, 3, http.StatusInternalServerError)
+	defer r.server.Close()
+
+	publishCreated(t)
+	id := s.next(t)
+	for attempts := 0; attempts < 2; attempts++ {
+		err := attempt(id, attempts, 8)
+		if err == nil || outbox.IsPermanent(err) {
+			t.Fatalf("attempt %d returned %v instead of a retry", attempts+1, err)
+		}
+	}
+
+	err := attempt(id, 2, 8)
+	if !outbox.IsPermanent(err) {
+		t.Fatalf("the third failure in a row returned %v instead of giving up", err)
+	}
+	if webhook.Status != StatusDisabled || !strings.HasPrefix(webhook.DisabledReason, "3 failed attempts in a row") {
+		t.Errorf("webhook is %s with reason %q", webhook.Status, webhook.DisabledReason)
+	}
+	delivery, _ := s.FindDelivery(bson.ObjectIdHex(id))
+	if delivery.Status != DeliveryFailed {
+		t.Errorf("delivery is %s after the webhook was disabled", delivery.Status)
+	}
+
+	// a disabled webhook gets no new events and its queued deliveries are not posted
+	publishCreated(t)
+	if len(s.queued) != 0 {
+		t.Errorf("events are queued for a disabled webhook: %v", s.queued)
+	}
+	if _, err := Replay(id); err != ErrWebhookDisabled {
+		t.Errorf("replay of a disabled webhook returned %v", err)
+	}
+	err = attempt(id, 0, 8)
+	if !outbox.IsPermanent(err) || len(r.received()) != 3 {
+		t.Errorf("a delivery of a disabled webhook was posted, error: %v", err)
+	}
+}
+
+func TestReplayKeepsEventID(t *testing.T) {
+	s, r, _ := setup(t, 15, http.StatusInternalServerError, http.StatusOK)
+	defer r.server.Close()
+
+	publishCreated(t)
+	id := s.next(t)
+	err := attempt(id, 0, 1)
+	if err == nil {
+		t.Fatal("the failing attempt succeeded")
+	}
+
+	replayed, err := Replay(id)
+	if err != nil {
+		t.Fatalf("cannot replay: %v", err)
+	}
+	if replayed.Status != DeliveryPending || replayed.Replays != 1 {
+		t.Errorf("unexpected replayed delivery %+v", replayed)
+	}
+
+	err = attempt(s.next(t), 0, 8)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	requests := r.received()
+	if len(requests) != 2 {
+		t.Fatalf("receiver got %d requests instead of 2", len(requests))
+	}
+	first, second := Event{}, Event{}
+	json.Unmarshal(requests[0].body, &first)
+	json.Unmarshal(requests[1].body, &second)
+	if len(first.ID) == 0 || first.ID != second.ID || requests[1].header.Get(DeliveryHeader) != id {
+		t.Errorf("replay sent event %s of delivery %s instead of event %s of %s", second.ID, requests[1].header.Get(DeliveryHeader), first.ID, id)
+	}
+	delivery, _ := s.FindDelivery(bson.ObjectIdHex(id))
+	if delivery.Status != DeliveryDelivered || len(delivery.Attempts) != 2 {
+		t.Errorf("unexpected delivery log %+v", delivery)
+	}
+}
+
+func TestPublishAlertEvents(t *testing.T) {
+	s, r, _ := setup(t, 15, http.StatusOK)
+	defer r.server.Close()
+
+	alert := common.Alert{ID: bson.NewObjectId(), ClientID: "client-1", Status: common.AlertStatusCleared}
+	for _, eventType := range []string{alerting.EventCleared, alerting.EventReopened, alerting.EventAssigned} {
+		err := publishAlert(&alerting.Event{Type: eventType, Alert: &alert})
+		if err != nil {
+			t.Fatalf("cannot publish %s: %v", eventType, err)
+		}
+	}
+
+	if len(s.queued) != 1 {
+		t.Fatalf("%d deliveries are queued instead of the cleared one", len(s.queued))
+	}
+	delivery, _ := s.FindDelivery(bson.ObjectIdHex(s.queued[0]))
+	if delivery.Event != EventAlertCleared {
+		t.Errorf("queued %s instead of %s", delivery.Event, EventAlertCleared)
+	}
+}
+
+func TestValidateURLRefusesPrivateAddresses(t *testing.T) {
+	v := viper.New()
+	v.Set("webhook.allow_http", true)
+	config.SetConfig(v)
+
+	for _, raw := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://10.1.2.3/hook",
+		"https://192.168.0.10/hook",
+		"https://[::1]/hook",
+		"ftp://93.184.216.34/hook",
+	} {
+		if ValidateURL(raw) {
+			t.Errorf("%s is accepted", raw)
+		}
+	}
+	if !ValidateURL("https://93.184.216.34/hook") {
+		t.Error("a public address is refused")
+	}
+}
+
+func TestDeliveryRefusesPrivateAddressWhenDialing(t *testing.T) {
+	s, r, _ := setup(t, 15, http.StatusOK)
+	defer r.server.Close()
+	// the url was accepted, the address it resolves to now is private
+	config.GetConfig().Set("webhook.allow_private_addresses", false)
+
+	publishCreated(t)
+	id := s.next(t)
+	err := attempt(id, 0, 8)
+	if err == nil {
+		t.Fatal("a delivery to the loopback address succeeded")
+	}
+	if len(r.received()) != 0 {
+		t.Error("the receiver on the loopback address got the delivery")
+	}
+	delivery, _ := s.FindDelivery(bson.ObjectIdHex(id))
+	if len(delivery.Attempts) != 1 || !strings.Contains(delivery.Attempts[0].Error, "private") {
+		t.Errorf("unexpected delivery log %+v", delivery.Attempts)
+	}
+}